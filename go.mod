@@ -5,9 +5,12 @@ go 1.20
 require (
 	github.com/aws/aws-sdk-go v1.44.295
 	github.com/btcsuite/btcd/btcec/v2 v2.3.2
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/gofrs/uuid v4.2.0+incompatible
 	github.com/sirupsen/logrus v1.4.2
 	github.com/syndtr/goleveldb v1.0.1-0.20210819022825-2ae1ddf74ef7
+	github.com/tjfoc/gmsm v1.4.1
+	golang.org/x/crypto v0.5.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -16,6 +19,7 @@ require (
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 )
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/nsmithuk/local-kms/src/server"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Migrates the data to the current schema version, then exits. Run with:
+
+	local-kms migrate [--dry-run]
+
+Migrations are also run whenever LKMS starts; this allows them to be checked, or run, ahead of time.
+*/
+func migrate(logger *log.Logger, options server.Options, args []string) {
+
+	dryRun := false
+
+	for _, arg := range args {
+		switch arg {
+		case "--dry-run":
+			dryRun = true
+		default:
+			logger.Fatalf("Unknown argument '%s'. Usage: local-kms migrate [--dry-run]", arg)
+		}
+	}
+
+	if err := server.Migrate(options, dryRun); err != nil {
+		logger.Fatalf("Migration failed: %s", err)
+	}
+}
+
+/*
+Replaces the data with a backup taken before a migration, then exits. Run with:
+
+	local-kms restore <backup file>
+*/
+func restore(logger *log.Logger, options server.Options, args []string) {
+
+	if len(args) != 1 {
+		logger.Fatal("Usage: local-kms restore <backup file>")
+	}
+
+	version, err := server.Restore(options, args[0])
+	if err != nil {
+		logger.Fatalf("Restore failed: %s", err)
+	}
+
+	logger.Infof("Restored %s. The data is at schema version %d.", args[0], version)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/server"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Returns the master key given by either environment variable, or nil if neither is set.
+*/
+func loadMasterKey(logger *log.Logger, passphraseVariable, pathVariable string) data.MasterKey {
+
+	passphrase := os.Getenv(passphraseVariable)
+	path := os.Getenv(pathVariable)
+
+	switch {
+	case passphrase != "" && path != "":
+		logger.Fatalf("Only one of %s and %s can be set", passphraseVariable, pathVariable)
+
+	case passphrase != "":
+		return data.NewPassphraseMasterKey(passphrase)
+
+	case path != "":
+		key, err := data.LoadMasterKeyFile(path)
+		if err != nil {
+			logger.Fatalf("Unable to load the master key: %s", err)
+		}
+		return key
+	}
+
+	return nil
+}
+
+/*
+Re-encrypts the data with the new master key, then exits. Run with:
+
+	local-kms rekey
+
+The current key is given as when starting LKMS, and the new key by KMS_NEW_MASTER_KEY_PASSPHRASE or
+KMS_NEW_MASTER_KEY_PATH. If there's no current key, the data is encrypted; if there's no new key, it's decrypted.
+*/
+func rekey(logger *log.Logger, options server.Options, newKey data.MasterKey) {
+
+	storage := options.Storage
+
+	switch storage.(type) {
+	case nil:
+		leveldb, err := data.NewLevelDBStorage(options.DataPath)
+		if err != nil {
+			logger.Fatalf("Unable to open the database in %s: %s", options.DataPath, err)
+		}
+		storage = leveldb
+
+	case *data.MemoryStorage:
+		logger.Fatal("Data stored in memory can't be re-keyed")
+	}
+
+	count, err := data.Rekey(storage, options.MasterKey, newKey)
+	storage.Close()
+
+	if err != nil {
+		logger.Fatalf("Re-key failed after %d records: %s. It can be run again, with the same keys, to complete it.", count, err)
+	}
+
+	switch {
+	case options.MasterKey == nil:
+		logger.Infof("Encrypted %d records in %s", count, options.DataPath)
+	case newKey == nil:
+		logger.Infof("Decrypted %d records in %s", count, options.DataPath)
+	default:
+		logger.Infof("Re-keyed %d records in %s", count, options.DataPath)
+	}
+}
@@ -0,0 +1,218 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+/*
+An attestation document, as produced by the Nitro Secure Module.
+*/
+type Document struct {
+	ModuleId    string          `cbor:"module_id"`
+	Digest      string          `cbor:"digest"`
+	Timestamp   uint64          `cbor:"timestamp"`
+	PCRs        map[uint][]byte `cbor:"pcrs"`
+	Certificate []byte          `cbor:"certificate"`
+	CABundle    [][]byte        `cbor:"cabundle"`
+	PublicKey   []byte          `cbor:"public_key"`
+	UserData    []byte          `cbor:"user_data"`
+	Nonce       []byte          `cbor:"nonce"`
+}
+
+// A COSE_Sign1 structure, as defined by RFC 8152.
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected cbor.RawMessage
+	Payload     []byte
+	Signature   []byte
+}
+
+type coseHeader struct {
+	Alg int `cbor:"1,keyasint"`
+}
+
+// COSE algorithm identifiers for ECDSA
+const (
+	coseAlgorithmEs256 = -7
+	coseAlgorithmEs384 = -35
+	coseAlgorithmEs512 = -36
+)
+
+/*
+Validates attestation documents against a set of trusted root certificates.
+*/
+type Verifier struct {
+	roots *x509.CertPool
+}
+
+/*
+Loads the trusted root certificates from a file of one or more PEM encoded certificates.
+*/
+func LoadVerifier(path string) (*Verifier, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := &Verifier{
+		roots: x509.NewCertPool(),
+	}
+
+	count := 0
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		verifier.roots.AddCert(certificate)
+		count++
+	}
+
+	if count == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	return verifier, nil
+}
+
+/*
+Returns the attestation document, once its signature and certificate chain have been validated.
+*/
+func (v *Verifier) Verify(document []byte) (*Document, error) {
+
+	var message coseSign1
+	if err := cbor.Unmarshal(document, &message); err != nil {
+		return nil, fmt.Errorf("the attestation document is not a valid COSE_Sign1 structure: %s", err)
+	}
+
+	var header coseHeader
+	if err := cbor.Unmarshal(message.Protected, &header); err != nil {
+		return nil, fmt.Errorf("the attestation document's protected header is invalid: %s", err)
+	}
+
+	var hash crypto.Hash
+	switch header.Alg {
+	case coseAlgorithmEs256:
+		hash = crypto.SHA256
+	case coseAlgorithmEs384:
+		hash = crypto.SHA384
+	case coseAlgorithmEs512:
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("the attestation document's signing algorithm %d is not supported", header.Alg)
+	}
+
+	doc := &Document{}
+	if err := cbor.Unmarshal(message.Payload, doc); err != nil {
+		return nil, fmt.Errorf("the attestation document's payload is invalid: %s", err)
+	}
+
+	if doc.ModuleId == "" || doc.Digest == "" || doc.Timestamp == 0 || len(doc.PCRs) == 0 ||
+		len(doc.Certificate) == 0 || len(doc.CABundle) == 0 {
+		return nil, errors.New("the attestation document is missing a mandatory field")
+	}
+
+	//---
+	// Validate the certificate chain
+
+	certificate, err := x509.ParseCertificate(doc.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("the attestation document's certificate is invalid: %s", err)
+	}
+
+	// The bundle starts with the root certificate, which must itself be one of our trusted roots
+	intermediates := x509.NewCertPool()
+	for _, c := range doc.CABundle {
+		bundled, err := x509.ParseCertificate(c)
+		if err != nil {
+			return nil, fmt.Errorf("the attestation document's CA bundle is invalid: %s", err)
+		}
+		intermediates.AddCert(bundled)
+	}
+
+	_, err = certificate.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("the attestation document's certificate is not trusted: %s", err)
+	}
+
+	//---
+	// Validate the signature
+
+	publicKey, ok := certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the attestation document's certificate must have an EC public key")
+	}
+
+	// The signed Sig_structure, with no external data
+	signed, err := cbor.Marshal([]interface{}{"Signature1", message.Protected, []byte{}, message.Payload})
+	if err != nil {
+		return nil, err
+	}
+
+	digest := hash.New()
+	digest.Write(signed)
+
+	// The signature is r and s concatenated, each the size of the curve
+	size := (publicKey.Curve.Params().BitSize + 7) / 8
+	if len(message.Signature) != 2*size {
+		return nil, errors.New("the attestation document's signature is invalid")
+	}
+
+	r := new(big.Int).SetBytes(message.Signature[:size])
+	s := new(big.Int).SetBytes(message.Signature[size:])
+
+	if !ecdsa.Verify(publicKey, digest.Sum(nil), r, s) {
+		return nil, errors.New("the attestation document's signature is invalid")
+	}
+
+	return doc, nil
+}
+
+/*
+Returns the RSA public key included in the attestation document, that the recipient's responses are encrypted to.
+*/
+func (d *Document) RecipientPublicKey() (*rsa.PublicKey, error) {
+	if len(d.PublicKey) == 0 {
+		return nil, errors.New("the attestation document does not include a public key")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(d.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("the attestation document's public key is invalid: %s", err)
+	}
+
+	rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the attestation document's public key must be an RSA key")
+	}
+
+	return rsaPublicKey, nil
+}
@@ -0,0 +1,203 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+/*
+The fixture in testdata is an attestation document signed by a certificate chained to testdata/root.pem, which was
+generated locally by testdata/generate.go.
+*/
+func loadFixture(t *testing.T) (*Verifier, []byte) {
+	t.Helper()
+
+	verifier, err := LoadVerifier(filepath.Join("testdata", "root.pem"))
+	if err != nil {
+		t.Fatalf("unable to load the root certificate: %s", err)
+	}
+
+	document, err := os.ReadFile(filepath.Join("testdata", "attestation-document.cbor"))
+	if err != nil {
+		t.Fatalf("unable to load the attestation document: %s", err)
+	}
+
+	return verifier, document
+}
+
+func loadRecipientKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("testdata", "recipient.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(content)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.(*rsa.PrivateKey)
+}
+
+/*
+Re-encodes the fixture's COSE_Sign1 structure, after it's been changed by the passed function.
+*/
+func modifyFixture(t *testing.T, document []byte, modify func(message *coseSign1)) []byte {
+	t.Helper()
+
+	var message coseSign1
+	if err := cbor.Unmarshal(document, &message); err != nil {
+		t.Fatal(err)
+	}
+
+	modify(&message)
+
+	encoded, err := cbor.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestVerify(t *testing.T) {
+	verifier, document := loadFixture(t)
+
+	doc, err := verifier.Verify(document)
+	if err != nil {
+		t.Fatalf("expected the document to verify, got %s", err)
+	}
+
+	if doc.ModuleId != "i-0123456789abcdef0-enc0123456789abcdef" || doc.Digest != "SHA384" {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+
+	if len(doc.PCRs) != 16 || len(doc.PCRs[0]) != 48 || doc.PCRs[0][0] != 0x01 {
+		t.Errorf("unexpected PCRs: %x", doc.PCRs)
+	}
+
+	publicKey, err := doc.RecipientPublicKey()
+	if err != nil {
+		t.Fatalf("unable to read the recipient's public key: %s", err)
+	}
+
+	if !publicKey.Equal(&loadRecipientKey(t).PublicKey) {
+		t.Errorf("the document's public key isn't the recipient's")
+	}
+}
+
+func TestVerifyUntrustedRoot(t *testing.T) {
+	_, document := loadFixture(t)
+
+	// Another root, generated the same way
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Local KMS Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "root.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := LoadVerifier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Verify(document); err == nil {
+		t.Error("expected the document not to be trusted")
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	verifier, document := loadFixture(t)
+
+	tests := map[string][]byte{
+		"not CBOR": []byte("not an attestation document"),
+
+		"tampered payload": modifyFixture(t, document, func(m *coseSign1) {
+			m.Payload[len(m.Payload)-1] ^= 0x01
+		}),
+
+		"tampered signature": modifyFixture(t, document, func(m *coseSign1) {
+			m.Signature[0] ^= 0x01
+		}),
+
+		"truncated signature": modifyFixture(t, document, func(m *coseSign1) {
+			m.Signature = m.Signature[:64]
+		}),
+
+		"unsupported algorithm": modifyFixture(t, document, func(m *coseSign1) {
+			m.Protected, _ = cbor.Marshal(map[int]int{1: -37})
+		}),
+
+		"missing certificate": modifyFixture(t, document, func(m *coseSign1) {
+			var doc Document
+			cbor.Unmarshal(m.Payload, &doc)
+			doc.Certificate = nil
+			m.Payload, _ = cbor.Marshal(doc)
+		}),
+
+		"leaf certificate replaced by the root": modifyFixture(t, document, func(m *coseSign1) {
+			var doc Document
+			cbor.Unmarshal(m.Payload, &doc)
+			doc.Certificate = doc.CABundle[0]
+			m.Payload, _ = cbor.Marshal(doc)
+		}),
+	}
+
+	for name, document := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := verifier.Verify(document); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRecipientPublicKeyMissing(t *testing.T) {
+	if _, err := (&Document{}).RecipientPublicKey(); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestLoadVerifierWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "root.pem")
+	if err := os.WriteFile(path, []byte("no certificates"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadVerifier(path); err == nil {
+		t.Error("expected an error")
+	}
+}
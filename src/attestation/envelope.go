@@ -0,0 +1,150 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+)
+
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEnvelopedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRsaesOaep              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMgf1                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSha256                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidAes256Cbc              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	sha256AlgorithmIdentifier = pkix.AlgorithmIdentifier{Algorithm: oidSha256}
+)
+
+// CMS structures, as defined by RFC 5652.
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     envelopedData `asn1:"explicit,tag:0"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	SubjectKeyIdentifier   []byte `asn1:"tag:0"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0"`
+}
+
+// As defined by RFC 4055
+type rsaesOaepParameters struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+}
+
+/*
+Returns the content as a CMS EnvelopedData structure, in DER form. The content is encrypted with AES-256-CBC, and the
+content encryption key with RSAES_OAEP_SHA_256, to the recipient's public key.
+*/
+func Envelope(content []byte, recipient *rsa.PublicKey) ([]byte, error) {
+
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	//---
+	// Encrypt the content
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS #7 padding
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	padded := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encryptedContent := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encryptedContent, padded)
+
+	//---
+	// Encrypt the content encryption key
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, recipient, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	//---
+
+	mgf1Parameters, err := asn1.Marshal(sha256AlgorithmIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	oaepParameters, err := asn1.Marshal(rsaesOaepParameters{
+		HashAlgorithm: sha256AlgorithmIdentifier,
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidMgf1,
+			Parameters: asn1.RawValue{FullBytes: mgf1Parameters},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ivParameter, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	// The recipient is identified by the SHA-1 hash of their public key, as per RFC 5280
+	subjectKeyIdentifier := sha1.Sum(x509.MarshalPKCS1PublicKey(recipient))
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content: envelopedData{
+			// Version 2, as the recipient is identified by subject key identifier
+			Version: 2,
+			RecipientInfos: []keyTransRecipientInfo{
+				{
+					Version:              2,
+					SubjectKeyIdentifier: subjectKeyIdentifier[:],
+					KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+						Algorithm:  oidRsaesOaep,
+						Parameters: asn1.RawValue{FullBytes: oaepParameters},
+					},
+					EncryptedKey: encryptedKey,
+				},
+			},
+			EncryptedContentInfo: encryptedContentInfo{
+				ContentType: oidData,
+				ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+					Algorithm:  oidAes256Cbc,
+					Parameters: asn1.RawValue{FullBytes: ivParameter},
+				},
+				EncryptedContent: encryptedContent,
+			},
+		},
+	})
+}
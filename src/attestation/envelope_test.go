@@ -0,0 +1,133 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+/*
+Decrypts a CMS EnvelopedData structure, as a recipient would, checking it's in the form Envelope produces.
+*/
+func openEnvelope(t *testing.T, envelope []byte, key *rsa.PrivateKey) []byte {
+	t.Helper()
+
+	var info contentInfo
+	if rest, err := asn1.Unmarshal(envelope, &info); err != nil || len(rest) != 0 {
+		t.Fatalf("the envelope is not a ContentInfo structure: %v", err)
+	}
+
+	if !info.ContentType.Equal(oidEnvelopedData) {
+		t.Fatalf("unexpected content type %s", info.ContentType)
+	}
+
+	if len(info.Content.RecipientInfos) != 1 {
+		t.Fatalf("expected a single recipient, got %d", len(info.Content.RecipientInfos))
+	}
+
+	recipient := info.Content.RecipientInfos[0]
+
+	subjectKeyIdentifier := sha1.Sum(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	if !bytes.Equal(recipient.SubjectKeyIdentifier, subjectKeyIdentifier[:]) {
+		t.Errorf("the recipient isn't identified by their subject key identifier")
+	}
+
+	if !recipient.KeyEncryptionAlgorithm.Algorithm.Equal(oidRsaesOaep) {
+		t.Fatalf("unexpected key encryption algorithm %s", recipient.KeyEncryptionAlgorithm.Algorithm)
+	}
+
+	var oaep rsaesOaepParameters
+	if _, err := asn1.Unmarshal(recipient.KeyEncryptionAlgorithm.Parameters.FullBytes, &oaep); err != nil {
+		t.Fatalf("invalid RSAES-OAEP parameters: %s", err)
+	}
+	if !oaep.HashAlgorithm.Algorithm.Equal(oidSha256) || !oaep.MaskGenAlgorithm.Algorithm.Equal(oidMgf1) {
+		t.Errorf("expected RSAES-OAEP with SHA-256 and MGF1, got %+v", oaep)
+	}
+
+	contentKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, recipient.EncryptedKey, nil)
+	if err != nil {
+		t.Fatalf("unable to decrypt the content encryption key: %s", err)
+	}
+
+	//---
+
+	content := info.Content.EncryptedContentInfo
+
+	if !content.ContentType.Equal(oidData) || !content.ContentEncryptionAlgorithm.Algorithm.Equal(oidAes256Cbc) {
+		t.Fatalf("unexpected content encryption: %+v", content)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(content.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("invalid IV: %s", err)
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(content.EncryptedContent) == 0 || len(content.EncryptedContent)%aes.BlockSize != 0 {
+		t.Fatalf("invalid encrypted content length %d", len(content.EncryptedContent))
+	}
+
+	plaintext := make([]byte, len(content.EncryptedContent))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, content.EncryptedContent)
+
+	// PKCS #7 padding
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > aes.BlockSize ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		t.Fatalf("invalid padding")
+	}
+
+	return plaintext[:len(plaintext)-padding]
+}
+
+func TestEnvelope(t *testing.T) {
+	key := loadRecipientKey(t)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 32, 1024} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		envelope, err := Envelope(content, &key.PublicKey)
+		if err != nil {
+			t.Fatalf("unable to envelope %d bytes: %s", size, err)
+		}
+
+		if opened := openEnvelope(t, envelope, key); !bytes.Equal(opened, content) {
+			t.Errorf("expected %x, got %x", content, opened)
+		}
+	}
+}
+
+func TestEnvelopeToAttestedRecipient(t *testing.T) {
+	verifier, document := loadFixture(t)
+
+	doc, err := verifier.Verify(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := doc.RecipientPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := Envelope([]byte("for the enclave"), publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened := openEnvelope(t, envelope, loadRecipientKey(t)); string(opened) != "for the enclave" {
+		t.Errorf("unexpected content %q", opened)
+	}
+}
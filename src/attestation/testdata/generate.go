@@ -0,0 +1,139 @@
+//go:build ignore
+
+/*
+Generates the attestation document fixture used by the tests: a root certificate, and an attestation document signed
+by a certificate chained to it, including the recipient's public key. The certificates are valid until 2120.
+
+	go run generate.go
+*/
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+type document struct {
+	ModuleId    string          `cbor:"module_id"`
+	Digest      string          `cbor:"digest"`
+	Timestamp   uint64          `cbor:"timestamp"`
+	PCRs        map[uint][]byte `cbor:"pcrs"`
+	Certificate []byte          `cbor:"certificate"`
+	CABundle    [][]byte        `cbor:"cabundle"`
+	PublicKey   []byte          `cbor:"public_key"`
+	UserData    []byte          `cbor:"user_data"`
+	Nonce       []byte          `cbor:"nonce"`
+}
+
+func main() {
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2120, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	root, rootKey := certificate("Local KMS Test Root", true, notBefore, notAfter, nil, nil)
+	intermediate, intermediateKey := certificate("Local KMS Test Intermediate", true, notBefore, notAfter, root, rootKey)
+	leaf, leafKey := certificate("Local KMS Test Enclave", false, notBefore, notAfter, intermediate, intermediateKey)
+
+	recipientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	check(err)
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&recipientKey.PublicKey)
+	check(err)
+
+	pcrs := make(map[uint][]byte)
+	for i := uint(0); i < 16; i++ {
+		pcrs[i] = make([]byte, 48)
+	}
+	pcrs[0][0] = 0x01
+
+	payload, err := cbor.Marshal(document{
+		ModuleId:    "i-0123456789abcdef0-enc0123456789abcdef",
+		Digest:      "SHA384",
+		Timestamp:   uint64(notBefore.UnixMilli()),
+		PCRs:        pcrs,
+		Certificate: leaf.Raw,
+		CABundle:    [][]byte{root.Raw, intermediate.Raw},
+		PublicKey:   publicKey,
+	})
+	check(err)
+
+	// ES384
+	protected, err := cbor.Marshal(map[int]int{1: -35})
+	check(err)
+
+	signed, err := cbor.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+	check(err)
+
+	digest := sha512.Sum384(signed)
+
+	r, s, err := ecdsa.Sign(rand.Reader, leafKey, digest[:])
+	check(err)
+
+	signature := make([]byte, 96)
+	r.FillBytes(signature[:48])
+	s.FillBytes(signature[48:])
+
+	attestationDocument, err := cbor.Marshal([]interface{}{protected, map[int]int{}, payload, signature})
+	check(err)
+
+	//---
+
+	recipientKeyDer, err := x509.MarshalPKCS8PrivateKey(recipientKey)
+	check(err)
+
+	check(os.WriteFile("root.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0644))
+	check(os.WriteFile("recipient.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: recipientKeyDer}), 0644))
+	check(os.WriteFile("attestation-document.cbor", attestationDocument, 0644))
+}
+
+func certificate(name string, ca bool, notBefore, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	check(err)
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	check(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+
+	if ca {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	// The root is self-signed
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	check(err)
+
+	c, err := x509.ParseCertificate(der)
+	check(err)
+
+	return c, key
+}
+
+func check(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "context"
+
+type contextKey struct{}
+
+/*
+Returns a copy of the context carrying the credential the request was verified with.
+*/
+func WithCredential(ctx context.Context, c *Credential) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+/*
+Returns the credential the request was verified with, if any.
+*/
+func CredentialFrom(ctx context.Context) (*Credential, bool) {
+	c, ok := ctx.Value(contextKey{}).(*Credential)
+	return c, ok && c != nil
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+var accountIdPattern = regexp.MustCompile(`^\d{12}$`)
+
+/*
+An access key, and the identity requests signed with it are made as.
+*/
+type Credential struct {
+	AccessKeyId     string `yaml:"AccessKeyId"`
+	SecretAccessKey string `yaml:"SecretAccessKey"`
+	AccountId       string `yaml:"AccountId"`
+	Principal       string `yaml:"Principal"`
+
+	// Set for temporary credentials. Requests must then carry it in their X-Amz-Security-Token header.
+	SessionToken string `yaml:"SessionToken"`
+}
+
+type Registry struct {
+	credentials map[string]*Credential
+}
+
+/*
+Loads a credential registry from a YAML file of the format:
+
+	Credentials:
+	  - AccessKeyId: AKIAEXAMPLE
+	    SecretAccessKey: secret
+	    AccountId: "111122223333"
+	    Principal: arn:aws:iam::111122223333:role/Example
+	    SessionToken: token
+
+Principal defaults to the account's root principal. SessionToken is only set for temporary credentials.
+*/
+func LoadRegistry(path string) (*Registry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var input struct {
+		Credentials []*Credential `yaml:"Credentials"`
+	}
+
+	if err := yaml.UnmarshalStrict(content, &input); err != nil {
+		return nil, err
+	}
+
+	registry := &Registry{
+		credentials: make(map[string]*Credential, len(input.Credentials)),
+	}
+
+	for i, c := range input.Credentials {
+		if c.AccessKeyId == "" || c.SecretAccessKey == "" {
+			return nil, fmt.Errorf("credential %d must have an AccessKeyId and SecretAccessKey", i+1)
+		}
+
+		if !accountIdPattern.MatchString(c.AccountId) {
+			return nil, fmt.Errorf("credential %s must have a 12 digit AccountId", c.AccessKeyId)
+		}
+
+		if c.Principal == "" {
+			c.Principal = "arn:aws:iam::" + c.AccountId + ":root"
+		}
+
+		if _, exists := registry.credentials[c.AccessKeyId]; exists {
+			return nil, fmt.Errorf("credential %s is defined more than once", c.AccessKeyId)
+		}
+
+		registry.credentials[c.AccessKeyId] = c
+	}
+
+	if len(registry.credentials) == 0 {
+		return nil, errors.New("no credentials found")
+	}
+
+	return registry, nil
+}
+
+func (r *Registry) Lookup(accessKeyId string) (*Credential, bool) {
+	c, ok := r.credentials[accessKeyId]
+	return c, ok
+}
+
+func (r *Registry) Len() int {
+	return len(r.credentials)
+}
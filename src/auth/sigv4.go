@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm     = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+	maxClockSkew  = 15 * time.Minute
+)
+
+// Replaced in tests, so requests can be verified against a fixed time.
+var now = time.Now
+
+/*
+Returned when a request fails verification. Type is the name of the exception returned to the client.
+*/
+type Error struct {
+	Type    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+func newError(exception, format string, a ...interface{}) *Error {
+	return &Error{exception, fmt.Sprintf(format, a...)}
+}
+
+//------------------------------------------
+
+/*
+Verifies the AWS Signature Version 4 of the request, returning the credential it was signed with.
+
+	The request's credential scope must be for the given service, in a region for which validRegion returns true.
+	The request body is read in full, and replaced, so it can still be read by the caller.
+*/
+func (reg *Registry) Verify(r *http.Request, expectedService string, validRegion func(string) bool) (*Credential, error) {
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, newError("MissingAuthenticationTokenException", "Missing Authentication Token")
+	}
+
+	if !strings.HasPrefix(header, algorithm+" ") {
+		return nil, newError("IncompleteSignatureException", "Unsupported AWS 'algorithm': '%s'.", strings.SplitN(header, " ", 2)[0])
+	}
+
+	//---
+	// Split the header into its Credential, SignedHeaders and Signature components
+
+	components := map[string]string{}
+	for _, c := range strings.Split(strings.TrimPrefix(header, algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(c), "=", 2)
+		if len(kv) == 2 {
+			components[kv[0]] = kv[1]
+		}
+	}
+
+	for _, name := range []string{"Credential", "SignedHeaders", "Signature"} {
+		if components[name] == "" {
+			return nil, newError("IncompleteSignatureException",
+				"Authorization header requires '%s' parameter. Authorization=%s", name, header)
+		}
+	}
+
+	//---
+	// Check the credential scope
+
+	scope := strings.Split(components["Credential"], "/")
+	if len(scope) != 5 || scope[4] != "aws4_request" {
+		return nil, newError("IncompleteSignatureException",
+			"Credential should be scoped to a valid date, region, service and terminator. Credential=%s", components["Credential"])
+	}
+
+	accessKeyId, scopeDate, region, service := scope[0], scope[1], scope[2], scope[3]
+
+	if service != expectedService {
+		return nil, newError("InvalidSignatureException", "Credential should be scoped to correct service: '%s'.", expectedService)
+	}
+
+	if !validRegion(region) {
+		return nil, newError("InvalidSignatureException", "Credential should be scoped to a valid region, not '%s'.", region)
+	}
+
+	credential, ok := reg.Lookup(accessKeyId)
+	if !ok {
+		return nil, newError("UnrecognizedClientException", "The security token included in the request is invalid.")
+	}
+
+	// Temporary credentials must be used with their session token; other credentials without one.
+	token := r.Header.Get("X-Amz-Security-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(credential.SessionToken)) != 1 {
+		return nil, newError("UnrecognizedClientException", "The security token included in the request is invalid.")
+	}
+
+	//---
+	// Check the request date
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		return nil, newError("IncompleteSignatureException",
+			"Authorization header requires existence of a 'X-Amz-Date' header.")
+	}
+
+	date, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return nil, newError("IncompleteSignatureException", "Unable to parse X-Amz-Date '%s'.", amzDate)
+	}
+
+	if scopeDate != amzDate[:8] {
+		return nil, newError("InvalidSignatureException",
+			"Date in Credential scope does not match YYYYMMDD from ISO-8601 version of date from HTTP: '%s' != '%s'",
+			scopeDate, amzDate[:8])
+	}
+
+	if skew := now().Sub(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, newError("InvalidSignatureException",
+			"Signature expired: %s is now outside the permitted %s of %s.",
+			amzDate, maxClockSkew, now().UTC().Format(amzDateFormat))
+	}
+
+	//---
+	// Hash the payload
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	payloadHash := sha256Hex(body)
+
+	if h := r.Header.Get("X-Amz-Content-Sha256"); h != "" && h != "UNSIGNED-PAYLOAD" {
+		if h != payloadHash {
+			return nil, newError("InvalidSignatureException",
+				"The provided 'x-amz-content-sha256' header does not match what was computed.")
+		}
+	}
+
+	//---
+	// Calculate the expected signature
+
+	signedHeaders := strings.Split(components["SignedHeaders"], ";")
+
+	if !containsString(signedHeaders, "host") {
+		return nil, newError("InvalidSignatureException", "'Host' must be a 'SignedHeader' in the AWS Authorization.")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		canonicalUri(r),
+		canonicalQuery(r),
+		canonicalHeaders(r, signedHeaders),
+		components["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		strings.Join(scope[1:], "/"),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+credential.SecretAccessKey), []byte(scopeDate))
+	key = hmacSha256(key, []byte(region))
+	key = hmacSha256(key, []byte(service))
+	key = hmacSha256(key, []byte("aws4_request"))
+
+	expected := hex.EncodeToString(hmacSha256(key, []byte(stringToSign)))
+
+	if !hmac.Equal([]byte(expected), []byte(components["Signature"])) {
+		return nil, newError("InvalidSignatureException",
+			"The request signature we calculated does not match the signature you provided. Check your AWS "+
+				"Secret Access Key and signing method. Consult the service documentation for details.")
+	}
+
+	return credential, nil
+}
+
+//------------------------------------------
+
+/*
+As with all services other than S3, each segment of the already escaped path is URI encoded a second time.
+*/
+func canonicalUri(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = uriEncode(s)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(r *http.Request) string {
+	query := r.URL.Query()
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	var b strings.Builder
+
+	for _, name := range signedHeaders {
+		var values []string
+
+		switch name {
+		case "host":
+			// Go moves the Host header out of the header map.
+			values = []string{r.Host}
+		default:
+			values = append([]string{}, r.Header.Values(textproto.CanonicalMIMEHeaderKey(name))...)
+			if name == "content-length" && len(values) == 0 && r.ContentLength >= 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		}
+
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	return b.String()
+}
+
+/*
+URI encodes every byte except the unreserved characters: A-Z, a-z, 0-9, '-', '.', '_' and '~'.
+*/
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSha256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
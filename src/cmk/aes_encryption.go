@@ -16,7 +16,7 @@ func (k *AesKey) Decrypt(version uint32, ciphertext []byte, context map[string]*
 		return
 	}
 
-	key := k.BackingKeys[version]
+	key := k.BackingKeys[version].Key
 
 	//---
 
@@ -49,7 +49,7 @@ func (k *AesKey) Decrypt(version uint32, ciphertext []byte, context map[string]*
 func (k *AesKey) EncryptAndPackage(plaintext []byte, context map[string]*string) (result []byte, err error) {
 
 	keyVersion := len(k.BackingKeys) - 1
-	dataKey := k.BackingKeys[keyVersion]
+	dataKey := k.BackingKeys[keyVersion].Key
 
 	//----------------------------
 	// Encrypt
@@ -2,6 +2,7 @@ package cmk
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -11,18 +12,50 @@ import (
 
 type AesKey struct {
 	BaseKey
-	BackingKeys         [][32]byte
-	NextKeyRotation     time.Time
-	ParametersForImport ParametersForImport
+	BackingKeys          []BackingKey
+	NextKeyRotation      time.Time
+	RotationPeriodInDays int `json:",omitempty"`
+	ParametersForImport  ParametersForImport
 }
 
+/*
+A version of the key's material. Versions after the first were created by a rotation.
+*/
+type BackingKey struct {
+	Key          [32]byte
+	RotationDate int64        `json:",omitempty"`
+	RotationType RotationType `json:",omitempty"`
+}
+
+// Backing keys were originally stored as just the 32 byte key.
+func (b *BackingKey) UnmarshalJSON(data []byte) error {
+	var key [32]byte
+	if err := json.Unmarshal(data, &key); err == nil {
+		b.Key = key
+		return nil
+	}
+
+	type backingKey BackingKey
+	return json.Unmarshal(data, (*backingKey)(b))
+}
+
+const (
+	DefaultRotationPeriodInDays = 365
+	MinRotationPeriodInDays     = 90
+	MaxRotationPeriodInDays     = 2560
+
+	// The maximum number of on-demand rotations per key
+	MaxOnDemandRotations = 10
+)
+
 func NewAesKey(metadata KeyMetadata, policy string, origin KeyOrigin) *AesKey {
 	k := &AesKey{
-		BackingKeys: [][32]byte{},
+		BackingKeys: []BackingKey{},
 	}
 
-	if origin != KeyOriginExternal {
-		k.BackingKeys = append(k.BackingKeys, generateKey())
+	// Key material for EXTERNAL keys is imported, and for AWS_CLOUDHSM keys comes from the cluster.
+	if origin != KeyOriginExternal && origin != KeyOriginAwsCloudHsm {
+		k.BackingKeys = append(k.BackingKeys, BackingKey{Key: generateKey()})
 	}
 
 	k.Type = TypeAes
@@ -77,9 +110,9 @@ func (k *AesKey) ImportKeyMaterial(m []byte) error {
 
 	// If this is the first time we're importing key material then we're all good
 	if len(k.BackingKeys) == 0 {
-		k.BackingKeys = append(k.BackingKeys, key)
+		k.BackingKeys = append(k.BackingKeys, BackingKey{Key: key})
 
-	} else if key != k.BackingKeys[0] {
+	} else if key != k.BackingKeys[0].Key {
 		// else if the key material doesn't match what was already imported then
 		// throw and error
 		return errors.New("Key material does not match existing key material.")
@@ -88,14 +121,17 @@ func (k *AesKey) ImportKeyMaterial(m []byte) error {
 	return nil
 }
 
-func (k *AesKey) RotateIfNeeded() bool {
+/*
+Rotates the key if automatic rotation is enabled, and was due before now.
+*/
+func (k *AesKey) RotateIfNeeded(now time.Time) bool {
 
-	if !k.NextKeyRotation.IsZero() && k.NextKeyRotation.Before(time.Now()) {
+	if !k.NextKeyRotation.IsZero() && k.NextKeyRotation.Before(now) {
 
-		k.BackingKeys = append(k.BackingKeys, generateKey())
+		k.rotate(RotationTypeAutomatic, now)
 
 		// Reset the rotation timer
-		k.NextKeyRotation = time.Now().AddDate(1, 0, 0)
+		k.NextKeyRotation = now.AddDate(0, 0, k.GetRotationPeriodInDays())
 
 		// The key did rotate
 		return true
@@ -105,6 +141,58 @@ func (k *AesKey) RotateIfNeeded() bool {
 	return false
 }
 
+/*
+Rotates the key immediately. The schedule of automatic rotations is not affected.
+*/
+func (k *AesKey) RotateOnDemand() error {
+	if k.CountRotations(RotationTypeOnDemand) >= MaxOnDemandRotations {
+		return &RotationLimitExceeded{}
+	}
+
+	k.rotate(RotationTypeOnDemand, time.Now())
+	return nil
+}
+
+func (k *AesKey) rotate(rotationType RotationType, now time.Time) {
+	k.BackingKeys = append(k.BackingKeys, BackingKey{
+		Key:          generateKey(),
+		RotationDate: now.Unix(),
+		RotationType: rotationType,
+	})
+}
+
+func (k *AesKey) CountRotations(rotationType RotationType) int {
+	count := 0
+	for _, b := range k.BackingKeys {
+		if b.RotationType == rotationType {
+			count++
+		}
+	}
+	return count
+}
+
+/*
+Returns the period between automatic rotations, defaulting to a year.
+*/
+func (k *AesKey) GetRotationPeriodInDays() int {
+	if k.RotationPeriodInDays == 0 {
+		return DefaultRotationPeriodInDays
+	}
+	return k.RotationPeriodInDays
+}
+
+/*
+Returns the date of the key's most recent rotation, or its creation date if it's never been rotated.
+*/
+func (k *AesKey) LastRotationDate() time.Time {
+	for i := len(k.BackingKeys) - 1; i >= 0; i-- {
+		if k.BackingKeys[i].RotationDate != 0 {
+			return time.Unix(k.BackingKeys[i].RotationDate, 0)
+		}
+	}
+	return time.Unix(k.Metadata.CreationDate, 0)
+}
+
 //-----------------------
 
 /*
@@ -129,6 +217,8 @@ func (k *AesKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		Metadata        KeyMetadata `yaml:"Metadata"`
 		BackingKeys     []string    `yaml:"BackingKeys"`
 		NextKeyRotation time.Time   `yaml:"NextKeyRotation"`
+
+		RotationPeriodInDays int `yaml:"RotationPeriodInDays"`
 	}
 
 	yk := YamlKey{}
@@ -141,6 +231,14 @@ func (k *AesKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	defaultSeededKeyMetadata(&k.Metadata)
 	k.NextKeyRotation = yk.NextKeyRotation
 
+	if yk.RotationPeriodInDays != 0 &&
+		(yk.RotationPeriodInDays < MinRotationPeriodInDays || yk.RotationPeriodInDays > MaxRotationPeriodInDays) {
+		return &UnmarshalYAMLError{
+			fmt.Sprintf("RotationPeriodInDays must be between %d and %d", MinRotationPeriodInDays, MaxRotationPeriodInDays),
+		}
+	}
+	k.RotationPeriodInDays = yk.RotationPeriodInDays
+
 	//-------------------------
 	// Decode backing keys
 
@@ -157,7 +255,7 @@ func (k *AesKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return &UnmarshalYAMLError{"At least one backing key must be supplied"}
 	}
 
-	k.BackingKeys = make([][32]byte, len(yk.BackingKeys))
+	k.BackingKeys = make([]BackingKey, len(yk.BackingKeys))
 
 	for i, keyStr := range yk.BackingKeys {
 
@@ -174,7 +272,7 @@ func (k *AesKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			}
 		}
 
-		copy(k.BackingKeys[i][:], keyBytes[:])
+		copy(k.BackingKeys[i].Key[:], keyBytes[:])
 	}
 	k.Metadata.KeyUsage = UsageEncryptDecrypt
 
@@ -0,0 +1,176 @@
+package cmk
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAesKey() *AesKey {
+	return NewAesKey(KeyMetadata{
+		Arn:          "arn:aws:kms:eu-west-2:111122223333:key/5e0ab35c-2d76-4a8b-a3a4-5ea2b41e3e4b",
+		CreationDate: time.Now().Add(-48 * time.Hour).Unix(),
+	}, "", KeyOriginAwsKms)
+}
+
+func TestBackingKeyUnmarshalLegacy(t *testing.T) {
+	var legacy [32]byte
+	for i := range legacy {
+		legacy[i] = byte(i)
+	}
+
+	// Keys stored before rotation types were recorded hold each backing key as a bare [32]byte array.
+	encoded, _ := json.Marshal(legacy)
+	record := `{"BackingKeys":[` + string(encoded) + `,{"Key":` + string(encoded) +
+		`,"RotationDate":1700000000,"RotationType":"ON_DEMAND"}]}`
+
+	var key AesKey
+	if err := json.Unmarshal([]byte(record), &key); err != nil {
+		t.Fatalf("unable to decode the key: %s", err)
+	}
+
+	if len(key.BackingKeys) != 2 {
+		t.Fatalf("expected 2 backing keys, got %d", len(key.BackingKeys))
+	}
+
+	if key.BackingKeys[0].Key != legacy || key.BackingKeys[0].RotationDate != 0 || key.BackingKeys[0].RotationType != "" {
+		t.Errorf("legacy backing key decoded incorrectly: %+v", key.BackingKeys[0])
+	}
+
+	if key.BackingKeys[1].Key != legacy || key.BackingKeys[1].RotationDate != 1700000000 ||
+		key.BackingKeys[1].RotationType != RotationTypeOnDemand {
+		t.Errorf("backing key decoded incorrectly: %+v", key.BackingKeys[1])
+	}
+}
+
+func TestBackingKeyRoundTrip(t *testing.T) {
+	key := newTestAesKey()
+	if err := key.RotateOnDemand(); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded AesKey
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.BackingKeys) != 2 {
+		t.Fatalf("expected 2 backing keys, got %d", len(decoded.BackingKeys))
+	}
+	for i := range key.BackingKeys {
+		if decoded.BackingKeys[i] != key.BackingKeys[i] {
+			t.Errorf("backing key %d changed: %+v != %+v", i, decoded.BackingKeys[i], key.BackingKeys[i])
+		}
+	}
+
+	// The original material has no rotation date, so it's omitted
+	if !bytes.Contains(encoded, []byte(`"BackingKeys":[{"Key":`)) || strings.Count(string(encoded), "RotationDate") != 1 {
+		t.Errorf("unexpected encoding: %s", encoded)
+	}
+}
+
+func TestBackingKeyUnmarshalInvalid(t *testing.T) {
+	var b BackingKey
+	if err := json.Unmarshal([]byte(`"not a key"`), &b); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestRotateOnDemand(t *testing.T) {
+	key := newTestAesKey()
+	original := key.BackingKeys[0].Key
+
+	for i := 0; i < MaxOnDemandRotations; i++ {
+		if err := key.RotateOnDemand(); err != nil {
+			t.Fatalf("rotation %d failed: %s", i+1, err)
+		}
+	}
+
+	if _, ok := key.RotateOnDemand().(*RotationLimitExceeded); !ok {
+		t.Errorf("expected the rotation limit to be exceeded")
+	}
+
+	if len(key.BackingKeys) != MaxOnDemandRotations+1 {
+		t.Errorf("expected %d backing keys, got %d", MaxOnDemandRotations+1, len(key.BackingKeys))
+	}
+
+	if key.BackingKeys[0].Key != original {
+		t.Errorf("the original key material changed")
+	}
+
+	if n := key.CountRotations(RotationTypeOnDemand); n != MaxOnDemandRotations {
+		t.Errorf("expected %d on-demand rotations, got %d", MaxOnDemandRotations, n)
+	}
+
+	if !key.NextKeyRotation.IsZero() {
+		t.Errorf("on-demand rotations shouldn't enable automatic rotation")
+	}
+}
+
+func TestRotateIfNeeded(t *testing.T) {
+	key := newTestAesKey()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Rotation isn't enabled
+	if key.RotateIfNeeded(now) {
+		t.Errorf("expected no rotation")
+	}
+
+	// Not yet due
+	key.RotationPeriodInDays = 90
+	key.NextKeyRotation = now.Add(time.Second)
+	if key.RotateIfNeeded(now) {
+		t.Errorf("expected no rotation")
+	}
+
+	// Due
+	if !key.RotateIfNeeded(now.Add(2 * time.Second)) {
+		t.Fatalf("expected a rotation")
+	}
+
+	if len(key.BackingKeys) != 2 || key.BackingKeys[1].RotationType != RotationTypeAutomatic {
+		t.Fatalf("expected an automatic rotation, got %+v", key.BackingKeys)
+	}
+
+	if key.BackingKeys[0].Key == key.BackingKeys[1].Key {
+		t.Errorf("expected new key material")
+	}
+
+	if !key.LastRotationDate().Equal(now.Add(2 * time.Second)) {
+		t.Errorf("expected the last rotation at %s, got %s", now.Add(2*time.Second), key.LastRotationDate())
+	}
+
+	if expected := now.Add(2*time.Second).AddDate(0, 0, 90); !key.NextKeyRotation.Equal(expected) {
+		t.Errorf("expected the next rotation at %s, got %s", expected, key.NextKeyRotation)
+	}
+
+	// Not due again until then
+	if key.RotateIfNeeded(now.AddDate(0, 0, 89)) {
+		t.Errorf("expected no rotation")
+	}
+}
+
+func TestRotationPeriod(t *testing.T) {
+	key := newTestAesKey()
+
+	if key.GetRotationPeriodInDays() != DefaultRotationPeriodInDays {
+		t.Errorf("expected the default period, got %d", key.GetRotationPeriodInDays())
+	}
+
+	key.RotationPeriodInDays = MinRotationPeriodInDays
+	if key.GetRotationPeriodInDays() != MinRotationPeriodInDays {
+		t.Errorf("expected %d, got %d", MinRotationPeriodInDays, key.GetRotationPeriodInDays())
+	}
+
+	// A key that's never rotated was last rotated when it was created
+	if key.LastRotationDate().Unix() != key.Metadata.CreationDate {
+		t.Errorf("expected the creation date, got %s", key.LastRotationDate())
+	}
+}
@@ -1,6 +1,7 @@
 package cmk
 
 import (
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -11,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec/v2"
+	kmsx509 "github.com/nsmithuk/local-kms/src/x509"
 	"math/big"
 )
 
@@ -19,51 +21,55 @@ type EcdsaPrivateKey ecdsa.PrivateKey
 
 type EccKey struct {
 	BaseKey
-	PrivateKey EcdsaPrivateKey
+	PrivateKey          EcdsaPrivateKey
+	ParametersForImport ParametersForImport
 }
 
 type ecdsaSignature struct {
 	R, S *big.Int
 }
 
-func NewEccKey(spec KeySpec, metadata KeyMetadata, policy string) (*EccKey, error) {
+func NewEccKey(spec KeySpec, usage KeyUsage, metadata KeyMetadata, policy string, origin KeyOrigin) (*EccKey, error) {
 
-	var curve elliptic.Curve
-
-	switch spec {
-	case SpecEccNistP256:
-		curve = elliptic.P256()
-	case SpecEccNistP384:
-		curve = elliptic.P384()
-	case SpecEccNistP521:
-		curve = elliptic.P521()
-	case SpecEccSecp256k1:
-		curve = btcec.S256()
-	default:
+	curve, ok := eccCurve(spec)
+	if !ok {
 		return nil, errors.New("key spec error")
 	}
 
-	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
-	if err != nil {
-		return nil, err
-	}
+	k := &EccKey{}
 
-	//---
+	// Keys with an EXTERNAL origin have their key material imported later
+	if origin != KeyOriginExternal {
+		privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
 
-	k := &EccKey{
-		PrivateKey: EcdsaPrivateKey(*privateKey),
+		k.PrivateKey = EcdsaPrivateKey(*privateKey)
 	}
 
+	//---
+
 	k.Type = TypeEcc
 	k.Metadata = metadata
 	k.Policy = policy
 
 	//---
 
-	k.Metadata.KeyUsage = UsageSignVerify
+	k.Metadata.KeyUsage = usage
 	k.Metadata.KeySpec = spec
 	k.Metadata.CustomerMasterKeySpec = spec
 
+	if usage == UsageKeyAgreement {
+		// Key agreement is only supported with the NIST curves
+		if spec == SpecEccSecp256k1 {
+			return nil, errors.New("key usage error")
+		}
+
+		k.Metadata.KeyAgreementAlgorithms = []KeyAgreementAlgorithm{KeyAgreementAlgorithmEcdh}
+		return k, nil
+	}
+
 	switch spec {
 	case SpecEccNistP256:
 		k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEcdsaSha256}
@@ -100,6 +106,60 @@ func (k *EccKey) GetMetadata() *KeyMetadata {
 
 //----------------------------------------------------
 
+func (k *EccKey) GetParametersForImport() *ParametersForImport {
+	return &k.ParametersForImport
+}
+
+func (k *EccKey) SetParametersForImport(p *ParametersForImport) {
+	k.ParametersForImport = *p
+}
+
+/*
+Imports an EC private key, in DER encoded PKCS8 format.
+*/
+func (k *EccKey) ImportKeyMaterial(m []byte) error {
+
+	// The local x509 package is used as it supports secp256k1
+	parseResult, err := kmsx509.ParsePKCS8PrivateKey(m)
+	if err != nil {
+		return errors.New("Key material must be a DER encoded PKCS8 private key.")
+	}
+
+	key, ok := parseResult.(*ecdsa.PrivateKey)
+	if !ok {
+		return errors.New("Key material is not an EC private key.")
+	}
+
+	if curve, _ := eccCurve(k.Metadata.KeySpec); key.Curve != curve {
+		return fmt.Errorf("Key material must be an EC private key on curve %s.", curve.Params().Name)
+	}
+
+	// If key material was already imported, it must be the same key material
+	if k.PrivateKey.D != nil && key.D.Cmp(k.PrivateKey.D) != 0 {
+		return errors.New("Key material does not match existing key material.")
+	}
+
+	k.PrivateKey = EcdsaPrivateKey(*key)
+
+	return nil
+}
+
+func eccCurve(spec KeySpec) (elliptic.Curve, bool) {
+	switch spec {
+	case SpecEccNistP256:
+		return elliptic.P256(), true
+	case SpecEccNistP384:
+		return elliptic.P384(), true
+	case SpecEccNistP521:
+		return elliptic.P521(), true
+	case SpecEccSecp256k1:
+		return btcec.S256(), true
+	}
+	return nil, false
+}
+
+//----------------------------------------------------
+
 func (k *EccKey) Sign(digest []byte, algorithm SigningAlgorithm) ([]byte, error) {
 
 	//--------------------------
@@ -190,6 +250,63 @@ func (k *EccKey) HashAndVerify(signature []byte, message []byte, algorithm Signi
 
 //----------------------------------------------------
 
+/*
+Derives a shared secret from the key's private key and the passed DER encoded (SubjectPublicKeyInfo) public key,
+which must be on the same curve. The secret is the raw ECDH output; no key derivation function is applied.
+*/
+func (k *EccKey) DeriveSharedSecret(publicKey []byte, algorithm KeyAgreementAlgorithm) ([]byte, error) {
+
+	if k.Metadata.KeyUsage != UsageKeyAgreement || algorithm != KeyAgreementAlgorithmEcdh {
+		return []byte{}, &InvalidKeyAgreementAlgorithm{}
+	}
+
+	peerKey, err := k.parsePeerPublicKey(publicKey)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	//---
+
+	key := ecdsa.PrivateKey(k.PrivateKey)
+
+	privateKey, err := key.ECDH()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return privateKey.ECDH(peerKey)
+}
+
+/*
+Returns an InvalidPublicKey error if a shared secret can't be derived with the passed public key.
+*/
+func (k *EccKey) CheckPublicKey(publicKey []byte) error {
+	_, err := k.parsePeerPublicKey(publicKey)
+	return err
+}
+
+func (k *EccKey) parsePeerPublicKey(publicKey []byte) (*ecdh.PublicKey, error) {
+
+	parsed, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, &InvalidPublicKey{"the public key is not a valid DER-encoded X.509 public key"}
+	}
+
+	peer, ok := parsed.(*ecdsa.PublicKey)
+	if !ok || peer.Curve != k.PrivateKey.Curve {
+		return nil, &InvalidPublicKey{fmt.Sprintf("the public key must be on curve %s", k.PrivateKey.Curve.Params().Name)}
+	}
+
+	peerKey, err := peer.ECDH()
+	if err != nil {
+		return nil, &InvalidPublicKey{err.Error()}
+	}
+
+	return peerKey, nil
+}
+
+//----------------------------------------------------
+
 type eccKeyMarshaledJSON struct {
 	D, X, Y   *big.Int
 	CurveType string
@@ -197,6 +314,11 @@ type eccKeyMarshaledJSON struct {
 
 func (k *EcdsaPrivateKey) MarshalJSON() ([]byte, error) {
 
+	// Keys pending the import of their key material have no curve
+	if k.Curve == nil {
+		return json.Marshal(&eccKeyMarshaledJSON{})
+	}
+
 	return json.Marshal(&eccKeyMarshaledJSON{
 		D:         k.D,
 		X:         k.X,
@@ -219,7 +341,13 @@ func (k *EcdsaPrivateKey) UnmarshalJSON(data []byte) error {
 	}
 
 	var pk ecdsa.PrivateKey
-	if marshaledKey.CurveType != "" {
+	if marshaledKey.D == nil {
+		// Keys pending the import of their key material
+
+		*k = EcdsaPrivateKey(pk)
+		return nil
+
+	} else if marshaledKey.CurveType != "" {
 		// Keys generated with Go 1.20 and after
 
 		pk.D = marshaledKey.D
@@ -304,13 +432,10 @@ func (k *EccKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	switch bitLen {
 	case 256:
 		k.Metadata.KeySpec = SpecEccNistP256
-		k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEcdsaSha256}
 	case 384:
 		k.Metadata.KeySpec = SpecEccNistP384
-		k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEcdsaSha384}
 	case 521:
 		k.Metadata.KeySpec = SpecEccNistP521
-		k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEcdsaSha512}
 	default:
 		return &UnmarshalYAMLError{
 			fmt.Sprintf(
@@ -321,10 +446,22 @@ func (k *EccKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	k.Metadata.CustomerMasterKeySpec = k.Metadata.KeySpec
 
-	if k.Metadata.KeyUsage != UsageSignVerify {
+	switch k.Metadata.KeyUsage {
+	case UsageSignVerify:
+		switch k.Metadata.KeySpec {
+		case SpecEccNistP256:
+			k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEcdsaSha256}
+		case SpecEccNistP384:
+			k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEcdsaSha384}
+		case SpecEccNistP521:
+			k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEcdsaSha512}
+		}
+	case UsageKeyAgreement:
+		k.Metadata.KeyAgreementAlgorithms = []KeyAgreementAlgorithm{KeyAgreementAlgorithmEcdh}
+	default:
 		return &UnmarshalYAMLError{
 			fmt.Sprintf(
-				"Only KeyUsage of (%s) supported for EC keys.\n", UsageSignVerify),
+				"Only KeyUsage of (%s, %s) supported for EC keys.\n", UsageSignVerify, UsageKeyAgreement),
 		}
 	}
 	return nil
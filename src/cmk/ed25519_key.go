@@ -0,0 +1,190 @@
+package cmk
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+type Ed25519Key struct {
+	BaseKey
+	PrivateKey ed25519.PrivateKey
+}
+
+func NewEd25519Key(metadata KeyMetadata, policy string) (*Ed25519Key, error) {
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	//---
+
+	k := &Ed25519Key{
+		PrivateKey: privateKey,
+	}
+
+	k.Type = TypeEd25519
+	k.Metadata = metadata
+	k.Policy = policy
+
+	//---
+
+	k.Metadata.KeyUsage = UsageSignVerify
+	k.Metadata.KeySpec = SpecEccEd25519
+	k.Metadata.CustomerMasterKeySpec = SpecEccEd25519
+	k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEd25519Sha512, SigningAlgorithmEd25519PhSha512}
+
+	return k, nil
+}
+
+//----------------------------------------------------
+
+func (k *Ed25519Key) GetArn() string {
+	return k.GetMetadata().Arn
+}
+
+func (k *Ed25519Key) GetPolicy() string {
+	return k.Policy
+}
+
+func (k *Ed25519Key) GetKeyType() KeyType {
+	return k.Type
+}
+
+func (k *Ed25519Key) GetMetadata() *KeyMetadata {
+	return &k.Metadata
+}
+
+//----------------------------------------------------
+
+/*
+Signs a SHA-512 digest, using Ed25519ph. Pure Ed25519 (ED25519_SHA_512) signs the full message, so cannot be used with a digest.
+*/
+func (k *Ed25519Key) Sign(digest []byte, algorithm SigningAlgorithm) ([]byte, error) {
+
+	if err := k.checkMessageType(algorithm, true); err != nil {
+		return []byte{}, err
+	}
+
+	if len(digest) != sha512.Size {
+		return []byte{}, &InvalidDigestLength{}
+	}
+
+	return k.PrivateKey.Sign(nil, digest, &ed25519.Options{Hash: crypto.SHA512})
+}
+
+/*
+Signs the raw message, using pure Ed25519. Ed25519ph (ED25519_PH_SHA_512) is only supported with a digest.
+*/
+func (k *Ed25519Key) HashAndSign(message []byte, algorithm SigningAlgorithm) ([]byte, error) {
+
+	if err := k.checkMessageType(algorithm, false); err != nil {
+		return []byte{}, err
+	}
+
+	return ed25519.Sign(k.PrivateKey, message), nil
+}
+
+//----------------------------------------------------
+
+func (k *Ed25519Key) Verify(signature []byte, digest []byte, algorithm SigningAlgorithm) (bool, error) {
+
+	if err := k.checkMessageType(algorithm, true); err != nil {
+		return false, err
+	}
+
+	if len(digest) != sha512.Size {
+		return false, &InvalidDigestLength{}
+	}
+
+	publicKey := k.PrivateKey.Public().(ed25519.PublicKey)
+
+	err := ed25519.VerifyWithOptions(publicKey, digest, signature, &ed25519.Options{Hash: crypto.SHA512})
+
+	return err == nil, nil
+}
+
+func (k *Ed25519Key) HashAndVerify(signature []byte, message []byte, algorithm SigningAlgorithm) (bool, error) {
+
+	if err := k.checkMessageType(algorithm, false); err != nil {
+		return false, err
+	}
+
+	publicKey := k.PrivateKey.Public().(ed25519.PublicKey)
+
+	return ed25519.Verify(publicKey, message, signature), nil
+}
+
+/*
+ED25519_SHA_512 requires a message type of RAW, and ED25519_PH_SHA_512 a message type of DIGEST.
+*/
+func (k *Ed25519Key) checkMessageType(algorithm SigningAlgorithm, digest bool) error {
+	switch algorithm {
+	case SigningAlgorithmEd25519Sha512:
+		if digest {
+			return &InvalidMessageType{fmt.Sprintf("%s signing algorithm requires MessageType RAW", algorithm)}
+		}
+	case SigningAlgorithmEd25519PhSha512:
+		if !digest {
+			return &InvalidMessageType{fmt.Sprintf("%s signing algorithm requires MessageType DIGEST", algorithm)}
+		}
+	default:
+		return &InvalidSigningAlgorithm{}
+	}
+	return nil
+}
+
+// ----------------------------------------------------
+// Construct key from YAML (seeding)
+// ---
+func (k *Ed25519Key) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	// Cannot use embedded 'Key' struct
+	// https://github.com/go-yaml/yaml/issues/263
+	type YamlKey struct {
+		Metadata      KeyMetadata `yaml:"Metadata"`
+		PrivateKeyPem string      `yaml:"PrivateKeyPem"`
+	}
+
+	yk := YamlKey{}
+	if err := unmarshal(&yk); err != nil {
+		return &UnmarshalYAMLError{err.Error()}
+	}
+
+	k.Type = TypeEd25519
+	k.Metadata = yk.Metadata
+	defaultSeededKeyMetadata(&k.Metadata)
+	pemDecoded, _ := pem.Decode([]byte(yk.PrivateKeyPem))
+	if pemDecoded == nil {
+		return &UnmarshalYAMLError{fmt.Sprintf("Unable to decode pem of key %s check the YAML.\n", k.Metadata.KeyId)}
+	}
+
+	parseResult, pkcsParseError := x509.ParsePKCS8PrivateKey(pemDecoded.Bytes)
+	if pkcsParseError != nil {
+		return &UnmarshalYAMLError{fmt.Sprintf("Unable to decode pem of key %s, Ensure it is in PKCS8 format with no password: %s.\n", k.Metadata.KeyId, pkcsParseError)}
+	}
+
+	privateKey, ok := parseResult.(ed25519.PrivateKey)
+	if !ok {
+		return &UnmarshalYAMLError{fmt.Sprintf("Key %s is not an Ed25519 key.\n", k.Metadata.KeyId)}
+	}
+
+	k.PrivateKey = privateKey
+
+	k.Metadata.KeySpec = SpecEccEd25519
+	k.Metadata.CustomerMasterKeySpec = SpecEccEd25519
+	k.Metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmEd25519Sha512, SigningAlgorithmEd25519PhSha512}
+
+	if k.Metadata.KeyUsage != UsageSignVerify {
+		return &UnmarshalYAMLError{
+			fmt.Sprintf(
+				"Only KeyUsage of (%s) supported for Ed25519 keys.\n", UsageSignVerify),
+		}
+	}
+	return nil
+}
@@ -13,3 +13,55 @@ type InvalidDigestLength struct{}
 func (v *InvalidDigestLength) Error() string {
 	return "invalid digest length"
 }
+
+//---
+
+type InvalidMacAlgorithm struct{}
+
+func (v *InvalidMacAlgorithm) Error() string {
+	return "invalid mac algorithm"
+}
+
+//---
+
+type RotationLimitExceeded struct{}
+
+func (v *RotationLimitExceeded) Error() string {
+	return "on-demand rotation limit exceeded"
+}
+
+//---
+
+type InvalidKeyAgreementAlgorithm struct{}
+
+func (v *InvalidKeyAgreementAlgorithm) Error() string {
+	return "invalid key agreement algorithm"
+}
+
+//---
+
+type InvalidPublicKey struct {
+	message string
+}
+
+func (v *InvalidPublicKey) Error() string {
+	return v.message
+}
+
+//---
+
+type InvalidMessageType struct {
+	message string
+}
+
+func (v *InvalidMessageType) Error() string {
+	return v.message
+}
+
+//---
+
+type InvalidEncryptionAlgorithm struct{}
+
+func (v *InvalidEncryptionAlgorithm) Error() string {
+	return "invalid encryption algorithm"
+}
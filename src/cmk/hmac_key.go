@@ -0,0 +1,236 @@
+package cmk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/nsmithuk/local-kms/src/service"
+)
+
+type HmacKey struct {
+	BaseKey
+	BackingKey          []byte
+	ParametersForImport ParametersForImport
+}
+
+func NewHmacKey(spec KeySpec, metadata KeyMetadata, policy string, origin KeyOrigin) (*HmacKey, error) {
+
+	length, _, ok := hmacKeyLength(spec)
+	if !ok {
+		return nil, errors.New("key spec error")
+	}
+
+	k := &HmacKey{}
+
+	// Keys with an EXTERNAL origin have their key material imported later
+	if origin != KeyOriginExternal {
+		k.BackingKey = service.GenerateRandomData(uint16(length))
+	}
+
+	k.Type = TypeHmac
+	k.Metadata = metadata
+	k.Policy = policy
+
+	//---
+
+	k.Metadata.KeyUsage = UsageGenerateVerifyMac
+	k.Metadata.KeySpec = spec
+	k.Metadata.CustomerMasterKeySpec = spec
+	k.Metadata.MacAlgorithms = []MacAlgorithm{hmacAlgorithmForSpec(spec)}
+
+	return k, nil
+}
+
+//----------------------------------------------------
+
+func (k *HmacKey) GetArn() string {
+	return k.GetMetadata().Arn
+}
+
+func (k *HmacKey) GetPolicy() string {
+	return k.Policy
+}
+
+func (k *HmacKey) GetKeyType() KeyType {
+	return k.Type
+}
+
+func (k *HmacKey) GetMetadata() *KeyMetadata {
+	return &k.Metadata
+}
+
+//----------------------------------------------------
+
+func (k *HmacKey) GetParametersForImport() *ParametersForImport {
+	return &k.ParametersForImport
+}
+
+func (k *HmacKey) SetParametersForImport(p *ParametersForImport) {
+	k.ParametersForImport = *p
+}
+
+func (k *HmacKey) ImportKeyMaterial(m []byte) error {
+
+	min, max, _ := hmacKeyLength(k.Metadata.KeySpec)
+	if len(m) < min || len(m) > max {
+		return fmt.Errorf("Invalid key length. Key must be between %d and %d bytes in length.", min, max)
+	}
+
+	// If key material was already imported, it must be the same key material
+	if len(k.BackingKey) > 0 && !hmac.Equal(m, k.BackingKey) {
+		return errors.New("Key material does not match existing key material.")
+	}
+
+	k.BackingKey = m
+
+	return nil
+}
+
+//----------------------------------------------------
+
+func (k *HmacKey) GenerateMac(message []byte, algorithm MacAlgorithm) ([]byte, error) {
+
+	//--------------------------
+	// Check the requested MAC Algorithm is supported by this key
+
+	validMacAlgorithm := false
+
+	for _, a := range k.Metadata.MacAlgorithms {
+		if a == algorithm {
+			validMacAlgorithm = true
+			break
+		}
+	}
+
+	if !validMacAlgorithm {
+		return []byte{}, &InvalidMacAlgorithm{}
+	}
+
+	//---
+
+	var digest func() hash.Hash
+
+	switch algorithm {
+	case MacAlgorithmHmacSha224:
+		digest = sha256.New224
+	case MacAlgorithmHmacSha256:
+		digest = sha256.New
+	case MacAlgorithmHmacSha384:
+		digest = sha512.New384
+	case MacAlgorithmHmacSha512:
+		digest = sha512.New
+	default:
+		return []byte{}, errors.New("unknown mac algorithm")
+	}
+
+	mac := hmac.New(digest, k.BackingKey)
+	mac.Write(message)
+
+	return mac.Sum(nil), nil
+}
+
+func (k *HmacKey) VerifyMac(message []byte, mac []byte, algorithm MacAlgorithm) (bool, error) {
+
+	expected, err := k.GenerateMac(message, algorithm)
+	if err != nil {
+		return false, err
+	}
+
+	return hmac.Equal(mac, expected), nil
+}
+
+//----------------------------------------------------
+
+/*
+Returns the generated key length for the spec, plus the maximum length permitted for seeded or imported key material.
+The minimum length permitted is the generated length.
+*/
+func hmacKeyLength(spec KeySpec) (length int, max int, ok bool) {
+	switch spec {
+	case SpecHmac224:
+		return 224 / 8, 64, true
+	case SpecHmac256:
+		return 256 / 8, 64, true
+	case SpecHmac384:
+		return 384 / 8, 128, true
+	case SpecHmac512:
+		return 512 / 8, 128, true
+	}
+	return 0, 0, false
+}
+
+func hmacAlgorithmForSpec(spec KeySpec) MacAlgorithm {
+	switch spec {
+	case SpecHmac224:
+		return MacAlgorithmHmacSha224
+	case SpecHmac256:
+		return MacAlgorithmHmacSha256
+	case SpecHmac384:
+		return MacAlgorithmHmacSha384
+	case SpecHmac512:
+		return MacAlgorithmHmacSha512
+	}
+	return ""
+}
+
+//----------------------------------------------------
+// Construct key from YAML (seeding)
+
+//---
+
+func (k *HmacKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	// Cannot use embedded 'Key' struct
+	// https://github.com/go-yaml/yaml/issues/263
+	type YamlKey struct {
+		Metadata   KeyMetadata `yaml:"Metadata"`
+		BackingKey string      `yaml:"BackingKey"`
+	}
+
+	yk := YamlKey{}
+	if err := unmarshal(&yk); err != nil {
+		return &UnmarshalYAMLError{err.Error()}
+	}
+
+	k.Type = TypeHmac
+	k.Metadata = yk.Metadata
+	defaultSeededKeyMetadata(&k.Metadata)
+
+	//-------------------------
+	// Decode backing key
+
+	min, max, ok := hmacKeyLength(k.Metadata.KeySpec)
+	if !ok {
+		return &UnmarshalYAMLError{
+			fmt.Sprintf(
+				"KeySpec must be one of (%s,%s,%s,%s). It is mandatory for HMAC keys.",
+				SpecHmac224, SpecHmac256, SpecHmac384, SpecHmac512),
+		}
+	}
+
+	keyBytes, err := hex.DecodeString(yk.BackingKey)
+	if err != nil {
+		return &UnmarshalYAMLError{fmt.Sprintf("Unable to decode hex key: %s", err)}
+	}
+
+	if len(keyBytes) < min || len(keyBytes) > max {
+		return &UnmarshalYAMLError{
+			fmt.Sprintf(
+				"Backing key for %s must be hex encoded and between %d and %d bytes. %d bytes found",
+				k.Metadata.KeySpec, min, max, len(keyBytes)),
+		}
+	}
+
+	k.BackingKey = keyBytes
+
+	k.Metadata.KeyUsage = UsageGenerateVerifyMac
+	k.Metadata.CustomerMasterKeySpec = k.Metadata.KeySpec
+	k.Metadata.MacAlgorithms = []MacAlgorithm{hmacAlgorithmForSpec(k.Metadata.KeySpec)}
+
+	return nil
+}
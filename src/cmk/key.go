@@ -3,7 +3,6 @@ package cmk
 import (
 	"crypto/rsa"
 	"fmt"
-	"github.com/nsmithuk/local-kms/src/config"
 	"time"
 )
 
@@ -15,6 +14,9 @@ const (
 	TypeAes KeyType = iota
 	TypeRsa
 	TypeEcc
+	TypeHmac
+	TypeEd25519
+	TypeSm2
 )
 
 //---
@@ -27,9 +29,15 @@ const (
 	SpecEccNistP384      KeySpec = "ECC_NIST_P384"
 	SpecEccNistP521      KeySpec = "ECC_NIST_P521"
 	SpecEccSecp256k1     KeySpec = "ECC_SECG_P256K1"
+	SpecEccEd25519       KeySpec = "ECC_NIST_EDWARDS25519"
+	SpecSm2              KeySpec = "SM2"
 	SpecRsa2048          KeySpec = "RSA_2048"
 	SpecRsa3072          KeySpec = "RSA_3072"
 	SpecRsa4096          KeySpec = "RSA_4096"
+	SpecHmac224          KeySpec = "HMAC_224"
+	SpecHmac256          KeySpec = "HMAC_256"
+	SpecHmac384          KeySpec = "HMAC_384"
+	SpecHmac512          KeySpec = "HMAC_512"
 )
 
 //---
@@ -40,6 +48,7 @@ const (
 	EncryptionAlgorithmAes           EncryptionAlgorithm = "SYMMETRIC_DEFAULT"
 	EncryptionAlgorithmRsaOaepSha1   EncryptionAlgorithm = "RSAES_OAEP_SHA_1"
 	EncryptionAlgorithmRsaOaepSha256 EncryptionAlgorithm = "RSAES_OAEP_SHA_256"
+	EncryptionAlgorithmSm2Pke        EncryptionAlgorithm = "SM2PKE"
 )
 
 //---
@@ -47,15 +56,37 @@ const (
 type SigningAlgorithm string
 
 const (
-	SigningAlgorithmEcdsaSha256   SigningAlgorithm = "ECDSA_SHA_256"
-	SigningAlgorithmEcdsaSha384   SigningAlgorithm = "ECDSA_SHA_384"
-	SigningAlgorithmEcdsaSha512   SigningAlgorithm = "ECDSA_SHA_512"
-	SigningAlgorithmRsaPssSha256  SigningAlgorithm = "RSASSA_PSS_SHA_256"
-	SigningAlgorithmRsaPssSha384  SigningAlgorithm = "RSASSA_PSS_SHA_384"
-	SigningAlgorithmRsaPssSha512  SigningAlgorithm = "RSASSA_PSS_SHA_512"
-	SigningAlgorithmRsaPkcsSha256 SigningAlgorithm = "RSASSA_PKCS1_V1_5_SHA_256"
-	SigningAlgorithmRsaPkcsSha384 SigningAlgorithm = "RSASSA_PKCS1_V1_5_SHA_384"
-	SigningAlgorithmRsaPkcsSha512 SigningAlgorithm = "RSASSA_PKCS1_V1_5_SHA_512"
+	SigningAlgorithmEcdsaSha256     SigningAlgorithm = "ECDSA_SHA_256"
+	SigningAlgorithmEcdsaSha384     SigningAlgorithm = "ECDSA_SHA_384"
+	SigningAlgorithmEcdsaSha512     SigningAlgorithm = "ECDSA_SHA_512"
+	SigningAlgorithmRsaPssSha256    SigningAlgorithm = "RSASSA_PSS_SHA_256"
+	SigningAlgorithmRsaPssSha384    SigningAlgorithm = "RSASSA_PSS_SHA_384"
+	SigningAlgorithmRsaPssSha512    SigningAlgorithm = "RSASSA_PSS_SHA_512"
+	SigningAlgorithmRsaPkcsSha256   SigningAlgorithm = "RSASSA_PKCS1_V1_5_SHA_256"
+	SigningAlgorithmRsaPkcsSha384   SigningAlgorithm = "RSASSA_PKCS1_V1_5_SHA_384"
+	SigningAlgorithmRsaPkcsSha512   SigningAlgorithm = "RSASSA_PKCS1_V1_5_SHA_512"
+	SigningAlgorithmEd25519Sha512   SigningAlgorithm = "ED25519_SHA_512"
+	SigningAlgorithmEd25519PhSha512 SigningAlgorithm = "ED25519_PH_SHA_512"
+	SigningAlgorithmSm2Dsa          SigningAlgorithm = "SM2DSA"
+)
+
+//---
+
+type MacAlgorithm string
+
+const (
+	MacAlgorithmHmacSha224 MacAlgorithm = "HMAC_SHA_224"
+	MacAlgorithmHmacSha256 MacAlgorithm = "HMAC_SHA_256"
+	MacAlgorithmHmacSha384 MacAlgorithm = "HMAC_SHA_384"
+	MacAlgorithmHmacSha512 MacAlgorithm = "HMAC_SHA_512"
+)
+
+//---
+
+type KeyAgreementAlgorithm string
+
+const (
+	KeyAgreementAlgorithmEcdh KeyAgreementAlgorithm = "ECDH"
 )
 
 //---
@@ -68,6 +99,11 @@ const (
 	KeyStatePendingImport   KeyState = "PendingImport"
 	KeyStatePendingDeletion KeyState = "PendingDeletion"
 	KeyStateUnavailable     KeyState = "Unavailable"
+
+	// Multi-Region keys only
+	KeyStateCreating               KeyState = "Creating"
+	KeyStateUpdating               KeyState = "Updating"
+	KeyStatePendingReplicaDeletion KeyState = "PendingReplicaDeletion"
 )
 
 //---
@@ -75,8 +111,10 @@ const (
 type KeyUsage string
 
 const (
-	UsageEncryptDecrypt KeyUsage = "ENCRYPT_DECRYPT"
-	UsageSignVerify     KeyUsage = "SIGN_VERIFY"
+	UsageEncryptDecrypt    KeyUsage = "ENCRYPT_DECRYPT"
+	UsageSignVerify        KeyUsage = "SIGN_VERIFY"
+	UsageGenerateVerifyMac KeyUsage = "GENERATE_VERIFY_MAC"
+	UsageKeyAgreement      KeyUsage = "KEY_AGREEMENT"
 )
 
 //---
@@ -84,9 +122,10 @@ const (
 type KeyOrigin string
 
 const (
-	KeyOriginAwsKms      KeyOrigin = "AWS_KMS"
-	KeyOriginExternal    KeyOrigin = "EXTERNAL"
-	KeyOriginAwsCloudHsm KeyOrigin = "AWS_CLOUDHSM"
+	KeyOriginAwsKms           KeyOrigin = "AWS_KMS"
+	KeyOriginExternal         KeyOrigin = "EXTERNAL"
+	KeyOriginAwsCloudHsm      KeyOrigin = "AWS_CLOUDHSM"
+	KeyOriginExternalKeyStore KeyOrigin = "EXTERNAL_KEY_STORE"
 )
 
 //---
@@ -94,9 +133,11 @@ const (
 type WrappingAlgorithm string
 
 const (
-	WrappingAlgorithmPkcs1V15  WrappingAlgorithm = "RSAES_PKCS1_V1_5"
-	WrappingAlgorithmOaepSha1  WrappingAlgorithm = "RSAES_OAEP_SHA_1"
-	WrappingAlgorithmOaepSh256 WrappingAlgorithm = "RSAES_OAEP_SHA_256"
+	WrappingAlgorithmPkcs1V15     WrappingAlgorithm = "RSAES_PKCS1_V1_5"
+	WrappingAlgorithmOaepSha1     WrappingAlgorithm = "RSAES_OAEP_SHA_1"
+	WrappingAlgorithmOaepSh256    WrappingAlgorithm = "RSAES_OAEP_SHA_256"
+	WrappingAlgorithmRsaAesSha1   WrappingAlgorithm = "RSA_AES_KEY_WRAP_SHA_1"
+	WrappingAlgorithmRsaAesSha256 WrappingAlgorithm = "RSA_AES_KEY_WRAP_SHA_256"
 )
 
 //---
@@ -108,6 +149,24 @@ const (
 	ExpirationModelKeyMaterialDoesNotExpire ExpirationModel = "KEY_MATERIAL_DOES_NOT_EXPIRE"
 )
 
+//---
+
+type RotationType string
+
+const (
+	RotationTypeAutomatic RotationType = "AUTOMATIC"
+	RotationTypeOnDemand  RotationType = "ON_DEMAND"
+)
+
+//---
+
+type MultiRegionKeyType string
+
+const (
+	MultiRegionKeyTypePrimary MultiRegionKeyType = "PRIMARY"
+	MultiRegionKeyTypeReplica MultiRegionKeyType = "REPLICA"
+)
+
 //------------------------------------------
 
 type Key interface {
@@ -115,6 +174,9 @@ type Key interface {
 	GetPolicy() string
 	GetKeyType() KeyType
 	GetMetadata() *KeyMetadata
+	SetPolicy(policy string)
+	GetVersion() int64
+	SetVersion(version int64)
 }
 
 type SigningKey interface {
@@ -125,33 +187,104 @@ type SigningKey interface {
 	HashAndVerify(signature []byte, digest []byte, algorithm SigningAlgorithm) (bool, error)
 }
 
+type KeyAgreementKey interface {
+	Key
+	DeriveSharedSecret(publicKey []byte, algorithm KeyAgreementAlgorithm) ([]byte, error)
+	CheckPublicKey(publicKey []byte) error
+}
+
+/*
+A key that supports an EXTERNAL origin, with its key material imported.
+*/
+type ImportableKey interface {
+	Key
+	GetParametersForImport() *ParametersForImport
+	SetParametersForImport(p *ParametersForImport)
+	ImportKeyMaterial(m []byte) error
+}
+
 //------------------------------------------
 
 type BaseKey struct {
 	Type     KeyType
 	Metadata KeyMetadata
 	Policy   string
+
+	// Incremented each time the key is saved, so concurrent changes to it can be detected.
+	Version int64 `json:",omitempty" yaml:"-"`
+}
+
+func (k *BaseKey) SetPolicy(policy string) {
+	k.Policy = policy
+}
+
+func (k *BaseKey) GetVersion() int64 {
+	return k.Version
+}
+
+func (k *BaseKey) SetVersion(version int64) {
+	k.Version = version
 }
 
 type KeyMetadata struct {
-	AWSAccountId    string          `json:",omitempty"`
-	Arn             string          `json:",omitempty"`
-	CreationDate    int64           `json:",omitempty"`
-	DeletionDate    int64           `json:",omitempty"`
-	Description     *string         `yaml:"Description"`
-	Enabled         bool            `yaml:"Enabled"`
-	ExpirationModel ExpirationModel `json:",omitempty"`
-	KeyId           string          `json:",omitempty" yaml:"KeyId"`
-	KeyManager      string          `json:",omitempty"`
-	KeyState        KeyState        `json:",omitempty"`
-	KeyUsage        KeyUsage        `json:",omitempty" yaml:"KeyUsage"`
-	Origin          KeyOrigin       `json:",omitempty" yaml:"Origin"`
-	ValidTo         int64           `json:",omitempty"`
-
-	SigningAlgorithms     []SigningAlgorithm    `json:",omitempty"`
-	EncryptionAlgorithms  []EncryptionAlgorithm `json:",omitempty"`
-	KeySpec               KeySpec               `json:",omitempty"`
-	CustomerMasterKeySpec KeySpec               `json:",omitempty"`
+	AWSAccountId      string          `json:",omitempty"`
+	Arn               string          `json:",omitempty"`
+	CloudHsmClusterId string          `json:",omitempty"`
+	CreationDate      int64           `json:",omitempty"`
+	CustomKeyStoreId  string          `json:",omitempty"`
+	DeletionDate      int64           `json:",omitempty"`
+	Description       *string         `yaml:"Description"`
+	Enabled           bool            `yaml:"Enabled"`
+	ExpirationModel   ExpirationModel `json:",omitempty"`
+	KeyId             string          `json:",omitempty" yaml:"KeyId"`
+	KeyManager        string          `json:",omitempty"`
+	KeyState          KeyState        `json:",omitempty"`
+	KeyUsage          KeyUsage        `json:",omitempty" yaml:"KeyUsage"`
+	Origin            KeyOrigin       `json:",omitempty" yaml:"Origin"`
+	ValidTo           int64           `json:",omitempty"`
+
+	XksKeyConfiguration *XksKeyConfiguration `json:",omitempty"`
+
+	MultiRegion                 bool
+	MultiRegionConfiguration    *MultiRegionConfiguration `json:",omitempty"`
+	PendingDeletionWindowInDays int64                     `json:",omitempty"`
+
+	SigningAlgorithms      []SigningAlgorithm      `json:",omitempty"`
+	EncryptionAlgorithms   []EncryptionAlgorithm   `json:",omitempty"`
+	MacAlgorithms          []MacAlgorithm          `json:",omitempty"`
+	KeyAgreementAlgorithms []KeyAgreementAlgorithm `json:",omitempty"`
+	KeySpec                KeySpec                 `json:",omitempty" yaml:"KeySpec"`
+	CustomerMasterKeySpec  KeySpec                 `json:",omitempty"`
+}
+
+// Identifies a key in an external key store's external key manager.
+type XksKeyConfiguration struct {
+	Id string
+}
+
+type MultiRegionKey struct {
+	Arn    string
+	Region string
+}
+
+/*
+Each copy of a multi-Region key holds the full configuration, so it's kept in sync across the primary and all replicas.
+*/
+type MultiRegionConfiguration struct {
+	MultiRegionKeyType MultiRegionKeyType
+	PrimaryKey         MultiRegionKey
+	ReplicaKeys        []MultiRegionKey
+}
+
+/*
+Returns the ARNs of every copy of the multi-Region key, the primary first.
+*/
+func (c *MultiRegionConfiguration) AllArns() []string {
+	arns := []string{c.PrimaryKey.Arn}
+	for _, r := range c.ReplicaKeys {
+		arns = append(arns, r.Arn)
+	}
+	return arns
 }
 
 type ParametersForImport struct {
@@ -169,9 +302,8 @@ func (e *UnmarshalYAMLError) Error() string {
 	return fmt.Sprintf("Error unmarshaling YAML: %s", e.message)
 }
 
+// The key's ARN and account are set when it's seeded, as they depend on the instance it's seeded into.
 func defaultSeededKeyMetadata(metadata *KeyMetadata) {
-	metadata.Arn = config.ArnPrefix() + "key/" + metadata.KeyId
-	metadata.AWSAccountId = config.AWSAccountId
 	metadata.CreationDate = time.Now().Unix()
 	metadata.Enabled = true
 	metadata.KeyManager = "CUSTOMER"
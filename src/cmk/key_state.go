@@ -0,0 +1,267 @@
+package cmk
+
+import "fmt"
+
+/*
+Whether, and how, each operation is permitted on a key in each of its states, following AWS' "Key states and
+permitted operations" table. Operations not listed don't act on a key, and so aren't restricted by its state.
+*/
+var keyStateRules = map[string]keyStateRule{
+	"Encrypt":                             cryptographicRule,
+	"Decrypt":                             cryptographicRule,
+	"ReEncrypt":                           cryptographicRule,
+	"GenerateDataKey":                     cryptographicRule,
+	"GenerateDataKeyWithoutPlaintext":     cryptographicRule,
+	"GenerateDataKeyPair":                 cryptographicRule,
+	"GenerateDataKeyPairWithoutPlaintext": cryptographicRule,
+	"GenerateMac":                         cryptographicRule,
+	"VerifyMac":                           cryptographicRule,
+	"Sign":                                cryptographicRule,
+	"Verify":                              cryptographicRule,
+	"GetPublicKey":                        cryptographicRule,
+	"DeriveSharedSecret":                  cryptographicRule,
+
+	"EnableKey":  enableDisableRule,
+	"DisableKey": enableDisableRule,
+
+	"EnableKeyRotation":  rotationRule,
+	"DisableKeyRotation": rotationRule,
+	"RotateKeyOnDemand":  rotationRule,
+
+	"CreateAlias":          managementRule,
+	"UpdateAlias":          managementRule,
+	"CreateGrant":          managementRule,
+	"PutKeyPolicy":         managementRule,
+	"UpdateKeyDescription": managementRule,
+	"TagResource":          managementRule,
+	"UntagResource":        managementRule,
+
+	"GetParametersForImport":    importRule,
+	"ImportKeyMaterial":         importRule,
+	"DeleteImportedKeyMaterial": importRule,
+
+	"ReplicateKey":        multiRegionRule,
+	"UpdatePrimaryRegion": multiRegionRule,
+
+	"ScheduleKeyDeletion": {
+		KeyStatePendingDeletion:        invalidState,
+		KeyStatePendingReplicaDeletion: invalidState,
+		KeyStateCreating:               invalidState,
+		KeyStateUpdating:               invalidState,
+	},
+
+	"CancelKeyDeletion": {
+		KeyStateEnabled:       notPendingDeletion,
+		KeyStateDisabled:      notPendingDeletion,
+		KeyStatePendingImport: notPendingDeletion,
+		KeyStateCreating:      notPendingDeletion,
+		KeyStateUpdating:      notPendingDeletion,
+	},
+
+	// Reading a key's metadata, policy, grants, tags, aliases and rotations is permitted in all states
+	"DescribeKey":          {},
+	"GetKeyPolicy":         {},
+	"ListKeyPolicies":      {},
+	"GetKeyRotationStatus": {},
+	"ListKeyRotations":     {},
+	"ListGrants":           {},
+	"ListResourceTags":     {},
+	"ListAliases":          {},
+	"DeleteAlias":          {},
+	"RetireGrant":          {},
+	"RevokeGrant":          {},
+}
+
+/*
+The state a key moves to after each operation, from each state it's permitted in. Operations and states not listed
+leave the key's state unchanged.
+*/
+var keyStateTransitions = map[string]map[KeyState]KeyState{
+	"EnableKey": {
+		KeyStateDisabled: KeyStateEnabled,
+	},
+	"DisableKey": {
+		KeyStateEnabled: KeyStateDisabled,
+	},
+	"ScheduleKeyDeletion": {
+		KeyStateEnabled:       KeyStatePendingDeletion,
+		KeyStateDisabled:      KeyStatePendingDeletion,
+		KeyStatePendingImport: KeyStatePendingDeletion,
+	},
+	"CancelKeyDeletion": {
+		KeyStatePendingDeletion:        KeyStateDisabled,
+		KeyStatePendingReplicaDeletion: KeyStateDisabled,
+	},
+	"ImportKeyMaterial": {
+		KeyStatePendingImport: KeyStateEnabled,
+	},
+	"DeleteImportedKeyMaterial": {
+		KeyStateEnabled:  KeyStatePendingImport,
+		KeyStateDisabled: KeyStatePendingImport,
+	},
+}
+
+//------------------------------------------
+
+/*
+The exception returned when an operation isn't permitted in a state. If format is empty, the message is the key's
+ARN followed by a description of its state.
+*/
+type keyStateDenial struct {
+	exception string
+	format    string
+}
+
+var (
+	disabled           = keyStateDenial{"DisabledException", ""}
+	invalidState       = keyStateDenial{"KMSInvalidStateException", ""}
+	notPendingDeletion = keyStateDenial{"KMSInvalidStateException", "%s is not pending deletion."}
+)
+
+// The states in which an operation is denied. All other states are permitted.
+type keyStateRule map[KeyState]keyStateDenial
+
+var (
+	cryptographicRule = keyStateRule{
+		KeyStateDisabled:               disabled,
+		KeyStatePendingDeletion:        invalidState,
+		KeyStatePendingReplicaDeletion: invalidState,
+		KeyStatePendingImport:          invalidState,
+		KeyStateUnavailable:            invalidState,
+		KeyStateCreating:               invalidState,
+	}
+
+	enableDisableRule = keyStateRule{
+		KeyStatePendingDeletion:        invalidState,
+		KeyStatePendingReplicaDeletion: invalidState,
+		KeyStatePendingImport:          invalidState,
+		KeyStateUnavailable:            invalidState,
+		KeyStateCreating:               invalidState,
+	}
+
+	rotationRule = keyStateRule{
+		KeyStateDisabled:               disabled,
+		KeyStatePendingDeletion:        invalidState,
+		KeyStatePendingReplicaDeletion: invalidState,
+		KeyStatePendingImport:          invalidState,
+		KeyStateUnavailable:            invalidState,
+		KeyStateCreating:               invalidState,
+	}
+
+	managementRule = keyStateRule{
+		KeyStatePendingDeletion:        invalidState,
+		KeyStatePendingReplicaDeletion: invalidState,
+		KeyStateCreating:               invalidState,
+	}
+
+	importRule = keyStateRule{
+		KeyStatePendingDeletion:        invalidState,
+		KeyStatePendingReplicaDeletion: invalidState,
+		KeyStateUnavailable:            invalidState,
+		KeyStateCreating:               invalidState,
+	}
+
+	multiRegionRule = keyStateRule{
+		KeyStatePendingDeletion:        invalidState,
+		KeyStatePendingReplicaDeletion: invalidState,
+		KeyStateUnavailable:            invalidState,
+		KeyStateCreating:               invalidState,
+		KeyStateUpdating:               invalidState,
+	}
+)
+
+var keyStateDescriptions = map[KeyState]string{
+	KeyStateEnabled:                "enabled",
+	KeyStateDisabled:               "disabled",
+	KeyStatePendingDeletion:        "pending deletion",
+	KeyStatePendingReplicaDeletion: "pending replica deletion",
+	KeyStatePendingImport:          "pending import",
+	KeyStateUnavailable:            "unavailable",
+	KeyStateCreating:               "creating",
+	KeyStateUpdating:               "updating",
+}
+
+//------------------------------------------
+
+/*
+Returned when an operation isn't permitted in the key's state. Type is the name of the exception returned to the client.
+*/
+type KeyStateError struct {
+	Type    string
+	Message string
+}
+
+func (e *KeyStateError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+/*
+Returns an error if the operation isn't permitted in the key's current state.
+
+	Whilst a key is Unavailable, the state it will return to once its custom key store is reconnected must also
+	permit the operation. So, for example, an Unavailable key can only have its deletion cancelled if it's pending
+	deletion.
+*/
+func CheckKeyState(operation string, key Key) *KeyStateError {
+	metadata := key.GetMetadata()
+	rule := keyStateRules[operation]
+
+	if denial, denied := rule[metadata.KeyState]; denied {
+		return newKeyStateError(denial, key.GetArn(), metadata.KeyState)
+	}
+
+	if metadata.KeyState == KeyStateUnavailable {
+		state := metadata.AvailableKeyState()
+		if denial, denied := rule[state]; denied {
+			return newKeyStateError(denial, key.GetArn(), state)
+		}
+	}
+
+	return nil
+}
+
+func newKeyStateError(denial keyStateDenial, arn string, state KeyState) *KeyStateError {
+	if denial.format != "" {
+		return &KeyStateError{denial.exception, fmt.Sprintf(denial.format, arn)}
+	}
+
+	return &KeyStateError{denial.exception, fmt.Sprintf("%s is %s.", arn, keyStateDescriptions[state])}
+}
+
+/*
+Moves the key to the state that follows the operation. Whilst a key is Unavailable, only its Enabled flag is
+updated; its state is restored from its metadata when its custom key store is reconnected.
+*/
+func TransitionKeyState(operation string, metadata *KeyMetadata) {
+	current := metadata.KeyState
+	if current == KeyStateUnavailable {
+		current = metadata.AvailableKeyState()
+	}
+
+	next, ok := keyStateTransitions[operation][current]
+	if !ok {
+		return
+	}
+
+	metadata.Enabled = next == KeyStateEnabled
+
+	if metadata.KeyState != KeyStateUnavailable {
+		metadata.KeyState = next
+	}
+}
+
+/*
+The state of the key when it's available; for keys in a custom key store, the state it's in once the store is connected.
+*/
+func (m *KeyMetadata) AvailableKeyState() KeyState {
+	switch {
+	case m.KeyState != KeyStateUnavailable:
+		return m.KeyState
+	case m.DeletionDate != 0:
+		return KeyStatePendingDeletion
+	case m.Enabled:
+		return KeyStateEnabled
+	default:
+		return KeyStateDisabled
+	}
+}
@@ -14,33 +14,29 @@ type RsaPrivateKey rsa.PrivateKey
 
 type RsaKey struct {
 	BaseKey
-	PrivateKey RsaPrivateKey
+	PrivateKey          RsaPrivateKey
+	ParametersForImport ParametersForImport
 }
 
-func NewRsaKey(spec KeySpec, usage KeyUsage, metadata KeyMetadata, policy string) (*RsaKey, error) {
+func NewRsaKey(spec KeySpec, usage KeyUsage, metadata KeyMetadata, policy string, origin KeyOrigin) (*RsaKey, error) {
 
-	var bits int
-
-	switch spec {
-	case SpecRsa2048:
-		bits = 2048
-	case SpecRsa3072:
-		bits = 3072
-	case SpecRsa4096:
-		bits = 4096
-	default:
+	bits, ok := rsaKeyBits(spec)
+	if !ok {
 		return nil, errors.New("key spec error")
 	}
 
 	//---
 
-	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
-	if err != nil {
-		return nil, err
-	}
+	k := &RsaKey{}
+
+	// Keys with an EXTERNAL origin have their key material imported later
+	if origin != KeyOriginExternal {
+		privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
 
-	k := &RsaKey{
-		PrivateKey: RsaPrivateKey(*privateKey),
+		k.PrivateKey = RsaPrivateKey(*privateKey)
 	}
 
 	k.Type = TypeRsa
@@ -97,6 +93,57 @@ func (k *RsaKey) GetMetadata() *KeyMetadata {
 
 //----------------------------------------------------
 
+func (k *RsaKey) GetParametersForImport() *ParametersForImport {
+	return &k.ParametersForImport
+}
+
+func (k *RsaKey) SetParametersForImport(p *ParametersForImport) {
+	k.ParametersForImport = *p
+}
+
+/*
+Imports an RSA private key, in DER encoded PKCS8 format.
+*/
+func (k *RsaKey) ImportKeyMaterial(m []byte) error {
+
+	parseResult, err := x509.ParsePKCS8PrivateKey(m)
+	if err != nil {
+		return errors.New("Key material must be a DER encoded PKCS8 private key.")
+	}
+
+	key, ok := parseResult.(*rsa.PrivateKey)
+	if !ok {
+		return errors.New("Key material is not an RSA private key.")
+	}
+
+	if bits, _ := rsaKeyBits(k.Metadata.KeySpec); key.N.BitLen() != bits {
+		return fmt.Errorf("Key material must be a %d bit RSA private key.", bits)
+	}
+
+	// If key material was already imported, it must be the same key material
+	if k.PrivateKey.D != nil && !key.Equal((*rsa.PrivateKey)(&k.PrivateKey)) {
+		return errors.New("Key material does not match existing key material.")
+	}
+
+	k.PrivateKey = RsaPrivateKey(*key)
+
+	return nil
+}
+
+func rsaKeyBits(spec KeySpec) (int, bool) {
+	switch spec {
+	case SpecRsa2048:
+		return 2048, true
+	case SpecRsa3072:
+		return 3072, true
+	case SpecRsa4096:
+		return 4096, true
+	}
+	return 0, false
+}
+
+//----------------------------------------------------
+
 func (k *RsaKey) Sign(digest []byte, algorithm SigningAlgorithm) ([]byte, error) {
 
 	//--------------------------
@@ -0,0 +1,341 @@
+package cmk
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/nsmithuk/local-kms/src/x509"
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+// We create our own type to manage JSON Marshaling
+type Sm2PrivateKey sm2.PrivateKey
+
+type Sm2Key struct {
+	BaseKey
+	PrivateKey Sm2PrivateKey
+}
+
+func NewSm2Key(usage KeyUsage, metadata KeyMetadata, policy string) (*Sm2Key, error) {
+
+	privateKey, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	//---
+
+	k := &Sm2Key{
+		PrivateKey: Sm2PrivateKey(*privateKey),
+	}
+
+	k.Type = TypeSm2
+	k.Metadata = metadata
+	k.Policy = policy
+
+	//---
+
+	k.Metadata.KeyUsage = usage
+	k.Metadata.KeySpec = SpecSm2
+	k.Metadata.CustomerMasterKeySpec = SpecSm2
+
+	err = setSm2Algorithms(&k.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func setSm2Algorithms(metadata *KeyMetadata) error {
+	switch metadata.KeyUsage {
+	case UsageSignVerify:
+		metadata.SigningAlgorithms = []SigningAlgorithm{SigningAlgorithmSm2Dsa}
+	case UsageEncryptDecrypt:
+		metadata.EncryptionAlgorithms = []EncryptionAlgorithm{EncryptionAlgorithmSm2Pke}
+	default:
+		return errors.New("key usage error")
+	}
+	return nil
+}
+
+//----------------------------------------------------
+
+func (k *Sm2Key) GetArn() string {
+	return k.GetMetadata().Arn
+}
+
+func (k *Sm2Key) GetPolicy() string {
+	return k.Policy
+}
+
+func (k *Sm2Key) GetKeyType() KeyType {
+	return k.Type
+}
+
+func (k *Sm2Key) GetMetadata() *KeyMetadata {
+	return &k.Metadata
+}
+
+/*
+Returns the public key as an ecdsa.PublicKey on the SM2 curve, for marshalling.
+*/
+func (k *Sm2Key) GetEcdsaPublicKey() *ecdsa.PublicKey {
+	return &ecdsa.PublicKey{
+		Curve: k.PrivateKey.Curve,
+		X:     k.PrivateKey.X,
+		Y:     k.PrivateKey.Y,
+	}
+}
+
+//----------------------------------------------------
+
+/*
+Signs the passed digest, which is expected to be SM3(ZA || message), as per GM/T 0003.
+*/
+func (k *Sm2Key) Sign(digest []byte, algorithm SigningAlgorithm) ([]byte, error) {
+
+	if algorithm != SigningAlgorithmSm2Dsa || k.Metadata.KeyUsage != UsageSignVerify {
+		return []byte{}, &InvalidSigningAlgorithm{}
+	}
+
+	if len(digest) != (256 / 8) {
+		return []byte{}, &InvalidDigestLength{}
+	}
+
+	key := sm2.PrivateKey(k.PrivateKey)
+
+	r, s, err := sm2SignDigest(&key, digest)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return asn1.Marshal(ecdsaSignature{r, s})
+}
+
+/*
+Signs the message, using the default distinguishing ID of 1234567812345678.
+*/
+func (k *Sm2Key) HashAndSign(message []byte, algorithm SigningAlgorithm) ([]byte, error) {
+
+	digest, err := k.PrivateKey.PublicKey.Sm3Digest(message, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return k.Sign(leftPad(digest, 256/8), algorithm)
+}
+
+//----------------------------------------------------
+
+func (k *Sm2Key) Verify(signature []byte, digest []byte, algorithm SigningAlgorithm) (bool, error) {
+
+	if algorithm != SigningAlgorithmSm2Dsa || k.Metadata.KeyUsage != UsageSignVerify {
+		return false, &InvalidSigningAlgorithm{}
+	}
+
+	sm2Signature := ecdsaSignature{}
+
+	_, err := asn1.Unmarshal(signature, &sm2Signature)
+	if err != nil {
+		return false, err
+	}
+
+	valid := sm2.Verify(&k.PrivateKey.PublicKey, digest, sm2Signature.R, sm2Signature.S)
+
+	return valid, nil
+}
+
+func (k *Sm2Key) HashAndVerify(signature []byte, message []byte, algorithm SigningAlgorithm) (bool, error) {
+
+	digest, err := k.PrivateKey.PublicKey.Sm3Digest(message, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return k.Verify(signature, leftPad(digest, 256/8), algorithm)
+}
+
+//----------------------------------------------------
+
+/*
+Encrypts the plaintext with SM2PKE, returning the ciphertext in the ASN.1 C1C3C2 format defined by GM/T 0009.
+*/
+func (k *Sm2Key) Encrypt(plaintext []byte, algorithm EncryptionAlgorithm) ([]byte, error) {
+
+	if algorithm != EncryptionAlgorithmSm2Pke || k.Metadata.KeyUsage != UsageEncryptDecrypt {
+		return []byte{}, &InvalidEncryptionAlgorithm{}
+	}
+
+	return sm2.EncryptAsn1(&k.PrivateKey.PublicKey, plaintext, rand.Reader)
+}
+
+func (k *Sm2Key) Decrypt(ciphertext []byte, algorithm EncryptionAlgorithm) ([]byte, error) {
+
+	if algorithm != EncryptionAlgorithmSm2Pke || k.Metadata.KeyUsage != UsageEncryptDecrypt {
+		return []byte{}, &InvalidEncryptionAlgorithm{}
+	}
+
+	key := sm2.PrivateKey(k.PrivateKey)
+
+	return sm2.DecryptAsn1(&key, ciphertext)
+}
+
+//----------------------------------------------------
+
+var sm2One = big.NewInt(1)
+
+/*
+The SM2 signature algorithm, applied to a pre-computed digest. The library in use only supports signing full messages.
+*/
+func sm2SignDigest(priv *sm2.PrivateKey, digest []byte) (r, s *big.Int, err error) {
+	e := new(big.Int).SetBytes(digest)
+	n := priv.Curve.Params().N
+
+	// (1 + d)^-1 mod n
+	dInv := new(big.Int).ModInverse(new(big.Int).Add(priv.D, sm2One), n)
+	if dInv == nil {
+		return nil, nil, errors.New("invalid sm2 private key")
+	}
+
+	for {
+		// k in [1, n-1]
+		k, err := rand.Int(rand.Reader, new(big.Int).Sub(n, sm2One))
+		if err != nil {
+			return nil, nil, err
+		}
+		k.Add(k, sm2One)
+
+		x1, _ := priv.Curve.ScalarBaseMult(k.Bytes())
+
+		// r = (e + x1) mod n
+		r = new(big.Int).Add(e, x1)
+		r.Mod(r, n)
+		if r.Sign() == 0 || new(big.Int).Add(r, k).Cmp(n) == 0 {
+			continue
+		}
+
+		// s = ((1 + d)^-1 * (k - r * d)) mod n
+		s = new(big.Int).Mul(r, priv.D)
+		s.Sub(k, s)
+		s.Mul(s, dInv)
+		s.Mod(s, n)
+		if s.Sign() != 0 {
+			return r, s, nil
+		}
+	}
+}
+
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+//----------------------------------------------------
+
+type sm2KeyMarshaledJSON struct {
+	D, X, Y *big.Int
+}
+
+func (k *Sm2PrivateKey) MarshalJSON() ([]byte, error) {
+
+	return json.Marshal(&sm2KeyMarshaledJSON{
+		D: k.D,
+		X: k.X,
+		Y: k.Y,
+	})
+}
+
+/*
+sm2.PrivateKey.Curve is an interface type, so we need to
+Unmarshal it ourselves to set the concrete type.
+*/
+func (k *Sm2PrivateKey) UnmarshalJSON(data []byte) error {
+
+	var marshaledKey sm2KeyMarshaledJSON
+
+	err := json.Unmarshal(data, &marshaledKey)
+	if err != nil {
+		return err
+	}
+
+	k.Curve = sm2.P256Sm2()
+	k.D = marshaledKey.D
+	k.X = marshaledKey.X
+	k.Y = marshaledKey.Y
+
+	return nil
+}
+
+// ----------------------------------------------------
+// Construct key from YAML (seeding)
+// ---
+func (k *Sm2Key) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	// Cannot use embedded 'Key' struct
+	// https://github.com/go-yaml/yaml/issues/263
+	type YamlKey struct {
+		Metadata      KeyMetadata `yaml:"Metadata"`
+		PrivateKeyPem string      `yaml:"PrivateKeyPem"`
+	}
+
+	yk := YamlKey{}
+	if err := unmarshal(&yk); err != nil {
+		return &UnmarshalYAMLError{err.Error()}
+	}
+
+	k.Type = TypeSm2
+	k.Metadata = yk.Metadata
+	defaultSeededKeyMetadata(&k.Metadata)
+	pemDecoded, _ := pem.Decode([]byte(yk.PrivateKeyPem))
+	if pemDecoded == nil {
+		return &UnmarshalYAMLError{fmt.Sprintf("Unable to decode pem of key %s check the YAML.\n", k.Metadata.KeyId)}
+	}
+
+	// Both PKCS8 ("PRIVATE KEY") and SEC1 ("EC PRIVATE KEY") encodings are accepted
+	var parseResult interface{}
+	var parseError error
+
+	if pemDecoded.Type == "EC PRIVATE KEY" {
+		parseResult, parseError = x509.ParseECPrivateKey(pemDecoded.Bytes)
+	} else {
+		parseResult, parseError = x509.ParsePKCS8PrivateKey(pemDecoded.Bytes)
+	}
+
+	if parseError != nil {
+		return &UnmarshalYAMLError{fmt.Sprintf("Unable to decode pem of key %s, Ensure it is in PKCS8 format with no password: %s.\n", k.Metadata.KeyId, parseError)}
+	}
+
+	privateKey, ok := parseResult.(*ecdsa.PrivateKey)
+	if !ok || privateKey.Curve != sm2.P256Sm2() {
+		return &UnmarshalYAMLError{fmt.Sprintf("Key %s is not an SM2 key.\n", k.Metadata.KeyId)}
+	}
+
+	k.PrivateKey = Sm2PrivateKey{
+		PublicKey: sm2.PublicKey{
+			Curve: privateKey.Curve,
+			X:     privateKey.X,
+			Y:     privateKey.Y,
+		},
+		D: privateKey.D,
+	}
+
+	k.Metadata.KeySpec = SpecSm2
+	k.Metadata.CustomerMasterKeySpec = SpecSm2
+
+	if err := setSm2Algorithms(&k.Metadata); err != nil {
+		return &UnmarshalYAMLError{
+			fmt.Sprintf(
+				"Only KeyUsage of (%s, %s) supported for SM2 keys.\n", UsageSignVerify, UsageEncryptDecrypt),
+		}
+	}
+	return nil
+}
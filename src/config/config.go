@@ -2,20 +2,61 @@ package config
 
 import "strings"
 
-var AWSRegion string
-var AWSAccountId string
-var DatabasePath string
+/*
+The configuration of a single LKMS instance. Each instance has its own, so several can run in the same process.
+*/
+type Config struct {
+	AWSRegion    string
+	AWSAccountId string
 
-func ArnPrefix() string {
-	return "arn:aws:kms:" + AWSRegion + ":" + AWSAccountId + ":"
+	// Regions served in addition to AWSRegion. Requests signed for one of these regions are handled within that region.
+	AdditionalRegions []string
+
+	// When true, requests are checked against the key's policy.
+	EnforceKeyPolicies bool
+
+	// The principal requests are treated as being made by, when they're not otherwise authenticated.
+	CallerPrincipal string
+
+	// Directory holding the local stand-ins for the CloudHSM clusters backing custom key stores.
+	HsmPath string
+}
+
+func (c *Config) ArnPrefix() string {
+	return c.ArnPrefixForRegion(c.AWSRegion)
+}
+
+func (c *Config) ArnPrefixForRegion(region string) string {
+	return "arn:aws:kms:" + region + ":" + c.AWSAccountId + ":"
 }
 
-func EnsureArn(prefix, target string) string {
+func (c *Config) EnsureArn(prefix, target string) string {
+	return c.EnsureArnForRegion(c.AWSRegion, prefix, target)
+}
+
+func (c *Config) EnsureArnForRegion(region, prefix, target string) string {
 
 	// If it's already an ARN
 	if strings.HasPrefix(target, "arn:") {
 		return target
 	}
 
-	return ArnPrefix() + prefix + target
+	return c.ArnPrefixForRegion(region) + prefix + target
+}
+
+/*
+Returns true if requests for the given region are handled by this instance.
+*/
+func (c *Config) IsServedRegion(region string) bool {
+	if region == c.AWSRegion {
+		return true
+	}
+
+	for _, r := range c.AdditionalRegions {
+		if r == region {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+A copy of every record in a storage, as stored. Encrypted records remain encrypted, alongside their header.
+*/
+type backup struct {
+	SchemaVersion int
+	Created       time.Time
+	Records       map[string][]byte
+}
+
+/*
+Writes a copy of every record in the storage to a new file in dir, returning the file's path. The storage should be
+the underlying one, not an EncryptedStorage, so the encryption header is included.
+*/
+func Backup(storage Storage, dir string, schemaVersion int) (string, error) {
+
+	b := backup{
+		SchemaVersion: schemaVersion,
+		Created:       time.Now().UTC(),
+		Records:       make(map[string][]byte),
+	}
+
+	err := storage.Iterate("", func(key string, value []byte) bool {
+		b.Records[key] = append([]byte{}, value...)
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	// Not .json, so a JSON storage backend kept in the same directory ignores it.
+	path := filepath.Join(dir, fmt.Sprintf("schema-v%d-%s.backup", schemaVersion, b.Created.Format("20060102T150405Z")))
+
+	return path, os.WriteFile(path, encoded, 0600)
+}
+
+/*
+Replaces every record in the storage with those in a backup. Returns the schema version the backup was taken at.
+*/
+func RestoreBackup(storage Storage, path string) (int, error) {
+
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var b backup
+
+	if err := json.Unmarshal(encoded, &b); err != nil || b.Records == nil {
+		return 0, fmt.Errorf("%s is not a backup", path)
+	}
+
+	batch := new(Batch)
+
+	err = storage.Iterate("", func(key string, value []byte) bool {
+		if _, ok := b.Records[key]; !ok {
+			batch.Delete(key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for key, value := range b.Records {
+		batch.Put(key, value)
+	}
+
+	return b.SchemaVersion, storage.Write(batch)
+}
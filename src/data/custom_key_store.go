@@ -0,0 +1,58 @@
+package data
+
+type CustomKeyStoreType string
+
+const (
+	CustomKeyStoreTypeAwsCloudHsm      CustomKeyStoreType = "AWS_CLOUDHSM"
+	CustomKeyStoreTypeExternalKeyStore CustomKeyStoreType = "EXTERNAL_KEY_STORE"
+)
+
+type ConnectionState string
+
+const (
+	ConnectionStateConnected     ConnectionState = "CONNECTED"
+	ConnectionStateConnecting    ConnectionState = "CONNECTING"
+	ConnectionStateFailed        ConnectionState = "FAILED"
+	ConnectionStateDisconnected  ConnectionState = "DISCONNECTED"
+	ConnectionStateDisconnecting ConnectionState = "DISCONNECTING"
+)
+
+type ConnectionErrorCode string
+
+const (
+	ConnectionErrorCodeInvalidCredentials ConnectionErrorCode = "INVALID_CREDENTIALS"
+	ConnectionErrorCodeClusterNotFound    ConnectionErrorCode = "CLUSTER_NOT_FOUND"
+	ConnectionErrorCodeInternalError      ConnectionErrorCode = "INTERNAL_ERROR"
+
+	ConnectionErrorCodeXksProxyAccessDenied         ConnectionErrorCode = "XKS_PROXY_ACCESS_DENIED"
+	ConnectionErrorCodeXksProxyInvalidConfiguration ConnectionErrorCode = "XKS_PROXY_INVALID_CONFIGURATION"
+	ConnectionErrorCodeXksProxyInvalidResponse      ConnectionErrorCode = "XKS_PROXY_INVALID_RESPONSE"
+	ConnectionErrorCodeXksProxyNotReachable         ConnectionErrorCode = "XKS_PROXY_NOT_REACHABLE"
+	ConnectionErrorCodeXksProxyTimedOut             ConnectionErrorCode = "XKS_PROXY_TIMED_OUT"
+)
+
+type XksProxyConfiguration struct {
+	AccessKeyId  string
+	Connectivity string
+	UriEndpoint  string
+	UriPath      string
+}
+
+type CustomKeyStore struct {
+	CustomKeyStoreId   string
+	CustomKeyStoreName string
+	CustomKeyStoreType CustomKeyStoreType
+
+	// AWS_CLOUDHSM stores
+	CloudHsmClusterId      string `json:",omitempty"`
+	TrustAnchorCertificate string `json:",omitempty"`
+	KeyStorePassword       string `json:",omitempty"`
+
+	// EXTERNAL_KEY_STORE stores
+	XksProxyConfiguration      *XksProxyConfiguration `json:",omitempty"`
+	XksProxyRawSecretAccessKey string                 `json:",omitempty"`
+
+	ConnectionState     ConnectionState
+	ConnectionErrorCode ConnectionErrorCode `json:",omitempty"`
+	CreationDate        int64
+}
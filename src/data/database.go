@@ -1,27 +1,101 @@
 package data
 
 import (
-	"github.com/syndtr/goleveldb/leveldb"
+	"errors"
+	"sync"
 )
 
+/*
+Returned when a key is saved after it's been changed by someone else since it was loaded.
+*/
+var ErrConflict = errors.New("data: the key was changed concurrently")
+
 type Database struct {
-	database *leveldb.DB
-}
+	storage Storage
 
-func NewDatabase(path string) *Database {
+	// Held while records are checked and written, so keys' versions are checked and incremented atomically.
+	writes *sync.Mutex
 
-	db, err := leveldb.OpenFile(path, nil)
-	if err != nil {
-		panic(err)
+	// Set when a save conflicts with a concurrent change. Nil outside of a session.
+	conflicted *bool
+
+	// Set once anything has been written through the session. Nil outside of a session.
+	written *bool
+}
+
+func NewDatabase(storage Storage) *Database {
+	return &Database{
+		storage: storage,
+		writes:  new(sync.Mutex),
 	}
+}
+
+/*
+Returns a view of the database for a single request, which records whether any of the request's saves conflicted with
+a concurrent change, and whether anything was written. If a save conflicted before anything was written, none of the
+request's changes have been applied, so it can be retried.
+*/
+func (d *Database) Session() *Database {
+	written := new(bool)
 
 	return &Database{
-		database: db,
+		storage:    &sessionStorage{d.storage, written},
+		writes:     d.writes,
+		conflicted: new(bool),
+		written:    written,
+	}
+}
+
+/*
+Returns true if a save made through the session conflicted with a concurrent change.
+*/
+func (d *Database) Conflicted() bool {
+	return d.conflicted != nil && *d.conflicted
+}
+
+/*
+Returns true if anything has been written through the session.
+*/
+func (d *Database) Written() bool {
+	return d.written != nil && *d.written
+}
+
+func (d *Database) recordConflict(err error) error {
+	if err == ErrConflict && d.conflicted != nil {
+		*d.conflicted = true
 	}
+	return err
 }
 
 func (d *Database) Close() {
-	d.database.Close()
+	d.storage.Close()
+}
+
+/*
+Records when a write succeeds.
+*/
+type sessionStorage struct {
+	Storage
+	written *bool
+}
+
+func (s *sessionStorage) Put(key string, value []byte) error {
+	return s.record(s.Storage.Put(key, value))
+}
+
+func (s *sessionStorage) Delete(key string) error {
+	return s.record(s.Storage.Delete(key))
+}
+
+func (s *sessionStorage) Write(batch *Batch) error {
+	return s.record(s.Storage.Write(batch))
+}
+
+func (s *sessionStorage) record(err error) error {
+	if err == nil {
+		*s.written = true
+	}
+	return err
 }
 
 //------------------------------------
@@ -36,5 +110,5 @@ func (e *InvalidMarkerExceptionError) Error() string {
 
 // Can delete any object type. e.g. key, alias, etc.
 func (d *Database) DeleteObject(arn string) error {
-	return d.database.Delete([]byte(arn), nil)
+	return d.storage.Delete(arn)
 }
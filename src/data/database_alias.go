@@ -2,7 +2,6 @@ package data
 
 import (
 	"encoding/json"
-	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 func (d *Database) SaveAlias(a *Alias) error {
@@ -11,12 +10,12 @@ func (d *Database) SaveAlias(a *Alias) error {
 		return err
 	}
 
-	return d.database.Put([]byte(a.AliasArn), encoded, nil)
+	return d.storage.Put(a.AliasArn, encoded)
 }
 
 func (d *Database) LoadAlias(arn string) (*Alias, error) {
 
-	encoded, err := d.database.Get([]byte(arn), nil)
+	encoded, err := d.storage.Get(arn)
 
 	if err != nil {
 		return nil, err
@@ -32,40 +31,42 @@ func (d *Database) LoadAlias(arn string) (*Alias, error) {
 
 func (d *Database) ListAlias(prefix string, limit int64, marker, key string) (aliases []*Alias, err error) {
 
-	iter := d.database.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
-
 	var count int64 = 0
 
 	pastMarker := false
 
-	for count < limit && iter.Next() {
+	iterErr := d.storage.Iterate(prefix, func(k string, value []byte) bool {
 
 		// If there's a marker, and we're not already past it, and the current item does not match the marker:
-		if marker != "" && !pastMarker && marker != string(iter.Key()) {
-			continue
+		if marker != "" && !pastMarker && marker != k {
+			return true
 		}
 
 		pastMarker = true
 
 		var a Alias
 
-		err = json.Unmarshal(iter.Value(), &a)
+		err = json.Unmarshal(value, &a)
 		if err != nil {
-			return
+			return false
 		}
 
 		if key != "" && a.TargetKeyId != key {
 			// If we're filtering by key, skip entry if the key doesn't match.
-			continue
+			return true
 		}
 
 		aliases = append(aliases, &a)
 
 		count++
+		return count < limit
+	})
+
+	if err != nil {
+		return
 	}
 
-	iter.Release()
-	err = iter.Error()
+	err = iterErr
 
 	if marker != "" && !pastMarker {
 		err = &InvalidMarkerExceptionError{}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"encoding/json"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+/*
+Custom key stores are saved under the region's ARN prefix, plus the store's ID.
+*/
+func (d *Database) SaveCustomKeyStore(prefix string, s *CustomKeyStore) error {
+	return d.SaveCustomKeyStoreWithKeys(prefix, s, nil)
+}
+
+/*
+Saves the custom key store along with changes to its keys, in a single write. Returns ErrConflict if any of the keys
+have been changed since they were loaded.
+*/
+func (d *Database) SaveCustomKeyStoreWithKeys(prefix string, s *CustomKeyStore, keys []cmk.Key) error {
+	return d.recordConflict(d.write(func(batch *Batch) error {
+		for _, k := range keys {
+			if err := d.putKey(batch, k); err != nil {
+				return err
+			}
+		}
+
+		encoded, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		batch.Put(prefix+"custom-key-store/"+s.CustomKeyStoreId, encoded)
+		return nil
+	}))
+}
+
+func (d *Database) LoadCustomKeyStore(prefix, id string) (*CustomKeyStore, error) {
+
+	encoded, err := d.storage.Get(prefix + "custom-key-store/" + id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//---
+
+	var s CustomKeyStore
+	err = json.Unmarshal(encoded, &s)
+
+	return &s, err
+}
+
+func (d *Database) DeleteCustomKeyStore(prefix, id string) error {
+	return d.DeleteObject(prefix + "custom-key-store/" + id)
+}
+
+func (d *Database) ListCustomKeyStores(prefix string) (stores []*CustomKeyStore, err error) {
+
+	iterErr := d.storage.Iterate(prefix+"custom-key-store/", func(k string, value []byte) bool {
+		var s CustomKeyStore
+
+		err = json.Unmarshal(value, &s)
+		if err != nil {
+			return false
+		}
+
+		stores = append(stores, &s)
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stores, iterErr
+}
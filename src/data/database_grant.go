@@ -0,0 +1,134 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+func (d *Database) SaveGrant(k cmk.Key, g *Grant) error {
+	encoded, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	// We save under a value of the key's ARN, plus the grant's ID.
+	return d.storage.Put(k.GetArn()+"/grant/"+g.GrantId, encoded)
+}
+
+func (d *Database) LoadGrant(keyArn, grantId string) (*Grant, error) {
+
+	encoded, err := d.storage.Get(keyArn + "/grant/" + grantId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//---
+
+	var g Grant
+	err = json.Unmarshal(encoded, &g)
+
+	return &g, err
+}
+
+func (d *Database) DeleteGrant(g *Grant) error {
+	return d.DeleteObject(g.KeyId + "/grant/" + g.GrantId)
+}
+
+func (d *Database) ListGrants(prefix string, limit int64, marker string) (grants []*Grant, err error) {
+
+	var count int64 = 0
+
+	pastMarker := false
+
+	// The prefix is the Key's ARN, plus /grant
+	iterErr := d.storage.Iterate(prefix+"/grant/", func(k string, value []byte) bool {
+
+		// If there's a marker, and we're not already past it, and the current item does not match the marker:
+		// The marker needs the Key ARN and /grant/ including
+		if marker != "" && !pastMarker && prefix+"/grant/"+marker != k {
+			return true
+		}
+
+		pastMarker = true
+
+		var g Grant
+
+		err = json.Unmarshal(value, &g)
+		if err != nil {
+			return false
+		}
+
+		grants = append(grants, &g)
+
+		count++
+		return count < limit
+	})
+
+	if err != nil {
+		return
+	}
+
+	err = iterErr
+
+	if marker != "" && !pastMarker {
+		err = &InvalidMarkerExceptionError{}
+	}
+
+	return
+}
+
+/*
+Returns grants, across all keys under the prefix, that the given principal can retire.
+*/
+func (d *Database) ListRetirableGrants(prefix string, retiringPrincipal string, limit int64, marker string) (grants []*Grant, err error) {
+
+	var count int64 = 0
+
+	pastMarker := false
+
+	iterErr := d.storage.Iterate(prefix, func(k string, value []byte) bool {
+
+		// Only include grants
+		if !strings.Contains(k, "/grant/") {
+			return true
+		}
+
+		var g Grant
+
+		err = json.Unmarshal(value, &g)
+		if err != nil {
+			return false
+		}
+
+		if g.RetiringPrincipal != retiringPrincipal {
+			return true
+		}
+
+		// If there's a marker, and we're not already past it, and the current item does not match the marker:
+		if marker != "" && !pastMarker && marker != g.GrantId {
+			return true
+		}
+
+		pastMarker = true
+
+		grants = append(grants, &g)
+
+		count++
+		return count < limit
+	})
+
+	if err != nil {
+		return
+	}
+
+	err = iterErr
+
+	if marker != "" && !pastMarker {
+		err = &InvalidMarkerExceptionError{}
+	}
+
+	return
+}
@@ -4,84 +4,163 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
-	"time"
 
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
+/*
+Saves the key. Returns ErrConflict if the key has been changed, or deleted, since it was loaded.
+*/
 func (d *Database) SaveKey(k cmk.Key) error {
-	encoded, err := json.Marshal(k)
-	if err != nil {
+	return d.SaveKeyWithTags(k, nil)
+}
+
+/*
+Saves the key along with the given tags, in a single write.
+*/
+func (d *Database) SaveKeyWithTags(k cmk.Key, tags []*Tag) error {
+	return d.recordConflict(d.write(func(batch *Batch) error {
+		err := d.putKey(batch, k)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range tags {
+			err = putTag(batch, k, t)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+}
+
+/*
+Deletes the key, along with its tags, grants, and the aliases that refer to it, in a single write.
+*/
+func (d *Database) DeleteKey(k cmk.Key) error {
+	return d.write(func(batch *Batch) error {
+		return d.deleteKey(batch, k)
+	})
+}
+
+/*
+Calls fn to add writes to a batch, then applies them together. Holds the writes lock throughout, so that what fn reads
+can't change before the batch is written.
+*/
+func (d *Database) write(fn func(batch *Batch) error) error {
+	d.writes.Lock()
+	defer d.writes.Unlock()
+
+	batch := new(Batch)
+
+	err := fn(batch)
+	if err != nil || batch.Len() == 0 {
 		return err
 	}
 
-	return d.database.Put([]byte(k.GetArn()), encoded, nil)
+	return d.storage.Write(batch)
 }
 
-func (d *Database) LoadKey(arn string) (cmk.Key, error) {
+/*
+Adds the key to the batch, with its version incremented. Returns ErrConflict if the stored key's version differs from
+the one loaded. Requires the writes lock.
+*/
+func (d *Database) putKey(batch *Batch, k cmk.Key) error {
 
-	encoded, err := d.database.Get([]byte(arn), nil)
+	encoded, err := d.storage.Get(k.GetArn())
 
-	if err != nil {
-		return nil, err
+	switch {
+	case err == ErrNotFound:
+		// A key that's been loaded, but no longer exists, was deleted concurrently.
+		if k.GetVersion() != 0 {
+			return ErrConflict
+		}
+
+	case err != nil:
+		return err
+
+	default:
+		var stored struct {
+			Version int64
+		}
+
+		err = json.Unmarshal(encoded, &stored)
+		if err != nil {
+			return err
+		}
+
+		if stored.Version != k.GetVersion() {
+			return ErrConflict
+		}
 	}
 
-	//---
+	k.SetVersion(k.GetVersion() + 1)
 
-	key, err := unmarshalKey(encoded)
+	encoded, err = json.Marshal(k)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	//---
+	batch.Put(k.GetArn(), encoded)
+	return nil
+}
 
-	switch k := key.(type) {
-	case *cmk.AesKey:
-		// Rotate the key, if needed
-		if rotated := k.RotateIfNeeded(); rotated {
-			d.SaveKey(k)
-		}
+/*
+Adds the deletion of the key, and all records that depend on it, to the batch. Requires the writes lock.
+*/
+func (d *Database) deleteKey(batch *Batch, k cmk.Key) error {
 
-		key = k
-	case *cmk.EccKey:
-		// This section/switch isn't really needed?
-		key = k
-	case *cmk.RsaKey:
-		// This section/switch isn't really needed?
-		key = k
-	default:
-		return nil, errors.New("key type not supported")
-	}
+	arn := k.GetArn()
 
-	//---
+	batch.Delete(arn)
 
-	// Migrate old keys to new naming
-	if key.GetMetadata().KeySpec == "" {
-		key.GetMetadata().KeySpec = key.GetMetadata().CustomerMasterKeySpec
+	// Tags and grants are stored beneath the key's ARN
+	err := d.storage.Iterate(arn+"/", func(key string, _ []byte) bool {
+		batch.Delete(key)
+		return true
+	})
+	if err != nil {
+		return err
 	}
 
-	//---
+	// Aliases are in the same account and region as their key
+	prefix := strings.TrimSuffix(arn, "key/"+k.GetMetadata().KeyId) + "alias/"
+
+	var unmarshalErr error
+
+	err = d.storage.Iterate(prefix, func(key string, value []byte) bool {
+		var a Alias
+
+		unmarshalErr = json.Unmarshal(value, &a)
+		if unmarshalErr != nil {
+			return false
+		}
 
-	// Delete key if it has expired
-	if key.GetMetadata().DeletionDate != 0 && key.GetMetadata().DeletionDate < time.Now().Unix() {
-		d.DeleteObject(arn)
-		return nil, leveldb.ErrNotFound
+		if a.TargetKeyId == k.GetMetadata().KeyId || a.TargetKeyId == arn {
+			batch.Delete(key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
 	}
 
-	// Reset key to pending import if key material has expired
-	if key.GetMetadata().ValidTo != 0 && key.GetMetadata().ValidTo < time.Now().Unix() {
-		key.GetMetadata().Enabled = false
-		key.GetMetadata().KeyState = cmk.KeyStatePendingImport
-		key.GetMetadata().ExpirationModel = ""
-		key.GetMetadata().ValidTo = 0
-		d.SaveKey(key)
+	return unmarshalErr
+}
+
+func (d *Database) LoadKey(arn string) (cmk.Key, error) {
+
+	encoded, err := d.storage.Get(arn)
+
+	if err != nil {
+		return nil, err
 	}
 
 	//---
 
-	return key, err
+	return unmarshalKey(encoded)
 }
 
 /*
@@ -92,44 +171,42 @@ Returns all keys.
 */
 func (d *Database) ListKeys(prefix string, limit int64, marker string) (keys []cmk.Key, err error) {
 
-	iter := d.database.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
-
 	var count int64 = 0
 
 	pastMarker := false
 
-	for count < limit && iter.Next() {
+	iterErr := d.storage.Iterate(prefix, func(k string, value []byte) bool {
 
-		// Exclude tags
-		if strings.Contains(string(iter.Key()), "/tag/") {
-			continue
+		// Exclude tags and grants
+		if strings.Contains(k, "/tag/") || strings.Contains(k, "/grant/") {
+			return true
 		}
 
 		// If there's a marker, and we're not already past it, and the current item does not match the marker:
-		if marker != "" && !pastMarker && marker != string(iter.Key()) {
-			continue
+		if marker != "" && !pastMarker && marker != k {
+			return true
 		}
 
 		pastMarker = true
 
-		key, err := unmarshalKey(iter.Value())
-		if err != nil {
-			return nil, err
-		}
+		var key cmk.Key
 
-		// Delete key if it has expired
-		if key.GetMetadata().DeletionDate != 0 && key.GetMetadata().DeletionDate < time.Now().Unix() {
-			d.DeleteObject(key.GetArn())
-			continue
+		key, err = unmarshalKey(value)
+		if err != nil {
+			return false
 		}
 
 		keys = append(keys, key)
 
 		count++
+		return count < limit
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	iter.Release()
-	err = iter.Error()
+	err = iterErr
 
 	if marker != "" && !pastMarker {
 		err = &InvalidMarkerExceptionError{}
@@ -144,7 +221,7 @@ func unmarshalKey(encoded []byte) (cmk.Key, error) {
 	// Unmarshal just the key's type
 
 	var kt struct {
-		Type cmk.KeyType
+		Type *cmk.KeyType
 	}
 
 	err := json.Unmarshal(encoded, &kt)
@@ -152,22 +229,29 @@ func unmarshalKey(encoded []byte) (cmk.Key, error) {
 		return nil, err
 	}
 
+	// Keys from before the type was stored are given one when the data is migrated.
+	if kt.Type == nil {
+		return nil, errors.New("key has no type; the data needs migrating")
+	}
+
 	//---------------------------------------------------------
 	// Unmarshal the full key, with the correct Implementation
 
 	var key cmk.Key
 
-	// If no key type has been set, the value of kt.Type will be 0 (an empty int).
-	// Therefore no key type being set will default to an AesKey.
-	// This is the desired behaviour for backwards compatibility.
-
-	switch kt.Type {
+	switch *kt.Type {
 	case cmk.TypeAes:
 		key = new(cmk.AesKey)
 	case cmk.TypeEcc:
 		key = new(cmk.EccKey)
 	case cmk.TypeRsa:
 		key = new(cmk.RsaKey)
+	case cmk.TypeHmac:
+		key = new(cmk.HmacKey)
+	case cmk.TypeEd25519:
+		key = new(cmk.Ed25519Key)
+	case cmk.TypeSm2:
+		key = new(cmk.Sm2Key)
 	default:
 		return nil, errors.New("key type not yet supported")
 	}
@@ -176,3 +260,37 @@ func unmarshalKey(encoded []byte) (cmk.Key, error) {
 
 	return key, err
 }
+
+/*
+Returns all keys held in the given custom key store.
+*/
+func (d *Database) ListKeysInCustomKeyStore(prefix, customKeyStoreId string) (keys []cmk.Key, err error) {
+
+	iterErr := d.storage.Iterate(prefix, func(k string, value []byte) bool {
+
+		// Exclude tags and grants
+		if strings.Contains(k, "/tag/") || strings.Contains(k, "/grant/") {
+			return true
+		}
+
+		var key cmk.Key
+
+		key, err = unmarshalKey(value)
+		if err != nil {
+			return false
+		}
+
+		if key.GetMetadata().CustomKeyStoreId != customKeyStoreId {
+			return true
+		}
+
+		keys = append(keys, key)
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, iterErr
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+/*
+Returns a deep copy of the passed key, with the correct implementation.
+*/
+func CloneKey(k cmk.Key) (cmk.Key, error) {
+	encoded, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalKey(encoded)
+}
+
+/*
+Saves a copy of a multi-Region key, then propagates its shared properties to every other copy.
+
+	Shared properties are the multi-Region configuration and, for symmetric keys, the key material and rotation schedule.
+	All other properties (description, policy, enabled state, deletion, etc.) are set independently on each copy.
+*/
+func (d *Database) SaveMultiRegionKey(source cmk.Key) error {
+	return d.recordConflict(d.write(func(batch *Batch) error {
+		return d.putMultiRegionKey(batch, source)
+	}))
+}
+
+/*
+Saves a new replica of a multi-Region key, along with its tags, then propagates the primary key's configuration, which
+now includes the replica, to every other copy. All of these changes are written together.
+*/
+func (d *Database) SaveReplicaKey(replica cmk.Key, tags []*Tag, primary cmk.Key) error {
+	return d.recordConflict(d.write(func(batch *Batch) error {
+
+		c := primary.GetMetadata().MultiRegionConfiguration
+		replica.GetMetadata().MultiRegionConfiguration = copyMultiRegionConfiguration(c, replica.GetArn())
+
+		err := d.putKey(batch, replica)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range tags {
+			err = putTag(batch, replica, t)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The replica isn't yet stored, so isn't changed again
+		return d.putMultiRegionKey(batch, primary)
+	}))
+}
+
+/*
+Adds the key, and every other copy of it, to the batch. Requires the writes lock.
+*/
+func (d *Database) putMultiRegionKey(batch *Batch, source cmk.Key) error {
+
+	err := d.putKey(batch, source)
+	if err != nil {
+		return err
+	}
+
+	c := source.GetMetadata().MultiRegionConfiguration
+	if c == nil {
+		return nil
+	}
+
+	for _, arn := range c.AllArns() {
+		if arn == source.GetArn() {
+			continue
+		}
+
+		// Loaded directly, to avoid triggering rotation or expiry on the copy.
+		encoded, err := d.storage.Get(arn)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		key, err := unmarshalKey(encoded)
+		if err != nil {
+			return err
+		}
+
+		//---
+
+		key.GetMetadata().MultiRegionConfiguration = copyMultiRegionConfiguration(c, arn)
+
+		if s, ok := source.(*cmk.AesKey); ok {
+			if k, ok := key.(*cmk.AesKey); ok {
+				k.BackingKeys = append([]cmk.BackingKey{}, s.BackingKeys...)
+				k.NextKeyRotation = s.NextKeyRotation
+				k.RotationPeriodInDays = s.RotationPeriodInDays
+			}
+		}
+
+		err = d.putKey(batch, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Deletes an expired key, along with its dependent records. If the key is a multi-Region replica, it's removed from the
+configuration of the remaining copies, and a primary key awaiting its replicas' deletion will start its own waiting
+period once the last is gone. All of these changes are written together.
+
+Returns ErrNotFound if the key has already been deleted, or ErrConflict if it's changed since it was loaded.
+*/
+func (d *Database) deleteExpiredKey(key cmk.Key, now time.Time) error {
+	return d.write(func(batch *Batch) error {
+
+		encoded, err := d.storage.Get(key.GetArn())
+		if err != nil {
+			return err
+		}
+
+		stored, err := unmarshalKey(encoded)
+		if err != nil {
+			return err
+		}
+
+		if stored.GetVersion() != key.GetVersion() {
+			return ErrConflict
+		}
+
+		err = d.deleteKey(batch, key)
+		if err != nil {
+			return err
+		}
+
+		//---
+
+		c := key.GetMetadata().MultiRegionConfiguration
+		if c == nil || c.MultiRegionKeyType != cmk.MultiRegionKeyTypeReplica {
+			return nil
+		}
+
+		encoded, err = d.storage.Get(c.PrimaryKey.Arn)
+		if err != nil {
+			return nil
+		}
+
+		primary, err := unmarshalKey(encoded)
+		if err != nil || primary.GetMetadata().MultiRegionConfiguration == nil {
+			return nil
+		}
+
+		metadata := primary.GetMetadata()
+
+		replicas := []cmk.MultiRegionKey{}
+		for _, r := range metadata.MultiRegionConfiguration.ReplicaKeys {
+			if r.Arn != key.GetArn() {
+				replicas = append(replicas, r)
+			}
+		}
+		metadata.MultiRegionConfiguration.ReplicaKeys = replicas
+
+		if metadata.KeyState == cmk.KeyStatePendingReplicaDeletion && len(replicas) == 0 {
+			metadata.KeyState = cmk.KeyStatePendingDeletion
+			metadata.DeletionDate = now.AddDate(0, 0, int(metadata.PendingDeletionWindowInDays)).Unix()
+		}
+
+		return d.putMultiRegionKey(batch, primary)
+	})
+}
+
+/*
+Returns a copy of the configuration, as seen by the copy of the key with the given ARN.
+*/
+func copyMultiRegionConfiguration(c *cmk.MultiRegionConfiguration, arn string) *cmk.MultiRegionConfiguration {
+	result := &cmk.MultiRegionConfiguration{
+		MultiRegionKeyType: cmk.MultiRegionKeyTypeReplica,
+		PrimaryKey:         c.PrimaryKey,
+		ReplicaKeys:        append([]cmk.MultiRegionKey{}, c.ReplicaKeys...),
+	}
+
+	if arn == c.PrimaryKey.Arn {
+		result.MultiRegionKeyType = cmk.MultiRegionKeyTypePrimary
+	}
+
+	return result
+}
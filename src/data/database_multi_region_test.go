@@ -0,0 +1,157 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+const testKeyId = "mrk-5e0ab35c2d764a8ba3a45ea2b41e3e4b"
+
+func newTestMultiRegionKey(t *testing.T, database *Database) *cmk.AesKey {
+	t.Helper()
+
+	arn := "arn:aws:kms:eu-west-2:111122223333:key/" + testKeyId
+
+	key := cmk.NewAesKey(cmk.KeyMetadata{
+		Arn:          arn,
+		KeyId:        testKeyId,
+		AWSAccountId: "111122223333",
+		Enabled:      true,
+		KeyState:     cmk.KeyStateEnabled,
+		MultiRegion:  true,
+		MultiRegionConfiguration: &cmk.MultiRegionConfiguration{
+			MultiRegionKeyType: cmk.MultiRegionKeyTypePrimary,
+			PrimaryKey:         cmk.MultiRegionKey{Arn: arn, Region: "eu-west-2"},
+		},
+	}, "", cmk.KeyOriginAwsKms)
+
+	if err := database.SaveKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func newTestReplica(t *testing.T, primary cmk.Key) cmk.Key {
+	t.Helper()
+
+	replica, err := CloneKey(primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arn := "arn:aws:kms:us-east-1:111122223333:key/" + testKeyId
+
+	replica.SetVersion(0)
+	replica.GetMetadata().Arn = arn
+
+	c := primary.GetMetadata().MultiRegionConfiguration
+	c.ReplicaKeys = append(c.ReplicaKeys, cmk.MultiRegionKey{Arn: arn, Region: "us-east-1"})
+
+	return replica
+}
+
+func TestSaveReplicaKey(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+	primary := newTestMultiRegionKey(t, database)
+
+	replica := newTestReplica(t, primary)
+
+	err := database.SaveReplicaKey(replica, []*Tag{{TagKey: "purpose", TagValue: "test"}}, primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each copy sees the replica, from its own point of view
+	for arn, keyType := range map[string]cmk.MultiRegionKeyType{
+		primary.GetArn(): cmk.MultiRegionKeyTypePrimary,
+		replica.GetArn(): cmk.MultiRegionKeyTypeReplica,
+	} {
+		key, err := database.LoadKey(arn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := key.GetMetadata().MultiRegionConfiguration
+		if c.MultiRegionKeyType != keyType || len(c.ReplicaKeys) != 1 || c.ReplicaKeys[0].Arn != replica.GetArn() {
+			t.Errorf("%s: unexpected configuration %+v", arn, c)
+		}
+	}
+
+	if tags, _ := database.ListTags(replica.GetArn(), 0, ""); len(tags) != 1 || tags[0].TagValue != "test" {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+}
+
+func TestSaveReplicaKeyConflict(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+	primary := newTestMultiRegionKey(t, database)
+
+	// The primary is changed concurrently
+	concurrent, _ := database.LoadKey(primary.GetArn())
+	if err := database.SaveKey(concurrent); err != nil {
+		t.Fatal(err)
+	}
+
+	session := database.Session()
+	replica := newTestReplica(t, primary)
+
+	err := session.SaveReplicaKey(replica, []*Tag{{TagKey: "purpose", TagValue: "test"}}, primary)
+	if err != ErrConflict || !session.Conflicted() {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	// Nothing was written, so it can be retried
+	if session.Written() {
+		t.Error("expected nothing to have been written")
+	}
+
+	if _, err := database.LoadKey(replica.GetArn()); err != ErrNotFound {
+		t.Errorf("expected the replica not to have been saved, got %v", err)
+	}
+
+	if tags, _ := database.ListTags(replica.GetArn(), 0, ""); len(tags) != 0 {
+		t.Errorf("expected the replica's tags not to have been saved, got %+v", tags)
+	}
+}
+
+func TestSaveCustomKeyStoreWithKeysConflict(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+	prefix := "arn:aws:kms:eu-west-2:111122223333:"
+
+	key := newTestMultiRegionKey(t, database)
+
+	concurrent, _ := database.LoadKey(key.GetArn())
+	if err := database.SaveKey(concurrent); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &CustomKeyStore{CustomKeyStoreId: "cks-1234567890abcdef0", ConnectionState: ConnectionStateConnected}
+
+	session := database.Session()
+	if err := session.SaveCustomKeyStoreWithKeys(prefix, store, []cmk.Key{key}); err != ErrConflict {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	if session.Written() {
+		t.Error("expected nothing to have been written")
+	}
+
+	if _, err := database.LoadCustomKeyStore(prefix, store.CustomKeyStoreId); err != ErrNotFound {
+		t.Errorf("expected the store not to have been saved, got %v", err)
+	}
+
+	// Once the key is up to date, both are saved
+	if err := session.SaveCustomKeyStoreWithKeys(prefix, store, []cmk.Key{concurrent}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !session.Written() {
+		t.Error("expected the write to have been recorded")
+	}
+
+	if _, err := database.LoadCustomKeyStore(prefix, store.CustomKeyStoreId); err != nil {
+		t.Errorf("expected the store to have been saved, got %v", err)
+	}
+}
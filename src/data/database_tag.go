@@ -3,52 +3,84 @@ package data
 import (
 	"encoding/json"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 func (d *Database) SaveTag(k cmk.Key, t *Tag) error {
+	return d.SaveTags(k, []*Tag{t})
+}
+
+/*
+Saves the tags in a single write.
+*/
+func (d *Database) SaveTags(k cmk.Key, tags []*Tag) error {
+	return d.write(func(batch *Batch) error {
+		for _, t := range tags {
+			if err := putTag(batch, k, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+Deletes the tags with the given keys in a single write.
+*/
+func (d *Database) DeleteTags(k cmk.Key, tagKeys []string) error {
+	return d.write(func(batch *Batch) error {
+		for _, tagKey := range tagKeys {
+			batch.Delete(k.GetArn() + "/tag/" + tagKey)
+		}
+		return nil
+	})
+}
+
+func putTag(batch *Batch, k cmk.Key, t *Tag) error {
 	encoded, err := json.Marshal(t)
 	if err != nil {
 		return err
 	}
 
 	// We save under a value of the key's ARN, plus the tag key value.
-	return d.database.Put([]byte(k.GetArn()+"/tag/"+t.TagKey), encoded, nil)
+	batch.Put(k.GetArn()+"/tag/"+t.TagKey, encoded)
+	return nil
 }
 
 func (d *Database) ListTags(prefix string, limit int64, marker string) (tags []*Tag, err error) {
 
-	// The prefix is the Key's ARN, plus /tag
-	iter := d.database.NewIterator(util.BytesPrefix([]byte(prefix+"/tag")), nil)
-
 	var count int64 = 0
 
 	pastMarker := false
 
-	for count < limit && iter.Next() {
+	// The prefix is the Key's ARN, plus /tag
+	iterErr := d.storage.Iterate(prefix+"/tag", func(k string, value []byte) bool {
 
 		// If there's a marker, and we're not already past it, and the current item does not match the marker:
 		// The marker needs the Key ARN and /tag/ including
-		if marker != "" && !pastMarker && prefix+"/tag/"+marker != string(iter.Key()) {
-			continue
+		if marker != "" && !pastMarker && prefix+"/tag/"+marker != k {
+			return true
 		}
 
 		pastMarker = true
 
 		var t Tag
 
-		err = json.Unmarshal(iter.Value(), &t)
+		err = json.Unmarshal(value, &t)
 		if err != nil {
-			return
+			return false
 		}
 
 		tags = append(tags, &t)
 
 		count++
+		return count < limit
+	})
+
+	if err != nil {
+		return
 	}
 
-	iter.Release()
-	err = iter.Error()
+	err = iterErr
 
 	if marker != "" && !pastMarker {
 		err = &InvalidMarkerExceptionError{}
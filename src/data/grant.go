@@ -0,0 +1,79 @@
+package data
+
+type GrantOperation string
+
+const (
+	GrantOperationDecrypt                             GrantOperation = "Decrypt"
+	GrantOperationEncrypt                             GrantOperation = "Encrypt"
+	GrantOperationGenerateDataKey                     GrantOperation = "GenerateDataKey"
+	GrantOperationGenerateDataKeyWithoutPlaintext     GrantOperation = "GenerateDataKeyWithoutPlaintext"
+	GrantOperationGenerateDataKeyPair                 GrantOperation = "GenerateDataKeyPair"
+	GrantOperationGenerateDataKeyPairWithoutPlaintext GrantOperation = "GenerateDataKeyPairWithoutPlaintext"
+	GrantOperationReEncryptFrom                       GrantOperation = "ReEncryptFrom"
+	GrantOperationReEncryptTo                         GrantOperation = "ReEncryptTo"
+	GrantOperationSign                                GrantOperation = "Sign"
+	GrantOperationVerify                              GrantOperation = "Verify"
+	GrantOperationGetPublicKey                        GrantOperation = "GetPublicKey"
+	GrantOperationCreateGrant                         GrantOperation = "CreateGrant"
+	GrantOperationRetireGrant                         GrantOperation = "RetireGrant"
+	GrantOperationDescribeKey                         GrantOperation = "DescribeKey"
+	GrantOperationGenerateMac                         GrantOperation = "GenerateMac"
+	GrantOperationVerifyMac                           GrantOperation = "VerifyMac"
+	GrantOperationDeriveSharedSecret                  GrantOperation = "DeriveSharedSecret"
+)
+
+type GrantConstraints struct {
+	EncryptionContextEquals map[string]string `json:",omitempty"`
+	EncryptionContextSubset map[string]string `json:",omitempty"`
+}
+
+type Grant struct {
+	KeyId             string
+	GrantId           string
+	Name              string `json:",omitempty"`
+	CreationDate      int64
+	GranteePrincipal  string
+	RetiringPrincipal string `json:",omitempty"`
+	IssuingAccount    string
+	Operations        []GrantOperation
+	Constraints       *GrantConstraints `json:",omitempty"`
+}
+
+func (g *Grant) AllowsOperation(operation GrantOperation) bool {
+	for _, o := range g.Operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Confirms the passed encryption context satisfies the grant's constraints, if it has any.
+*/
+func (g *Grant) AllowsEncryptionContext(context map[string]*string) bool {
+	if g.Constraints == nil {
+		return true
+	}
+
+	if g.Constraints.EncryptionContextEquals != nil {
+		if len(context) != len(g.Constraints.EncryptionContextEquals) {
+			return false
+		}
+		for k, v := range g.Constraints.EncryptionContextEquals {
+			if c, ok := context[k]; !ok || c == nil || *c != v {
+				return false
+			}
+		}
+	}
+
+	if g.Constraints.EncryptionContextSubset != nil {
+		for k, v := range g.Constraints.EncryptionContextSubset {
+			if c, ok := context[k]; !ok || c == nil || *c != v {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+/*
+A change made as a key's lifecycle progresses, either to the key itself, or to a record left behind once it's deleted.
+*/
+type Transition struct {
+	Arn         string
+	Description string
+}
+
+/*
+Applies every lifecycle transition that's due by now, across all keys: deletion once a key's waiting period is over, automatic
+rotation, and the expiry of imported key material and of import parameters. Tags, grants and aliases left behind by
+keys that no longer exist are removed. Returns the transitions made.
+
+Keys changed concurrently are skipped; their transitions are applied on the next call.
+*/
+func (d *Database) AdvanceLifecycle(now time.Time) ([]Transition, error) {
+
+	var keyArns, dependents, aliases []string
+
+	err := d.storage.Iterate("arn:", func(k string, _ []byte) bool {
+		switch {
+		case isKeyRecord(k):
+			keyArns = append(keyArns, k)
+		case strings.Contains(k, ":key/"):
+			dependents = append(dependents, k)
+		case strings.Contains(k, ":alias/"):
+			aliases = append(aliases, k)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var transitions []Transition
+
+	for _, arn := range keyArns {
+		encoded, err := d.storage.Get(arn)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return transitions, err
+		}
+
+		key, err := unmarshalKey(encoded)
+		if err != nil {
+			return transitions, err
+		}
+
+		_, descriptions, err := d.advanceKey(key, now)
+		if err == ErrConflict {
+			// Changed since it was loaded, so left for the next run
+			continue
+		} else if err != nil {
+			return transitions, err
+		}
+
+		for _, description := range descriptions {
+			transitions = append(transitions, Transition{Arn: arn, Description: description})
+		}
+	}
+
+	//---
+
+	orphans, err := d.deleteOrphans(dependents, aliases)
+
+	return append(transitions, orphans...), err
+}
+
+/*
+Applies any lifecycle transitions that are due to the key by now. Returns the key as it now is, or nil if it's been deleted,
+along with a description of each transition made.
+*/
+func (d *Database) advanceKey(key cmk.Key, now time.Time) (cmk.Key, []string, error) {
+
+	if DeletionDue(key, now) {
+		err := d.deleteExpiredKey(key, now)
+		if err == ErrNotFound {
+			// Deleted concurrently
+			return nil, nil, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		return nil, []string{"Key deleted"}, nil
+	}
+
+	var transitions []string
+
+	rotated := false
+	if k, ok := key.(*cmk.AesKey); ok {
+		rotated = k.RotateIfNeeded(now)
+		if rotated {
+			transitions = append(transitions, "Key rotated")
+		}
+	}
+
+	if ExpireKeyMaterial(key, now) {
+		transitions = append(transitions, "Key material expired")
+	}
+
+	if k, ok := key.(cmk.ImportableKey); ok {
+		// The wrapping key is discarded. The token is kept, so using it is still reported as expired, not invalid.
+		if p := *k.GetParametersForImport(); p.PrivateKey.N != nil && p.ParametersValidTo < now.Unix() {
+			p.PrivateKey = rsa.PrivateKey{}
+			k.SetParametersForImport(&p)
+
+			transitions = append(transitions, "Import parameters expired")
+		}
+	}
+
+	if len(transitions) == 0 {
+		return key, nil, nil
+	}
+
+	err := d.write(func(batch *Batch) error {
+		// Replicas of a multi-Region key share the new key material
+		if rotated {
+			return d.putMultiRegionKey(batch, key)
+		}
+		return d.putKey(batch, key)
+	})
+
+	return key, transitions, err
+}
+
+/*
+Returns true if the key's waiting period is over, so it's due to be deleted.
+*/
+func DeletionDue(key cmk.Key, now time.Time) bool {
+	deletionDate := key.GetMetadata().DeletionDate
+	return deletionDate != 0 && deletionDate < now.Unix()
+}
+
+/*
+Resets the key to pending import if its imported key material has expired by now. Returns true if it had.
+*/
+func ExpireKeyMaterial(key cmk.Key, now time.Time) bool {
+	metadata := key.GetMetadata()
+
+	if metadata.ValidTo == 0 || metadata.ValidTo >= now.Unix() {
+		return false
+	}
+
+	metadata.Enabled = false
+	metadata.KeyState = cmk.KeyStatePendingImport
+	metadata.ExpirationModel = ""
+	metadata.ValidTo = 0
+
+	return true
+}
+
+/*
+Removes the tags, grants and aliases of keys that no longer exist. Each record is checked again under the writes lock,
+so none created alongside a new key can be removed.
+*/
+func (d *Database) deleteOrphans(dependents, aliases []string) ([]Transition, error) {
+
+	var transitions []Transition
+
+	err := d.write(func(batch *Batch) error {
+
+		exists := func(arn string) (bool, error) {
+			_, err := d.storage.Get(arn)
+			if err == ErrNotFound {
+				return false, nil
+			}
+			return err == nil, err
+		}
+
+		// Tags and grants are stored beneath their key's ARN. e.g. <key arn>/tag/<tag key>
+		for _, k := range dependents {
+			parts := strings.SplitN(k, "/", 3)
+			if len(parts) != 3 {
+				continue
+			}
+
+			found, err := exists(parts[0] + "/" + parts[1])
+			if err != nil {
+				return err
+			}
+
+			// Skipped if it was removed along with its key
+			stored, err := exists(k)
+			if err != nil {
+				return err
+			}
+
+			if !found && stored {
+				batch.Delete(k)
+				transitions = append(transitions, Transition{Arn: k, Description: "Orphaned " + strings.SplitN(parts[2], "/", 2)[0] + " removed"})
+			}
+		}
+
+		for _, k := range aliases {
+			encoded, err := d.storage.Get(k)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			var a Alias
+
+			err = json.Unmarshal(encoded, &a)
+			if err != nil {
+				return err
+			}
+
+			// Aliases are in the same account and region as their key
+			target := a.TargetKeyId
+			if !strings.HasPrefix(target, "arn:") {
+				target = k[:strings.Index(k, ":alias/")] + ":key/" + target
+			}
+
+			found, err := exists(target)
+			if err != nil {
+				return err
+			}
+
+			if !found {
+				batch.Delete(k)
+				transitions = append(transitions, Transition{Arn: k, Description: "Orphaned alias removed"})
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return transitions, nil
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+// The time transitions are applied at, so none depend on when the tests run.
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestKey(t *testing.T, database *Database, keyId string, configure func(k *cmk.AesKey)) *cmk.AesKey {
+	t.Helper()
+
+	key := cmk.NewAesKey(cmk.KeyMetadata{
+		Arn:          testPrefix + "key/" + keyId,
+		KeyId:        keyId,
+		AWSAccountId: "111122223333",
+		Enabled:      true,
+		KeyState:     cmk.KeyStateEnabled,
+	}, "", cmk.KeyOriginAwsKms)
+
+	configure(key)
+
+	if err := database.SaveKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func advanceLifecycle(t *testing.T, database *Database, now time.Time, expected ...string) {
+	t.Helper()
+
+	transitions, err := database.AdvanceLifecycle(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	made := []string{}
+	for _, transition := range transitions {
+		made = append(made, fmt.Sprintf("%s: %s", transition.Description, strings.TrimPrefix(transition.Arn, testPrefix)))
+	}
+
+	sort.Strings(made)
+	sort.Strings(expected)
+
+	if strings.Join(made, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected transitions %q, got %q", expected, made)
+	}
+}
+
+//------------------------------------
+
+func TestAdvanceLifecycleRotation(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+
+	key := newTestKey(t, database, "rotated", func(k *cmk.AesKey) {
+		k.NextKeyRotation = testNow.Add(time.Second)
+	})
+
+	// Not yet due
+	advanceLifecycle(t, database, testNow)
+
+	advanceLifecycle(t, database, testNow.Add(2*time.Second), "Key rotated: key/rotated")
+
+	stored, err := database.LoadKey(key.GetArn())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := stored.(*cmk.AesKey)
+
+	if len(rotated.BackingKeys) != 2 || !rotated.LastRotationDate().Equal(testNow.Add(2*time.Second)) {
+		t.Errorf("expected a rotation at %s, got %+v", testNow.Add(2*time.Second), rotated.BackingKeys)
+	}
+
+	if expected := testNow.Add(2*time.Second).AddDate(0, 0, cmk.DefaultRotationPeriodInDays); !rotated.NextKeyRotation.Equal(expected) {
+		t.Errorf("expected the next rotation at %s, got %s", expected, rotated.NextKeyRotation)
+	}
+
+	// Applied once
+	advanceLifecycle(t, database, testNow.Add(3*time.Second))
+}
+
+func TestAdvanceLifecycleDeletion(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+
+	key := newTestKey(t, database, "deleted", func(k *cmk.AesKey) {
+		k.Metadata.Enabled = false
+		k.Metadata.KeyState = cmk.KeyStatePendingDeletion
+		k.Metadata.DeletionDate = testNow.Add(time.Second).Unix()
+	})
+
+	if err := database.SaveTag(key, &Tag{TagKey: "purpose", TagValue: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	alias := &Alias{AliasArn: testPrefix + "alias/deleted", AliasName: "alias/deleted", TargetKeyId: "deleted"}
+	if err := database.SaveAlias(alias); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys are only deleted by AdvanceLifecycle, not when they're read
+	advanceLifecycle(t, database, testNow)
+
+	if _, err := database.LoadKey(key.GetArn()); err != nil {
+		t.Errorf("expected the key to still exist, got %v", err)
+	}
+
+	advanceLifecycle(t, database, testNow.Add(2*time.Second), "Key deleted: key/deleted")
+
+	if _, err := database.LoadKey(key.GetArn()); err != ErrNotFound {
+		t.Errorf("expected the key to have been deleted, got %v", err)
+	}
+
+	if keys, err := database.ListKeys(testPrefix+"key/", 100, ""); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys, got %d, %v", len(keys), err)
+	}
+
+	// Along with its tags and aliases
+	expectRecord(t, database.storage, key.GetArn()+"/tag/purpose", "")
+	expectRecord(t, database.storage, alias.AliasArn, "")
+}
+
+func TestAdvanceLifecycleKeyMaterialExpiry(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+
+	key := newTestKey(t, database, "imported", func(k *cmk.AesKey) {
+		k.Metadata.Origin = cmk.KeyOriginExternal
+		k.Metadata.ExpirationModel = cmk.ExpirationModelKeyMaterialExpires
+		k.Metadata.ValidTo = testNow.Add(time.Second).Unix()
+	})
+
+	advanceLifecycle(t, database, testNow)
+	advanceLifecycle(t, database, testNow.Add(2*time.Second), "Key material expired: key/imported")
+
+	stored, err := database.LoadKey(key.GetArn())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m := stored.GetMetadata(); m.KeyState != cmk.KeyStatePendingImport || m.Enabled || m.ValidTo != 0 || m.ExpirationModel != "" {
+		t.Errorf("expected the key to be pending import, got %+v", m)
+	}
+}
+
+func TestAdvanceLifecycleReplicaDeletion(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+	primary := newTestMultiRegionKey(t, database)
+
+	replica := newTestReplica(t, primary)
+	if err := database.SaveReplicaKey(replica, nil, primary); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary waits for its replica to be deleted
+	primary.Metadata.Enabled = false
+	primary.Metadata.KeyState = cmk.KeyStatePendingReplicaDeletion
+	primary.Metadata.PendingDeletionWindowInDays = 7
+
+	replica.GetMetadata().Enabled = false
+	replica.GetMetadata().KeyState = cmk.KeyStatePendingDeletion
+	replica.GetMetadata().DeletionDate = testNow.Add(-time.Second).Unix()
+
+	for _, k := range []cmk.Key{primary, replica} {
+		if err := database.SaveKey(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	advanceLifecycle(t, database, testNow, "Key deleted: "+replica.GetArn())
+
+	stored, err := database.LoadKey(primary.GetArn())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Its own waiting period starts once the last replica is gone
+	m := stored.GetMetadata()
+
+	if m.KeyState != cmk.KeyStatePendingDeletion || m.DeletionDate != testNow.AddDate(0, 0, 7).Unix() {
+		t.Errorf("expected the primary to be deleted at %s, got %s, %s", testNow.AddDate(0, 0, 7), m.KeyState, time.Unix(m.DeletionDate, 0))
+	}
+
+	if len(m.MultiRegionConfiguration.ReplicaKeys) != 0 {
+		t.Errorf("expected no replicas, got %+v", m.MultiRegionConfiguration.ReplicaKeys)
+	}
+}
+
+func TestAdvanceLifecycleOrphans(t *testing.T) {
+	database := NewDatabase(NewMemoryStorage())
+
+	key := newTestKey(t, database, "kept", func(k *cmk.AesKey) {})
+
+	records := map[string]string{
+		testPrefix + "key/kept/tag/purpose":  `{"TagKey":"purpose","TagValue":"test"}`,
+		testPrefix + "alias/kept":            `{"AliasName":"alias/kept","TargetKeyId":"kept"}`,
+		testPrefix + "key/gone/tag/purpose":  `{"TagKey":"purpose","TagValue":"test"}`,
+		testPrefix + "key/gone/grant/grant1": `{}`,
+		testPrefix + "alias/gone":            `{"AliasName":"alias/gone","TargetKeyId":"gone"}`,
+	}
+
+	for k, value := range records {
+		if err := database.storage.Put(k, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	advanceLifecycle(t, database, testNow,
+		"Orphaned tag removed: key/gone/tag/purpose",
+		"Orphaned grant removed: key/gone/grant/grant1",
+		"Orphaned alias removed: alias/gone",
+	)
+
+	expectRecord(t, database.storage, key.GetArn()+"/tag/purpose", records[key.GetArn()+"/tag/purpose"])
+	expectRecord(t, database.storage, testPrefix+"alias/kept", records[testPrefix+"alias/kept"])
+
+	for _, k := range []string{"key/gone/tag/purpose", "key/gone/grant/grant1", "alias/gone"} {
+		expectRecord(t, database.storage, testPrefix+k, "")
+	}
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	masterKeyLength = 32
+
+	passphraseKDF        = "PBKDF2-HMAC-SHA256"
+	passphraseIterations = 600000
+	passphraseSaltLength = 16
+)
+
+// Encrypted with the master key, to check the right key has been given.
+var masterKeyCheck = []byte("local-kms master key")
+
+/*
+The key records are encrypted with, at rest. Either read from a key file, or derived from a passphrase.
+*/
+type MasterKey interface {
+	// Returns a new header for the key, along with the key itself. Passphrases are given a fresh salt.
+	newHeader() (*encryptionHeader, []byte, error)
+
+	// Returns the key described by an existing header.
+	derive(h *encryptionHeader) ([]byte, error)
+}
+
+/*
+Describes how the master key is obtained, and allows it to be checked. Stored, unencrypted, alongside the records.
+*/
+type encryptionHeader struct {
+	KDF        string `json:",omitempty"`
+	Iterations int    `json:",omitempty"`
+	Salt       []byte `json:",omitempty"`
+
+	// masterKeyCheck, encrypted with the master key
+	Check []byte
+}
+
+/*
+Returns the cipher for the master key, or an error if the key doesn't match the header.
+*/
+func (h *encryptionHeader) open(key MasterKey) (cipher.AEAD, error) {
+
+	k, err := key.derive(h)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := open(aead, h.Check, nil); err != nil {
+		return nil, errors.New("the master key does not match the one the data was encrypted with")
+	}
+
+	return aead, nil
+}
+
+//------------------------------------
+// Key files
+
+type fileMasterKey struct {
+	key []byte
+}
+
+/*
+Reads a master key from a file, holding either 32 bytes, or 32 bytes base64 encoded. One can be generated with:
+
+	openssl rand -base64 32 > master.key
+*/
+func LoadMasterKeyFile(path string) (MasterKey, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) == masterKeyLength {
+		return &fileMasterKey{content}, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != masterKeyLength {
+		return nil, fmt.Errorf("%s must hold a %d byte key, either raw or base64 encoded", path, masterKeyLength)
+	}
+
+	return &fileMasterKey{key}, nil
+}
+
+func (k *fileMasterKey) newHeader() (*encryptionHeader, []byte, error) {
+	return &encryptionHeader{}, k.key, nil
+}
+
+func (k *fileMasterKey) derive(h *encryptionHeader) ([]byte, error) {
+	if h.KDF != "" {
+		return nil, errors.New("the data was encrypted with a passphrase, not a key file")
+	}
+	return k.key, nil
+}
+
+//------------------------------------
+// Passphrases
+
+type passphraseMasterKey struct {
+	passphrase string
+}
+
+/*
+Derives the master key from a passphrase, using PBKDF2. The salt is stored alongside the records.
+*/
+func NewPassphraseMasterKey(passphrase string) MasterKey {
+	return &passphraseMasterKey{passphrase}
+}
+
+func (k *passphraseMasterKey) newHeader() (*encryptionHeader, []byte, error) {
+
+	h := &encryptionHeader{
+		KDF:        passphraseKDF,
+		Iterations: passphraseIterations,
+		Salt:       make([]byte, passphraseSaltLength),
+	}
+
+	if _, err := rand.Read(h.Salt); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := k.derive(h)
+	return h, key, err
+}
+
+func (k *passphraseMasterKey) derive(h *encryptionHeader) ([]byte, error) {
+	switch h.KDF {
+	case passphraseKDF:
+		return pbkdf2.Key([]byte(k.passphrase), h.Salt, h.Iterations, masterKeyLength, sha256.New), nil
+	case "":
+		return nil, errors.New("the data was encrypted with a key file, not a passphrase")
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %s", h.KDF)
+	}
+}
+
+//------------------------------------
+// AES-GCM
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+Encrypts the plaintext, returning it prefixed with its nonce.
+*/
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+}
@@ -0,0 +1,145 @@
+package data
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func newTestMasterKey(b byte) MasterKey {
+	return &fileMasterKey{bytes.Repeat([]byte{b}, masterKeyLength)}
+}
+
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		hash       func() hash.Hash
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		// RFC 6070, PBKDF2-HMAC-SHA1
+		{sha1.New, "password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{sha1.New, "password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{sha1.New, "password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{sha1.New, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{sha1.New, "pass\x00word", "sa\x00lt", 4096, "56fa6aa75548099dcc37d7f03425e0c3"},
+
+		// RFC 7914, PBKDF2-HMAC-SHA256, truncated to the master key's length
+		{sha256.New, "passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{sha256.New, "Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	}
+
+	for _, test := range tests {
+		expected, _ := hex.DecodeString(test.expected)
+
+		if key := pbkdf2.Key([]byte(test.password), []byte(test.salt), test.iterations, len(expected), test.hash); !bytes.Equal(key, expected) {
+			t.Errorf("%q, %q, %d: expected %x, got %x", test.password, test.salt, test.iterations, expected, key)
+		}
+
+		// Passphrases are derived with PBKDF2-HMAC-SHA256
+		if test.hash().Size() != sha256.Size {
+			continue
+		}
+
+		key, err := NewPassphraseMasterKey(test.password).derive(&encryptionHeader{
+			KDF:        passphraseKDF,
+			Iterations: test.iterations,
+			Salt:       []byte(test.salt),
+		})
+		if err != nil || !bytes.Equal(key, expected) {
+			t.Errorf("%q, %q, %d: expected %x, got %x, %v", test.password, test.salt, test.iterations, expected, key, err)
+		}
+	}
+}
+
+func TestLoadMasterKeyFile(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, masterKeyLength)
+
+	tests := map[string]struct {
+		content []byte
+		valid   bool
+	}{
+		"raw":                 {key, true},
+		"base64":              {[]byte(base64.StdEncoding.EncodeToString(key) + "\n"), true},
+		"too short":           {key[:16], false},
+		"base64, too short":   {[]byte(base64.StdEncoding.EncodeToString(key[:16])), false},
+		"not base64 or a key": {[]byte(strings.Repeat("?", 40)), false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "master.key")
+			if err := os.WriteFile(path, test.content, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			masterKey, err := LoadMasterKeyFile(path)
+			if !test.valid {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if derived, _ := masterKey.derive(&encryptionHeader{}); !bytes.Equal(derived, key) {
+				t.Errorf("expected %x, got %x", key, derived)
+			}
+		})
+	}
+}
+
+func TestMasterKeyMismatch(t *testing.T) {
+	header, _, err := createEncryptionHeader(newTestMasterKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := header.open(newTestMasterKey(1)); err != nil {
+		t.Errorf("expected the key to match: %s", err)
+	}
+
+	if _, err := header.open(newTestMasterKey(2)); err == nil {
+		t.Error("expected a different key not to match")
+	}
+
+	if _, err := header.open(NewPassphraseMasterKey("passphrase")); err == nil {
+		t.Error("expected a passphrase not to match a key file's header")
+	}
+
+	//---
+
+	header, _, err = createEncryptionHeader(NewPassphraseMasterKey("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.KDF != passphraseKDF || header.Iterations != passphraseIterations || len(header.Salt) != passphraseSaltLength {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	if _, err := header.open(NewPassphraseMasterKey("passphrase")); err != nil {
+		t.Errorf("expected the passphrase to match: %s", err)
+	}
+
+	if _, err := header.open(NewPassphraseMasterKey("another passphrase")); err == nil {
+		t.Error("expected a different passphrase not to match")
+	}
+
+	if _, err := header.open(newTestMasterKey(1)); err == nil {
+		t.Error("expected a key file not to match a passphrase's header")
+	}
+}
@@ -0,0 +1,259 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Where the data's schema version is stored. Not an ARN, so never matched by the database's prefixes.
+const schemaVersionKey = "schema-version"
+
+/*
+A change to the format of stored records. Migrations are applied in order, each once, to bring stored data up to the
+current schema version.
+*/
+type Migration struct {
+	// The schema version the data is at once the migration has been applied
+	Version int
+
+	Description string
+
+	// Returns the record's new value, or nil if the record is unchanged.
+	migrate func(key string, value []byte) ([]byte, error)
+}
+
+/*
+All migrations, in the order they're applied. New migrations are added to the end, with the next version.
+*/
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Set KeySpec from CustomerMasterKeySpec, on keys created before KeySpec was introduced",
+		migrate:     migrateKeySpec,
+	},
+	{
+		Version:     2,
+		Description: "Set the Type of keys created before asymmetric keys were supported to AES",
+		migrate:     migrateKeyType,
+	},
+}
+
+/*
+Returns the schema version of data written by this version of LKMS.
+*/
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+/*
+What a migration of the data did, or would do.
+*/
+type MigrationReport struct {
+	// The schema version the data was at, and is now at
+	From int
+	To   int
+
+	// The migrations applied, in order
+	Applied []AppliedMigration
+}
+
+type AppliedMigration struct {
+	Migration
+
+	// The number of records the migration changed
+	Records int
+}
+
+/*
+Returns true if any records were, or would be, changed.
+*/
+func (r *MigrationReport) Pending() bool {
+	return r.From != r.To
+}
+
+//------------------------------------
+
+/*
+Applies all migrations the data hasn't yet had, in order. The records are read, migrated in memory, then written back,
+along with the new schema version, in a single batch. If dryRun is true, nothing is written; the report says what would
+have been changed.
+
+Empty storage is taken to be at the current schema version. Data written by a newer version of LKMS is rejected.
+*/
+func Migrate(storage Storage, dryRun bool) (*MigrationReport, error) {
+
+	records := make(map[string][]byte)
+	var keys []string
+
+	err := storage.Iterate("", func(key string, value []byte) bool {
+		if strings.HasPrefix(key, "arn:") {
+			keys = append(keys, key)
+			records[key] = append([]byte{}, value...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	from, stored, err := loadSchemaVersion(storage, len(records) == 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MigrationReport{
+		From: from,
+		To:   SchemaVersion(),
+	}
+
+	if from > report.To {
+		return nil, fmt.Errorf("the data is at schema version %d, which is newer than this version of LKMS supports (%d)", from, report.To)
+	}
+
+	if !report.Pending() {
+		if !stored && !dryRun {
+			// New data. Its version is recorded so future migrations know to skip it.
+			err = storage.Put(schemaVersionKey, []byte(strconv.Itoa(report.To)))
+		}
+		return report, err
+	}
+
+	//---
+
+	changed := make(map[string]bool)
+
+	for _, m := range migrations {
+		if m.Version <= from {
+			continue
+		}
+
+		applied := AppliedMigration{Migration: m}
+
+		for _, key := range keys {
+			value, err := m.migrate(key, records[key])
+			if err != nil {
+				return nil, fmt.Errorf("migration %d failed on %s: %s", m.Version, key, err)
+			}
+
+			if value != nil {
+				records[key] = value
+				changed[key] = true
+				applied.Records++
+			}
+		}
+
+		report.Applied = append(report.Applied, applied)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	//---
+
+	batch := new(Batch)
+
+	for _, key := range keys {
+		if changed[key] {
+			batch.Put(key, records[key])
+		}
+	}
+
+	batch.Put(schemaVersionKey, []byte(strconv.Itoa(report.To)))
+
+	return report, storage.Write(batch)
+}
+
+/*
+Returns the stored schema version, and whether one was stored. Data from before schema versions were introduced has
+none, so is at version 0, unless it's empty.
+*/
+func loadSchemaVersion(storage Storage, empty bool) (int, bool, error) {
+
+	encoded, err := storage.Get(schemaVersionKey)
+	if err == ErrNotFound {
+		if empty {
+			return SchemaVersion(), false, nil
+		}
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	version, err := strconv.Atoi(string(encoded))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid schema version %q", encoded)
+	}
+
+	return version, true, nil
+}
+
+//------------------------------------
+// Migrations
+
+/*
+Returns true if the record is a key, rather than a key's tag or grant, an alias, etc.
+*/
+func isKeyRecord(key string) bool {
+	arn := strings.SplitN(key, ":", 6)
+	return len(arn) == 6 && strings.HasPrefix(arn[5], "key/") && strings.Count(arn[5], "/") == 1
+}
+
+func migrateKeySpec(key string, value []byte) ([]byte, error) {
+	if !isKeyRecord(key) {
+		return nil, nil
+	}
+
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+
+	var metadata map[string]json.RawMessage
+	if err := json.Unmarshal(record["Metadata"], &metadata); err != nil {
+		return nil, err
+	}
+
+	var keySpec string
+	if raw, ok := metadata["KeySpec"]; ok {
+		if err := json.Unmarshal(raw, &keySpec); err != nil {
+			return nil, err
+		}
+	}
+
+	if keySpec != "" || metadata["CustomerMasterKeySpec"] == nil {
+		return nil, nil
+	}
+
+	metadata["KeySpec"] = metadata["CustomerMasterKeySpec"]
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	record["Metadata"] = encoded
+
+	return json.Marshal(record)
+}
+
+func migrateKeyType(key string, value []byte) ([]byte, error) {
+	if !isKeyRecord(key) {
+		return nil, nil
+	}
+
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+
+	if _, ok := record["Type"]; ok {
+		return nil, nil
+	}
+
+	record["Type"] = json.RawMessage(`0`)
+
+	return json.Marshal(record)
+}
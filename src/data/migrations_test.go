@@ -0,0 +1,290 @@
+package data
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+/*
+Records as written by a version of LKMS from before schema versions were introduced. Keys have no KeySpec, only a
+CustomerMasterKeySpec; AES keys have no Type, and their backing keys are stored as just the 32 byte key.
+*/
+func newTestVersion0Records() map[string]string {
+	// An array, not a slice, so encoded as a list of numbers
+	material, _ := json.Marshal(testKeyMaterial())
+
+	return map[string]string{
+		testPrefix + "key/aes": `{"Metadata":{"Arn":"` + testPrefix + `key/aes","KeyId":"aes","Enabled":true,` +
+			`"KeyState":"Enabled","KeyUsage":"ENCRYPT_DECRYPT","CustomerMasterKeySpec":"SYMMETRIC_DEFAULT"},` +
+			`"BackingKeys":[` + string(material) + `],"Policy":""}`,
+		testPrefix + "key/rsa": `{"Type":1,"Metadata":{"Arn":"` + testPrefix + `key/rsa","KeyId":"rsa","Enabled":true,` +
+			`"KeyState":"Enabled","KeyUsage":"SIGN_VERIFY","CustomerMasterKeySpec":"RSA_2048"},"Policy":""}`,
+		testPrefix + "key/aes/tag/env": `{"TagKey":"env","TagValue":"test"}`,
+		testPrefix + "alias/aes":       `{"AliasName":"alias/aes","TargetKeyId":"aes"}`,
+	}
+}
+
+func testKeyMaterial() [32]byte {
+	var material [32]byte
+	for i := range material {
+		material[i] = byte(i)
+	}
+	return material
+}
+
+func newTestVersion0Storage(t *testing.T) (Storage, map[string]string) {
+	t.Helper()
+
+	storage := NewMemoryStorage()
+	records := newTestVersion0Records()
+
+	for key, value := range records {
+		if err := storage.Put(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return storage, records
+}
+
+func expectUnchanged(t *testing.T, storage Storage, records map[string]string) {
+	t.Helper()
+
+	for key, value := range records {
+		expectRecord(t, storage, key, value)
+	}
+	expectRecord(t, storage, schemaVersionKey, "")
+}
+
+func expectMigrationReport(t *testing.T, report *MigrationReport, from int, changed ...int) {
+	t.Helper()
+
+	if report.From != from || report.To != SchemaVersion() {
+		t.Errorf("expected a migration from %d to %d, got %d to %d", from, SchemaVersion(), report.From, report.To)
+	}
+
+	if len(report.Applied) != len(changed) {
+		t.Fatalf("expected %d migrations to be applied, got %+v", len(changed), report.Applied)
+	}
+
+	for i, m := range report.Applied {
+		if m.Version != from+i+1 || m.Records != changed[i] {
+			t.Errorf("expected migration %d to change %d records, got migration %d changing %d",
+				from+i+1, changed[i], m.Version, m.Records)
+		}
+	}
+}
+
+//------------------------------------
+
+func TestMigrateKeySpec(t *testing.T) {
+	records := newTestVersion0Records()
+
+	value, err := migrateKeySpec(testPrefix+"key/aes", []byte(records[testPrefix+"key/aes"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var migrated struct{ Metadata cmk.KeyMetadata }
+	if err := json.Unmarshal(value, &migrated); err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated.Metadata.KeySpec != cmk.SpecSymmetricDefault || migrated.Metadata.CustomerMasterKeySpec != cmk.SpecSymmetricDefault {
+		t.Errorf("expected the KeySpec to be set from the CustomerMasterKeySpec, got %+v", migrated.Metadata)
+	}
+
+	// Already migrated
+	if value, err := migrateKeySpec(testPrefix+"key/aes", value); value != nil || err != nil {
+		t.Errorf("expected a key with a KeySpec to be unchanged, got %s, %v", value, err)
+	}
+
+	// Not a key
+	tag := testPrefix + "key/aes/tag/env"
+	if value, err := migrateKeySpec(tag, []byte(records[tag])); value != nil || err != nil {
+		t.Errorf("expected a tag to be unchanged, got %s, %v", value, err)
+	}
+}
+
+func TestMigrateKeyType(t *testing.T) {
+	records := newTestVersion0Records()
+
+	value, err := migrateKeyType(testPrefix+"key/aes", []byte(records[testPrefix+"key/aes"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var migrated struct{ Type *cmk.KeyType }
+	if err := json.Unmarshal(value, &migrated); err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated.Type == nil || *migrated.Type != cmk.TypeAes {
+		t.Errorf("expected the key's type to be set to AES, got %s", value)
+	}
+
+	// Keys with a type keep it
+	if value, err := migrateKeyType(testPrefix+"key/rsa", []byte(records[testPrefix+"key/rsa"])); value != nil || err != nil {
+		t.Errorf("expected an RSA key to be unchanged, got %s, %v", value, err)
+	}
+
+	alias := testPrefix + "alias/aes"
+	if value, err := migrateKeyType(alias, []byte(records[alias])); value != nil || err != nil {
+		t.Errorf("expected an alias to be unchanged, got %s, %v", value, err)
+	}
+}
+
+/*
+Data written before schema versions were introduced is migrated to the current version, after which its keys can be
+read.
+*/
+func TestMigrateVersion0(t *testing.T) {
+	storage, records := newTestVersion0Storage(t)
+
+	// Not yet readable
+	if _, err := NewDatabase(storage).LoadKey(testPrefix + "key/aes"); err == nil {
+		t.Fatal("expected an unmigrated key to be rejected")
+	}
+
+	report, err := Migrate(storage, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migration 1 sets both keys' KeySpec; migration 2 sets just the AES key's type.
+	expectMigrationReport(t, report, 0, 2, 1)
+	expectRecord(t, storage, schemaVersionKey, strconv.Itoa(SchemaVersion()))
+
+	key, err := NewDatabase(storage).LoadKey(testPrefix + "key/aes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aes, ok := key.(*cmk.AesKey)
+	if !ok {
+		t.Fatalf("expected an AES key, got %T", key)
+	}
+
+	if aes.Metadata.KeySpec != cmk.SpecSymmetricDefault {
+		t.Errorf("expected a KeySpec of %s, got %s", cmk.SpecSymmetricDefault, aes.Metadata.KeySpec)
+	}
+
+	if len(aes.BackingKeys) != 1 || aes.BackingKeys[0].Key != testKeyMaterial() {
+		t.Errorf("expected the key material to be kept, got %+v", aes.BackingKeys)
+	}
+
+	// Records other than keys are left as they were
+	for _, k := range []string{testPrefix + "key/aes/tag/env", testPrefix + "alias/aes"} {
+		expectRecord(t, storage, k, records[k])
+	}
+
+	// Each migration is applied once
+	report, err = Migrate(storage, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMigrationReport(t, report, SchemaVersion())
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	storage, records := newTestVersion0Storage(t)
+
+	report, err := Migrate(storage, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Pending() {
+		t.Error("expected migrations to be pending")
+	}
+
+	// The report says what would be changed, without anything being changed
+	expectMigrationReport(t, report, 0, 2, 1)
+	expectUnchanged(t, storage, records)
+}
+
+func TestMigrateEmptyStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	report, err := Migrate(storage, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectMigrationReport(t, report, SchemaVersion())
+	expectRecord(t, storage, schemaVersionKey, "")
+
+	// New data is recorded as being at the current version
+	if _, err := Migrate(storage, false); err != nil {
+		t.Fatal(err)
+	}
+	expectRecord(t, storage, schemaVersionKey, strconv.Itoa(SchemaVersion()))
+}
+
+func TestMigrateRejectsNewerData(t *testing.T) {
+	storage, _ := newTestVersion0Storage(t)
+
+	if err := storage.Put(schemaVersionKey, []byte(strconv.Itoa(SchemaVersion()+1))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Migrate(storage, false); err == nil {
+		t.Error("expected data from a newer version to be rejected")
+	}
+}
+
+//------------------------------------
+
+func TestBackupAndRestore(t *testing.T) {
+	storage, records := newTestVersion0Storage(t)
+
+	path, err := Backup(storage, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a backup only the owner can read, got %v, %v", info, err)
+	}
+
+	if _, err := Migrate(storage, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written after the backup, so removed on restore
+	if err := storage.Put(testPrefix+"key/new", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := RestoreBackup(storage, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 0 {
+		t.Errorf("expected the backup to be at schema version 0, got %d", version)
+	}
+
+	expectUnchanged(t, storage, records)
+	expectRecord(t, storage, testPrefix+"key/new", "")
+}
+
+func TestRestoreRejectsOtherFiles(t *testing.T) {
+	path := t.TempDir() + "/records.json"
+
+	if err := os.WriteFile(path, []byte(`{"Name":"not a backup"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, records := newTestVersion0Storage(t)
+
+	if _, err := RestoreBackup(storage, path); err == nil {
+		t.Error("expected a file that isn't a backup to be rejected")
+	}
+
+	expectUnchanged(t, storage, records)
+}
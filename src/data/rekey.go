@@ -0,0 +1,153 @@
+package data
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+/*
+Re-encrypts every record in the storage with a new master key, in place. from is the key the records are currently
+encrypted with, or nil if they're not encrypted. to is the key to encrypt them with, or nil to leave them unencrypted.
+Records already encrypted are re-keyed by re-encrypting their data keys alone.
+
+The storage must not be in use while it's re-keyed. If a re-key is interrupted, it can be run again with the same keys
+to complete it; until then, re-keying to any other key is refused. Returns the number of records changed.
+*/
+func Rekey(storage Storage, from, to MasterKey) (int, error) {
+
+	if from == nil && to == nil {
+		return 0, errors.New("either a current or a new master key is required")
+	}
+
+	//---------------------------------------------------------
+	// The current master key
+
+	header, err := loadEncryptionHeader(storage, encryptionHeaderKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var fromAEAD cipher.AEAD
+
+	if from != nil {
+		if header == nil {
+			return 0, errors.New("the data is not encrypted, so no current master key is needed")
+		}
+
+		fromAEAD, err = header.open(from)
+		if err != nil {
+			return 0, err
+		}
+	} else if header != nil {
+		return 0, errors.New("the data is encrypted, so the current master key is needed")
+	}
+
+	//---------------------------------------------------------
+	// The new master key. Its header is kept until the re-key completes, so an interrupted re-key can be resumed.
+
+	var pending *encryptionHeader
+	var toAEAD cipher.AEAD
+
+	if to != nil {
+		pending, err = loadEncryptionHeader(storage, pendingEncryptionHeaderKey)
+		if err != nil {
+			return 0, err
+		}
+
+		if pending != nil {
+			// Records may already be encrypted with the pending key, so only it can be used to resume.
+			toAEAD, err = pending.open(to)
+			if err != nil {
+				return 0, errors.New("an interrupted re-key to a different master key must be completed first, with the same new master key")
+			}
+		} else {
+			pending, toAEAD, err = createEncryptionHeader(to)
+			if err != nil {
+				return 0, err
+			}
+
+			err = saveEncryptionHeader(storage, pendingEncryptionHeaderKey, pending)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	//---------------------------------------------------------
+	// Records
+
+	var keys []string
+	values := make(map[string][]byte)
+
+	err = storage.Iterate("", func(key string, value []byte) bool {
+		if !isUnencryptedRecord(key) {
+			keys = append(keys, key)
+			values[key] = append([]byte{}, value...)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, key := range keys {
+		value := values[key]
+
+		e, encrypted := decodeEnvelope(value)
+
+		switch {
+		case encrypted && to != nil:
+			if _, err := open(toAEAD, e.EncryptedDataKey, []byte(key)); err == nil {
+				// Already re-keyed
+				continue
+			}
+			if from == nil {
+				return count, fmt.Errorf("%s is encrypted with an unknown master key", key)
+			}
+			value, err = rewrapRecord(fromAEAD, toAEAD, key, value)
+
+		case encrypted:
+			value, err = decryptRecord(fromAEAD, key, value)
+
+		case to == nil:
+			// Already decrypted
+			continue
+
+		case from != nil:
+			return count, fmt.Errorf("%s is not encrypted", key)
+
+		default:
+			value, err = encryptRecord(toAEAD, key, value)
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		err = storage.Put(key, value)
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	//---------------------------------------------------------
+	// The new master key replaces the current one
+
+	if to != nil {
+		err = saveEncryptionHeader(storage, encryptionHeaderKey, pending)
+		if err != nil {
+			return count, err
+		}
+
+		err = storage.Delete(pendingEncryptionHeaderKey)
+	} else {
+		err = storage.Delete(encryptionHeaderKey)
+	}
+
+	return count, err
+}
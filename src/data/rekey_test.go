@@ -0,0 +1,138 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+/*
+Fails every Put after the first n, as if the process was stopped part way through.
+*/
+type interruptedStorage struct {
+	*MemoryStorage
+	puts int
+}
+
+func (s *interruptedStorage) Put(key string, value []byte) error {
+	if s.puts == 0 {
+		return errors.New("interrupted")
+	}
+	s.puts--
+	return s.MemoryStorage.Put(key, value)
+}
+
+func newTestRecords(t *testing.T, storage Storage, n int) map[string]string {
+	t.Helper()
+
+	records := make(map[string]string)
+	for i := 0; i < n; i++ {
+		key, value := fmt.Sprintf("%skey/%d", testPrefix, i), fmt.Sprintf(`{"N":%d}`, i)
+
+		if err := storage.Put(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		records[key] = value
+	}
+
+	return records
+}
+
+func expectRecords(t *testing.T, raw Storage, key MasterKey, records map[string]string) {
+	t.Helper()
+
+	storage, err := NewEncryptedStorage(raw, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, value := range records {
+		expectRecord(t, storage, k, value)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	raw := NewMemoryStorage()
+	records := newTestRecords(t, raw, 5)
+
+	// Encrypted
+	if count, err := Rekey(raw, nil, newTestMasterKey(1)); err != nil || count != 5 {
+		t.Fatalf("expected 5 records to be encrypted, got %d, %v", count, err)
+	}
+	expectRecords(t, raw, newTestMasterKey(1), records)
+
+	// Re-keyed
+	if _, err := Rekey(raw, newTestMasterKey(2), newTestMasterKey(3)); err == nil {
+		t.Error("expected the wrong current master key to be rejected")
+	}
+
+	if count, err := Rekey(raw, newTestMasterKey(1), NewPassphraseMasterKey("passphrase")); err != nil || count != 5 {
+		t.Fatalf("expected 5 records to be re-keyed, got %d, %v", count, err)
+	}
+	expectRecords(t, raw, NewPassphraseMasterKey("passphrase"), records)
+
+	if _, err := NewEncryptedStorage(raw, newTestMasterKey(1)); err == nil {
+		t.Error("expected the old master key to be rejected")
+	}
+
+	// Decrypted
+	if count, err := Rekey(raw, NewPassphraseMasterKey("passphrase"), nil); err != nil || count != 5 {
+		t.Fatalf("expected 5 records to be decrypted, got %d, %v", count, err)
+	}
+
+	for k, value := range records {
+		expectRecord(t, raw, k, value)
+	}
+
+	if encrypted, _ := IsEncrypted(raw); encrypted {
+		t.Error("expected the storage not to be encrypted")
+	}
+}
+
+func TestRekeyResumed(t *testing.T) {
+	raw := NewMemoryStorage()
+	records := newTestRecords(t, raw, 5)
+
+	if _, err := Rekey(raw, nil, newTestMasterKey(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupted after the pending header and two records are written
+	interrupted := &interruptedStorage{MemoryStorage: raw, puts: 3}
+
+	if count, err := Rekey(interrupted, newTestMasterKey(1), newTestMasterKey(2)); err == nil || count != 2 {
+		t.Fatalf("expected the re-key to be interrupted after 2 records, got %d, %v", count, err)
+	}
+
+	// The records already re-keyed would be lost if another new key was used
+	if _, err := Rekey(raw, newTestMasterKey(1), newTestMasterKey(3)); err == nil {
+		t.Fatal("expected a re-key to a different master key to be refused")
+	}
+
+	// The pending key is kept, so the re-key can still be resumed
+	mustGet(t, raw, pendingEncryptionHeaderKey)
+
+	// Resumed with the same keys, only the remaining records are changed
+	if count, err := Rekey(raw, newTestMasterKey(1), newTestMasterKey(2)); err != nil || count != 3 {
+		t.Fatalf("expected 3 records to be re-keyed, got %d, %v", count, err)
+	}
+
+	expectRecord(t, raw, pendingEncryptionHeaderKey, "")
+	expectRecords(t, raw, newTestMasterKey(2), records)
+
+	// Once complete, it can be re-keyed again
+	if count, err := Rekey(raw, newTestMasterKey(2), newTestMasterKey(3)); err != nil || count != 5 {
+		t.Fatalf("expected 5 records to be re-keyed, got %d, %v", count, err)
+	}
+	expectRecords(t, raw, newTestMasterKey(3), records)
+}
+
+func mustGet(t *testing.T, storage Storage, key string) []byte {
+	t.Helper()
+
+	value, err := storage.Get(key)
+	if err != nil {
+		t.Fatalf("%s: %s", key, err)
+	}
+	return value
+}
@@ -0,0 +1,56 @@
+package data
+
+import "errors"
+
+// Returned by Storage.Get when no record is stored under the key.
+var ErrNotFound = errors.New("data: not found")
+
+/*
+The key-value store the database's records are kept in. Records are stored under their ARN, or a path beneath it,
+and are iterated in the byte order of their keys.
+*/
+type Storage interface {
+	// Returns the value stored under the key, or ErrNotFound.
+	Get(key string) ([]byte, error)
+
+	Put(key string, value []byte) error
+
+	// Deleting a key that isn't stored is not an error.
+	Delete(key string) error
+
+	/*
+		Calls fn with each record whose key starts with prefix, in key order, until fn returns false. The value is only
+		valid until fn returns. Records written by fn aren't necessarily seen by the same iteration.
+	*/
+	Iterate(prefix string, fn func(key string, value []byte) bool) error
+
+	// Applies all of the batch's writes together, so they're seen either all at once or not at all.
+	Write(batch *Batch) error
+
+	Close() error
+}
+
+/*
+A set of writes to be applied together, in order, by Storage.Write.
+*/
+type Batch struct {
+	writes []batchWrite
+}
+
+type batchWrite struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+func (b *Batch) Put(key string, value []byte) {
+	b.writes = append(b.writes, batchWrite{key: key, value: value})
+}
+
+func (b *Batch) Delete(key string) {
+	b.writes = append(b.writes, batchWrite{key: key, delete: true})
+}
+
+func (b *Batch) Len() int {
+	return len(b.writes)
+}
@@ -0,0 +1,319 @@
+package data
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Where the encryption header is stored. Not an ARN, so never matched by the database's prefixes.
+	encryptionHeaderKey = "encryption"
+
+	// Where a re-key's new header is stored, until the re-key completes.
+	pendingEncryptionHeaderKey = "encryption-pending"
+
+	dataKeyLength = 32
+)
+
+/*
+Records kept unencrypted: the encryption headers themselves, and the schema version, which is needed to migrate the data
+before it's opened.
+*/
+func isUnencryptedRecord(key string) bool {
+	return key == encryptionHeaderKey || key == pendingEncryptionHeaderKey || key == schemaVersionKey
+}
+
+/*
+A record, envelope encrypted. The value is encrypted with a data key unique to the record, which is in turn encrypted
+with the master key. Both are bound to the record's storage key.
+*/
+type envelope struct {
+	EncryptedDataKey []byte
+	Ciphertext       []byte
+}
+
+func decodeEnvelope(value []byte) (*envelope, bool) {
+	var e envelope
+	if json.Unmarshal(value, &e) != nil || len(e.EncryptedDataKey) == 0 || len(e.Ciphertext) == 0 {
+		return nil, false
+	}
+	return &e, true
+}
+
+/*
+Wraps another Storage, encrypting each record's value with the master key before it's stored.
+*/
+type EncryptedStorage struct {
+	storage Storage
+	master  cipher.AEAD
+}
+
+/*
+Opens storage encrypted with the given master key. If the storage is empty it's set up for the key; if it already
+holds records that aren't encrypted, they must first be encrypted with Rekey.
+*/
+func NewEncryptedStorage(storage Storage, key MasterKey) (*EncryptedStorage, error) {
+
+	header, err := loadEncryptionHeader(storage, encryptionHeaderKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var master cipher.AEAD
+
+	if header != nil {
+		master, err = header.open(key)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		empty := true
+		err = storage.Iterate("", func(k string, value []byte) bool {
+			empty = isUnencryptedRecord(k)
+			return empty
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !empty {
+			return nil, errors.New("the data is not encrypted; it must first be encrypted with the rekey command")
+		}
+
+		header, master, err = createEncryptionHeader(key)
+		if err != nil {
+			return nil, err
+		}
+
+		err = saveEncryptionHeader(storage, encryptionHeaderKey, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &EncryptedStorage{
+		storage: storage,
+		master:  master,
+	}, nil
+}
+
+/*
+Returns true if the storage's records are encrypted, so it must be opened with NewEncryptedStorage.
+*/
+func IsEncrypted(storage Storage) (bool, error) {
+	header, err := loadEncryptionHeader(storage, encryptionHeaderKey)
+	return header != nil, err
+}
+
+func (s *EncryptedStorage) Get(key string) ([]byte, error) {
+	value, err := s.storage.Get(key)
+	if err != nil || key == schemaVersionKey {
+		return value, err
+	}
+	return decryptRecord(s.master, key, value)
+}
+
+func (s *EncryptedStorage) Put(key string, value []byte) error {
+	if key == schemaVersionKey {
+		return s.storage.Put(key, value)
+	}
+	encrypted, err := encryptRecord(s.master, key, value)
+	if err != nil {
+		return err
+	}
+	return s.storage.Put(key, encrypted)
+}
+
+func (s *EncryptedStorage) Delete(key string) error {
+	return s.storage.Delete(key)
+}
+
+func (s *EncryptedStorage) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+
+	var err error
+
+	iterErr := s.storage.Iterate(prefix, func(key string, value []byte) bool {
+		if key == encryptionHeaderKey || key == pendingEncryptionHeaderKey {
+			return true
+		}
+
+		if key == schemaVersionKey {
+			return fn(key, value)
+		}
+
+		value, err = decryptRecord(s.master, key, value)
+		if err != nil {
+			return false
+		}
+
+		return fn(key, value)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return iterErr
+}
+
+func (s *EncryptedStorage) Write(batch *Batch) error {
+
+	encrypted := new(Batch)
+
+	for _, w := range batch.writes {
+		if w.delete {
+			encrypted.Delete(w.key)
+			continue
+		}
+
+		if w.key == schemaVersionKey {
+			encrypted.Put(w.key, w.value)
+			continue
+		}
+
+		value, err := encryptRecord(s.master, w.key, w.value)
+		if err != nil {
+			return err
+		}
+
+		encrypted.Put(w.key, value)
+	}
+
+	return s.storage.Write(encrypted)
+}
+
+func (s *EncryptedStorage) Close() error {
+	return s.storage.Close()
+}
+
+//------------------------------------
+
+func encryptRecord(master cipher.AEAD, key string, value []byte) ([]byte, error) {
+
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var e envelope
+
+	e.Ciphertext, err = seal(aead, value, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	e.EncryptedDataKey, err = seal(master, dataKey, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(e)
+}
+
+func decryptRecord(master cipher.AEAD, key string, value []byte) ([]byte, error) {
+
+	e, ok := decodeEnvelope(value)
+	if !ok {
+		return nil, fmt.Errorf("%s is not encrypted", key)
+	}
+
+	dataKey, err := open(master, e.EncryptedDataKey, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the data key for %s", key)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err = open(aead, e.Ciphertext, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s", key)
+	}
+
+	return value, nil
+}
+
+/*
+Re-encrypts a record's data key with a new master key. The value itself is left as it is.
+*/
+func rewrapRecord(from, to cipher.AEAD, key string, value []byte) ([]byte, error) {
+
+	e, ok := decodeEnvelope(value)
+	if !ok {
+		return nil, fmt.Errorf("%s is not encrypted", key)
+	}
+
+	dataKey, err := open(from, e.EncryptedDataKey, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the data key for %s", key)
+	}
+
+	e.EncryptedDataKey, err = seal(to, dataKey, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(e)
+}
+
+//------------------------------------
+
+func createEncryptionHeader(key MasterKey) (*encryptionHeader, cipher.AEAD, error) {
+
+	header, k, err := key.newHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header.Check, err = seal(aead, masterKeyCheck, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, aead, nil
+}
+
+/*
+Returns nil if there's no header stored under the key.
+*/
+func loadEncryptionHeader(storage Storage, key string) (*encryptionHeader, error) {
+
+	encoded, err := storage.Get(key)
+	if err == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var header encryptionHeader
+
+	err = json.Unmarshal(encoded, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+func saveEncryptionHeader(storage Storage, key string, header *encryptionHeader) error {
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	return storage.Put(key, encoded)
+}
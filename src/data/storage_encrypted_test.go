@@ -0,0 +1,95 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	raw := NewMemoryStorage()
+
+	storage, err := NewEncryptedStorage(raw, newTestMasterKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := testPrefix + "key/a"
+	alias := testPrefix + "alias/alias/a"
+
+	if err := storage.Put(key, []byte(`{"Secret":"key material"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := &Batch{}
+	batch.Put(alias, []byte(`{"Secret":"alias"}`))
+	batch.Put(schemaVersionKey, []byte("2"))
+	if err := storage.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the schema version and the header are stored in the clear
+	raw.Iterate("", func(k string, value []byte) bool {
+		if bytes.Contains(value, []byte("Secret")) {
+			t.Errorf("%s is stored in the clear: %s", k, value)
+		}
+		return true
+	})
+
+	expectRecord(t, raw, schemaVersionKey, "2")
+
+	if encrypted, _ := IsEncrypted(raw); !encrypted {
+		t.Error("expected the storage to be encrypted")
+	}
+
+	// Read back through the encrypted storage
+	expectRecord(t, storage, key, `{"Secret":"key material"}`)
+	expectRecord(t, storage, alias, `{"Secret":"alias"}`)
+	expectRecord(t, storage, schemaVersionKey, "2")
+
+	if keys := iterateKeys(t, storage, ""); keys != "alias/alias/a, key/a, schema-version" {
+		t.Errorf("unexpected keys %s", keys)
+	}
+
+	// Records are bound to their key, so can't be moved
+	value, _ := raw.Get(key)
+	raw.Put(testPrefix+"key/b", value)
+
+	if _, err := storage.Get(testPrefix + "key/b"); err == nil {
+		t.Error("expected a record moved to another key not to decrypt")
+	}
+
+	if err := storage.Iterate(testPrefix+"key/", func(string, []byte) bool { return true }); err == nil {
+		t.Error("expected iterating over a record that doesn't decrypt to fail")
+	}
+
+	raw.Delete(testPrefix + "key/b")
+
+	// Reopened with the same key
+	storage, err = NewEncryptedStorage(raw, newTestMasterKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRecord(t, storage, key, `{"Secret":"key material"}`)
+
+	// But not with another
+	if _, err := NewEncryptedStorage(raw, newTestMasterKey(2)); err == nil {
+		t.Error("expected the wrong master key to be rejected")
+	}
+}
+
+func TestEncryptedStorageRequiresRekey(t *testing.T) {
+	raw := NewMemoryStorage()
+	raw.Put(testPrefix+"key/a", []byte(`{"Secret":"key material"}`))
+
+	if _, err := NewEncryptedStorage(raw, newTestMasterKey(1)); err == nil {
+		t.Error("expected unencrypted data to be refused")
+	}
+
+	// The schema version alone doesn't need re-keying
+	raw = NewMemoryStorage()
+	raw.Put(schemaVersionKey, []byte("2"))
+
+	if _, err := NewEncryptedStorage(raw, newTestMasterKey(1)); err != nil {
+		t.Errorf("expected storage holding only the schema version to be encrypted: %s", err)
+	}
+}
@@ -0,0 +1,662 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How often the directory is checked for changes made outside of LKMS.
+const jsonStorageReloadInterval = time.Second
+
+// Prefixes the temporary files records are written to, before they're renamed into place.
+const jsonStorageTempPrefix = ".tmp-"
+
+/*
+Stores each record as its own pretty-printed JSON file, in a directory layout mirroring the records' ARNs:
+
+	<region>/<account>/key/<key id>/key.json
+	<region>/<account>/key/<key id>/policy.json
+	<region>/<account>/key/<key id>/tags/<tag key>.json
+	<region>/<account>/key/<key id>/grants/<grant id>.json
+	<region>/<account>/alias/<alias name>.json
+	<region>/<account>/custom-key-store/<custom key store id>.json
+
+A key's policy is split out of its record, and kept verbatim. Names are path escaped, so the alias alias/a/b is
+kept in alias/a%2Fb.json. Files are written atomically, via a rename, and are reloaded when they change on disk.
+*/
+type JSONStorage struct {
+	path   string
+	logger log.FieldLogger
+
+	mutex   sync.RWMutex
+	records map[string][]byte
+
+	// The size and modification time of each file, when last loaded or written
+	files map[string]jsonFileState
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type jsonFileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func NewJSONStorage(path string, logger log.FieldLogger) (*JSONStorage, error) {
+
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JSONStorage{
+		path:   path,
+		logger: logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	files, err := s.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	s.records, err = s.load(files)
+	if err != nil {
+		return nil, err
+	}
+
+	s.files = files
+
+	go s.watch()
+
+	return s, nil
+}
+
+func (s *JSONStorage) Get(key string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, ok := s.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (s *JSONStorage) Put(key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.put(key, value)
+}
+
+func (s *JSONStorage) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.delete(key)
+}
+
+/*
+Applies the writes while holding the lock, so readers see all of them or none. On disk, each file is replaced
+atomically, but the batch as a whole isn't.
+*/
+func (s *JSONStorage) Write(batch *Batch) error {
+
+	// Fail before anything is written, if any of the keys can't be stored.
+	for _, w := range batch.writes {
+		if _, err := jsonStoragePath(w.key); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, w := range batch.writes {
+		var err error
+
+		if w.delete {
+			err = s.delete(w.key)
+		} else {
+			err = s.put(w.key, w.value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Requires the lock to be held.
+func (s *JSONStorage) put(key string, value []byte) error {
+
+	file, err := jsonStoragePath(key)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(file, "/key.json") {
+		err = s.writeKey(file, value)
+	} else {
+		err = s.writeRecord(file, value)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.records[key] = append([]byte{}, value...)
+	return nil
+}
+
+// Requires the lock to be held.
+func (s *JSONStorage) delete(key string) error {
+
+	file, err := jsonStoragePath(key)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(file, "/key.json") {
+		err = s.remove(policyPath(file))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.remove(file)
+	if err != nil {
+		return err
+	}
+
+	delete(s.records, key)
+	return nil
+}
+
+/*
+Iterates over a snapshot of the matching records, so fn is free to write to the storage.
+*/
+func (s *JSONStorage) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	s.mutex.RLock()
+
+	keys := make([]string, 0)
+	for k := range s.records {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		values[k] = s.records[k]
+	}
+
+	s.mutex.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !fn(k, values[k]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONStorage) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+//------------------------------------
+// Paths
+
+/*
+Returns the path, relative to the storage's directory and slash separated, of the file the record is kept in.
+*/
+func jsonStoragePath(key string) (string, error) {
+
+	// Records that aren't kept under an ARN, such as the encryption header, are kept at the top level.
+	if !strings.HasPrefix(key, "arn:") {
+		return url.PathEscape(key) + ".json", nil
+	}
+
+	// arn:aws:kms:<region>:<account>:<resource>
+	arn := strings.SplitN(key, ":", 6)
+	if len(arn) != 6 || arn[0] != "arn" || arn[1] != "aws" || arn[2] != "kms" || arn[3] == "" || arn[4] == "" {
+		return "", fmt.Errorf("%s is not a KMS ARN", key)
+	}
+
+	region, account := arn[3], arn[4]
+
+	resourceType, resource, ok := strings.Cut(arn[5], "/")
+	if !ok || resource == "" {
+		return "", fmt.Errorf("%s is not a KMS ARN", key)
+	}
+
+	if resourceType != "key" {
+		return region + "/" + account + "/" + resourceType + "/" + url.PathEscape(resource) + ".json", nil
+	}
+
+	// Tags and grants are kept beneath their key. e.g. key/<key id>/tag/<tag key>
+	keyId, child, _ := strings.Cut(resource, "/")
+	dir := region + "/" + account + "/key/" + url.PathEscape(keyId)
+
+	if child == "" {
+		return dir + "/key.json", nil
+	}
+
+	childType, name, ok := strings.Cut(child, "/")
+	if !ok || name == "" {
+		return "", fmt.Errorf("%s is not a KMS ARN", key)
+	}
+
+	return dir + "/" + childType + "s/" + url.PathEscape(name) + ".json", nil
+}
+
+/*
+The inverse of jsonStoragePath. Returns false for files that don't hold a record, including key policies.
+*/
+func jsonStorageKey(file string) (string, bool) {
+
+	parts := strings.Split(file, "/")
+	if !strings.HasSuffix(file, ".json") {
+		return "", false
+	}
+
+	unescape := func(name string) (string, bool) {
+		name, err := url.PathUnescape(strings.TrimSuffix(name, ".json"))
+		return name, err == nil && name != ""
+	}
+
+	if len(parts) == 1 {
+		return unescape(parts[0])
+	}
+
+	if len(parts) < 4 {
+		return "", false
+	}
+
+	prefix := "arn:aws:kms:" + parts[0] + ":" + parts[1] + ":" + parts[2] + "/"
+
+	if parts[2] != "key" {
+		name, ok := unescape(parts[3])
+		return prefix + name, ok && len(parts) == 4
+	}
+
+	keyId, ok := unescape(parts[3])
+	if !ok {
+		return "", false
+	}
+
+	switch {
+	case len(parts) == 5 && parts[4] == "key.json":
+		return prefix + keyId, true
+	case len(parts) == 6 && strings.HasSuffix(parts[4], "s"):
+		name, ok := unescape(parts[5])
+		return prefix + keyId + "/" + strings.TrimSuffix(parts[4], "s") + "/" + name, ok
+	}
+
+	return "", false
+}
+
+func policyPath(keyFile string) string {
+	return strings.TrimSuffix(keyFile, "key.json") + "policy.json"
+}
+
+//------------------------------------
+// Writing
+
+/*
+Writes a key's record, with its policy split out into policy.json.
+*/
+func (s *JSONStorage) writeKey(file string, value []byte) error {
+
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal(value, &fields)
+	if err != nil {
+		return err
+	}
+
+	var policy string
+
+	if raw, ok := fields["Policy"]; ok {
+		err = json.Unmarshal(raw, &policy)
+		if err != nil {
+			return err
+		}
+		delete(fields, "Policy")
+	}
+
+	encoded, err := prettyJSON(fields)
+	if err != nil {
+		return err
+	}
+
+	if policy == "" {
+		err = s.remove(policyPath(file))
+	} else {
+		err = s.write(policyPath(file), []byte(policy))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.write(file, encoded)
+}
+
+func (s *JSONStorage) writeRecord(file string, value []byte) error {
+
+	var encoded bytes.Buffer
+
+	err := json.Indent(&encoded, value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	encoded.WriteByte('\n')
+
+	return s.write(file, collapseNumberArrays(encoded.Bytes()))
+}
+
+/*
+Writes the file atomically, by writing to a temporary file in the same directory and renaming it into place.
+*/
+func (s *JSONStorage) write(file string, contents []byte) error {
+
+	path := filepath.Join(s.path, filepath.FromSlash(file))
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), jsonStorageTempPrefix)
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(contents)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	s.files[file] = jsonFileState{info.Size(), info.ModTime()}
+	return nil
+}
+
+/*
+Removes the file, along with any directories left empty.
+*/
+func (s *JSONStorage) remove(file string) error {
+
+	path := filepath.Join(s.path, filepath.FromSlash(file))
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	delete(s.files, file)
+
+	for dir := filepath.Dir(path); dir != s.path && strings.HasPrefix(dir, s.path); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func prettyJSON(v interface{}) ([]byte, error) {
+	var encoded bytes.Buffer
+
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(v)
+	return collapseNumberArrays(encoded.Bytes()), err
+}
+
+// Matches an indented array holding only numbers, such as a key's material.
+var numberArray = regexp.MustCompile(`\[\n(?:\s*-?[0-9][0-9.eE+-]*,?\n)+\s*\]`)
+
+/*
+Puts arrays of numbers on a single line, rather than one number per line.
+*/
+func collapseNumberArrays(encoded []byte) []byte {
+	return numberArray.ReplaceAllFunc(encoded, func(array []byte) []byte {
+		return []byte("[" + strings.Join(strings.Fields(string(array[1:len(array)-1])), " ") + "]")
+	})
+}
+
+//------------------------------------
+// Loading
+
+/*
+Returns the state of each JSON file in the directory. Files being written, and hidden directories such as .git, are
+skipped.
+*/
+func (s *JSONStorage) scan() (map[string]jsonFileState, error) {
+
+	files := make(map[string]jsonFileState)
+
+	err := filepath.WalkDir(s.path, func(path string, entry fs.DirEntry, err error) error {
+		// Removed since the directory was read; it'll be seen to be gone on the next scan.
+		if errors.Is(err, fs.ErrNotExist) && path != s.path {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// Records' names, such as tag keys, can start with a dot, but the directories holding them can't.
+		if entry.IsDir() && path != s.path && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if strings.HasPrefix(entry.Name(), jsonStorageTempPrefix) {
+			return nil
+		}
+
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		file, err := filepath.Rel(s.path, path)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(file)] = jsonFileState{info.Size(), info.ModTime()}
+		return nil
+	})
+
+	return files, err
+}
+
+/*
+Reads the records held in the given files. Values are compacted, and keys have their policy merged back in.
+*/
+func (s *JSONStorage) load(files map[string]jsonFileState) (map[string][]byte, error) {
+
+	records := make(map[string][]byte, len(files))
+
+	for file := range files {
+		key, ok := jsonStorageKey(file)
+		if !ok {
+			continue
+		}
+
+		contents, err := os.ReadFile(filepath.Join(s.path, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasSuffix(file, "/key.json") {
+			contents, err = s.mergePolicy(file, contents)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		}
+
+		var value bytes.Buffer
+
+		err = json.Compact(&value, contents)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+
+		records[key] = value.Bytes()
+	}
+
+	return records, nil
+}
+
+func (s *JSONStorage) mergePolicy(file string, contents []byte) ([]byte, error) {
+
+	policy, err := os.ReadFile(filepath.Join(s.path, filepath.FromSlash(policyPath(file))))
+	if os.IsNotExist(err) {
+		return contents, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+
+	err = json.Unmarshal(contents, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	fields["Policy"], err = json.Marshal(string(policy))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fields)
+}
+
+/*
+Reloads all records whenever a file is added, changed or removed outside of LKMS. If the files can't be loaded, the
+records already held are kept until the files next change.
+*/
+func (s *JSONStorage) watch() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(jsonStorageReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		files, err := s.scan()
+		if err != nil {
+			s.logger.Warnf("Unable to scan %s for changes: %s", s.path, err)
+			continue
+		}
+
+		s.mutex.RLock()
+		changed := !jsonFilesEqual(files, s.files)
+		s.mutex.RUnlock()
+
+		if changed {
+			s.reload()
+		}
+	}
+}
+
+func (s *JSONStorage) reload() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Scanned again under the lock, so no write can be missed.
+	files, err := s.scan()
+	if err != nil {
+		s.logger.Warnf("Unable to scan %s for changes: %s", s.path, err)
+		return
+	}
+
+	s.files = files
+
+	records, err := s.load(files)
+	if err != nil {
+		s.logger.Warnf("Unable to reload data from %s: %s", s.path, err)
+		return
+	}
+
+	s.records = records
+
+	s.logger.Infof("Data reloaded from %s", s.path)
+}
+
+func jsonFilesEqual(a, b map[string]jsonFileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for file, state := range a {
+		if other, ok := b[file]; !ok || other.size != state.size || !other.modTime.Equal(state.modTime) {
+			return false
+		}
+	}
+
+	return true
+}
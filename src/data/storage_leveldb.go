@@ -0,0 +1,74 @@
+package data
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+Stores records in a LevelDB database on disk.
+*/
+type LevelDBStorage struct {
+	database *leveldb.DB
+}
+
+func NewLevelDBStorage(path string) (*LevelDBStorage, error) {
+
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LevelDBStorage{
+		database: db,
+	}, nil
+}
+
+func (s *LevelDBStorage) Get(key string) ([]byte, error) {
+	value, err := s.database.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *LevelDBStorage) Put(key string, value []byte) error {
+	return s.database.Put([]byte(key), value, nil)
+}
+
+func (s *LevelDBStorage) Delete(key string) error {
+	return s.database.Delete([]byte(key), nil)
+}
+
+func (s *LevelDBStorage) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+
+	iter := s.database.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if !fn(string(iter.Key()), iter.Value()) {
+			break
+		}
+	}
+
+	return iter.Error()
+}
+
+func (s *LevelDBStorage) Write(batch *Batch) error {
+
+	b := new(leveldb.Batch)
+
+	for _, w := range batch.writes {
+		if w.delete {
+			b.Delete([]byte(w.key))
+		} else {
+			b.Put([]byte(w.key), w.value)
+		}
+	}
+
+	return s.database.Write(b, nil)
+}
+
+func (s *LevelDBStorage) Close() error {
+	return s.database.Close()
+}
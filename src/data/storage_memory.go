@@ -0,0 +1,99 @@
+package data
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Stores records in memory. Nothing is persisted; each instance starts empty.
+*/
+type MemoryStorage struct {
+	mutex   sync.RWMutex
+	records map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		records: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStorage) Get(key string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, ok := s.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (s *MemoryStorage) Put(key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *MemoryStorage) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+/*
+Iterates over a snapshot of the matching records, so fn is free to write to the storage.
+*/
+func (s *MemoryStorage) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	s.mutex.RLock()
+
+	keys := make([]string, 0)
+	for k := range s.records {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		values[k] = s.records[k]
+	}
+
+	s.mutex.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !fn(k, values[k]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) Write(batch *Batch) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, w := range batch.writes {
+		if w.delete {
+			delete(s.records, w.key)
+		} else {
+			s.records[w.key] = append([]byte{}, w.value...)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,343 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const testPrefix = "arn:aws:kms:eu-west-2:111122223333:"
+
+func newTestLogger() *log.Logger {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+/*
+Each implementation of Storage, opened in the passed directory. Persistent implementations see, when reopened in the
+same directory, what was written before they were closed.
+*/
+var storageImplementations = map[string]struct {
+	open       func(t *testing.T, path string) Storage
+	persistent bool
+}{
+	"memory": {
+		open: func(t *testing.T, path string) Storage {
+			return NewMemoryStorage()
+		},
+	},
+	"leveldb": {
+		open: func(t *testing.T, path string) Storage {
+			storage, err := NewLevelDBStorage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return storage
+		},
+		persistent: true,
+	},
+	"json": {
+		open: func(t *testing.T, path string) Storage {
+			storage, err := NewJSONStorage(path, newTestLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return storage
+		},
+		persistent: true,
+	},
+}
+
+func expectRecord(t *testing.T, storage Storage, key, expected string) {
+	t.Helper()
+
+	value, err := storage.Get(key)
+	if expected == "" {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %q, %v", key, value, err)
+		}
+		return
+	}
+
+	if err != nil || string(value) != expected {
+		t.Errorf("%s: expected %s, got %q, %v", key, expected, value, err)
+	}
+}
+
+func iterateKeys(t *testing.T, storage Storage, prefix string) string {
+	t.Helper()
+
+	var keys []string
+	err := storage.Iterate(prefix, func(key string, value []byte) bool {
+		keys = append(keys, strings.TrimPrefix(key, testPrefix))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Join(keys, ", ")
+}
+
+/*
+Values are compact JSON objects, with their fields in order, as that's what the database stores and so what the JSON
+storage returns once its files are reloaded.
+*/
+func TestStorageContract(t *testing.T) {
+	for name, implementation := range storageImplementations {
+		t.Run(name, func(t *testing.T) {
+			path := t.TempDir()
+			storage := implementation.open(t, path)
+
+			key := testPrefix + "key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+			// Get, put and delete
+			expectRecord(t, storage, key, "")
+
+			if err := storage.Put(key, []byte(`{"Version":1}`)); err != nil {
+				t.Fatal(err)
+			}
+			expectRecord(t, storage, key, `{"Version":1}`)
+
+			if err := storage.Put(key, []byte(`{"Version":2}`)); err != nil {
+				t.Fatal(err)
+			}
+			expectRecord(t, storage, key, `{"Version":2}`)
+
+			// The returned value is the caller's to change
+			value, _ := storage.Get(key)
+			value[1] = 'X'
+			expectRecord(t, storage, key, `{"Version":2}`)
+
+			if err := storage.Delete(key); err != nil {
+				t.Fatal(err)
+			}
+			expectRecord(t, storage, key, "")
+
+			if err := storage.Delete(key); err != nil {
+				t.Errorf("deleting a key that isn't stored: %s", err)
+			}
+
+			// Iteration, by prefix and in key order
+			records := []string{
+				"key/b", "key/b/tag/2", "key/b/tag/1", "key/a", "key/bb", "alias/alias/b", "alias/alias/a",
+			}
+			for i, name := range records {
+				if err := storage.Put(testPrefix+name, []byte(fmt.Sprintf(`{"N":%d}`, i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if keys := iterateKeys(t, storage, testPrefix+"key/"); keys != "key/a, key/b, key/b/tag/1, key/b/tag/2, key/bb" {
+				t.Errorf("unexpected keys %s", keys)
+			}
+
+			if keys := iterateKeys(t, storage, testPrefix+"key/b/"); keys != "key/b/tag/1, key/b/tag/2" {
+				t.Errorf("unexpected keys %s", keys)
+			}
+
+			if keys := iterateKeys(t, storage, testPrefix+"grant/"); keys != "" {
+				t.Errorf("unexpected keys %s", keys)
+			}
+
+			var visited int
+			storage.Iterate(testPrefix, func(key string, value []byte) bool {
+				visited++
+				return visited < 3
+			})
+			if visited != 3 {
+				t.Errorf("expected iteration to stop after 3 records, got %d", visited)
+			}
+
+			// Records can be written while iterating
+			err := storage.Iterate(testPrefix+"alias/", func(key string, value []byte) bool {
+				if err := storage.Put(key+"-copy", value); err != nil {
+					t.Error(err)
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectRecord(t, storage, testPrefix+"alias/alias/a-copy", `{"N":6}`)
+
+			// Batches are applied in order
+			batch := &Batch{}
+			batch.Put(testPrefix+"key/c", []byte(`{"N":7}`))
+			batch.Delete(testPrefix + "key/a")
+			batch.Put(testPrefix+"key/b", []byte(`{"N":8}`))
+			batch.Delete(testPrefix + "key/b/tag/1")
+			batch.Put(testPrefix+"key/d", []byte(`{"N":9}`))
+			batch.Delete(testPrefix + "key/d")
+
+			if batch.Len() != 6 {
+				t.Errorf("expected 6 writes, got %d", batch.Len())
+			}
+
+			if err := storage.Write(batch); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := map[string]string{
+				"key/a": "", "key/b": `{"N":8}`, "key/b/tag/1": "", "key/b/tag/2": `{"N":1}`,
+				"key/bb": `{"N":4}`, "key/c": `{"N":7}`, "key/d": "",
+			}
+			for name, value := range expected {
+				expectRecord(t, storage, testPrefix+name, value)
+			}
+
+			if err := storage.Write(&Batch{}); err != nil {
+				t.Errorf("writing an empty batch: %s", err)
+			}
+
+			if err := storage.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !implementation.persistent {
+				return
+			}
+
+			// Reopened, the records are as they were left
+			storage = implementation.open(t, path)
+			defer storage.Close()
+
+			for name, value := range expected {
+				expectRecord(t, storage, testPrefix+name, value)
+			}
+
+			if keys := iterateKeys(t, storage, testPrefix+"alias/"); keys != "alias/alias/a, alias/alias/a-copy, alias/alias/b, alias/alias/b-copy" {
+				t.Errorf("unexpected keys %s", keys)
+			}
+		})
+	}
+}
+
+/*
+Waits for the JSON storage to notice a change made outside of LKMS.
+*/
+func waitForRecord(t *testing.T, storage Storage, key, expected string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * jsonStorageReloadInterval)
+
+	for {
+		value, err := storage.Get(key)
+		if (expected == "" && errors.Is(err, ErrNotFound)) || (err == nil && string(value) == expected) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: expected %q, got %q, %v", key, expected, value, err)
+		}
+
+		time.Sleep(jsonStorageReloadInterval / 10)
+	}
+}
+
+func TestJSONStorageReload(t *testing.T) {
+	path := t.TempDir()
+
+	storage, err := NewJSONStorage(path, newTestLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	key := testPrefix + "key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+	err = storage.Put(key, []byte(`{"Description":"before","Policy":"{\"Version\":\"2012-10-17\"}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The policy is kept verbatim, beside the key
+	keyDir := filepath.Join(path, "eu-west-2", "111122223333", "key", "1234abcd-12ab-34cd-56ef-1234567890ab")
+
+	policy, err := os.ReadFile(filepath.Join(keyDir, "policy.json"))
+	if err != nil || string(policy) != `{"Version":"2012-10-17"}` {
+		t.Errorf("unexpected policy %q, %v", policy, err)
+	}
+
+	// Files changed outside of LKMS are reloaded
+	err = os.WriteFile(filepath.Join(keyDir, "key.json"), []byte("{\n  \"Description\": \"after\"\n}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRecord(t, storage, key, `{"Description":"after","Policy":"{\"Version\":\"2012-10-17\"}"}`)
+
+	aliasFile := filepath.Join(path, "eu-west-2", "111122223333", "alias", "alias%2Fadded.json")
+	if err := os.MkdirAll(filepath.Dir(aliasFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(aliasFile, []byte("{\n  \"AliasName\": \"alias/added\"\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRecord(t, storage, testPrefix+"alias/alias/added", `{"AliasName":"alias/added"}`)
+
+	if err := os.Remove(aliasFile); err != nil {
+		t.Fatal(err)
+	}
+	waitForRecord(t, storage, testPrefix+"alias/alias/added", "")
+
+	// Invalid files are ignored until they're fixed, keeping the records already held
+	if err := os.WriteFile(filepath.Join(keyDir, "key.json"), []byte("{ not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * jsonStorageReloadInterval)
+	expectRecord(t, storage, key, `{"Description":"after","Policy":"{\"Version\":\"2012-10-17\"}"}`)
+
+	if err := os.Remove(filepath.Join(keyDir, "policy.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(keyDir, "key.json"), []byte(`{"Description":"fixed"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRecord(t, storage, key, `{"Description":"fixed"}`)
+}
+
+func TestJSONStorageReloadsDotPrefixedNames(t *testing.T) {
+	path := t.TempDir()
+
+	storage, err := NewJSONStorage(path, newTestLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := testPrefix + "key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	tag := key + "/tag/.env"
+
+	if err := storage.Put(key, []byte(`{"Description":"tagged"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(tag, []byte(`{"TagKey":".env","TagValue":"test"}`)); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	// A file left part-written is never loaded
+	tags := filepath.Join(path, "eu-west-2", "111122223333", "key", "1234abcd-12ab-34cd-56ef-1234567890ab", "tags")
+	if err := os.WriteFile(filepath.Join(tags, jsonStorageTempPrefix+"123.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = NewJSONStorage(path, newTestLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	expectRecord(t, storage, tag, `{"TagKey":".env","TagValue":"test"}`)
+
+	if keys := iterateKeys(t, storage, key+"/"); keys != "key/1234abcd-12ab-34cd-56ef-1234567890ab/tag/.env" {
+		t.Errorf("unexpected records %s", keys)
+	}
+}
@@ -1,11 +1,8 @@
 package handler
 
 import (
-	"fmt"
-
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 )
 
 func (r *RequestHandler) CancelKeyDeletion() Response {
@@ -29,33 +26,30 @@ func (r *RequestHandler) CancelKeyDeletion() Response {
 
 	//---
 
-	target := config.EnsureArn("key/", *body.KeyId)
-
-	// Lookup the key
-	key, _ := r.database.LoadKey(target)
-
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", target)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:CancelKeyDeletion", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	if key.GetMetadata().DeletionDate == 0 {
-		// Key is pending deletion; cannot re-schedule
-		msg := fmt.Sprintf("%s is not pending deletion.", target)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	key.GetMetadata().Enabled = true
-	key.GetMetadata().KeyState = cmk.KeyStateEnabled
+	// As with AWS, the key is disabled once its deletion is cancelled
+	cmk.TransitionKeyState(r.operation(), key.GetMetadata())
+
 	key.GetMetadata().DeletionDate = 0
+	key.GetMetadata().PendingDeletionWindowInDays = 0
 
 	//--------------------------------
 	// Save the key
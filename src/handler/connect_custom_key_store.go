@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
+)
+
+func (r *RequestHandler) ConnectCustomKeyStore() Response {
+
+	var body *kms.ConnectCustomKeyStoreInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.ConnectCustomKeyStoreInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.CustomKeyStoreId == nil {
+		msg := "CustomKeyStoreId is a required parameter"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	//---
+
+	store, response := r.getCustomKeyStore(*body.CustomKeyStoreId)
+	if store == nil {
+		return response
+	}
+
+	switch store.ConnectionState {
+	case data.ConnectionStateConnected:
+		// Already connected; nothing to do.
+		r.logger.Infof("Custom key store %s is already connected\n", store.CustomKeyStoreId)
+		return NewResponse(200, nil)
+
+	case data.ConnectionStateFailed:
+		msg := fmt.Sprintf("Custom key store %s failed to connect. It must be disconnected before it can be "+
+			"connected again.", store.CustomKeyStoreId)
+
+		r.logger.Warnf(msg)
+		return NewCustomKeyStoreInvalidStateExceptionResponse(msg)
+	}
+
+	//---
+
+	var code data.ConnectionErrorCode
+
+	switch store.CustomKeyStoreType {
+	case data.CustomKeyStoreTypeExternalKeyStore:
+		code, _ = r.connectXksProxy(store)
+	default:
+		_, code = r.connectCluster(store)
+	}
+
+	var keys []cmk.Key
+
+	// As with AWS, a failure to connect is reported via the store's connection state, not the response.
+	if code != "" {
+		store.ConnectionState = data.ConnectionStateFailed
+		store.ConnectionErrorCode = code
+	} else {
+		store.ConnectionState = data.ConnectionStateConnected
+		store.ConnectionErrorCode = ""
+
+		keys, response = r.customKeyStoreKeysInState(store, true)
+		if !response.Empty() {
+			return response
+		}
+	}
+
+	if response := r.saveCustomKeyStore(store, keys); !response.Empty() {
+		return response
+	}
+
+	r.logger.Infof("Custom key store %s is now %s\n", store.CustomKeyStoreId, store.ConnectionState)
+
+	return NewResponse(200, nil)
+}
@@ -3,7 +3,6 @@ package handler
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/config"
 	"github.com/nsmithuk/local-kms/src/data"
 	"strings"
 )
@@ -57,31 +56,26 @@ func (r *RequestHandler) CreateAlias() Response {
 
 	// --------------------------------
 
-	target := config.EnsureArn("key/", *body.TargetKeyId)
-
-	// Lookup the key
-	key, _ := r.database.LoadKey(target)
-
+	key, response := r.getKeyByParameter("targetKeyId", *body.TargetKeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", target)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:CreateAlias", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	if key.GetMetadata().DeletionDate != 0 {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is pending deletion.", target)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	aliasArn := config.ArnPrefix() + *body.AliasName
+	aliasArn := r.arnPrefix() + *body.AliasName
 
 	_, err = r.database.LoadAlias(aliasArn)
 
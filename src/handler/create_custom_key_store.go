@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/hsm"
+	"github.com/nsmithuk/local-kms/src/service"
+)
+
+func (r *RequestHandler) CreateCustomKeyStore() Response {
+
+	var body *kms.CreateCustomKeyStoreInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.CreateCustomKeyStoreInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.CustomKeyStoreName == nil {
+		msg := "CustomKeyStoreName is a required parameter"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if len(*body.CustomKeyStoreName) < 1 || len(*body.CustomKeyStoreName) > 256 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'customKeyStoreName' failed to satisfy "+
+			"constraint: Member must have length between 1 and 256", *body.CustomKeyStoreName)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	storeType := data.CustomKeyStoreTypeAwsCloudHsm
+
+	if body.CustomKeyStoreType != nil {
+		switch *body.CustomKeyStoreType {
+		case "AWS_CLOUDHSM", "EXTERNAL_KEY_STORE":
+			storeType = data.CustomKeyStoreType(*body.CustomKeyStoreType)
+		default:
+			msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'customKeyStoreType' failed to satisfy "+
+				"constraint: Member must satisfy enum value set: [EXTERNAL_KEY_STORE, AWS_CLOUDHSM]", *body.CustomKeyStoreType)
+
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+	}
+
+	//---
+
+	stores, err := r.database.ListCustomKeyStores(r.arnPrefix())
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	for _, s := range stores {
+		if s.CustomKeyStoreName == *body.CustomKeyStoreName {
+			msg := fmt.Sprintf("Custom key store name '%s' is already in use.", *body.CustomKeyStoreName)
+
+			r.logger.Warnf(msg)
+			return NewCustomKeyStoreNameInUseExceptionResponse(msg)
+		}
+	}
+
+	store := &data.CustomKeyStore{
+		// IDs have a 'cks-' prefix, followed by 17 hex characters
+		CustomKeyStoreId:   "cks-" + hex.EncodeToString(service.GenerateRandomData(9))[:17],
+		CustomKeyStoreName: *body.CustomKeyStoreName,
+		CustomKeyStoreType: storeType,
+		ConnectionState:    data.ConnectionStateDisconnected,
+		CreationDate:       time.Now().Unix(),
+	}
+
+	var response Response
+
+	switch storeType {
+	case data.CustomKeyStoreTypeAwsCloudHsm:
+		response = r.createCloudHsmKeyStore(body, store, stores)
+	case data.CustomKeyStoreTypeExternalKeyStore:
+		response = r.createExternalKeyStore(body, store, stores)
+	}
+
+	if !response.Empty() {
+		return response
+	}
+
+	err = r.database.SaveCustomKeyStore(r.arnPrefix(), store)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("New custom key store created: %s\n", store.CustomKeyStoreId)
+
+	return NewResponse(200, &struct {
+		CustomKeyStoreId string
+	}{
+		CustomKeyStoreId: store.CustomKeyStoreId,
+	})
+}
+
+/*
+Validates the parameters of an AWS_CLOUDHSM key store, creating its local cluster if it doesn't already exist.
+*/
+func (r *RequestHandler) createCloudHsmKeyStore(body *kms.CreateCustomKeyStoreInput, store *data.CustomKeyStore, stores []*data.CustomKeyStore) Response {
+
+	if body.XksProxyUriEndpoint != nil || body.XksProxyUriPath != nil || body.XksProxyConnectivity != nil ||
+		body.XksProxyAuthenticationCredential != nil || body.XksProxyVpcEndpointServiceName != nil {
+
+		msg := "XKS proxy parameters are not valid for custom key stores of type AWS_CLOUDHSM"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.CloudHsmClusterId == nil {
+		msg := "CloudHsmClusterId is a required parameter for custom key stores of type AWS_CLOUDHSM"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if body.TrustAnchorCertificate == nil {
+		msg := "TrustAnchorCertificate is a required parameter for custom key stores of type AWS_CLOUDHSM"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if body.KeyStorePassword == nil {
+		msg := "KeyStorePassword is a required parameter for custom key stores of type AWS_CLOUDHSM"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if len(*body.CloudHsmClusterId) < 19 || len(*body.CloudHsmClusterId) > 24 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'cloudHsmClusterId' failed to satisfy "+
+			"constraint: Member must have length between 19 and 24", *body.CloudHsmClusterId)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(*body.KeyStorePassword) < 7 || len(*body.KeyStorePassword) > 32 {
+		msg := "1 validation error detected: Value at 'keyStorePassword' failed to satisfy " +
+			"constraint: Member must have length between 7 and 32"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if block, _ := pem.Decode([]byte(*body.TrustAnchorCertificate)); block == nil || block.Type != "CERTIFICATE" {
+		msg := "The trust anchor certificate is not a PEM encoded certificate."
+
+		r.logger.Warnf(msg)
+		return NewIncorrectTrustAnchorExceptionResponse(msg)
+	}
+
+	for _, s := range stores {
+		if s.CloudHsmClusterId == *body.CloudHsmClusterId {
+			msg := fmt.Sprintf("Cluster %s is already associated with custom key store %s.",
+				*body.CloudHsmClusterId, s.CustomKeyStoreId)
+
+			r.logger.Warnf(msg)
+			return NewCloudHsmClusterInUseExceptionResponse(msg)
+		}
+	}
+
+	//---
+
+	// The local cluster is created along with the store, if it doesn't already exist.
+	cluster, err := hsm.OpenCluster(r.config.HsmPath, *body.CloudHsmClusterId)
+	if err == hsm.ErrClusterNotFound {
+		_, err = hsm.InitialiseCluster(r.config.HsmPath, *body.CloudHsmClusterId, *body.TrustAnchorCertificate, *body.KeyStorePassword)
+		if err != nil {
+			r.logger.Error(err)
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+		r.logger.Infof("New cluster initialised: %s\n", *body.CloudHsmClusterId)
+
+	} else if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+
+	} else if cluster.TrustAnchorCertificate() != *body.TrustAnchorCertificate {
+		msg := fmt.Sprintf("The trust anchor certificate does not match that of cluster %s.", *body.CloudHsmClusterId)
+
+		r.logger.Warnf(msg)
+		return NewIncorrectTrustAnchorExceptionResponse(msg)
+	}
+
+	//---
+
+	store.CloudHsmClusterId = *body.CloudHsmClusterId
+	store.TrustAnchorCertificate = *body.TrustAnchorCertificate
+	store.KeyStorePassword = *body.KeyStorePassword
+
+	return Response{}
+}
+
+/*
+Validates the parameters of an EXTERNAL_KEY_STORE key store, confirming its XKS proxy can be reached.
+*/
+func (r *RequestHandler) createExternalKeyStore(body *kms.CreateCustomKeyStoreInput, store *data.CustomKeyStore, stores []*data.CustomKeyStore) Response {
+
+	if body.CloudHsmClusterId != nil || body.TrustAnchorCertificate != nil || body.KeyStorePassword != nil {
+		msg := "CloudHSM parameters are not valid for custom key stores of type EXTERNAL_KEY_STORE"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.XksProxyConnectivity == nil {
+		msg := "XksProxyConnectivity is a required parameter for custom key stores of type EXTERNAL_KEY_STORE"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if body.XksProxyUriEndpoint == nil {
+		msg := "XksProxyUriEndpoint is a required parameter for custom key stores of type EXTERNAL_KEY_STORE"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if body.XksProxyUriPath == nil {
+		msg := "XksProxyUriPath is a required parameter for custom key stores of type EXTERNAL_KEY_STORE"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if body.XksProxyAuthenticationCredential == nil {
+		msg := "XksProxyAuthenticationCredential is a required parameter for custom key stores of type EXTERNAL_KEY_STORE"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	configuration := &data.XksProxyConfiguration{
+		Connectivity: *body.XksProxyConnectivity,
+		UriEndpoint:  *body.XksProxyUriEndpoint,
+		UriPath:      *body.XksProxyUriPath,
+	}
+
+	credential := body.XksProxyAuthenticationCredential
+	if credential.AccessKeyId != nil {
+		configuration.AccessKeyId = *credential.AccessKeyId
+	}
+
+	var secret string
+	if credential.RawSecretAccessKey != nil {
+		secret = *credential.RawSecretAccessKey
+	}
+
+	response := r.validateXksProxyConfiguration(configuration, secret, body.XksProxyVpcEndpointServiceName)
+	if !response.Empty() {
+		return response
+	}
+
+	for _, s := range stores {
+		if c := s.XksProxyConfiguration; c != nil && c.UriEndpoint == configuration.UriEndpoint && c.UriPath == configuration.UriPath {
+			msg := fmt.Sprintf("The XKS proxy URI %s%s is already associated with custom key store %s.",
+				configuration.UriEndpoint, configuration.UriPath, s.CustomKeyStoreId)
+
+			r.logger.Warnf(msg)
+			return NewXksProxyUriInUseExceptionResponse(msg)
+		}
+	}
+
+	//---
+
+	store.XksProxyConfiguration = configuration
+	store.XksProxyRawSecretAccessKey = secret
+
+	// As with AWS, the proxy must be reachable, and accept the credential, for the store to be created.
+	if _, err := r.connectXksProxy(store); err != nil {
+		return r.xksErrorResponse(err)
+	}
+
+	return Response{}
+}
@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/service"
+)
+
+var grantNamePattern = regexp.MustCompile(`^[a-zA-Z0-9:/_-]+$`)
+
+func (r *RequestHandler) CreateGrant() Response {
+
+	var body *kms.CreateGrantInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.CreateGrantInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.GranteePrincipal == nil {
+		msg := "1 validation error detected: Value null at 'granteePrincipal' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(*body.GranteePrincipal) < 1 || len(*body.GranteePrincipal) > 256 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'granteePrincipal' failed to satisfy "+
+			"constraint: Member must have length between 1 and 256", *body.GranteePrincipal)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.RetiringPrincipal != nil && (len(*body.RetiringPrincipal) < 1 || len(*body.RetiringPrincipal) > 256) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'retiringPrincipal' failed to satisfy "+
+			"constraint: Member must have length between 1 and 256", *body.RetiringPrincipal)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(body.Operations) == 0 {
+		msg := "1 validation error detected: Value null at 'operations' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.Name != nil && (len(*body.Name) > 256 || !grantNamePattern.MatchString(*body.Name)) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'name' failed to satisfy constraint: "+
+			"Member must satisfy regular expression pattern: ^[a-zA-Z0-9:/_-]+$", *body.Name)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	operations := make([]data.GrantOperation, len(body.Operations))
+	for i, o := range body.Operations {
+		operations[i] = data.GrantOperation(*o)
+	}
+
+	//---
+
+	key, response := r.getKey(*body.KeyId)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:CreateGrant", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	valid := validGrantOperations(key)
+
+	for _, o := range operations {
+		if !containsGrantOperation(valid, o) {
+			msg := fmt.Sprintf("Grant operation %s is not valid for key %s with KeySpec %s and KeyUsage %s.",
+				o, key.GetArn(), key.GetMetadata().KeySpec, key.GetMetadata().KeyUsage)
+
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+	}
+
+	var constraints *data.GrantConstraints
+
+	if body.Constraints != nil && (body.Constraints.EncryptionContextEquals != nil || body.Constraints.EncryptionContextSubset != nil) {
+
+		if body.Constraints.EncryptionContextEquals != nil && body.Constraints.EncryptionContextSubset != nil {
+			msg := "Only one of EncryptionContextEquals or EncryptionContextSubset can be specified in grant constraints."
+
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
+		for _, o := range operations {
+			if !containsGrantOperation(grantOperationsSupportingConstraints, o) {
+				msg := fmt.Sprintf("Grant constraints are not supported for grant operation %s.", o)
+
+				r.logger.Warnf(msg)
+				return NewValidationExceptionResponse(msg)
+			}
+		}
+
+		constraints = &data.GrantConstraints{
+			EncryptionContextEquals: flattenEncryptionContext(body.Constraints.EncryptionContextEquals),
+			EncryptionContextSubset: flattenEncryptionContext(body.Constraints.EncryptionContextSubset),
+		}
+	}
+
+	//---
+
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
+	grant := &data.Grant{
+		KeyId:            key.GetArn(),
+		GrantId:          hex.EncodeToString(service.GenerateRandomData(32)),
+		CreationDate:     time.Now().Unix(),
+		GranteePrincipal: *body.GranteePrincipal,
+		IssuingAccount:   "arn:aws:iam::" + r.config.AWSAccountId + ":root",
+		Operations:       operations,
+		Constraints:      constraints,
+	}
+
+	if body.Name != nil {
+		grant.Name = *body.Name
+	}
+
+	if body.RetiringPrincipal != nil {
+		grant.RetiringPrincipal = *body.RetiringPrincipal
+	}
+
+	//---
+
+	// Creating a grant with the same name and parameters as an existing grant returns the existing grant's ID.
+	if grant.Name != "" {
+		existing, err := r.database.ListGrants(key.GetArn(), 10000, "")
+		if err != nil {
+			r.logger.Error(err)
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+		for _, g := range existing {
+			if g.Name == grant.Name && g.GranteePrincipal == grant.GranteePrincipal &&
+				g.RetiringPrincipal == grant.RetiringPrincipal && reflect.DeepEqual(g.Operations, grant.Operations) &&
+				reflect.DeepEqual(g.Constraints, grant.Constraints) {
+
+				r.logger.Infof("Existing grant returned: %s on %s\n", g.GrantId, key.GetArn())
+
+				return NewResponse(200, map[string]string{
+					"GrantId":    g.GrantId,
+					"GrantToken": service.PackGrantToken(key.GetArn(), g.GrantId),
+				})
+			}
+		}
+	}
+
+	//--------------------------------
+	// Save the grant
+
+	err = r.database.SaveGrant(key, grant)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("New grant created: %s on %s for %s\n", grant.GrantId, key.GetArn(), grant.GranteePrincipal)
+
+	return NewResponse(200, map[string]string{
+		"GrantId":    grant.GrantId,
+		"GrantToken": service.PackGrantToken(key.GetArn(), grant.GrantId),
+	})
+}
+
+//------------------------------------------
+
+var grantOperationsSupportingConstraints = []data.GrantOperation{
+	data.GrantOperationDecrypt,
+	data.GrantOperationEncrypt,
+	data.GrantOperationGenerateDataKey,
+	data.GrantOperationGenerateDataKeyWithoutPlaintext,
+	data.GrantOperationGenerateDataKeyPair,
+	data.GrantOperationGenerateDataKeyPairWithoutPlaintext,
+	data.GrantOperationReEncryptFrom,
+	data.GrantOperationReEncryptTo,
+	data.GrantOperationCreateGrant,
+	data.GrantOperationRetireGrant,
+	data.GrantOperationDescribeKey,
+}
+
+/*
+Returns the grant operations that are applicable to the type and usage of the given key.
+*/
+func validGrantOperations(key cmk.Key) []data.GrantOperation {
+
+	operations := []data.GrantOperation{
+		data.GrantOperationCreateGrant,
+		data.GrantOperationRetireGrant,
+		data.GrantOperationDescribeKey,
+	}
+
+	switch key.(type) {
+	case *cmk.AesKey:
+		return append(operations,
+			data.GrantOperationDecrypt,
+			data.GrantOperationEncrypt,
+			data.GrantOperationGenerateDataKey,
+			data.GrantOperationGenerateDataKeyWithoutPlaintext,
+			data.GrantOperationGenerateDataKeyPair,
+			data.GrantOperationGenerateDataKeyPairWithoutPlaintext,
+			data.GrantOperationReEncryptFrom,
+			data.GrantOperationReEncryptTo,
+		)
+	}
+
+	switch key.GetMetadata().KeyUsage {
+	case cmk.UsageEncryptDecrypt:
+		return append(operations,
+			data.GrantOperationDecrypt,
+			data.GrantOperationEncrypt,
+			data.GrantOperationReEncryptFrom,
+			data.GrantOperationReEncryptTo,
+			data.GrantOperationGetPublicKey,
+		)
+	case cmk.UsageSignVerify:
+		return append(operations,
+			data.GrantOperationSign,
+			data.GrantOperationVerify,
+			data.GrantOperationGetPublicKey,
+		)
+	case cmk.UsageKeyAgreement:
+		return append(operations,
+			data.GrantOperationDeriveSharedSecret,
+			data.GrantOperationGetPublicKey,
+		)
+	case cmk.UsageGenerateVerifyMac:
+		return append(operations,
+			data.GrantOperationGenerateMac,
+			data.GrantOperationVerifyMac,
+		)
+	}
+
+	return operations
+}
+
+func containsGrantOperation(operations []data.GrantOperation, operation data.GrantOperation) bool {
+	for _, o := range operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func flattenEncryptionContext(context map[string]*string) map[string]string {
+	if context == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(context))
+	for k, v := range context {
+		if v != nil {
+			result[k] = *v
+		} else {
+			result[k] = ""
+		}
+	}
+	return result
+}
@@ -2,12 +2,12 @@ package handler
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/gofrs/uuid"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 	"github.com/nsmithuk/local-kms/src/data"
 )
 
@@ -24,15 +24,33 @@ func (r *RequestHandler) CreateKey() Response {
 
 	keyId := uuid.Must(uuid.NewV4()).String()
 
+	// Multi-Region keys have an 'mrk-' prefix, followed by 32 hex characters
+	multiRegion := body.MultiRegion != nil && *body.MultiRegion
+	if multiRegion {
+		keyId = "mrk-" + strings.ReplaceAll(keyId, "-", "")
+	}
+
 	metadata := cmk.KeyMetadata{
-		Arn:          config.ArnPrefix() + "key/" + keyId,
+		Arn:          r.arnPrefix() + "key/" + keyId,
 		KeyId:        keyId,
-		AWSAccountId: config.AWSAccountId,
+		AWSAccountId: r.config.AWSAccountId,
 		CreationDate: time.Now().Unix(),
 		Enabled:      true,
 		KeyManager:   "CUSTOMER",
 		KeyState:     cmk.KeyStateEnabled,
 		Origin:       cmk.KeyOriginAwsKms,
+		MultiRegion:  multiRegion,
+	}
+
+	if multiRegion {
+		metadata.MultiRegionConfiguration = &cmk.MultiRegionConfiguration{
+			MultiRegionKeyType: cmk.MultiRegionKeyTypePrimary,
+			PrimaryKey: cmk.MultiRegionKey{
+				Arn:    metadata.Arn,
+				Region: r.region,
+			},
+			ReplicaKeys: []cmk.MultiRegionKey{},
+		}
 	}
 
 	//--------------------------------
@@ -63,20 +81,13 @@ func (r *RequestHandler) CreateKey() Response {
 		metadata.Description = body.Description
 	}
 
-	if body.Policy == nil {
-		policy := fmt.Sprintf(`{
-			"Id": "key-default-policy",
-			"Version": "2012-10-17",
-			"Statement": [{
-				"Sid": "Enable IAM User Permissions",
-				"Effect": "Allow",
-				"Principal": {
-					"AWS": "arn:aws:iam::%s:root"
-				},
-				"Action": "kms:*",
-				"Resource": "*"
-			}]
-		}`, config.AWSAccountId)
+	if body.Policy != nil {
+		response = r.validateKeyPolicy(metadata.Arn, *body.Policy, body.BypassPolicyLockoutSafetyCheck)
+		if !response.Empty() {
+			return response
+		}
+	} else {
+		policy := r.defaultKeyPolicy()
 		body.Policy = &policy
 	}
 
@@ -96,13 +107,39 @@ func (r *RequestHandler) CreateKey() Response {
 		body.KeySpec = &sd
 	}
 
+	if body.CustomKeyStoreId != nil && (body.Origin == nil || (*body.Origin != "AWS_CLOUDHSM" && *body.Origin != "EXTERNAL_KEY_STORE")) {
+		msg := fmt.Sprintf("Origin must be AWS_CLOUDHSM or EXTERNAL_KEY_STORE when a CustomKeyStoreId is specified.")
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if body.XksKeyId != nil && (body.Origin == nil || *body.Origin != "EXTERNAL_KEY_STORE") {
+		msg := fmt.Sprintf("XksKeyId can only be specified when Origin is EXTERNAL_KEY_STORE.")
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	// The custom key store the key is created in, if any
+	var store *data.CustomKeyStore
+
 	if body.Origin != nil {
 		switch *body.Origin {
 		case "AWS_KMS":
 			// nop
 		case "EXTERNAL":
 
-			if *body.KeySpec != "SYMMETRIC_DEFAULT" {
+			if multiRegion {
+				msg := fmt.Sprintf("Local KMS does not yet support multi-Region keys with Origin EXTERNAL.")
+				r.logger.Warnf(msg)
+				return NewUnsupportedOperationException(msg)
+			}
+
+			// Key material can be imported for symmetric, RSA, NIST & SECG ECC, and HMAC keys
+			switch *body.KeySpec {
+			case "SYMMETRIC_DEFAULT", "RSA_2048", "RSA_3072", "RSA_4096", "ECC_NIST_P256", "ECC_NIST_P384",
+				"ECC_NIST_P521", "ECC_SECG_P256K1", "HMAC_224", "HMAC_256", "HMAC_384", "HMAC_512":
+				// nop
+			default:
 				msg := fmt.Sprintf("KeySpec %s is not supported for Origin %s", *body.KeySpec, *body.Origin)
 
 				r.logger.Warnf(msg)
@@ -114,15 +151,64 @@ func (r *RequestHandler) CreateKey() Response {
 			metadata.Enabled = false
 			metadata.KeyState = cmk.KeyStatePendingImport
 
-		case "AWS_CLOUDHSM":
+		case "AWS_CLOUDHSM", "EXTERNAL_KEY_STORE":
 
-			msg := fmt.Sprintf("Local KMS does not yet support Origin AWS_CLOUDHSM.")
-			r.logger.Warnf(msg)
-			return NewUnsupportedOperationException(msg)
+			if body.CustomKeyStoreId == nil {
+				msg := fmt.Sprintf("You must specify a CustomKeyStoreId when Origin is %s.", *body.Origin)
+				r.logger.Warnf(msg)
+				return NewValidationExceptionResponse(msg)
+			}
+
+			if multiRegion {
+				msg := fmt.Sprintf("Multi-Region keys cannot be created in a custom key store.")
+				r.logger.Warnf(msg)
+				return NewUnsupportedOperationException(msg)
+			}
+
+			if *body.KeySpec != "SYMMETRIC_DEFAULT" {
+				msg := fmt.Sprintf("KeySpec %s is not supported for Origin %s", *body.KeySpec, *body.Origin)
+
+				r.logger.Warnf(msg)
+				return NewValidationExceptionResponse(msg)
+			}
+
+			store, response = r.getCustomKeyStore(*body.CustomKeyStoreId)
+			if store == nil {
+				return response
+			}
+
+			// AWS_CLOUDHSM keys must be in an AWS_CLOUDHSM store, and EXTERNAL_KEY_STORE keys in an external key store
+			if (*body.Origin == "EXTERNAL_KEY_STORE") != (store.CustomKeyStoreType == data.CustomKeyStoreTypeExternalKeyStore) {
+				msg := fmt.Sprintf("Origin %s is not valid for custom key store %s, of type %s.",
+					*body.Origin, store.CustomKeyStoreId, store.CustomKeyStoreType)
+
+				r.logger.Warnf(msg)
+				return NewUnsupportedOperationException(msg)
+			}
+
+			if store.ConnectionState != data.ConnectionStateConnected {
+				msg := fmt.Sprintf("Custom key store %s is not connected.", store.CustomKeyStoreId)
+
+				r.logger.Warnf(msg)
+				return NewCustomKeyStoreInvalidStateExceptionResponse(msg)
+			}
+
+			metadata.Origin = cmk.KeyOrigin(*body.Origin)
+			metadata.CustomKeyStoreId = store.CustomKeyStoreId
+			metadata.CloudHsmClusterId = store.CloudHsmClusterId
+
+			if store.CustomKeyStoreType == data.CustomKeyStoreTypeExternalKeyStore {
+				response = r.validateXksKey(store, body.XksKeyId)
+				if !response.Empty() {
+					return response
+				}
+
+				metadata.XksKeyConfiguration = &cmk.XksKeyConfiguration{Id: *body.XksKeyId}
+			}
 
 		default:
 
-			msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'origin' failed to satisfy constraint: Member must satisfy enum value set: [EXTERNAL, AWS_CLOUDHSM, AWS_KMS]", *body.Origin)
+			msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'origin' failed to satisfy constraint: Member must satisfy enum value set: [EXTERNAL, AWS_CLOUDHSM, AWS_KMS, EXTERNAL_KEY_STORE]", *body.Origin)
 
 			r.logger.Warnf(msg)
 			return NewValidationExceptionResponse(msg)
@@ -152,13 +238,34 @@ func (r *RequestHandler) CreateKey() Response {
 			return NewValidationExceptionResponse(msg)
 		}
 
+		// Key agreement is only supported with the NIST curves
+		if !(*body.KeyUsage == "SIGN_VERIFY" || (*body.KeyUsage == "KEY_AGREEMENT" && *body.KeySpec != "ECC_SECG_P256K1")) {
+			msg := fmt.Sprintf("KeyUsage %s is not compatible with KeySpec %s", *body.KeyUsage, *body.KeySpec)
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
+		key, err = cmk.NewEccKey(cmk.KeySpec(*body.KeySpec), cmk.KeyUsage(*body.KeyUsage), metadata, *body.Policy, metadata.Origin)
+		if err != nil {
+			r.logger.Error(err)
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+	case "ECC_NIST_EDWARDS25519":
+
+		if body.KeyUsage == nil {
+			msg := fmt.Sprintf("You must specify a KeyUsage value for an asymmetric CMK.")
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
 		if *body.KeyUsage != "SIGN_VERIFY" {
-			msg := fmt.Sprintf("KeyUsage ENCRYPT_DECRYPT is not compatible with KeySpec %s", *body.KeySpec)
+			msg := fmt.Sprintf("KeyUsage %s is not compatible with KeySpec %s", *body.KeyUsage, *body.KeySpec)
 			r.logger.Warnf(msg)
 			return NewValidationExceptionResponse(msg)
 		}
 
-		key, err = cmk.NewEccKey(cmk.KeySpec(*body.KeySpec), metadata, *body.Policy)
+		key, err = cmk.NewEd25519Key(metadata, *body.Policy)
 		if err != nil {
 			r.logger.Error(err)
 			return NewInternalFailureExceptionResponse(err.Error())
@@ -178,7 +285,47 @@ func (r *RequestHandler) CreateKey() Response {
 			return NewValidationExceptionResponse(msg)
 		}
 
-		key, err = cmk.NewRsaKey(cmk.KeySpec(*body.KeySpec), cmk.KeyUsage(*body.KeyUsage), metadata, *body.Policy)
+		key, err = cmk.NewRsaKey(cmk.KeySpec(*body.KeySpec), cmk.KeyUsage(*body.KeyUsage), metadata, *body.Policy, metadata.Origin)
+		if err != nil {
+			r.logger.Error(err)
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+	case "SM2":
+
+		if body.KeyUsage == nil {
+			msg := fmt.Sprintf("You must specify a KeyUsage value for an asymmetric CMK.")
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
+		if !(*body.KeyUsage == "SIGN_VERIFY" || *body.KeyUsage == "ENCRYPT_DECRYPT") {
+			msg := fmt.Sprintf("KeyUsage %s is not compatible with KeySpec %s", *body.KeyUsage, *body.KeySpec)
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
+		key, err = cmk.NewSm2Key(cmk.KeyUsage(*body.KeyUsage), metadata, *body.Policy)
+		if err != nil {
+			r.logger.Error(err)
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+	case "HMAC_224", "HMAC_256", "HMAC_384", "HMAC_512":
+
+		if body.KeyUsage == nil {
+			msg := fmt.Sprintf("You must specify a KeyUsage value for an HMAC KMS key.")
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
+		if *body.KeyUsage != "GENERATE_VERIFY_MAC" {
+			msg := fmt.Sprintf("KeyUsage %s is not compatible with KeySpec %s", *body.KeyUsage, *body.KeySpec)
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
+		key, err = cmk.NewHmacKey(cmk.KeySpec(*body.KeySpec), metadata, *body.Policy, metadata.Origin)
 		if err != nil {
 			r.logger.Error(err)
 			return NewInternalFailureExceptionResponse(err.Error())
@@ -188,7 +335,8 @@ func (r *RequestHandler) CreateKey() Response {
 
 		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'KeySpec' "+
 			"failed to satisfy constraint: Member must satisfy enum value set: [RSA_2048, ECC_NIST_P384, "+
-			"ECC_NIST_P256, ECC_NIST_P521, RSA_3072, ECC_SECG_P256K1, RSA_4096, SYMMETRIC_DEFAULT]", *body.KeySpec)
+			"ECC_NIST_P256, ECC_NIST_P521, RSA_3072, ECC_SECG_P256K1, ECC_NIST_EDWARDS25519, RSA_4096, SYMMETRIC_DEFAULT, "+
+			"HMAC_224, HMAC_256, HMAC_384, HMAC_512, SM2]", *body.KeySpec)
 
 		r.logger.Warnf(msg)
 
@@ -196,9 +344,28 @@ func (r *RequestHandler) CreateKey() Response {
 	}
 
 	//--------------------------------
-	// Save the key
+	// Generate the key material within an AWS_CLOUDHSM store's cluster
+
+	if store != nil && store.CustomKeyStoreType == data.CustomKeyStoreTypeAwsCloudHsm {
+		response = r.generateCustomKeyStoreKeyMaterial(store, key)
+		if !response.Empty() {
+			return response
+		}
+	}
+
+	//--------------------------------
+	// Save the key, along with its tags
+
+	tags := make([]*data.Tag, 0, len(body.Tags))
 
-	err = r.database.SaveKey(key)
+	for _, kv := range body.Tags {
+		tags = append(tags, &data.Tag{
+			TagKey:   *kv.TagKey,
+			TagValue: *kv.TagValue,
+		})
+	}
+
+	err = r.database.SaveKeyWithTags(key, tags)
 	if err != nil {
 		r.logger.Error(err)
 		return NewInternalFailureExceptionResponse(err.Error())
@@ -206,19 +373,8 @@ func (r *RequestHandler) CreateKey() Response {
 
 	r.logger.Infof("New %s key created: %s\n", key.GetMetadata().KeySpec, key.GetArn())
 
-	//--------------------------------
-	// Create the tags
-
-	if body.Tags != nil && len(body.Tags) > 0 {
-		for _, kv := range body.Tags {
-			t := &data.Tag{
-				TagKey:   *kv.TagKey,
-				TagValue: *kv.TagValue,
-			}
-			_ = r.database.SaveTag(key, t)
-
-			r.logger.Infof("New tag created: %s / %s\n", t.TagKey, t.TagValue)
-		}
+	for _, t := range tags {
+		r.logger.Infof("New tag created: %s / %s\n", t.TagKey, t.TagValue)
 	}
 
 	//---
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/hsm"
+)
+
+// A custom key store, as returned by DescribeCustomKeyStores. Passwords and secrets are never returned.
+type CustomKeyStoreOutput struct {
+	CustomKeyStoreId       string
+	CustomKeyStoreName     string
+	CustomKeyStoreType     data.CustomKeyStoreType
+	CloudHsmClusterId      string                      `json:",omitempty"`
+	TrustAnchorCertificate string                      `json:",omitempty"`
+	XksProxyConfiguration  *data.XksProxyConfiguration `json:",omitempty"`
+	ConnectionState        data.ConnectionState
+	ConnectionErrorCode    data.ConnectionErrorCode `json:",omitempty"`
+	CreationDate           int64
+}
+
+/*
+Finds a custom key store by its ID.
+*/
+func (r *RequestHandler) getCustomKeyStore(id string) (*data.CustomKeyStore, Response) {
+
+	store, _ := r.database.LoadCustomKeyStore(r.arnPrefix(), id)
+
+	if store == nil {
+		msg := fmt.Sprintf("Custom key store '%s' does not exist", id)
+
+		r.logger.Warnf(msg)
+		return nil, NewCustomKeyStoreNotFoundExceptionResponse(msg)
+	}
+
+	return store, Response{}
+}
+
+/*
+Opens the store's cluster, and logs in as its kmsuser. If this fails, the reason is returned as a connection error code.
+*/
+func (r *RequestHandler) connectCluster(store *data.CustomKeyStore) (*hsm.Cluster, data.ConnectionErrorCode) {
+
+	cluster, err := hsm.OpenCluster(r.config.HsmPath, store.CloudHsmClusterId)
+	if err == hsm.ErrClusterNotFound {
+		r.logger.Warnf("Cluster %s for custom key store %s not found", store.CloudHsmClusterId, store.CustomKeyStoreId)
+		return nil, data.ConnectionErrorCodeClusterNotFound
+	} else if err != nil {
+		r.logger.Error(err)
+		return nil, data.ConnectionErrorCodeInternalError
+	}
+
+	if err := cluster.Login(store.KeyStorePassword); err != nil {
+		r.logger.Warnf("Unable to log in to cluster %s for custom key store %s", store.CloudHsmClusterId, store.CustomKeyStoreId)
+		return nil, data.ConnectionErrorCodeInvalidCredentials
+	}
+
+	return cluster, ""
+}
+
+/*
+Generates the key's material within the custom key store's cluster.
+*/
+func (r *RequestHandler) generateCustomKeyStoreKeyMaterial(store *data.CustomKeyStore, key cmk.Key) Response {
+
+	cluster, code := r.connectCluster(store)
+	if cluster == nil {
+		msg := fmt.Sprintf("Unable to connect to the cluster of custom key store %s: %s.", store.CustomKeyStoreId, code)
+
+		r.logger.Warnf(msg)
+		return NewCustomKeyStoreInvalidStateExceptionResponse(msg)
+	}
+
+	material, err := cluster.GenerateKey(key.GetMetadata().KeyId)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	importable, ok := key.(cmk.ImportableKey)
+	if !ok {
+		msg := fmt.Sprintf("KeySpec %s is not supported in a custom key store", key.GetMetadata().KeySpec)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if err := importable.ImportKeyMaterial(material); err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	return Response{}
+}
+
+/*
+Returns the keys in the custom key store, with their states updated. Whilst the store is disconnected its keys are
+Unavailable; once reconnected they return to the state implied by their metadata. The keys are saved with the store.
+*/
+func (r *RequestHandler) customKeyStoreKeysInState(store *data.CustomKeyStore, connected bool) ([]cmk.Key, Response) {
+
+	keys, err := r.database.ListKeysInCustomKeyStore(r.arnPrefix()+"key/", store.CustomKeyStoreId)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	for _, key := range keys {
+		metadata := key.GetMetadata()
+
+		if connected {
+			metadata.KeyState = metadata.AvailableKeyState()
+		} else {
+			metadata.KeyState = cmk.KeyStateUnavailable
+		}
+	}
+
+	return keys, Response{}
+}
+
+/*
+Saves the custom key store along with changes to its keys, so either all are applied or none are.
+*/
+func (r *RequestHandler) saveCustomKeyStore(store *data.CustomKeyStore, keys []cmk.Key) Response {
+
+	err := r.database.SaveCustomKeyStoreWithKeys(r.arnPrefix(), store, keys)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	for _, key := range keys {
+		r.logger.Infof("Key %s is now %s", key.GetArn(), key.GetMetadata().KeyState)
+	}
+
+	return Response{}
+}
+
+func newCustomKeyStoreOutput(store *data.CustomKeyStore) *CustomKeyStoreOutput {
+	return &CustomKeyStoreOutput{
+		CustomKeyStoreId:       store.CustomKeyStoreId,
+		CustomKeyStoreName:     store.CustomKeyStoreName,
+		CustomKeyStoreType:     store.CustomKeyStoreType,
+		CloudHsmClusterId:      store.CloudHsmClusterId,
+		TrustAnchorCertificate: store.TrustAnchorCertificate,
+		XksProxyConfiguration:  store.XksProxyConfiguration,
+		ConnectionState:        store.ConnectionState,
+		ConnectionErrorCode:    store.ConnectionErrorCode,
+		CreationDate:           store.CreationDate,
+	}
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"crypto/rsa"
 	"encoding/base64"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
@@ -8,9 +9,15 @@ import (
 	"github.com/nsmithuk/local-kms/src/service"
 )
 
+// The AWS library version in use doesn't support Recipient.
+type DecryptInput struct {
+	kms.DecryptInput
+	Recipient *RecipientInfo
+}
+
 func (r *RequestHandler) Decrypt() Response {
 
-	var body *kms.DecryptInput
+	var body *DecryptInput
 	err := r.decodeBodyInto(&body)
 
 	if err != nil {
@@ -22,7 +29,7 @@ func (r *RequestHandler) Decrypt() Response {
 			return NewSerializationExceptionResponse("")
 		}
 
-		body = &kms.DecryptInput{}
+		body = &DecryptInput{}
 	}
 
 	//--------------------------------
@@ -49,6 +56,15 @@ func (r *RequestHandler) Decrypt() Response {
 		body.EncryptionAlgorithm = &d
 	}
 
+	var recipientKey *rsa.PublicKey
+	if body.Recipient != nil {
+		var response Response
+		recipientKey, response = r.recipientPublicKey(body.Recipient)
+		if !response.Empty() {
+			return response
+		}
+	}
+
 	//--------------------------------
 
 	// If a KeyId is provided, we always use that, even for symmetric keys.
@@ -89,18 +105,57 @@ func (r *RequestHandler) Decrypt() Response {
 
 		// We only use the unpacked keyArn if a key wasn't supplied.
 		if key == nil {
-			key, response = r.getUsableKey(keyArn)
+			key, _ = r.getKey(r.localMultiRegionKeyArn(keyArn))
+
+			if key == nil {
+				// We override the returned error on decrypt. The message is more generic such that it doesn't leak any metadata.
+				msg := "The ciphertext refers to a customer master key that does not exist, does not exist in this region, " +
+					"or you are not allowed to access."
+
+				return NewAccessDeniedExceptionResponse(msg)
+			}
+
+			// As with AWS, a key that exists but can't be used returns the reason.
+			response = r.checkKeyState(key)
+			if !response.Empty() {
+				return response
+			}
 		}
 	}
 
-	// If the response is not empty, there was an error
-	if key == nil || !response.Empty() {
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
 
-		// We override the returned error on decrypt. The message is more generic such that it doesn't leak any metadata.
-		msg := "The ciphertext refers to a customer master key that does not exist, does not exist in this region, " +
-			"or you are not allowed to access."
+	response = r.checkKeyPolicy(key, "kms:Decrypt", body.EncryptionContext)
+	if !response.Empty() {
+		return response
+	}
+
+	if key.GetMetadata().KeyUsage != cmk.UsageEncryptDecrypt {
+		msg := fmt.Sprintf("%s key usage is %s which is not valid for Decrypt.", key.GetArn(), key.GetMetadata().KeyUsage)
+		r.logger.Warnf(msg)
+		return NewInvalidKeyUsageException(msg)
+	}
+
+	// As with AWS, ciphertext for a recipient is only produced by symmetric encryption keys
+	if body.Recipient != nil && key.GetMetadata().KeySpec != cmk.SpecSymmetricDefault {
+		msg := fmt.Sprintf("Recipient is not supported for %s, as its key spec is %s. Only %s keys support it.",
+			key.GetArn(), key.GetMetadata().KeySpec, cmk.SpecSymmetricDefault)
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if _, ok := key.(*cmk.Sm2Key); ok && cmk.EncryptionAlgorithm(*body.EncryptionAlgorithm) != cmk.EncryptionAlgorithmSm2Pke {
+		msg := fmt.Sprintf("Algorithm %s is incompatible with key spec %s.", *body.EncryptionAlgorithm, key.GetMetadata().KeySpec)
+		r.logger.Warnf(msg)
+		return NewInvalidKeyUsageException(msg)
+	}
 
-		return NewAccessDeniedExceptionResponse(msg)
+	// Every check has passed; nothing is decrypted for a dry run.
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
 	}
 
 	//--------------------------------
@@ -110,7 +165,14 @@ func (r *RequestHandler) Decrypt() Response {
 	switch k := key.(type) {
 	case *cmk.AesKey:
 
-		plaintext, err = k.Decrypt(keyVersion, ciphertext, body.EncryptionContext)
+		plaintext, response = r.decryptSymmetric(k, keyVersion, ciphertext, body.EncryptionContext)
+		if !response.Empty() {
+			return response
+		}
+
+	case *cmk.RsaKey:
+
+		plaintext, err = k.Decrypt(ciphertext, cmk.EncryptionAlgorithm(*body.EncryptionAlgorithm))
 		if err != nil {
 			msg := fmt.Sprintf("Unable to decode Ciphertext: %s", err)
 			r.logger.Warnf(msg)
@@ -118,7 +180,7 @@ func (r *RequestHandler) Decrypt() Response {
 			return NewInvalidCiphertextExceptionResponse("")
 		}
 
-	case *cmk.RsaKey:
+	case *cmk.Sm2Key:
 
 		plaintext, err = k.Decrypt(ciphertext, cmk.EncryptionAlgorithm(*body.EncryptionAlgorithm))
 		if err != nil {
@@ -129,20 +191,33 @@ func (r *RequestHandler) Decrypt() Response {
 		}
 
 	default:
+
 		return NewInternalFailureExceptionResponse("key type not yet supported for decryption")
 	}
 
 	//--------------------------------
 
-	r.logger.Infof("Decryption called: %s\n", key.GetArn())
-
-	return NewResponse(200, &struct {
-		KeyId               string
-		Plaintext           []byte
-		EncryptionAlgorithm cmk.EncryptionAlgorithm
+	output := &struct {
+		KeyId                  string
+		Plaintext              []byte `json:",omitempty"`
+		CiphertextForRecipient []byte `json:",omitempty"`
+		EncryptionAlgorithm    cmk.EncryptionAlgorithm
 	}{
 		KeyId:               key.GetArn(),
-		Plaintext:           plaintext,
 		EncryptionAlgorithm: cmk.EncryptionAlgorithm(*body.EncryptionAlgorithm),
-	})
+	}
+
+	// With a recipient, the plaintext is only returned encrypted to the recipient's public key
+	if recipientKey != nil {
+		output.CiphertextForRecipient, response = r.ciphertextForRecipient(plaintext, recipientKey)
+		if !response.Empty() {
+			return response
+		}
+	} else {
+		output.Plaintext = plaintext
+	}
+
+	r.logger.Infof("Decryption called: %s\n", key.GetArn())
+
+	return NewResponse(200, output)
 }
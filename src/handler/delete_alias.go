@@ -3,7 +3,6 @@ package handler
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/config"
 	"strings"
 )
 
@@ -41,9 +40,9 @@ func (r *RequestHandler) DeleteAlias() Response {
 
 	//--------------------------------
 
-	aliasArn := config.ArnPrefix() + *body.AliasName
+	aliasArn := r.arnPrefix() + *body.AliasName
 
-	_, err = r.database.LoadAlias(aliasArn)
+	alias, err := r.database.LoadAlias(aliasArn)
 
 	if err != nil {
 		msg := fmt.Sprintf("Alias '%s' does not exist", aliasArn)
@@ -52,6 +51,14 @@ func (r *RequestHandler) DeleteAlias() Response {
 		return NewNotFoundExceptionResponse(msg)
 	}
 
+	// The alias may still refer to a key that has since been deleted.
+	if key, _ := r.database.LoadKey(r.ensureArn("key/", alias.TargetKeyId)); key != nil {
+		response := r.checkKeyPolicy(key, "kms:DeleteAlias", nil)
+		if !response.Empty() {
+			return response
+		}
+	}
+
 	r.database.DeleteObject(aliasArn)
 
 	//---
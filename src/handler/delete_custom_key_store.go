@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/data"
+)
+
+func (r *RequestHandler) DeleteCustomKeyStore() Response {
+
+	var body *kms.DeleteCustomKeyStoreInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.DeleteCustomKeyStoreInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.CustomKeyStoreId == nil {
+		msg := "CustomKeyStoreId is a required parameter"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	//---
+
+	store, response := r.getCustomKeyStore(*body.CustomKeyStoreId)
+	if store == nil {
+		return response
+	}
+
+	if store.ConnectionState != data.ConnectionStateDisconnected {
+		msg := fmt.Sprintf("Custom key store %s must be disconnected before it can be deleted.", store.CustomKeyStoreId)
+
+		r.logger.Warnf(msg)
+		return NewCustomKeyStoreInvalidStateExceptionResponse(msg)
+	}
+
+	keys, err := r.database.ListKeysInCustomKeyStore(r.arnPrefix()+"key/", store.CustomKeyStoreId)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	if len(keys) > 0 {
+		msg := fmt.Sprintf("Custom key store %s contains %d keys. All keys must be deleted before the store can "+
+			"be deleted.", store.CustomKeyStoreId, len(keys))
+
+		r.logger.Warnf(msg)
+		return NewCustomKeyStoreHasCMKsExceptionResponse(msg)
+	}
+
+	//---
+
+	// The cluster itself is left in place, as it would be with AWS.
+	err = r.database.DeleteCustomKeyStore(r.arnPrefix(), store.CustomKeyStoreId)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("Custom key store deleted: %s\n", store.CustomKeyStoreId)
+
+	return NewResponse(200, nil)
+}
@@ -40,6 +40,11 @@ func (r *RequestHandler) DeleteImportedKeyMaterial() Response {
 		return NewNotFoundExceptionResponse(msg)
 	}
 
+	response = r.checkKeyPolicy(key, "kms:DeleteImportedKeyMaterial", nil)
+	if !response.Empty() {
+		return response
+	}
+
 	//---
 
 	// Check key metadata
@@ -52,18 +57,9 @@ func (r *RequestHandler) DeleteImportedKeyMaterial() Response {
 		return NewUnsupportedOperationException(msg)
 	}
 
-	switch keyMetadata.KeyState {
-	case cmk.KeyStatePendingDeletion:
-		msg := fmt.Sprintf("%s is pending deletion.", *body.KeyId)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
-
-	case cmk.KeyStateUnavailable:
-		msg := fmt.Sprintf("%s is unavailable.", *body.KeyId)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
@@ -71,8 +67,7 @@ func (r *RequestHandler) DeleteImportedKeyMaterial() Response {
 	// We're good to go. Instead of actually deleting anything, we leave the imported
 	// key material in place as any attempt to import key material again must import the
 	// same key material again.
-	keyMetadata.KeyState = cmk.KeyStatePendingImport
-	keyMetadata.Enabled = false
+	cmk.TransitionKeyState(r.operation(), keyMetadata)
 
 	//--------------------------------
 	// Save the key
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+// Custom struct, as DeriveSharedSecret isn't supported by the AWS library version in use.
+type DeriveSharedSecretInput struct {
+	KeyId                 *string
+	KeyAgreementAlgorithm *string
+	PublicKey             []byte
+	GrantTokens           []*string
+}
+
+func (r *RequestHandler) DeriveSharedSecret() Response {
+
+	var body *DeriveSharedSecretInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &DeriveSharedSecretInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.KeyAgreementAlgorithm == nil {
+		msg := "1 validation error detected: Value null at 'keyAgreementAlgorithm' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if *body.KeyAgreementAlgorithm != string(cmk.KeyAgreementAlgorithmEcdh) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'keyAgreementAlgorithm' failed to satisfy "+
+			"constraint: Member must satisfy enum value set: [ECDH]", *body.KeyAgreementAlgorithm)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(body.PublicKey) < 1 || len(body.PublicKey) > 8192 {
+		msg := "1 validation error detected: Value at 'publicKey' failed to satisfy constraint: Member must " +
+			"have length greater than or equal to 1 and less than or equal to 8192"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//----------------------------------
+
+	key, response := r.getUsableKey(*body.KeyId)
+
+	// If the response is not empty, there was an error
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:DeriveSharedSecret", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	agreementKey, ok := key.(cmk.KeyAgreementKey)
+	if !ok || key.GetMetadata().KeyUsage != cmk.UsageKeyAgreement {
+		msg := fmt.Sprintf("%s key usage is %s which is not valid for DeriveSharedSecret.", key.GetArn(), key.GetMetadata().KeyUsage)
+
+		r.logger.Warnf(msg)
+		return NewInvalidKeyUsageException(msg)
+	}
+
+	if err := agreementKey.CheckPublicKey(body.PublicKey); err != nil {
+		msg := fmt.Sprintf("The public key is invalid for key spec %s: %s.", key.GetMetadata().KeySpec, err)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	// Every check has passed; no secret is derived for a dry run.
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
+	//---
+
+	secret, err := agreementKey.DeriveSharedSecret(body.PublicKey, cmk.KeyAgreementAlgorithm(*body.KeyAgreementAlgorithm))
+	if err != nil {
+
+		if _, ok := err.(*cmk.InvalidKeyAgreementAlgorithm); ok {
+			msg := fmt.Sprintf("Algorithm %s is incompatible with key spec %s.", *body.KeyAgreementAlgorithm, key.GetMetadata().KeySpec)
+
+			r.logger.Warnf(msg)
+			return NewInvalidKeyUsageException(msg)
+		}
+
+		if _, ok := err.(*cmk.InvalidPublicKey); ok {
+			msg := fmt.Sprintf("The public key is invalid for key spec %s: %s.", key.GetMetadata().KeySpec, err)
+
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+
+		r.logger.Error(err.Error())
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("Shared secret derived with %s, using key %s\n", *body.KeyAgreementAlgorithm, key.GetArn())
+
+	return NewResponse(200, &struct {
+		KeyId                 string
+		SharedSecret          []byte
+		KeyAgreementAlgorithm cmk.KeyAgreementAlgorithm
+		KeyOrigin             cmk.KeyOrigin
+	}{
+		KeyId:                 key.GetArn(),
+		SharedSecret:          secret,
+		KeyAgreementAlgorithm: cmk.KeyAgreementAlgorithm(*body.KeyAgreementAlgorithm),
+		KeyOrigin:             key.GetMetadata().Origin,
+	})
+}
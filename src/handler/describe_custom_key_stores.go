@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func (r *RequestHandler) DescribeCustomKeyStores() Response {
+
+	var body *kms.DescribeCustomKeyStoresInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.DescribeCustomKeyStoresInput{}
+	}
+
+	//---
+
+	var marker string
+	var limit int64 = 1000
+
+	if body.Marker != nil {
+		marker = *body.Marker
+	}
+	if body.Limit != nil {
+		limit = *body.Limit
+	}
+
+	//--------------------------------
+	// Validation
+
+	if limit < 1 || limit > 1000 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%d' at 'limit' failed to satisfy "+
+			"constraint: Minimum value of 1. Maximum value of 1000.", limit)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.CustomKeyStoreId != nil && body.CustomKeyStoreName != nil {
+		msg := "You cannot specify both CustomKeyStoreId and CustomKeyStoreName in the same request."
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//--------------------------------
+
+	stores, err := r.database.ListCustomKeyStores(r.arnPrefix())
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	response := &struct {
+		NextMarker      string `json:",omitempty"`
+		Truncated       bool
+		CustomKeyStores []*CustomKeyStoreOutput
+	}{
+		CustomKeyStores: []*CustomKeyStoreOutput{},
+	}
+
+	pastMarker := marker == ""
+
+	for _, store := range stores {
+
+		if body.CustomKeyStoreId != nil && store.CustomKeyStoreId != *body.CustomKeyStoreId {
+			continue
+		}
+
+		if body.CustomKeyStoreName != nil && store.CustomKeyStoreName != *body.CustomKeyStoreName {
+			continue
+		}
+
+		// If there's a marker, and we're not already past it, and the current item does not match the marker
+		if !pastMarker && store.CustomKeyStoreId != marker {
+			continue
+		}
+
+		pastMarker = true
+
+		// If there are more than the limit, return the 'next' ID as the NextMarker
+		if int64(len(response.CustomKeyStores)) == limit {
+			response.Truncated = true
+			response.NextMarker = store.CustomKeyStoreId
+			break
+		}
+
+		response.CustomKeyStores = append(response.CustomKeyStores, newCustomKeyStoreOutput(store))
+	}
+
+	if !pastMarker {
+		r.logger.Warnf("Invalid marker passed")
+		return New400ExceptionResponse("InvalidMarkerException", "")
+	}
+
+	// A specific store was requested, but not found
+	if len(response.CustomKeyStores) == 0 && (body.CustomKeyStoreId != nil || body.CustomKeyStoreName != nil) {
+		msg := "The specified custom key store does not exist."
+
+		r.logger.Warnf(msg)
+		return NewCustomKeyStoreNotFoundExceptionResponse(msg)
+	}
+
+	//---
+
+	r.logger.Infof("%d custom key stores described\n", len(response.CustomKeyStores))
+
+	return NewResponse(200, response)
+}
@@ -1,20 +1,17 @@
 package handler
 
 import (
-	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
-	"strings"
 )
 
 func (r *RequestHandler) DescribeKey() Response {
 
-	var body *kms.ScheduleKeyDeletionInput
+	var body *kms.DescribeKeyInput
 	err := r.decodeBodyInto(&body)
 
 	if err != nil {
-		body = &kms.ScheduleKeyDeletionInput{}
+		body = &kms.DescribeKeyInput{}
 	}
 
 	//--------------------------------
@@ -27,43 +24,24 @@ func (r *RequestHandler) DescribeKey() Response {
 		return NewMissingParameterResponse(msg)
 	}
 
-	var keyId = *body.KeyId
-
-	//---
-
-	// If it's an alias, map it to a key
-	if strings.Contains(keyId, "alias/") {
-		aliasArn := config.EnsureArn("", *body.KeyId)
-
-		alias, err := r.database.LoadAlias(aliasArn)
-
-		if err != nil {
-			msg := fmt.Sprintf("Alias '%s' does not exist", keyId)
-
-			r.logger.Warnf(msg)
-			return NewNotFoundExceptionResponse(msg)
-		}
-
-		keyId = alias.TargetKeyId
+	key, response := r.getKey(*body.KeyId)
+	if key == nil {
+		return response
 	}
 
-	//---
-
-	// Lookup the key
-	keyId = config.EnsureArn("key/", keyId)
-
-	key, err := r.database.LoadKey(keyId)
-
-	if key == nil {
-		msg := fmt.Sprintf("error loading key: %s", err.Error())
-		r.logger.Errorf(msg)
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
 
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:DescribeKey", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	response := map[string]*cmk.KeyMetadata{
+	output := map[string]*cmk.KeyMetadata{
 		"KeyMetadata": key.GetMetadata(),
 	}
 
@@ -71,5 +49,5 @@ func (r *RequestHandler) DescribeKey() Response {
 
 	r.logger.Infof("Key described: %s\n", key.GetArn())
 
-	return NewResponse(200, response)
+	return NewResponse(200, output)
 }
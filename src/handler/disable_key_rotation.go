@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 	"time"
 )
 
@@ -29,23 +28,31 @@ func (r *RequestHandler) DisableKeyRotation() Response {
 
 	//---
 
-	keyArn := config.EnsureArn("key/", *body.KeyId)
-
-	// Lookup the key
-	key, _ := r.database.LoadKey(keyArn)
-
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", keyArn)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:DisableKeyRotation", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
 	// Check the key supports rotation
-	if key.GetMetadata().Origin == cmk.KeyOriginExternal {
-		msg := fmt.Sprintf("%s origin is EXTERNAL which is not valid for this operation.", key.GetArn())
+	if origin := key.GetMetadata().Origin; origin == cmk.KeyOriginExternal || origin == cmk.KeyOriginAwsCloudHsm ||
+		origin == cmk.KeyOriginExternalKeyStore {
+		msg := fmt.Sprintf("%s origin is %s which is not valid for this operation.", key.GetArn(), key.GetMetadata().Origin)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	// Rotation is a shared property of multi-Region keys, so is only managed on the primary key
+	if c := key.GetMetadata().MultiRegionConfiguration; c != nil && c.MultiRegionKeyType != cmk.MultiRegionKeyTypePrimary {
+		msg := fmt.Sprintf("%s is a multi-Region replica key. Automatic key rotation can only be managed on the "+
+			"primary key %s.", key.GetArn(), c.PrimaryKey.Arn)
 
 		r.logger.Warnf(msg)
 		return NewUnsupportedOperationException(msg)
@@ -53,7 +60,7 @@ func (r *RequestHandler) DisableKeyRotation() Response {
 
 	if _, ok := key.(*cmk.AesKey); !ok {
 
-		r.logger.Warnf(fmt.Sprintf("Key '%s' does does not support rotation", keyArn))
+		r.logger.Warnf(fmt.Sprintf("Key '%s' does does not support rotation", key.GetArn()))
 
 		// I suspect that it's an error to return a 200, but it is what AWS currently do.
 		return NewResponse(200, nil)
@@ -64,22 +71,9 @@ func (r *RequestHandler) DisableKeyRotation() Response {
 
 	//---
 
-	if key.GetMetadata().DeletionDate != 0 {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is pending deletion.", keyArn)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
-	}
-
-	//---
-
-	if !key.GetMetadata().Enabled {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is disabled.", keyArn)
-
-		r.logger.Warnf(msg)
-		return NewDisabledExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
@@ -90,7 +84,7 @@ func (r *RequestHandler) DisableKeyRotation() Response {
 	//--------------------------------
 	// Save the key
 
-	err = r.database.SaveKey(key)
+	err = r.database.SaveMultiRegionKey(key)
 	if err != nil {
 		r.logger.Error(err)
 		return NewInternalFailureExceptionResponse(err.Error())
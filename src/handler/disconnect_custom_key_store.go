@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/data"
+)
+
+func (r *RequestHandler) DisconnectCustomKeyStore() Response {
+
+	var body *kms.DisconnectCustomKeyStoreInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.DisconnectCustomKeyStoreInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.CustomKeyStoreId == nil {
+		msg := "CustomKeyStoreId is a required parameter"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	//---
+
+	store, response := r.getCustomKeyStore(*body.CustomKeyStoreId)
+	if store == nil {
+		return response
+	}
+
+	// The store's keys are unavailable until it's reconnected
+	keys, response := r.customKeyStoreKeysInState(store, false)
+	if !response.Empty() {
+		return response
+	}
+
+	store.ConnectionState = data.ConnectionStateDisconnected
+	store.ConnectionErrorCode = ""
+
+	if response := r.saveCustomKeyStore(store, keys); !response.Empty() {
+		return response
+	}
+
+	r.logger.Infof("Custom key store %s is now %s\n", store.CustomKeyStoreId, store.ConnectionState)
+
+	return NewResponse(200, nil)
+}
@@ -1,11 +1,8 @@
 package handler
 
 import (
-	"fmt"
-
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 )
 
 func (r *RequestHandler) EnableKey() Response {
@@ -29,32 +26,26 @@ func (r *RequestHandler) EnableKey() Response {
 
 	//---
 
-	keyArn := config.EnsureArn("key/", *body.KeyId)
-
-	// Lookup the key
-	key, _ := r.database.LoadKey(keyArn)
-
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", keyArn)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:EnableKey", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	if key.GetMetadata().DeletionDate != 0 {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is pending deletion.", keyArn)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	key.GetMetadata().Enabled = true
-	key.GetMetadata().KeyState = cmk.KeyStateEnabled
+	cmk.TransitionKeyState(r.operation(), key.GetMetadata())
 
 	//--------------------------------
 	// Save the key
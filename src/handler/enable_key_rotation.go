@@ -2,19 +2,23 @@ package handler
 
 import (
 	"fmt"
-	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 	"time"
 )
 
+// Custom struct, as RotationPeriodInDays isn't supported by the AWS library version in use.
+type EnableKeyRotationInput struct {
+	KeyId                *string
+	RotationPeriodInDays *int64
+}
+
 func (r *RequestHandler) EnableKeyRotation() Response {
 
-	var body *kms.EnableKeyRotationInput
+	var body *EnableKeyRotationInput
 	err := r.decodeBodyInto(&body)
 
 	if err != nil {
-		body = &kms.EnableKeyRotationInput{}
+		body = &EnableKeyRotationInput{}
 	}
 
 	//--------------------------------
@@ -27,70 +31,84 @@ func (r *RequestHandler) EnableKeyRotation() Response {
 		return NewMissingParameterResponse(msg)
 	}
 
-	//---
+	if body.RotationPeriodInDays != nil &&
+		(*body.RotationPeriodInDays < cmk.MinRotationPeriodInDays || *body.RotationPeriodInDays > cmk.MaxRotationPeriodInDays) {
 
-	keyArn := config.EnsureArn("key/", *body.KeyId)
+		msg := fmt.Sprintf("1 validation error detected: Value '%d' at 'rotationPeriodInDays' failed to satisfy "+
+			"constraint: Member must have value between %d and %d", *body.RotationPeriodInDays,
+			cmk.MinRotationPeriodInDays, cmk.MaxRotationPeriodInDays)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
 
-	// Lookup the key
-	key, _ := r.database.LoadKey(keyArn)
+	//---
 
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", keyArn)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:EnableKeyRotation", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
 	// Check the key supports rotation
-	if key.GetMetadata().Origin == cmk.KeyOriginExternal {
-		msg := fmt.Sprintf("%s origin is EXTERNAL which is not valid for this operation.", key.GetArn())
+	if origin := key.GetMetadata().Origin; origin == cmk.KeyOriginExternal || origin == cmk.KeyOriginAwsCloudHsm ||
+		origin == cmk.KeyOriginExternalKeyStore {
+		msg := fmt.Sprintf("%s origin is %s which is not valid for this operation.", key.GetArn(), key.GetMetadata().Origin)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	// Rotation is a shared property of multi-Region keys, so is only managed on the primary key
+	if c := key.GetMetadata().MultiRegionConfiguration; c != nil && c.MultiRegionKeyType != cmk.MultiRegionKeyTypePrimary {
+		msg := fmt.Sprintf("%s is a multi-Region replica key. Automatic key rotation can only be managed on the "+
+			"primary key %s.", key.GetArn(), c.PrimaryKey.Arn)
 
 		r.logger.Warnf(msg)
 		return NewUnsupportedOperationException(msg)
 	}
 
 	if _, ok := key.(*cmk.AesKey); !ok {
-		r.logger.Warnf(fmt.Sprintf("Key '%s' does does not support rotation", keyArn))
+		r.logger.Warnf(fmt.Sprintf("Key '%s' does does not support rotation", key.GetArn()))
 
 		return NewUnsupportedOperationException("")
 	}
 
 	//---
 
-	if key.GetMetadata().DeletionDate != 0 {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is pending deletion.", keyArn)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	if !key.GetMetadata().Enabled {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is disabled.", keyArn)
+	aesKey := key.(*cmk.AesKey)
 
-		r.logger.Warnf(msg)
-		return NewDisabledExceptionResponse(msg)
+	period := cmk.DefaultRotationPeriodInDays
+	if body.RotationPeriodInDays != nil {
+		period = int(*body.RotationPeriodInDays)
 	}
 
-	//---
-
-	// If it's already enabled, don't reset it to another year. TODO - is this correct?
-	if key.(*cmk.AesKey).NextKeyRotation.IsZero() {
-		key.(*cmk.AesKey).NextKeyRotation = time.Now().AddDate(1, 0, 0)
+	if aesKey.NextKeyRotation.IsZero() {
+		aesKey.NextKeyRotation = time.Now().AddDate(0, 0, period)
+	} else if period != aesKey.GetRotationPeriodInDays() {
+		// If rotation is already enabled, a new period applies from the key's last rotation.
+		aesKey.NextKeyRotation = aesKey.LastRotationDate().AddDate(0, 0, period)
 	}
 
-	// To allow testing...
-	//key.NextKeyRotation = time.Now().Add( time.Second * 10 )
+	aesKey.RotationPeriodInDays = period
 
 	//--------------------------------
 	// Save the key
 
-	err = r.database.SaveKey(key)
+	err = r.database.SaveMultiRegionKey(key)
 	if err != nil {
 		r.logger.Error(err)
 		return NewInternalFailureExceptionResponse(err.Error())
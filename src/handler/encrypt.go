@@ -55,6 +55,41 @@ func (r *RequestHandler) Encrypt() Response {
 		return response
 	}
 
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:Encrypt", body.EncryptionContext)
+	if !response.Empty() {
+		return response
+	}
+
+	if key.GetMetadata().KeyUsage != cmk.UsageEncryptDecrypt {
+		msg := fmt.Sprintf("%s key usage is %s which is not valid for Encrypt.", key.GetArn(), key.GetMetadata().KeyUsage)
+		r.logger.Warnf(msg)
+		return NewInvalidKeyUsageException(msg)
+	}
+
+	if _, ok := key.(*cmk.Sm2Key); ok {
+		if cmk.EncryptionAlgorithm(*body.EncryptionAlgorithm) != cmk.EncryptionAlgorithmSm2Pke {
+			msg := fmt.Sprintf("Algorithm %s is incompatible with key spec %s.", *body.EncryptionAlgorithm, key.GetMetadata().KeySpec)
+			r.logger.Warnf(msg)
+			return NewInvalidKeyUsageException(msg)
+		}
+
+		if len(body.Plaintext) > 1024 {
+			msg := fmt.Sprintf("Plaintext is too long for encryption algorithm %s.", *body.EncryptionAlgorithm)
+			r.logger.Warnf(msg)
+			return NewValidationExceptionResponse(msg)
+		}
+	}
+
+	// Every check has passed; nothing is encrypted for a dry run.
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
 	//----------------------------------
 
 	var cipherResponse []byte
@@ -62,20 +97,21 @@ func (r *RequestHandler) Encrypt() Response {
 	switch k := key.(type) {
 	case *cmk.AesKey:
 
-		cipherResponse, err = k.EncryptAndPackage(body.Plaintext, body.EncryptionContext)
-		if err != nil {
-			r.logger.Error(err.Error())
-			return NewInternalFailureExceptionResponse(err.Error())
+		cipherResponse, response = r.encryptAndPackage(k, body.Plaintext, body.EncryptionContext)
+		if !response.Empty() {
+			return response
 		}
 
 	case *cmk.RsaKey:
 
-		if k.GetMetadata().KeyUsage != cmk.UsageEncryptDecrypt {
-			msg := fmt.Sprintf("%s key usage is %s which is not valid for Encrypt.", k.GetArn(), k.GetMetadata().KeyUsage)
-			r.logger.Warnf(msg)
-			return NewInvalidKeyUsageException(msg)
+		cipherResponse, err = k.Encrypt(body.Plaintext, cmk.EncryptionAlgorithm(*body.EncryptionAlgorithm))
+		if err != nil {
+			r.logger.Error(err.Error())
+			return NewInternalFailureExceptionResponse(err.Error())
 		}
 
+	case *cmk.Sm2Key:
+
 		cipherResponse, err = k.Encrypt(body.Plaintext, cmk.EncryptionAlgorithm(*body.EncryptionAlgorithm))
 		if err != nil {
 			r.logger.Error(err.Error())
@@ -84,12 +120,6 @@ func (r *RequestHandler) Encrypt() Response {
 
 	default:
 
-		if k.GetMetadata().KeyUsage == cmk.UsageSignVerify {
-			msg := fmt.Sprintf("%s key usage is SIGN_VERIFY which is not valid for Encrypt.", k.GetArn())
-			r.logger.Warnf(msg)
-			return NewInvalidKeyUsageException(msg)
-		}
-
 		return NewInternalFailureExceptionResponse("key type not yet supported for encryption")
 	}
 
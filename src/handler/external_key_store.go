@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/gofrs/uuid"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/xks"
+)
+
+var xksProxyUriPathPattern = regexp.MustCompile(`^(/[a-zA-Z0-9/_-]+)?/kms/xks/v\d{1,2}$`)
+
+/*
+Validates an external key store's proxy configuration, and authentication credential.
+*/
+func (r *RequestHandler) validateXksProxyConfiguration(c *data.XksProxyConfiguration, secret string, vpcEndpointServiceName *string) Response {
+
+	switch c.Connectivity {
+	case "PUBLIC_ENDPOINT":
+		// nop
+	case "VPC_ENDPOINT_SERVICE":
+		msg := "Local KMS does not support XKS proxy connectivity VPC_ENDPOINT_SERVICE."
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	default:
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'xksProxyConnectivity' failed to satisfy "+
+			"constraint: Member must satisfy enum value set: [PUBLIC_ENDPOINT, VPC_ENDPOINT_SERVICE]", c.Connectivity)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if vpcEndpointServiceName != nil {
+		msg := "XksProxyVpcEndpointServiceName is only valid with XKS proxy connectivity VPC_ENDPOINT_SERVICE."
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	// Unlike AWS, http endpoints are allowed; so proxies running locally don't need TLS.
+	endpoint, err := url.Parse(c.UriEndpoint)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" ||
+		(endpoint.Path != "" && endpoint.Path != "/") || endpoint.RawQuery != "" {
+
+		msg := fmt.Sprintf("XksProxyUriEndpoint '%s' is invalid. It must be the scheme and host of the XKS proxy, "+
+			"for example https://xks.example.com", c.UriEndpoint)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if !xksProxyUriPathPattern.MatchString(c.UriPath) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'xksProxyUriPath' failed to satisfy "+
+			"constraint: Member must satisfy regular expression pattern: %s", c.UriPath, xksProxyUriPathPattern)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(c.AccessKeyId) < 20 || len(c.AccessKeyId) > 30 {
+		msg := "1 validation error detected: Value at 'xksProxyAuthenticationCredential.accessKeyId' failed to " +
+			"satisfy constraint: Member must have length between 20 and 30"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(secret) < 43 || len(secret) > 64 {
+		msg := "1 validation error detected: Value at 'xksProxyAuthenticationCredential.rawSecretAccessKey' failed " +
+			"to satisfy constraint: Member must have length between 43 and 64"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	return Response{}
+}
+
+func (r *RequestHandler) xksClient(store *data.CustomKeyStore) *xks.Client {
+	c := store.XksProxyConfiguration
+	return xks.NewClient(c.UriEndpoint, c.UriPath, c.AccessKeyId, store.XksProxyRawSecretAccessKey, r.region)
+}
+
+func (r *RequestHandler) xksRequestMetadata(keyArn string) xks.RequestMetadata {
+	return xks.RequestMetadata{
+		AwsPrincipalArn: r.principal,
+		KmsKeyArn:       keyArn,
+		KmsOperation:    r.operation(),
+		KmsRequestId:    uuid.Must(uuid.NewV4()).String(),
+	}
+}
+
+/*
+Returns the exception for a failed request to an XKS proxy.
+*/
+func (r *RequestHandler) xksErrorResponse(err error) Response {
+	if e, ok := err.(*xks.Error); ok {
+		r.logger.Warnf(e.Error())
+		return New400ExceptionResponse(e.Type, e.Message)
+	}
+
+	r.logger.Error(err)
+	return NewInternalFailureExceptionResponse(err.Error())
+}
+
+/*
+Checks the health of the store's XKS proxy. If it's unhealthy, the reason is returned as a connection error code.
+*/
+func (r *RequestHandler) connectXksProxy(store *data.CustomKeyStore) (data.ConnectionErrorCode, error) {
+
+	_, err := r.xksClient(store).Health(r.xksRequestMetadata(""))
+	if err == nil {
+		return "", nil
+	}
+
+	r.logger.Warnf("XKS proxy for custom key store %s is unhealthy: %s", store.CustomKeyStoreId, err)
+
+	e, ok := err.(*xks.Error)
+	if !ok {
+		return data.ConnectionErrorCodeInternalError, err
+	}
+
+	switch e.Type {
+	case "XksProxyIncorrectAuthenticationCredentialException":
+		return data.ConnectionErrorCodeXksProxyAccessDenied, err
+	case "XksProxyInvalidConfigurationException":
+		return data.ConnectionErrorCodeXksProxyInvalidConfiguration, err
+	case "XksProxyUriUnreachableException":
+		return data.ConnectionErrorCodeXksProxyNotReachable, err
+	case "DependencyTimeoutException":
+		return data.ConnectionErrorCodeXksProxyTimedOut, err
+	default:
+		return data.ConnectionErrorCodeXksProxyInvalidResponse, err
+	}
+}
+
+/*
+Confirms the external key exists, is usable as an XKS key, and isn't already backing another key in the store.
+*/
+func (r *RequestHandler) validateXksKey(store *data.CustomKeyStore, xksKeyId *string) Response {
+
+	if xksKeyId == nil {
+		msg := "XksKeyId is a required parameter when Origin is EXTERNAL_KEY_STORE"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if len(*xksKeyId) < 1 || len(*xksKeyId) > 128 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'xksKeyId' failed to satisfy "+
+			"constraint: Member must have length between 1 and 128", *xksKeyId)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	keys, err := r.database.ListKeysInCustomKeyStore(r.arnPrefix()+"key/", store.CustomKeyStoreId)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	for _, key := range keys {
+		if c := key.GetMetadata().XksKeyConfiguration; c != nil && c.Id == *xksKeyId {
+			msg := fmt.Sprintf("External key %s is already associated with %s.", *xksKeyId, key.GetArn())
+
+			r.logger.Warnf(msg)
+			return NewXksKeyAlreadyInUseExceptionResponse(msg)
+		}
+	}
+
+	//---
+
+	metadata, err := r.xksClient(store).GetKeyMetadata(*xksKeyId, r.xksRequestMetadata(""))
+	if err != nil {
+		return r.xksErrorResponse(err)
+	}
+
+	usages := map[string]bool{}
+	for _, usage := range metadata.KeyUsage {
+		usages[usage] = true
+	}
+
+	if metadata.KeySpec != xks.KeySpecAes256 || !usages["ENCRYPT"] || !usages["DECRYPT"] {
+		msg := fmt.Sprintf("External key %s must be an AES_256 key, with ENCRYPT and DECRYPT key usage.", *xksKeyId)
+
+		r.logger.Warnf(msg)
+		return NewXksKeyInvalidConfigurationExceptionResponse(msg)
+	}
+
+	if metadata.KeyStatus != "ENABLED" {
+		msg := fmt.Sprintf("External key %s is %s. It must be ENABLED.", *xksKeyId, metadata.KeyStatus)
+
+		r.logger.Warnf(msg)
+		return NewXksKeyInvalidConfigurationExceptionResponse(msg)
+	}
+
+	return Response{}
+}
+
+//---
+
+/*
+The additional authenticated data sent to the proxy, binding the external ciphertext to the encryption context.
+*/
+func xksAdditionalAuthenticatedData(context map[string]*string) []byte {
+	if len(context) == 0 {
+		return nil
+	}
+
+	// Map keys are sorted when marshalled, so the result is consistent
+	encoded, _ := json.Marshal(context)
+	hash := sha256.Sum256(encoded)
+
+	return hash[:]
+}
+
+/*
+Encrypts the plaintext under the key's external key, via its store's XKS proxy.
+*/
+func (r *RequestHandler) xksEncrypt(key *cmk.AesKey, plaintext []byte, context map[string]*string) ([]byte, Response) {
+
+	store, response := r.getCustomKeyStore(key.GetMetadata().CustomKeyStoreId)
+	if store == nil {
+		return nil, response
+	}
+
+	ciphertext, err := r.xksClient(store).Encrypt(key.GetMetadata().XksKeyConfiguration.Id, plaintext,
+		xksAdditionalAuthenticatedData(context), r.xksRequestMetadata(key.GetArn()))
+
+	if err != nil {
+		return nil, r.xksErrorResponse(err)
+	}
+
+	encoded, err := json.Marshal(ciphertext)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	return encoded, Response{}
+}
+
+/*
+Decrypts ciphertext produced by xksEncrypt, via the key's store's XKS proxy.
+*/
+func (r *RequestHandler) xksDecrypt(key *cmk.AesKey, encoded []byte, context map[string]*string) ([]byte, Response) {
+
+	var ciphertext xks.Ciphertext
+	if err := json.Unmarshal(encoded, &ciphertext); err != nil {
+		r.logger.Warnf(fmt.Sprintf("Unable to decode external ciphertext: %s", err))
+		return nil, NewInvalidCiphertextExceptionResponse("")
+	}
+
+	store, response := r.getCustomKeyStore(key.GetMetadata().CustomKeyStoreId)
+	if store == nil {
+		return nil, response
+	}
+
+	plaintext, err := r.xksClient(store).Decrypt(key.GetMetadata().XksKeyConfiguration.Id, &ciphertext,
+		xksAdditionalAuthenticatedData(context), r.xksRequestMetadata(key.GetArn()))
+
+	if err != nil {
+		return nil, r.xksErrorResponse(err)
+	}
+
+	return plaintext, Response{}
+}
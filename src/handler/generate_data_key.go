@@ -1,23 +1,32 @@
 package handler
 
 import (
+	"crypto/rsa"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
 	"github.com/nsmithuk/local-kms/src/service"
 )
 
 type GenerateDataKeyResponse struct {
-	KeyId          string
-	Plaintext      []byte `json:",omitempty"`
-	CiphertextBlob []byte
+	KeyId                  string
+	Plaintext              []byte `json:",omitempty"`
+	CiphertextBlob         []byte
+	CiphertextForRecipient []byte `json:",omitempty"`
+}
+
+// The AWS library version in use doesn't support Recipient.
+type GenerateDataKeyInput struct {
+	kms.GenerateDataKeyInput
+	Recipient *RecipientInfo
 }
 
 //----------------------------------
 
 func (r *RequestHandler) GenerateDataKey() Response {
 
-	errResponse, keyResponse := r.generateDataKey()
+	errResponse, keyResponse := r.generateDataKey(data.GrantOperationGenerateDataKey)
 
 	if !errResponse.Empty() {
 		return errResponse
@@ -33,13 +42,13 @@ func (r *RequestHandler) GenerateDataKey() Response {
 //------------------------------------------------------------------------------------------
 // Generate code shared between GenerateDataKey() and GenerateDataKeyWithoutPlaintext()
 
-func (r *RequestHandler) generateDataKey() (Response, *GenerateDataKeyResponse) {
+func (r *RequestHandler) generateDataKey(operation data.GrantOperation) (Response, *GenerateDataKeyResponse) {
 
-	var body *kms.GenerateDataKeyInput
+	var body *GenerateDataKeyInput
 	err := r.decodeBodyInto(&body)
 
 	if err != nil {
-		body = &kms.GenerateDataKeyInput{}
+		body = &GenerateDataKeyInput{}
 	}
 
 	var bytesRequired uint16
@@ -96,6 +105,16 @@ func (r *RequestHandler) generateDataKey() (Response, *GenerateDataKeyResponse)
 		bytesRequired = uint16(*body.NumberOfBytes)
 	}
 
+	// Only GenerateDataKey supports a recipient; GenerateDataKeyWithoutPlaintext has no such parameter
+	var recipientKey *rsa.PublicKey
+	if body.Recipient != nil && operation == data.GrantOperationGenerateDataKey {
+		var response Response
+		recipientKey, response = r.recipientPublicKey(body.Recipient)
+		if !response.Empty() {
+			return response, nil
+		}
+	}
+
 	//----------------------------------
 
 	key, response := r.getUsableKey(*body.KeyId)
@@ -105,25 +124,20 @@ func (r *RequestHandler) generateDataKey() (Response, *GenerateDataKeyResponse)
 		return response, nil
 	}
 
-	//----------------------------------
-
-	plaintext := service.GenerateRandomData(bytesRequired)
-
-	var cipherResponse []byte
-
-	switch k := key.(type) {
-	case *cmk.AesKey:
-
-		cipherResponse, err = k.EncryptAndPackage(plaintext, body.EncryptionContext)
-		if err != nil {
-			r.logger.Error(err.Error())
-			return NewInternalFailureExceptionResponse(err.Error()), nil
-		}
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response, nil
+	}
 
-	default:
+	response = r.checkKeyPolicy(key, "kms:"+string(operation), body.EncryptionContext)
+	if !response.Empty() {
+		return response, nil
+	}
 
-		if k.GetMetadata().KeyUsage == cmk.UsageSignVerify {
-			msg := fmt.Sprintf("%s key usage is SIGN_VERIFY which is not valid for GenerateDataKey.", k.GetArn())
+	aesKey, ok := key.(*cmk.AesKey)
+	if !ok {
+		if key.GetMetadata().KeyUsage != cmk.UsageEncryptDecrypt {
+			msg := fmt.Sprintf("%s key usage is %s which is not valid for GenerateDataKey.", key.GetArn(), key.GetMetadata().KeyUsage)
 
 			r.logger.Warnf(msg)
 			return NewInvalidKeyUsageException(msg), nil
@@ -132,9 +146,34 @@ func (r *RequestHandler) generateDataKey() (Response, *GenerateDataKeyResponse)
 		return NewInternalFailureExceptionResponse("key type not yet supported for encryption"), nil
 	}
 
-	return Response{}, &GenerateDataKeyResponse{
+	// Every check has passed; no data key is generated for a dry run.
+	if response := r.checkDryRun(); !response.Empty() {
+		return response, nil
+	}
+
+	//----------------------------------
+
+	plaintext := service.GenerateRandomData(bytesRequired)
+
+	cipherResponse, response := r.encryptAndPackage(aesKey, plaintext, body.EncryptionContext)
+	if !response.Empty() {
+		return response, nil
+	}
+
+	output := &GenerateDataKeyResponse{
 		KeyId:          key.GetArn(),
-		Plaintext:      plaintext,
 		CiphertextBlob: cipherResponse,
 	}
+
+	// With a recipient, the plaintext is only returned encrypted to the recipient's public key
+	if recipientKey != nil {
+		output.CiphertextForRecipient, response = r.ciphertextForRecipient(plaintext, recipientKey)
+		if !response.Empty() {
+			return response, nil
+		}
+	} else {
+		output.Plaintext = plaintext
+	}
+
+	return Response{}, output
 }
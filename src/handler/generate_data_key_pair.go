@@ -10,7 +10,9 @@ import (
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
 	"github.com/nsmithuk/local-kms/src/x509"
+	"github.com/tjfoc/gmsm/sm2"
 )
 
 type GenerateDataKeyPairResponse struct {
@@ -22,7 +24,7 @@ type GenerateDataKeyPairResponse struct {
 }
 
 func (r *RequestHandler) GenerateDataKeyPair() Response {
-	errResponse, keyResponse := r.generateDataKeyPair()
+	errResponse, keyResponse := r.generateDataKeyPair(data.GrantOperationGenerateDataKeyPair)
 
 	if !errResponse.Empty() {
 		return errResponse
@@ -35,7 +37,7 @@ func (r *RequestHandler) GenerateDataKeyPair() Response {
 	return NewResponse(200, keyResponse)
 }
 
-func (r *RequestHandler) generateDataKeyPair() (Response, *GenerateDataKeyPairResponse) {
+func (r *RequestHandler) generateDataKeyPair(operation data.GrantOperation) (Response, *GenerateDataKeyPairResponse) {
 
 	var body *kms.GenerateDataKeyPairInput
 	err := r.decodeBodyInto(&body)
@@ -61,6 +63,18 @@ func (r *RequestHandler) generateDataKeyPair() (Response, *GenerateDataKeyPairRe
 		return NewValidationExceptionResponse(msg), nil
 	}
 
+	keyPairSpec := cmk.KeySpec(*body.KeyPairSpec)
+
+	switch keyPairSpec {
+	case cmk.SpecEccNistP256, cmk.SpecEccNistP384, cmk.SpecEccNistP521, cmk.SpecEccSecp256k1, cmk.SpecSm2,
+		cmk.SpecRsa2048, cmk.SpecRsa3072, cmk.SpecRsa4096:
+		// nop
+	default:
+		msg := "1 validation error detected: KeyPairSpec is invalid."
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg), nil
+	}
+
 	//----------------------------------
 
 	key, response := r.getUsableKey(*body.KeyId)
@@ -70,9 +84,35 @@ func (r *RequestHandler) generateDataKeyPair() (Response, *GenerateDataKeyPairRe
 		return response, nil
 	}
 
-	//----------------------------------
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response, nil
+	}
 
-	keyPairSpec := cmk.KeySpec(*body.KeyPairSpec)
+	response = r.checkKeyPolicy(key, "kms:"+string(operation), body.EncryptionContext)
+	if !response.Empty() {
+		return response, nil
+	}
+
+	aesKey, ok := key.(*cmk.AesKey)
+	if !ok {
+		if key.GetMetadata().KeyUsage != cmk.UsageEncryptDecrypt {
+			msg := fmt.Sprintf("%s key usage is %s which is not valid for GenerateDataKeyPair.", key.GetArn(), key.GetMetadata().KeyUsage)
+			r.logger.Warnf(msg)
+			return NewInvalidKeyUsageException(msg), nil
+		}
+
+		msg := fmt.Sprintf("%s key KeySpec is %s which is not valid for GenerateDataKeyPair.", key.GetArn(), key.GetMetadata().CustomerMasterKeySpec)
+		r.logger.Warnf(msg)
+		return NewInvalidKeyUsageException(msg), nil
+	}
+
+	// Every check has passed; no key pair is generated for a dry run.
+	if response := r.checkDryRun(); !response.Empty() {
+		return response, nil
+	}
+
+	//----------------------------------
 
 	var publicKey interface{}
 	var privateKey interface{}
@@ -107,6 +147,22 @@ func (r *RequestHandler) generateDataKeyPair() (Response, *GenerateDataKeyPairRe
 		privateKey = k
 		publicKey = &k.PublicKey
 
+	case cmk.SpecSm2:
+
+		k, err := sm2.GenerateKey(rand.Reader)
+		if err != nil {
+			return NewInternalFailureExceptionResponse(err.Error()), nil
+		}
+
+		// Marshalled as an EC key, identified by the SM2 curve's OID
+		ecKey := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: k.Curve, X: k.X, Y: k.Y},
+			D:         k.D,
+		}
+
+		privateKey = ecKey
+		publicKey = &ecKey.PublicKey
+
 	case cmk.SpecRsa2048:
 		fallthrough
 	case cmk.SpecRsa3072:
@@ -132,9 +188,7 @@ func (r *RequestHandler) generateDataKeyPair() (Response, *GenerateDataKeyPairRe
 		publicKey = &k.PublicKey
 
 	default:
-		msg := "1 validation error detected: KeyPairSpec is invalid."
-		r.logger.Warnf(msg)
-		return NewValidationExceptionResponse(msg), nil
+		return NewInternalFailureExceptionResponse("key pair spec not yet supported"), nil
 	}
 
 	public, err := x509.MarshalPKIXPublicKey(publicKey)
@@ -149,32 +203,11 @@ func (r *RequestHandler) generateDataKeyPair() (Response, *GenerateDataKeyPairRe
 
 	//---
 
-	var cipherResponse []byte
-
-	switch k := key.(type) {
-	case *cmk.AesKey:
-
-		cipherResponse, err = k.EncryptAndPackage(private, body.EncryptionContext)
-		if err != nil {
-			r.logger.Error(err.Error())
-			return NewInternalFailureExceptionResponse(err.Error()), nil
-		}
-
-	default:
-
-		if k.GetMetadata().KeyUsage == cmk.UsageSignVerify {
-			msg := fmt.Sprintf("%s key usage is %s which is not valid for GenerateDataKeyPair.", k.GetArn(), k.GetMetadata().KeyUsage)
-			r.logger.Warnf(msg)
-			return NewInvalidKeyUsageException(msg), nil
-		}
-
-		msg := fmt.Sprintf("%s key KeySpec is %s which is not valid for GenerateDataKeyPair.", k.GetArn(), k.GetMetadata().CustomerMasterKeySpec)
-		r.logger.Warnf(msg)
-		return NewInvalidKeyUsageException(msg), nil
+	cipherResponse, response := r.encryptAndPackage(aesKey, private, body.EncryptionContext)
+	if !response.Empty() {
+		return response, nil
 	}
 
-	//---
-
 	return Response{}, &GenerateDataKeyPairResponse{
 		KeyId:                    key.GetArn(),
 		KeyPairSpec:              string(keyPairSpec),
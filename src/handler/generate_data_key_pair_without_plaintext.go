@@ -1,7 +1,9 @@
 package handler
 
+import "github.com/nsmithuk/local-kms/src/data"
+
 func (r *RequestHandler) GenerateDataKeyPairWithoutPlaintext() Response {
-	errResponse, keyResponse := r.generateDataKeyPair()
+	errResponse, keyResponse := r.generateDataKeyPair(data.GrantOperationGenerateDataKeyPairWithoutPlaintext)
 
 	if !errResponse.Empty() {
 		return errResponse
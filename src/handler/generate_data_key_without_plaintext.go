@@ -1,7 +1,9 @@
 package handler
 
+import "github.com/nsmithuk/local-kms/src/data"
+
 func (r *RequestHandler) GenerateDataKeyWithoutPlaintext() Response {
-	errResponse, keyResponse := r.generateDataKey()
+	errResponse, keyResponse := r.generateDataKey(data.GrantOperationGenerateDataKeyWithoutPlaintext)
 
 	if !errResponse.Empty() {
 		return errResponse
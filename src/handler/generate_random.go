@@ -1,18 +1,25 @@
 package handler
 
 import (
+	"crypto/rsa"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/service"
 )
 
+// The AWS library version in use doesn't support Recipient.
+type GenerateRandomInput struct {
+	kms.GenerateRandomInput
+	Recipient *RecipientInfo
+}
+
 func (r *RequestHandler) GenerateRandom() Response {
 
-	var body *kms.GenerateRandomInput
+	var body *GenerateRandomInput
 	err := r.decodeBodyInto(&body)
 
 	if err != nil {
-		body = &kms.GenerateRandomInput{}
+		body = &GenerateRandomInput{}
 	}
 
 	//--------------------------------
@@ -41,18 +48,39 @@ func (r *RequestHandler) GenerateRandom() Response {
 		return NewValidationExceptionResponse(msg)
 	}
 
+	var recipientKey *rsa.PublicKey
+	if body.Recipient != nil {
+		var response Response
+		recipientKey, response = r.recipientPublicKey(body.Recipient)
+		if !response.Empty() {
+			return response
+		}
+	}
+
 	//--------------------------------
 
 	data := service.GenerateRandomData(uint16(*body.NumberOfBytes))
 
+	output := &struct {
+		Plaintext              []byte `json:",omitempty"`
+		CiphertextForRecipient []byte `json:",omitempty"`
+	}{}
+
+	// With a recipient, the random data is only returned encrypted to the recipient's public key
+	if recipientKey != nil {
+		var response Response
+		output.CiphertextForRecipient, response = r.ciphertextForRecipient(data, recipientKey)
+		if !response.Empty() {
+			return response
+		}
+	} else {
+		output.Plaintext = data
+	}
+
 	//---
 
 	r.logger.Infof("Random data generated: %d bytes\n", len(data))
 
-	return NewResponse(200, &struct {
-		Plaintext []byte
-	}{
-		Plaintext: data,
-	})
+	return NewResponse(200, output)
 
 }
@@ -1,9 +1,7 @@
 package handler
 
 import (
-	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/config"
 )
 
 func (r *RequestHandler) GetKeyPolicy() Response {
@@ -34,16 +32,14 @@ func (r *RequestHandler) GetKeyPolicy() Response {
 
 	//---
 
-	keyArn := config.EnsureArn("key/", *body.KeyId)
-
-	// Lookup the key
-	key, _ := r.database.LoadKey(keyArn)
-
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", keyArn)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:GetKeyPolicy", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 )
 
 func (r *RequestHandler) GetKeyRotationStatus() Response {
@@ -28,30 +27,29 @@ func (r *RequestHandler) GetKeyRotationStatus() Response {
 
 	//---
 
-	keyArn := config.EnsureArn("key/", *body.KeyId)
-
-	// Lookup the key
-	key, _ := r.database.LoadKey(keyArn)
-
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", keyArn)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:GetKeyRotationStatus", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
 	// Check the key supports rotation
-	if key.GetMetadata().Origin == cmk.KeyOriginExternal {
-		msg := fmt.Sprintf("%s origin is EXTERNAL which is not valid for this operation.", key.GetArn())
+	if origin := key.GetMetadata().Origin; origin == cmk.KeyOriginExternal || origin == cmk.KeyOriginAwsCloudHsm ||
+		origin == cmk.KeyOriginExternalKeyStore {
+		msg := fmt.Sprintf("%s origin is %s which is not valid for this operation.", key.GetArn(), key.GetMetadata().Origin)
 
 		r.logger.Warnf(msg)
 		return NewUnsupportedOperationException(msg)
 	}
 
 	if _, ok := key.(*cmk.AesKey); !ok {
-		r.logger.Warnf(fmt.Sprintf("Key '%s' does does not support rotation", keyArn))
+		r.logger.Warnf(fmt.Sprintf("Key '%s' does does not support rotation", key.GetArn()))
 
 		// Hard code false for non-AES CMKs.
 		return NewResponse(200, map[string]bool{
@@ -61,9 +59,26 @@ func (r *RequestHandler) GetKeyRotationStatus() Response {
 
 	//---
 
+	aesKey := key.(*cmk.AesKey)
+
+	// OnDemandRotationStartDate is only returned while an on-demand rotation is in progress. Local KMS completes them
+	// within RotateKeyOnDemand, so it's always omitted. This is listed in the README's known differences.
+	output := &struct {
+		KeyId                string
+		KeyRotationEnabled   bool
+		RotationPeriodInDays int   `json:",omitempty"`
+		NextRotationDate     int64 `json:",omitempty"`
+	}{
+		KeyId:              key.GetArn(),
+		KeyRotationEnabled: !aesKey.NextKeyRotation.IsZero(),
+	}
+
+	if output.KeyRotationEnabled {
+		output.RotationPeriodInDays = aesKey.GetRotationPeriodInDays()
+		output.NextRotationDate = aesKey.NextKeyRotation.Unix()
+	}
+
 	r.logger.Infof("Key rotation status returned: %s\n", key.GetArn())
 
-	return NewResponse(200, map[string]bool{
-		"KeyRotationEnabled": !key.(*cmk.AesKey).NextKeyRotation.IsZero(),
-	})
+	return NewResponse(200, output)
 }
@@ -6,7 +6,6 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/kms"
@@ -48,11 +47,11 @@ func (r *RequestHandler) GetParametersForImport() Response {
 
 	var wrappingAlgorithm cmk.WrappingAlgorithm
 	switch *body.WrappingAlgorithm {
-	case "RSAES_PKCS1_V1_5", "RSAES_OAEP_SHA_1", "RSAES_OAEP_SHA_256":
+	case "RSAES_PKCS1_V1_5", "RSAES_OAEP_SHA_1", "RSAES_OAEP_SHA_256", "RSA_AES_KEY_WRAP_SHA_1", "RSA_AES_KEY_WRAP_SHA_256":
 		wrappingAlgorithm = cmk.WrappingAlgorithm(*body.WrappingAlgorithm)
 
 	default:
-		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'wrappingAlgorithm' failed to satisfy constraint: Member must satisfy enum value set: [RSAES_OAEP_SHA_1, RSAES_OAEP_SHA_256, RSAES_PKCS1_V1_5]", *body.WrappingAlgorithm)
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'wrappingAlgorithm' failed to satisfy constraint: Member must satisfy enum value set: [RSAES_OAEP_SHA_1, RSAES_OAEP_SHA_256, RSAES_PKCS1_V1_5, RSA_AES_KEY_WRAP_SHA_1, RSA_AES_KEY_WRAP_SHA_256]", *body.WrappingAlgorithm)
 
 		r.logger.Warnf(msg)
 		return NewValidationExceptionResponse(msg)
@@ -65,10 +64,17 @@ func (r *RequestHandler) GetParametersForImport() Response {
 		return NewMissingParameterResponse(msg)
 	}
 
-	// If wrapping key ever starts accepting additional values then we'll need to adjust this
-	var bits = 2048
-	if strings.Compare(*body.WrappingKeySpec, "RSA_2048") != 0 {
-		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'wrappingKeySpec' failed to satisfy constraint: Member must satisfy enum value set: [RSA_2048]", *body.WrappingKeySpec)
+	var bits int
+	switch *body.WrappingKeySpec {
+	case "RSA_2048":
+		bits = 2048
+	case "RSA_3072":
+		bits = 3072
+	case "RSA_4096":
+		bits = 4096
+
+	default:
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'wrappingKeySpec' failed to satisfy constraint: Member must satisfy enum value set: [RSA_2048, RSA_3072, RSA_4096]", *body.WrappingKeySpec)
 
 		r.logger.Warnf(msg)
 		return NewValidationExceptionResponse(msg)
@@ -88,6 +94,11 @@ func (r *RequestHandler) GetParametersForImport() Response {
 		return NewNotFoundExceptionResponse(msg)
 	}
 
+	response = r.checkKeyPolicy(key, "kms:GetParametersForImport", nil)
+	if !response.Empty() {
+		return response
+	}
+
 	keyMetadata := key.GetMetadata()
 	if keyMetadata.Origin != "EXTERNAL" {
 		msg := fmt.Sprintf("%s origin is %s which is not valid for this operation.", key.GetArn(), keyMetadata.Origin)
@@ -96,18 +107,25 @@ func (r *RequestHandler) GetParametersForImport() Response {
 		return NewUnsupportedOperationException(msg)
 	}
 
-	switch keyMetadata.KeyState {
-	case cmk.KeyStatePendingDeletion:
-		msg := fmt.Sprintf("%s is pending deletion.", *body.KeyId)
+	importableKey, ok := key.(cmk.ImportableKey)
+	if !ok {
+		msg := fmt.Sprintf("%s key spec is %s which is not valid for this operation.", key.GetArn(), keyMetadata.KeySpec)
 
 		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+		return NewUnsupportedOperationException(msg)
+	}
 
-	case cmk.KeyStateUnavailable:
-		msg := fmt.Sprintf("%s is unavailable.", *body.KeyId)
+	// PKCS #1 v1.5 is only supported when importing symmetric key material
+	if wrappingAlgorithm == cmk.WrappingAlgorithmPkcs1V15 && keyMetadata.KeySpec != cmk.SpecSymmetricDefault {
+		msg := fmt.Sprintf("Wrapping algorithm %s is not supported for key spec %s.", wrappingAlgorithm, keyMetadata.KeySpec)
 
 		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	// Create and save the parameters for key material import
@@ -137,8 +155,7 @@ func (r *RequestHandler) GetParametersForImport() Response {
 		WrappingAlgorithm: wrappingAlgorithm,
 	}
 
-	// Note - this is guaranteed to be an AesKey by virtue of the `EXTERNAL` origin
-	key.(*cmk.AesKey).SetParametersForImport(params)
+	importableKey.SetParametersForImport(params)
 
 	//--------------------------------
 	// Save the key
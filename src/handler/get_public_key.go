@@ -36,6 +36,16 @@ func (r *RequestHandler) GetPublicKey() Response {
 		return response
 	}
 
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:GetPublicKey", nil)
+	if !response.Empty() {
+		return response
+	}
+
 	//---
 
 	var publicKey []byte
@@ -57,6 +67,20 @@ func (r *RequestHandler) GetPublicKey() Response {
 			return NewInternalFailureExceptionResponse(err.Error())
 		}
 
+	case *cmk.Sm2Key:
+
+		publicKey, err = x509.MarshalPKIXPublicKey(k.GetEcdsaPublicKey())
+		if err != nil {
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+	case *cmk.Ed25519Key:
+
+		publicKey, err = x509.MarshalPKIXPublicKey(k.PrivateKey.Public())
+		if err != nil {
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
 	default:
 		r.logger.Warnf(fmt.Sprintf("Key '%s' does does not support returning a public key", key.GetArn()))
 		return NewUnsupportedOperationException("")
@@ -65,20 +89,22 @@ func (r *RequestHandler) GetPublicKey() Response {
 	//---
 
 	return NewResponse(200, &struct {
-		KeyId                 string
-		CustomerMasterKeySpec cmk.KeySpec
-		KeySpec               cmk.KeySpec
-		//EncryptionAlgorithms	[]cmk.EncryptionAlgorithm
-		SigningAlgorithms []cmk.SigningAlgorithm
-		KeyUsage          cmk.KeyUsage
-		PublicKey         []byte
+		KeyId                  string
+		CustomerMasterKeySpec  cmk.KeySpec
+		KeySpec                cmk.KeySpec
+		EncryptionAlgorithms   []cmk.EncryptionAlgorithm   `json:",omitempty"`
+		SigningAlgorithms      []cmk.SigningAlgorithm      `json:",omitempty"`
+		KeyAgreementAlgorithms []cmk.KeyAgreementAlgorithm `json:",omitempty"`
+		KeyUsage               cmk.KeyUsage
+		PublicKey              []byte
 	}{
-		KeyId:                 key.GetArn(),
-		CustomerMasterKeySpec: key.GetMetadata().CustomerMasterKeySpec,
-		KeySpec:               key.GetMetadata().KeySpec,
-		//EncryptionAlgorithms: key.GetMetadata().EncryptionAlgorithms,
-		SigningAlgorithms: key.GetMetadata().SigningAlgorithms,
-		KeyUsage:          key.GetMetadata().KeyUsage,
-		PublicKey:         publicKey,
+		KeyId:                  key.GetArn(),
+		CustomerMasterKeySpec:  key.GetMetadata().CustomerMasterKeySpec,
+		KeySpec:                key.GetMetadata().KeySpec,
+		EncryptionAlgorithms:   key.GetMetadata().EncryptionAlgorithms,
+		SigningAlgorithms:      key.GetMetadata().SigningAlgorithms,
+		KeyAgreementAlgorithms: key.GetMetadata().KeyAgreementAlgorithms,
+		KeyUsage:               key.GetMetadata().KeyUsage,
+		PublicKey:              publicKey,
 	})
 }
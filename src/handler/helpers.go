@@ -2,51 +2,16 @@ package handler
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/policy"
+	"github.com/nsmithuk/local-kms/src/service"
 )
 
-/*
-	Finds a key for a given key or alias name or ARN
-*/
-func (r *RequestHandler) getKey(keyId string) (cmk.Key, Response) {
-
-	// If it's an alias, map it to a key
-	if strings.Contains(keyId, "alias/") {
-		aliasArn := config.EnsureArn("", keyId)
-
-		alias, err := r.database.LoadAlias(aliasArn)
-
-		if err != nil {
-			msg := fmt.Sprintf("Alias %s is not found.", config.ArnPrefix()+keyId)
-
-			r.logger.Warnf(msg)
-			return nil, NewNotFoundExceptionResponse(msg)
-		}
-
-		keyId = alias.TargetKeyId
-	}
-
-	//---
-
-	// Lookup the key
-	keyId = config.EnsureArn("key/", keyId)
-
-	key, _ := r.database.LoadKey(keyId)
-
-	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", keyId)
-		r.logger.Warnf(msg)
-
-		return nil, NewNotFoundExceptionResponse(msg)
-	}
-
-	return key, Response{}
-}
-
 /*
 	Finds a key for a given key or alias name or ARN
 	And confirms that it's available to use for cryptographic operations.
@@ -60,31 +25,24 @@ func (r *RequestHandler) getUsableKey(keyId string) (cmk.Key, Response) {
 
 	//----------------------------------
 
-	if key.GetMetadata().KeyState == cmk.KeyStatePendingImport {
-		// Key material hasn't been imported
-		msg := fmt.Sprintf("%s is pending import.", keyId)
-
-		r.logger.Warnf(msg)
-		return nil, NewKMSInvalidStateExceptionResponse(msg)
-	}
-
-	if key.GetMetadata().DeletionDate != 0 {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is pending deletion.", keyId)
-
-		r.logger.Warnf(msg)
-		return nil, NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return nil, response
 	}
 
-	if !key.GetMetadata().Enabled {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is disabled.", keyId)
+	return key, Response{}
+}
 
-		r.logger.Warnf(msg)
-		return nil, NewDisabledExceptionResponse(msg)
+/*
+Confirms the key's state permits the requested operation, as set out in cmk's key state table.
+*/
+func (r *RequestHandler) checkKeyState(key cmk.Key) Response {
+	if err := cmk.CheckKeyState(r.operation(), key); err != nil {
+		r.logger.Warnf(err.Message)
+		return New400ExceptionResponse(err.Type, err.Message)
 	}
 
-	return key, Response{}
+	return Response{}
 }
 
 func (r *RequestHandler) validateTags(tags []*kms.Tag) Response {
@@ -122,3 +80,259 @@ func (r *RequestHandler) validateTags(tags []*kms.Tag) Response {
 	// Return an empty response if all is well
 	return Response{}
 }
+
+/*
+Confirms any grant tokens passed are well-formed.
+
+	Grant tokens let a grant be used before it's eventually consistent in AWS. Grants take effect immediately in
+	Local KMS, so the tokens grant nothing; as in AWS, those for grants that don't apply to the key or operation are
+	ignored, and permission comes from the key's policy and grants alone.
+*/
+func (r *RequestHandler) validateGrantTokens(tokens []*string) Response {
+	if len(tokens) > 10 {
+		msg := "1 validation error detected: Value at 'grantTokens' failed to satisfy constraint: Member must " +
+			"have length less than or equal to 10"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	for _, token := range tokens {
+		if token == nil {
+			continue
+		}
+
+		if _, _, ok := service.UnpackGrantToken(*token); !ok {
+			r.logger.Warnf("Unable to deconstruct grant token")
+			return NewInvalidGrantTokenExceptionResponse("Grant token is invalid.")
+		}
+	}
+
+	return Response{}
+}
+
+/*
+Ciphertext produced under a multi-Region key can be decrypted by any related multi-Region key.
+If the ARN is for a multi-Region key, the ARN of the related key in the request's region is returned.
+*/
+func (r *RequestHandler) localMultiRegionKeyArn(keyArn string) string {
+	parts := strings.SplitN(keyArn, ":", 6)
+
+	if len(parts) == 6 && parts[4] == r.config.AWSAccountId && strings.HasPrefix(parts[5], "key/mrk-") {
+		return r.arnPrefix() + parts[5]
+	}
+
+	return keyArn
+}
+
+/*
+Returns the policy applied to new keys when none is given
+*/
+func (r *RequestHandler) defaultKeyPolicy() string {
+	return fmt.Sprintf(`{
+			"Id": "key-default-policy",
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Sid": "Enable IAM User Permissions",
+				"Effect": "Allow",
+				"Principal": {
+					"AWS": "arn:aws:iam::%s:root"
+				},
+				"Action": "kms:*",
+				"Resource": "*"
+			}]
+		}`, r.config.AWSAccountId)
+}
+
+/*
+If key policies are enforced, confirms the caller is permitted to perform the action on the key.
+
+	The caller is permitted if the key's policy allows the action, or if a grant on the key for the caller allows
+	the operation. An explicit deny in the key's policy always takes precedence.
+*/
+func (r *RequestHandler) checkKeyPolicy(key cmk.Key, action string, context map[string]*string) Response {
+	if !r.config.EnforceKeyPolicies {
+		return Response{}
+	}
+
+	document, err := policy.Parse(key.GetPolicy())
+	if err != nil {
+		msg := fmt.Sprintf("User: %s is not authorized to perform: %s on resource: %s because the key policy "+
+			"could not be parsed: %s", r.principal, action, key.GetArn(), err)
+
+		r.logger.Warnf(msg)
+		return NewAccessDeniedExceptionResponse(msg)
+	}
+
+	decision := document.Evaluate(&policy.Request{
+		Principal: r.principal,
+		Account:   r.account,
+		Action:    action,
+		Resource:  key.GetArn(),
+		Context:   r.policyContext(key, context),
+	})
+
+	var msg string
+
+	switch decision {
+	case policy.Allow:
+		return Response{}
+	case policy.ExplicitDeny:
+		msg = fmt.Sprintf("User: %s is not authorized to perform: %s on resource: %s with an explicit deny "+
+			"in a resource-based policy", r.principal, action, key.GetArn())
+	default:
+		if r.grantAllows(key, data.GrantOperation(strings.TrimPrefix(action, "kms:")), context) {
+			return Response{}
+		}
+
+		msg = fmt.Sprintf("User: %s is not authorized to perform: %s on resource: %s because no "+
+			"resource-based policy allows the %s action", r.principal, action, key.GetArn(), action)
+	}
+
+	r.logger.Warnf(msg)
+	return NewAccessDeniedExceptionResponse(msg)
+}
+
+/*
+Returns the condition keys available when evaluating a key policy, with lowercase names.
+*/
+func (r *RequestHandler) policyContext(key cmk.Key, encryptionContext map[string]*string) map[string][]string {
+	context := map[string][]string{
+		"aws:principalarn":     {r.principal},
+		"aws:principalaccount": {r.account},
+		"kms:calleraccount":    {r.account},
+		"kms:keyspec":          {string(key.GetMetadata().KeySpec)},
+		"kms:keyusage":         {string(key.GetMetadata().KeyUsage)},
+		"kms:keyorigin":        {string(key.GetMetadata().Origin)},
+		"kms:multiregion":      {strconv.FormatBool(key.GetMetadata().MultiRegion)},
+	}
+
+	// There are no AWS services locally, so the caller can state which service the request is made via.
+	if service := r.request.Header.Get("X-Local-Kms-Via-Service"); service != "" {
+		context["kms:viaservice"] = []string{service}
+	}
+
+	if len(encryptionContext) > 0 {
+		keys := make([]string, 0, len(encryptionContext))
+		for k, v := range encryptionContext {
+			keys = append(keys, k)
+			if v != nil {
+				context["kms:encryptioncontext:"+strings.ToLower(k)] = []string{*v}
+			}
+		}
+		context["kms:encryptioncontextkeys"] = keys
+	}
+
+	tags, _ := r.database.ListTags(key.GetArn(), 10000, "")
+	for _, t := range tags {
+		context["aws:resourcetag/"+strings.ToLower(t.TagKey)] = []string{t.TagValue}
+	}
+
+	return context
+}
+
+/*
+Returns true if a grant on the key, for the calling principal, allows the operation.
+*/
+func (r *RequestHandler) grantAllows(key cmk.Key, operation data.GrantOperation, context map[string]*string) bool {
+	grants, err := r.database.ListGrants(key.GetArn(), 10000, "")
+	if err != nil {
+		return false
+	}
+
+	for _, g := range grants {
+		if policy.Identifies(g.GranteePrincipal, r.principal, r.account) &&
+			g.AllowsOperation(operation) && g.AllowsEncryptionContext(context) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+If key policies are enforced, confirms a new key policy is well-formed, and that it doesn't lock the caller out of
+the key by not allowing them to change the policy again, unless the lockout safety check is bypassed.
+*/
+func (r *RequestHandler) validateKeyPolicy(keyArn, keyPolicy string, bypassLockoutSafetyCheck *bool) Response {
+	if !r.config.EnforceKeyPolicies {
+		return Response{}
+	}
+
+	document, err := policy.Parse(keyPolicy)
+	if err != nil {
+		msg := fmt.Sprintf("The key policy is malformed: %s", err)
+
+		r.logger.Warnf(msg)
+		return NewMalformedPolicyDocumentExceptionResponse(msg)
+	}
+
+	if bypassLockoutSafetyCheck != nil && *bypassLockoutSafetyCheck {
+		return Response{}
+	}
+
+	decision := document.Evaluate(&policy.Request{
+		Principal: r.principal,
+		Account:   r.account,
+		Action:    "kms:PutKeyPolicy",
+		Resource:  keyArn,
+		Context: map[string][]string{
+			"aws:principalarn":     {r.principal},
+			"aws:principalaccount": {r.account},
+			"kms:calleraccount":    {r.account},
+		},
+	})
+
+	if decision != policy.Allow {
+		msg := "The new key policy will not allow you to update the key policy in the future."
+
+		r.logger.Warnf(msg)
+		return NewMalformedPolicyDocumentExceptionResponse(msg)
+	}
+
+	return Response{}
+}
+
+/*
+Encrypts the plaintext under a symmetric key, returning the packaged ciphertext blob.
+Keys in an external key store are double encrypted; first under the external key, then under the key's own material.
+*/
+func (r *RequestHandler) encryptAndPackage(key *cmk.AesKey, plaintext []byte, context map[string]*string) ([]byte, Response) {
+
+	if key.GetMetadata().Origin == cmk.KeyOriginExternalKeyStore {
+		var response Response
+
+		plaintext, response = r.xksEncrypt(key, plaintext, context)
+		if !response.Empty() {
+			return nil, response
+		}
+	}
+
+	ciphertext, err := key.EncryptAndPackage(plaintext, context)
+	if err != nil {
+		r.logger.Error(err.Error())
+		return nil, NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	return ciphertext, Response{}
+}
+
+/*
+Decrypts ciphertext produced by encryptAndPackage.
+*/
+func (r *RequestHandler) decryptSymmetric(key *cmk.AesKey, version uint32, ciphertext []byte, context map[string]*string) ([]byte, Response) {
+
+	plaintext, err := key.Decrypt(version, ciphertext, context)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to decode Ciphertext: %s", err)
+		r.logger.Warnf(msg)
+
+		return nil, NewInvalidCiphertextExceptionResponse("")
+	}
+
+	if key.GetMetadata().Origin == cmk.KeyOriginExternalKeyStore {
+		return r.xksDecrypt(key, plaintext, context)
+	}
+
+	return plaintext, Response{}
+}
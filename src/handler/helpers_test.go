@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/service"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	testAccount   = "111122223333"
+	testPrincipal = "arn:aws:iam::111122223333:user/alice"
+)
+
+func newTestHandler(t *testing.T, enforce bool, viaService string) *RequestHandler {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	request := httptest.NewRequest("POST", "/", nil)
+	if viaService != "" {
+		request.Header.Set("X-Local-Kms-Via-Service", viaService)
+	}
+
+	return NewRequestHandler(request, logger, &config.Config{
+		AWSRegion:          "eu-west-2",
+		AWSAccountId:       testAccount,
+		CallerPrincipal:    testPrincipal,
+		EnforceKeyPolicies: enforce,
+	}, data.NewDatabase(data.NewMemoryStorage()), nil)
+}
+
+func newTestKey(t *testing.T, r *RequestHandler, policy string) cmk.Key {
+	t.Helper()
+
+	metadata := cmk.KeyMetadata{
+		AWSAccountId: testAccount,
+		Arn:          r.arnPrefix() + "key/5e0ab35c-2d76-4a8b-a3a4-5ea2b41e3e4b",
+		KeyId:        "5e0ab35c-2d76-4a8b-a3a4-5ea2b41e3e4b",
+		Enabled:      true,
+		KeyState:     cmk.KeyStateEnabled,
+		KeyUsage:     cmk.UsageEncryptDecrypt,
+		KeySpec:      cmk.SpecSymmetricDefault,
+	}
+
+	key := cmk.NewAesKey(metadata, policy, cmk.KeyOriginAwsKms)
+	if err := r.database.SaveKey(key); err != nil {
+		t.Fatalf("unable to save the key: %s", err)
+	}
+
+	return key
+}
+
+func accessDeniedMessage(t *testing.T, response Response) string {
+	t.Helper()
+
+	var body map[string]string
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+		t.Fatalf("unable to decode the response: %s", err)
+	}
+
+	if body["__type"] != "AccessDeniedException" {
+		t.Fatalf("expected an AccessDeniedException, got %s", response.Body)
+	}
+
+	return body["Message"]
+}
+
+func TestCheckKeyPolicyAllows(t *testing.T) {
+	r := newTestHandler(t, true, "")
+	key := newTestKey(t, r, r.defaultKeyPolicy())
+
+	if response := r.checkKeyPolicy(key, "kms:Encrypt", nil); !response.Empty() {
+		t.Errorf("expected the default policy to allow the account, got %s", response.Body)
+	}
+}
+
+func TestCheckKeyPolicyDefaultDeny(t *testing.T) {
+	r := newTestHandler(t, true, "")
+	key := newTestKey(t, r, `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:user/bob"},"Action":"kms:*","Resource":"*"}]}`)
+
+	msg := accessDeniedMessage(t, r.checkKeyPolicy(key, "kms:Encrypt", nil))
+
+	expected := "User: " + testPrincipal + " is not authorized to perform: kms:Encrypt on resource: " + key.GetArn() +
+		" because no resource-based policy allows the kms:Encrypt action"
+	if msg != expected {
+		t.Errorf("expected message %q, got %q", expected, msg)
+	}
+}
+
+func TestCheckKeyPolicyExplicitDeny(t *testing.T) {
+	r := newTestHandler(t, true, "")
+	key := newTestKey(t, r, `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:root"},"Action":"kms:*","Resource":"*"},
+		{"Effect":"Deny","Principal":"*","Action":"kms:Decrypt","Resource":"*"}]}`)
+
+	if response := r.checkKeyPolicy(key, "kms:Encrypt", nil); !response.Empty() {
+		t.Errorf("expected Encrypt to be allowed, got %s", response.Body)
+	}
+
+	msg := accessDeniedMessage(t, r.checkKeyPolicy(key, "kms:Decrypt", nil))
+
+	expected := "User: " + testPrincipal + " is not authorized to perform: kms:Decrypt on resource: " + key.GetArn() +
+		" with an explicit deny in a resource-based policy"
+	if msg != expected {
+		t.Errorf("expected message %q, got %q", expected, msg)
+	}
+}
+
+func TestCheckKeyPolicyNotEnforced(t *testing.T) {
+	r := newTestHandler(t, false, "")
+	key := newTestKey(t, r, `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Deny","Principal":"*","Action":"kms:*","Resource":"*"}]}`)
+
+	if response := r.checkKeyPolicy(key, "kms:Encrypt", nil); !response.Empty() {
+		t.Errorf("expected the policy to be ignored, got %s", response.Body)
+	}
+}
+
+func TestCheckKeyPolicyUnparsable(t *testing.T) {
+	r := newTestHandler(t, true, "")
+	key := newTestKey(t, r, `{"Version":"2012-10-17","Statement":[{"Effect":"Maybe"}]}`)
+
+	accessDeniedMessage(t, r.checkKeyPolicy(key, "kms:Encrypt", nil))
+}
+
+func TestCheckKeyPolicyGrant(t *testing.T) {
+	r := newTestHandler(t, true, "")
+	key := newTestKey(t, r, `{"Version":"2012-10-17","Statement":[]}`)
+
+	err := r.database.SaveGrant(key, &data.Grant{
+		KeyId:            key.GetArn(),
+		GrantId:          "0c237476b39f8bc44e45212e08498fbe3151305030726c0590dd8d3e9f3d6a60",
+		GranteePrincipal: testPrincipal,
+		Operations:       []data.GrantOperation{data.GrantOperationDecrypt},
+		Constraints: &data.GrantConstraints{
+			EncryptionContextSubset: map[string]string{"Department": "IT"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to save the grant: %s", err)
+	}
+
+	it, hr := "IT", "HR"
+
+	if response := r.checkKeyPolicy(key, "kms:Decrypt", map[string]*string{"Department": &it}); !response.Empty() {
+		t.Errorf("expected the grant to allow Decrypt, got %s", response.Body)
+	}
+
+	// Not an operation the grant allows
+	accessDeniedMessage(t, r.checkKeyPolicy(key, "kms:Encrypt", map[string]*string{"Department": &it}))
+
+	// Not an encryption context the grant allows
+	accessDeniedMessage(t, r.checkKeyPolicy(key, "kms:Decrypt", map[string]*string{"Department": &hr}))
+}
+
+func TestCheckKeyPolicyGrantDoesNotOverrideDeny(t *testing.T) {
+	r := newTestHandler(t, true, "")
+	key := newTestKey(t, r, `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Deny","Principal":"*","Action":"kms:Decrypt","Resource":"*"}]}`)
+
+	err := r.database.SaveGrant(key, &data.Grant{
+		KeyId:            key.GetArn(),
+		GrantId:          "9d5a8a4c5e2f1b3d7e6c4a2b0f8e6d4c2a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d",
+		GranteePrincipal: testPrincipal,
+		Operations:       []data.GrantOperation{data.GrantOperationDecrypt},
+	})
+	if err != nil {
+		t.Fatalf("unable to save the grant: %s", err)
+	}
+
+	accessDeniedMessage(t, r.checkKeyPolicy(key, "kms:Decrypt", nil))
+}
+
+func TestCheckKeyPolicyViaService(t *testing.T) {
+	policy := `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Allow","Principal":"*","Action":"kms:Decrypt","Resource":"*",
+			"Condition":{"StringEquals":{"kms:ViaService":"s3.eu-west-2.amazonaws.com"}}}]}`
+
+	r := newTestHandler(t, true, "s3.eu-west-2.amazonaws.com")
+	key := newTestKey(t, r, policy)
+
+	if response := r.checkKeyPolicy(key, "kms:Decrypt", nil); !response.Empty() {
+		t.Errorf("expected the request via S3 to be allowed, got %s", response.Body)
+	}
+
+	r = newTestHandler(t, true, "")
+	key = newTestKey(t, r, policy)
+
+	accessDeniedMessage(t, r.checkKeyPolicy(key, "kms:Decrypt", nil))
+}
+
+func TestValidateGrantTokens(t *testing.T) {
+	r := newTestHandler(t, false, "")
+	key := newTestKey(t, r, "")
+
+	err := r.database.SaveGrant(key, &data.Grant{
+		KeyId:            key.GetArn(),
+		GrantId:          "1f3a5c7e9b2d4f6a8c0e1b3d5f7a9c2e4b6d8f0a1c3e5b7d9f2a4c6e8b0d1f3a",
+		GranteePrincipal: testPrincipal,
+		Operations:       []data.GrantOperation{data.GrantOperationSign},
+	})
+	if err != nil {
+		t.Fatalf("unable to save the grant: %s", err)
+	}
+
+	// Tokens for grants that don't apply to the key or operation, or no longer exist, are ignored
+	for _, token := range []string{
+		service.PackGrantToken(key.GetArn(), "1f3a5c7e9b2d4f6a8c0e1b3d5f7a9c2e4b6d8f0a1c3e5b7d9f2a4c6e8b0d1f3a"),
+		service.PackGrantToken(r.arnPrefix()+"key/0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e", "0c237476b39f8bc44e45212e08498fbe"),
+	} {
+		if response := r.validateGrantTokens([]*string{&token}); !response.Empty() {
+			t.Errorf("expected the token to be ignored, got %s", response.Body)
+		}
+	}
+
+	malformed := "not a grant token"
+	if response := r.validateGrantTokens([]*string{&malformed}); !strings.Contains(response.Body, "InvalidGrantTokenException") {
+		t.Errorf("expected an InvalidGrantTokenException, got %s", response.Body)
+	}
+
+	tokens := make([]*string, 11)
+	if response := r.validateGrantTokens(tokens); !strings.Contains(response.Body, "ValidationException") {
+		t.Errorf("expected a ValidationException, got %s", response.Body)
+	}
+}
+
+func TestGetKeyAppliesDueTransitions(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Unix()
+
+	t.Run("waiting period over", func(t *testing.T) {
+		r := newTestHandler(t, false, "")
+		key := newTestKey(t, r, "")
+
+		key.GetMetadata().Enabled = false
+		key.GetMetadata().KeyState = cmk.KeyStatePendingDeletion
+		key.GetMetadata().DeletionDate = past
+		if err := r.database.SaveKey(key); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, response := r.getKey(key.GetArn()); !strings.Contains(response.Body, "NotFoundException") {
+			t.Errorf("expected a NotFoundException, got %s", response.Body)
+		}
+	})
+
+	t.Run("key material expired", func(t *testing.T) {
+		r := newTestHandler(t, false, "")
+		r.request.Header.Set("X-Amz-Target", "TrentService.Encrypt")
+
+		key := newTestKey(t, r, "")
+
+		key.GetMetadata().ExpirationModel = "KEY_MATERIAL_EXPIRES"
+		key.GetMetadata().ValidTo = past
+		if err := r.database.SaveKey(key); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, response := r.getUsableKey(key.GetArn()); !strings.Contains(response.Body, "KMSInvalidStateException") {
+			t.Errorf("expected a KMSInvalidStateException, got %s", response.Body)
+		}
+
+		if loaded, _ := r.getKey(key.GetArn()); loaded.GetMetadata().KeyState != cmk.KeyStatePendingImport {
+			t.Errorf("expected the key to be seen as %s, got %s", cmk.KeyStatePendingImport, loaded.GetMetadata().KeyState)
+		}
+
+		// The transition is left for the scheduler to store
+		if stored, _ := r.database.LoadKey(key.GetArn()); stored.GetMetadata().ValidTo != past {
+			t.Errorf("the stored key shouldn't be changed")
+		}
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/service"
 )
 
 // Using custom struct to be able to decode ValidTo
@@ -98,6 +99,11 @@ func (r *RequestHandler) ImportKeyMaterial() Response {
 		return NewNotFoundExceptionResponse(msg)
 	}
 
+	response = r.checkKeyPolicy(key, "kms:ImportKeyMaterial", nil)
+	if !response.Empty() {
+		return response
+	}
+
 	keyMetadata := key.GetMetadata()
 	if keyMetadata.Origin != cmk.KeyOriginExternal {
 		msg := fmt.Sprintf("%s origin is %s which is not valid for this operation.", key.GetArn(), keyMetadata.Origin)
@@ -106,21 +112,20 @@ func (r *RequestHandler) ImportKeyMaterial() Response {
 		return NewUnsupportedOperationException(msg)
 	}
 
-	switch keyMetadata.KeyState {
-	case cmk.KeyStatePendingDeletion:
-		msg := fmt.Sprintf("%s is pending deletion.", *body.KeyId)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
+	}
 
-	case cmk.KeyStateUnavailable:
-		msg := fmt.Sprintf("%s is unavailable.", *body.KeyId)
+	importableKey, ok := key.(cmk.ImportableKey)
+	if !ok {
+		msg := fmt.Sprintf("%s key spec is %s which is not valid for this operation.", key.GetArn(), keyMetadata.KeySpec)
 
 		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+		return NewUnsupportedOperationException(msg)
 	}
 
-	params := key.(*cmk.AesKey).GetParametersForImport()
+	params := importableKey.GetParametersForImport()
 	if params == nil || !bytes.Equal(params.ImportToken, body.ImportToken) {
 
 		r.logger.Warnf("Invalid import token when when calling the ImportKeyMaterial operation for key %s.", key.GetArn())
@@ -137,15 +142,29 @@ func (r *RequestHandler) ImportKeyMaterial() Response {
 	// Attempt to decrypt the encyrpted key material
 	var decrypterOps crypto.DecrypterOpts
 	switch params.WrappingAlgorithm {
-	case cmk.WrappingAlgorithmOaepSha1:
+	case cmk.WrappingAlgorithmOaepSha1, cmk.WrappingAlgorithmRsaAesSha1:
 		decrypterOps = &rsa.OAEPOptions{Hash: crypto.SHA1}
-	case cmk.WrappingAlgorithmOaepSh256:
+	case cmk.WrappingAlgorithmOaepSh256, cmk.WrappingAlgorithmRsaAesSha256:
 		decrypterOps = &rsa.OAEPOptions{Hash: crypto.SHA256}
 	case cmk.WrappingAlgorithmPkcs1V15:
 		decrypterOps = &rsa.PKCS1v15DecryptOptions{}
 	}
 
-	keyMaterial, err := params.PrivateKey.Decrypt(rand.Reader, body.EncryptedKeyMaterial, decrypterOps)
+	encryptedKeyMaterial := body.EncryptedKeyMaterial
+	var wrappedKeyMaterial []byte
+
+	// With RSA_AES_KEY_WRAP, the RSA encrypted AES key is followed by the AES wrapped key material
+	if params.WrappingAlgorithm == cmk.WrappingAlgorithmRsaAesSha1 || params.WrappingAlgorithm == cmk.WrappingAlgorithmRsaAesSha256 {
+		size := params.PrivateKey.Size()
+		if len(encryptedKeyMaterial) <= size {
+			r.logger.Warnf("EncryptedKeyMaterial is too short for wrapping algorithm %s", params.WrappingAlgorithm)
+			return NewInvalidCiphertextExceptionResponse("")
+		}
+
+		encryptedKeyMaterial, wrappedKeyMaterial = encryptedKeyMaterial[:size], encryptedKeyMaterial[size:]
+	}
+
+	keyMaterial, err := params.PrivateKey.Decrypt(rand.Reader, encryptedKeyMaterial, decrypterOps)
 	if err != nil {
 		msg := fmt.Sprintf("Unable to decode EncryptedKeyMaterial: %s", err.Error())
 
@@ -153,7 +172,18 @@ func (r *RequestHandler) ImportKeyMaterial() Response {
 		return NewInvalidCiphertextExceptionResponse("")
 	}
 
-	if err = key.(*cmk.AesKey).ImportKeyMaterial(keyMaterial); err != nil {
+	if wrappedKeyMaterial != nil {
+		// What was decrypted was the AES wrapping key
+		keyMaterial, err = service.UnwrapKeyWithPadding(keyMaterial, wrappedKeyMaterial)
+		if err != nil {
+			msg := fmt.Sprintf("Unable to unwrap EncryptedKeyMaterial: %s", err.Error())
+
+			r.logger.Warnf(msg)
+			return NewInvalidCiphertextExceptionResponse("")
+		}
+	}
+
+	if err = importableKey.ImportKeyMaterial(keyMaterial); err != nil {
 		msg := fmt.Sprintf("Unable to import key material: %s", err.Error())
 
 		r.logger.Warnf(msg)
@@ -161,8 +191,8 @@ func (r *RequestHandler) ImportKeyMaterial() Response {
 	}
 
 	keyMetadata.ExpirationModel = expirationModel
-	keyMetadata.KeyState = cmk.KeyStateEnabled
-	keyMetadata.Enabled = true
+	// Reimporting key material into a key that isn't pending import leaves its state unchanged
+	cmk.TransitionKeyState(r.operation(), keyMetadata)
 
 	if expirationModel == cmk.ExpirationModelKeyMaterialExpires {
 		keyMetadata.ValidTo = *body.ValidTo
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
+)
+
+/*
+The key identifiers an operation accepts. All operations accept a key ID or key ARN; some also accept an alias name
+or alias ARN. Only some operations can be used with keys in other accounts, which must then be identified by ARN.
+*/
+type keyIdentifierRule struct {
+	aliases      bool
+	crossAccount bool
+}
+
+var (
+	// Cryptographic operations, and DescribeKey
+	anyKeyIdentifier = keyIdentifierRule{aliases: true, crossAccount: true}
+
+	// Operations on grants, and GetKeyRotationStatus
+	crossAccountKeyIdentifier = keyIdentifierRule{crossAccount: true}
+)
+
+/*
+Operations not listed accept only the key ID or key ARN of a key in the caller's account.
+*/
+var keyIdentifierRules = map[string]keyIdentifierRule{
+	"Encrypt":                             anyKeyIdentifier,
+	"Decrypt":                             anyKeyIdentifier,
+	"ReEncrypt":                           anyKeyIdentifier,
+	"GenerateDataKey":                     anyKeyIdentifier,
+	"GenerateDataKeyWithoutPlaintext":     anyKeyIdentifier,
+	"GenerateDataKeyPair":                 anyKeyIdentifier,
+	"GenerateDataKeyPairWithoutPlaintext": anyKeyIdentifier,
+	"GenerateMac":                         anyKeyIdentifier,
+	"VerifyMac":                           anyKeyIdentifier,
+	"Sign":                                anyKeyIdentifier,
+	"Verify":                              anyKeyIdentifier,
+	"GetPublicKey":                        anyKeyIdentifier,
+	"DeriveSharedSecret":                  anyKeyIdentifier,
+	"DescribeKey":                         anyKeyIdentifier,
+
+	"CreateGrant":          crossAccountKeyIdentifier,
+	"ListGrants":           crossAccountKeyIdentifier,
+	"RetireGrant":          crossAccountKeyIdentifier,
+	"RevokeGrant":          crossAccountKeyIdentifier,
+	"GetKeyRotationStatus": crossAccountKeyIdentifier,
+}
+
+/*
+Finds a key by the identifier passed in the request's KeyId parameter.
+*/
+func (r *RequestHandler) getKey(keyId string) (cmk.Key, Response) {
+	return r.getKeyByParameter("keyId", keyId)
+}
+
+/*
+Finds a key by the identifier passed in the named request parameter, applying the operation's identifier rules.
+*/
+func (r *RequestHandler) getKeyByParameter(parameter, keyId string) (cmk.Key, Response) {
+
+	keyArn, response := r.resolveKeyArn(parameter, keyId)
+	if !response.Empty() {
+		return nil, response
+	}
+
+	key, _ := r.database.LoadKey(keyArn)
+
+	// Lifecycle transitions are made by the scheduler, which may be disabled. Those already due are applied to the
+	// loaded key only, so a key can't be used once it should have been deleted, or its key material has expired.
+	now := time.Now()
+
+	if key == nil || data.DeletionDue(key, now) {
+		msg := fmt.Sprintf("Key '%s' does not exist", keyArn)
+
+		r.logger.Warnf(msg)
+		return nil, NewNotFoundExceptionResponse(msg)
+	}
+
+	data.ExpireKeyMaterial(key, now)
+
+	return key, Response{}
+}
+
+/*
+Returns the ARN of the key identified, following aliases. Key IDs and alias names identify keys in the instance's
+account, within the request's region.
+*/
+func (r *RequestHandler) resolveKeyArn(parameter, keyId string) (string, Response) {
+
+	if len(keyId) < 1 || len(keyId) > 2048 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at '%s' failed to satisfy constraint: "+
+			"Member must have length between 1 and 2048", keyId, parameter)
+
+		r.logger.Warnf(msg)
+		return "", NewValidationExceptionResponse(msg)
+	}
+
+	rule := keyIdentifierRules[r.operation()]
+
+	// Keys are kept in the instance's account
+	prefix := r.arnPrefix()
+
+	var resourceArn string
+
+	if strings.HasPrefix(keyId, "arn:") {
+		// arn:<partition>:kms:<region>:<account>:<key|alias>/<id>
+		parts := strings.SplitN(keyId, ":", 6)
+
+		if len(parts) != 6 || parts[2] != "kms" || parts[4] == "" ||
+			!(strings.HasPrefix(parts[5], "key/") || strings.HasPrefix(parts[5], "alias/")) {
+
+			msg := fmt.Sprintf("Invalid arn %s", keyId)
+
+			r.logger.Warnf(msg)
+			return "", NewNotFoundExceptionResponse(msg)
+		}
+
+		// Keys can only be used in the region the request is made in
+		if parts[3] != r.region {
+			msg := fmt.Sprintf("Invalid arn %s. Keys can only be used in region %s.", keyId, r.region)
+
+			r.logger.Warnf(msg)
+			return "", NewNotFoundExceptionResponse(msg)
+		}
+
+		if parts[4] != r.config.AWSAccountId && !rule.crossAccount {
+			msg := fmt.Sprintf("Invalid arn %s. %s cannot be used with keys in another account.", keyId, r.operation())
+
+			r.logger.Warnf(msg)
+			return "", NewNotFoundExceptionResponse(msg)
+		}
+
+		resourceArn = keyId
+
+	} else if strings.HasPrefix(keyId, "alias/") {
+		resourceArn = prefix + keyId
+
+	} else {
+		resourceArn = prefix + "key/" + keyId
+	}
+
+	//---
+
+	parts := strings.SplitN(resourceArn, ":", 6)
+
+	if !strings.HasPrefix(parts[5], "alias/") {
+		return resourceArn, Response{}
+	}
+
+	if !rule.aliases {
+		msg := fmt.Sprintf("Invalid keyId %s", keyId)
+
+		r.logger.Warnf(msg)
+		return "", NewNotFoundExceptionResponse(msg)
+	}
+
+	alias, _ := r.database.LoadAlias(resourceArn)
+
+	if alias == nil {
+		msg := fmt.Sprintf("Alias %s is not found.", resourceArn)
+
+		r.logger.Warnf(msg)
+		return "", NewNotFoundExceptionResponse(msg)
+	}
+
+	// The alias' target is the ID of a key in the same account and region as the alias
+	if strings.HasPrefix(alias.TargetKeyId, "arn:") {
+		return alias.TargetKeyId, Response{}
+	}
+
+	return strings.Join(parts[:5], ":") + ":key/" + alias.TargetKeyId, Response{}
+}
@@ -3,7 +3,6 @@ package handler
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/config"
 	"github.com/nsmithuk/local-kms/src/data"
 )
 
@@ -46,16 +45,9 @@ func (r *RequestHandler) ListAliases() Response {
 
 	if body.KeyId != nil {
 
-		target := config.EnsureArn("key/", *body.KeyId)
-
-		// Lookup the key
-		key, _ := r.database.LoadKey(target)
-
+		key, response := r.getKey(*body.KeyId)
 		if key == nil {
-			msg := fmt.Sprintf("Key '%s' does not exist", target)
-
-			r.logger.Warnf(msg)
-			return NewNotFoundExceptionResponse(msg)
+			return response
 		}
 
 		//---
@@ -66,7 +58,7 @@ func (r *RequestHandler) ListAliases() Response {
 	//--------------------------------
 
 	// Return 1 extra result to determine if there are > limit
-	aliases, err := r.database.ListAlias(config.ArnPrefix()+"alias/", limit+1, marker, keyFilter)
+	aliases, err := r.database.ListAlias(r.arnPrefix()+"alias/", limit+1, marker, keyFilter)
 	if err != nil {
 
 		if _, ok := err.(*data.InvalidMarkerExceptionError); ok {
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/data"
+)
+
+/*
+Note: Grants can be listed even if a key is disabled or pending deletion.
+*/
+
+func (r *RequestHandler) ListGrants() Response {
+
+	var body *kms.ListGrantsInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.ListGrantsInput{}
+	}
+
+	//---
+
+	var marker string
+	var limit int64 = 50
+
+	if body.Marker != nil {
+		marker = *body.Marker
+	}
+	if body.Limit != nil {
+		limit = *body.Limit
+	}
+
+	//--------------------------------
+	// Validation
+
+	if limit < 1 || limit > 100 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%d' at 'limit' failed to satisfy "+
+			"constraint: Minimum value of 1. Maximum value of 100.", limit)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	key, response := r.getKey(*body.KeyId)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:ListGrants", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	// Filters are applied after the lookup, so we load all grants for the key.
+	grants, err := r.database.ListGrants(key.GetArn(), 10000, "")
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	var filtered []*data.Grant
+	pastMarker := marker == ""
+
+	for _, g := range grants {
+		if body.GrantId != nil && g.GrantId != *body.GrantId {
+			continue
+		}
+		if body.GranteePrincipal != nil && g.GranteePrincipal != *body.GranteePrincipal {
+			continue
+		}
+		if !pastMarker && g.GrantId != marker {
+			continue
+		}
+
+		pastMarker = true
+		filtered = append(filtered, g)
+	}
+
+	if !pastMarker {
+		r.logger.Warnf("Invalid marker passed")
+		return New400ExceptionResponse("InvalidMarkerException", "")
+	}
+
+	//---
+
+	output := &struct {
+		NextMarker string `json:",omitempty"`
+		Truncated  bool
+		Grants     []*data.Grant
+	}{}
+
+	// If there are more than the limit, return the 'next' ID as the NextMarker
+	if int64(len(filtered)) > limit {
+		output.Truncated = true
+		output.NextMarker = filtered[limit].GrantId
+
+		// Strip out the extra results.
+		filtered = filtered[:limit]
+	}
+
+	output.Grants = filtered
+	if output.Grants == nil {
+		output.Grants = []*data.Grant{}
+	}
+
+	r.logger.Infof("%d grants listed for key %s\n", len(filtered), key.GetArn())
+
+	return NewResponse(200, output)
+}
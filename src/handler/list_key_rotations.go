@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+// Custom struct, as ListKeyRotations isn't supported by the AWS library version in use.
+type ListKeyRotationsInput struct {
+	KeyId  *string
+	Limit  *int64
+	Marker *string
+}
+
+func (r *RequestHandler) ListKeyRotations() Response {
+
+	var body *ListKeyRotationsInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &ListKeyRotationsInput{}
+	}
+
+	//---
+
+	var limit int64 = 100
+
+	if body.Limit != nil {
+		limit = *body.Limit
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if limit < 1 || limit > 1000 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%d' at 'limit' failed to satisfy "+
+			"constraint: Minimum value of 1. Maximum value of 1000.", limit)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	key, response := r.getKey(*body.KeyId)
+	if key == nil {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:ListKeyRotations", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	aesKey, ok := key.(*cmk.AesKey)
+	if !ok {
+		msg := fmt.Sprintf("%s key spec is %s which is not valid for this operation.", key.GetArn(), key.GetMetadata().KeySpec)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if origin := key.GetMetadata().Origin; origin == cmk.KeyOriginExternal || origin == cmk.KeyOriginAwsCloudHsm ||
+		origin == cmk.KeyOriginExternalKeyStore {
+		msg := fmt.Sprintf("%s origin is %s which is not valid for this operation.", key.GetArn(), key.GetMetadata().Origin)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	//---
+
+	type KeyRotation struct {
+		KeyId        string
+		RotationDate int64
+		RotationType cmk.RotationType
+	}
+
+	// The first backing key is the key's original material, so isn't a rotation.
+	rotations := make([]*KeyRotation, 0, len(aesKey.BackingKeys))
+	for _, b := range aesKey.BackingKeys {
+		if b.RotationDate != 0 {
+			rotations = append(rotations, &KeyRotation{
+				KeyId:        key.GetArn(),
+				RotationDate: b.RotationDate,
+				RotationType: b.RotationType,
+			})
+		}
+	}
+
+	// The marker is the index of the next rotation to return.
+	var start int64
+	if body.Marker != nil {
+		start, err = strconv.ParseInt(*body.Marker, 10, 64)
+		if err != nil || start < 0 || start > int64(len(rotations)) {
+			r.logger.Warnf("Invalid marker passed")
+			return New400ExceptionResponse("InvalidMarkerException", "")
+		}
+	}
+
+	output := &struct {
+		NextMarker string `json:",omitempty"`
+		Truncated  bool
+		Rotations  []*KeyRotation
+	}{}
+
+	end := start + limit
+	if end < int64(len(rotations)) {
+		output.Truncated = true
+		output.NextMarker = strconv.FormatInt(end, 10)
+	} else {
+		end = int64(len(rotations))
+	}
+
+	output.Rotations = rotations[start:end]
+
+	r.logger.Infof("%d key rotations listed: %s\n", len(output.Rotations), key.GetArn())
+
+	return NewResponse(200, output)
+}
@@ -3,7 +3,6 @@ package handler
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/config"
 	"github.com/nsmithuk/local-kms/src/data"
 )
 
@@ -44,7 +43,7 @@ func (r *RequestHandler) ListKeys() Response {
 	//---
 
 	// Return 1 extra result to determine if there are > limit
-	keys, err := r.database.ListKeys(config.ArnPrefix()+"key/", limit+1, marker)
+	keys, err := r.database.ListKeys(r.arnPrefix()+"key/", limit+1, marker)
 	if err != nil {
 
 		if _, ok := err.(*data.InvalidMarkerExceptionError); ok {
@@ -3,7 +3,6 @@ package handler
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/config"
 	"github.com/nsmithuk/local-kms/src/data"
 )
 
@@ -50,15 +49,14 @@ func (r *RequestHandler) ListResourceTags() Response {
 		return NewMissingParameterResponse(msg)
 	}
 
-	keyId := config.EnsureArn("key/", *body.KeyId)
-
-	key, _ := r.database.LoadKey(keyId)
-
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", keyId)
-		r.logger.Warnf(msg)
+		return response
+	}
 
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:ListResourceTags", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
@@ -68,7 +66,7 @@ func (r *RequestHandler) ListResourceTags() Response {
 
 	//---
 
-	response := &struct {
+	output := &struct {
 		NextMarker string `json:",omitempty"`
 		Truncated  bool
 		Tags       []*data.Tag
@@ -76,16 +74,16 @@ func (r *RequestHandler) ListResourceTags() Response {
 
 	// If there are more than the limit, return the 'next' ID as the NextMarker
 	if int64(len(tags)) > limit {
-		response.Truncated = true
-		response.NextMarker = tags[len(tags)-1].TagKey
+		output.Truncated = true
+		output.NextMarker = tags[len(tags)-1].TagKey
 
 		// Strip out the extra result.
 		tags = tags[:limit]
 	}
 
-	response.Tags = tags
+	output.Tags = tags
 
 	r.logger.Infof("%d tags listed for key %s\n", len(tags), key.GetMetadata().Arn)
 
-	return NewResponse(200, response)
+	return NewResponse(200, output)
 }
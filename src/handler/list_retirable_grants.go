@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/data"
+)
+
+func (r *RequestHandler) ListRetirableGrants() Response {
+
+	var body *kms.ListRetirableGrantsInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.ListRetirableGrantsInput{}
+	}
+
+	//---
+
+	var marker string
+	var limit int64 = 50
+
+	if body.Marker != nil {
+		marker = *body.Marker
+	}
+	if body.Limit != nil {
+		limit = *body.Limit
+	}
+
+	//--------------------------------
+	// Validation
+
+	if limit < 1 || limit > 100 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%d' at 'limit' failed to satisfy "+
+			"constraint: Minimum value of 1. Maximum value of 100.", limit)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.RetiringPrincipal == nil {
+		msg := "1 validation error detected: Value null at 'retiringPrincipal' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	// Return 1 extra result to determine if there are > limit
+	grants, err := r.database.ListRetirableGrants(r.arnPrefix()+"key/", *body.RetiringPrincipal, limit+1, marker)
+	if err != nil {
+
+		if _, ok := err.(*data.InvalidMarkerExceptionError); ok {
+			r.logger.Warnf("Invalid marker passed")
+			return New400ExceptionResponse("InvalidMarkerException", "")
+		}
+
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	//---
+
+	response := &struct {
+		NextMarker string `json:",omitempty"`
+		Truncated  bool
+		Grants     []*data.Grant
+	}{
+		Grants: []*data.Grant{},
+	}
+
+	// If there are more than the limit, return the 'next' ID as the NextMarker
+	if int64(len(grants)) > limit {
+		response.Truncated = true
+		response.NextMarker = grants[len(grants)-1].GrantId
+
+		// Strip out the extra result.
+		grants = grants[:limit]
+	}
+
+	if grants != nil {
+		response.Grants = grants
+	}
+
+	r.logger.Infof("%d retirable grants listed for %s\n", len(grants), *body.RetiringPrincipal)
+
+	return NewResponse(200, response)
+}
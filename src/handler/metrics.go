@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Counts the calls made to each operation, by response code, and the time spent handling them.
+*/
+type Metrics struct {
+	mutex      sync.Mutex
+	operations map[metricsKey]*OperationMetrics
+}
+
+type metricsKey struct {
+	operation string
+	code      int
+}
+
+type OperationMetrics struct {
+	Operation string
+	Code      int
+	Calls     int64
+	Duration  time.Duration
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		operations: make(map[metricsKey]*OperationMetrics),
+	}
+}
+
+/*
+Returns middleware that records each call in the metrics.
+*/
+func (m *Metrics) Middleware() Middleware {
+	return func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			start := time.Now()
+			response := next(c)
+
+			m.record(c.Operation.Name, response.Code, time.Since(start))
+
+			return response
+		}
+	}
+}
+
+func (m *Metrics) record(operation string, code int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := metricsKey{operation, code}
+
+	metrics, ok := m.operations[key]
+	if !ok {
+		metrics = &OperationMetrics{Operation: operation, Code: code}
+		m.operations[key] = metrics
+	}
+
+	metrics.Calls++
+	metrics.Duration += duration
+}
+
+/*
+Returns a copy of the current metrics, ordered by operation and response code.
+*/
+func (m *Metrics) Snapshot() []OperationMetrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snapshot := make([]OperationMetrics, 0, len(m.operations))
+	for _, metrics := range m.operations {
+		snapshot = append(snapshot, *metrics)
+	}
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Operation != snapshot[j].Operation {
+			return snapshot[i].Operation < snapshot[j].Operation
+		}
+		return snapshot[i].Code < snapshot[j].Code
+	})
+
+	return snapshot
+}
+
+/*
+Serves the metrics in the Prometheus text exposition format.
+*/
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot := m.Snapshot()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP local_kms_requests_total Requests handled, by operation and response code.")
+	fmt.Fprintln(w, "# TYPE local_kms_requests_total counter")
+	for _, s := range snapshot {
+		fmt.Fprintf(w, "local_kms_requests_total{operation=%q,code=\"%d\"} %d\n", s.Operation, s.Code, s.Calls)
+	}
+
+	fmt.Fprintln(w, "# HELP local_kms_request_duration_seconds_total Time spent handling requests, by operation and response code.")
+	fmt.Fprintln(w, "# TYPE local_kms_request_duration_seconds_total counter")
+	for _, s := range snapshot {
+		fmt.Fprintf(w, "local_kms_request_duration_seconds_total{operation=%q,code=\"%d\"} %f\n", s.Operation, s.Code, s.Duration.Seconds())
+	}
+}
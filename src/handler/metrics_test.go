@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	var events []string
+
+	metrics := NewMetrics()
+
+	d := newTestDispatcher()
+	d.Use(metrics.Middleware())
+
+	ok := newTestOperation(&events, NewResponse(200, nil))
+	failed := newTestOperation(&events, NewValidationExceptionResponse("invalid"))
+	failed.Name = "AnotherOperation"
+
+	d.Dispatch(newTestCall(ok))
+	d.Dispatch(newTestCall(ok))
+	d.Dispatch(newTestCall(failed))
+
+	snapshot := metrics.Snapshot()
+
+	if len(snapshot) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", snapshot)
+	}
+
+	// Ordered by operation
+	if snapshot[0].Operation != "AnotherOperation" || snapshot[0].Code != 400 || snapshot[0].Calls != 1 {
+		t.Errorf("unexpected entry %+v", snapshot[0])
+	}
+
+	if snapshot[1].Operation != "TestOperation" || snapshot[1].Code != 200 || snapshot[1].Calls != 2 {
+		t.Errorf("unexpected entry %+v", snapshot[1])
+	}
+
+	// The snapshot is a copy
+	snapshot[1].Calls = 100
+	if metrics.Snapshot()[1].Calls != 2 {
+		t.Errorf("expected the snapshot not to change the metrics")
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.record("Encrypt", 200, 0)
+	metrics.record("Encrypt", 200, 0)
+	metrics.record("Decrypt", 400, 0)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+
+	for _, line := range []string{
+		"# TYPE local_kms_requests_total counter",
+		`local_kms_requests_total{operation="Decrypt",code="400"} 1`,
+		`local_kms_requests_total{operation="Encrypt",code="200"} 2`,
+		"# TYPE local_kms_request_duration_seconds_total counter",
+		`local_kms_request_duration_seconds_total{operation="Encrypt",code="200"} 0.000000`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected the line %q, in:\n%s", line, body)
+		}
+	}
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %s", recorder.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/nsmithuk/local-kms/src/attestation"
+	"github.com/nsmithuk/local-kms/src/auth"
+	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+A single request for an operation, as it's passed along the middleware chain.
+*/
+type Call struct {
+	Operation *Operation
+	Request   *http.Request
+
+	/*
+		The request's body, decoded into the operation's input type before any middleware runs, so middleware can
+		inspect it. Nil if the body isn't valid for the operation. The operation is given its own copy, so changes made
+		here have no effect on it.
+	*/
+	Input interface{}
+
+	// The raw body, kept so the operation can be retried
+	body []byte
+
+	// Set by the RequestId middleware
+	RequestId string
+
+	// Headers added to the response
+	Header http.Header
+
+	// Used by the operation, and all middleware, to log against the request
+	Logger *log.Entry
+}
+
+func NewCall(operation *Operation, r *http.Request, logger *log.Logger) *Call {
+	return &Call{
+		Operation: operation,
+		Request:   r,
+		Header:    http.Header{},
+		Logger:    log.NewEntry(logger),
+	}
+}
+
+// Handles a call, returning the response to send.
+type Invoker func(c *Call) Response
+
+/*
+Wraps an Invoker. Middleware can act on the call before passing it to next, act on the response after, or return
+its own response without calling next at all.
+*/
+type Middleware func(next Invoker) Invoker
+
+//------------------------------------------
+
+// How many times an operation is retried, when its changes conflict with concurrent changes.
+const maxConflictRetries = 10
+
+/*
+Passes each call through the middleware chain, then on to its operation.
+*/
+type Dispatcher struct {
+	config   *config.Config
+	database *data.Database
+	verifier *attestation.Verifier
+
+	middleware []Middleware
+}
+
+func NewDispatcher(c *config.Config, d *data.Database, v *attestation.Verifier) *Dispatcher {
+	return &Dispatcher{
+		config:   c,
+		database: d,
+		verifier: v,
+	}
+}
+
+/*
+Adds middleware to the end of the chain, so it runs after all middleware already added, immediately around the
+operation. Middleware must all be added before the first call is dispatched.
+*/
+func (d *Dispatcher) Use(middleware ...Middleware) {
+	d.middleware = append(d.middleware, middleware...)
+}
+
+func (d *Dispatcher) Dispatch(c *Call) Response {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	c.body = body
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if input, err := c.Operation.DecodeInput(body); err == nil {
+		c.Input = input
+	}
+
+	invoke := d.invoke
+
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		invoke = d.middleware[i](invoke)
+	}
+
+	return invoke(c)
+}
+
+/*
+Handles the call. If the operation's changes conflict with a concurrent change to the same key, it's retried against
+the key as it now is, up to maxConflictRetries times. It's only retried if none of its changes were written, so an
+operation writing more than once must make the write that can conflict first.
+*/
+func (d *Dispatcher) invoke(c *Call) Response {
+	for attempt := 0; ; attempt++ {
+		c.Request.Body = io.NopCloser(bytes.NewReader(c.body))
+
+		// Each attempt decodes its own input, so it's unaffected by changes made to it by an earlier attempt
+		database := d.database.Session()
+		handler := NewRequestHandler(c.Request, c.Logger, d.config, database, d.verifier)
+		handler.setInput(c.Operation.DecodeInput(c.body))
+
+		response := c.Operation.handle(handler)
+
+		if !database.Conflicted() || attempt == maxConflictRetries {
+			return response
+		}
+
+		// Retrying would apply the changes already written a second time
+		if database.Written() {
+			c.Logger.Warnf("%s conflicted with a concurrent change after writing, so can't be retried\n", c.Operation.Name)
+			return response
+		}
+
+		c.Logger.Infof("%s conflicted with a concurrent change, so will be retried\n", c.Operation.Name)
+	}
+}
+
+//------------------------------------------
+// Standard middleware
+
+/*
+Gives each call a unique ID, which is returned in the x-amzn-RequestId header and included in all its log entries.
+*/
+func RequestId() Middleware {
+	return func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			c.RequestId = uuid.Must(uuid.NewV4()).String()
+			c.Header.Set("x-amzn-RequestId", c.RequestId)
+			c.Logger = c.Logger.WithField("request_id", c.RequestId)
+
+			return next(c)
+		}
+	}
+}
+
+/*
+Logs each call, and its outcome, at debug level.
+*/
+func Logging() Middleware {
+	return func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			c.Logger.Debugf("%s requested by %s\n", c.Operation.Name, c.Request.RemoteAddr)
+
+			start := time.Now()
+			response := next(c)
+
+			c.Logger.Debugf("%s responded %d in %s\n", c.Operation.Name, response.Code, time.Since(start))
+
+			return response
+		}
+	}
+}
+
+/*
+Requires each call to be signed by one of the registry's credentials, for one of the valid regions. The credential is
+added to the request's context, and the call is then made as its principal.
+*/
+func Authentication(registry *auth.Registry, validRegion func(string) bool) Middleware {
+	return func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			credential, err := registry.Verify(c.Request, "kms", validRegion)
+			if err != nil {
+				if e, ok := err.(*auth.Error); ok {
+					c.Logger.Warnf("Request signature verification failed: %s", e)
+					return New400ExceptionResponse(e.Type, e.Message)
+				}
+
+				c.Logger.Error(err)
+				return NewInternalFailureExceptionResponse(err.Error())
+			}
+
+			c.Request = c.Request.WithContext(auth.WithCredential(c.Request.Context(), credential))
+
+			return next(c)
+		}
+	}
+}
+
+/*
+Limits calls, across all operations, to rate per second, allowing bursts of up to burst calls. Calls over the limit
+are rejected with a ThrottlingException.
+*/
+func Throttling(rate float64, burst int) Middleware {
+	var mutex sync.Mutex
+
+	tokens := float64(burst)
+	last := time.Now()
+
+	return func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			mutex.Lock()
+
+			now := time.Now()
+			tokens = math.Min(float64(burst), tokens+now.Sub(last).Seconds()*rate)
+			last = now
+
+			allowed := tokens >= 1
+			if allowed {
+				tokens--
+			}
+
+			mutex.Unlock()
+
+			if !allowed {
+				msg := "Rate exceeded"
+
+				c.Logger.Warnf("%s throttled: %s", c.Operation.Name, msg)
+				return NewThrottlingExceptionResponse(msg)
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/auth"
+	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func newTestDispatcher() *Dispatcher {
+	return NewDispatcher(&config.Config{
+		AWSRegion:    "eu-west-2",
+		AWSAccountId: testAccount,
+	}, data.NewDatabase(data.NewMemoryStorage()), nil)
+}
+
+func newTestCall(operation *Operation) *Call {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	request := httptest.NewRequest("POST", "/", strings.NewReader("{}"))
+	request.Header.Set("X-Amz-Target", "TrentService."+operation.Name)
+
+	return NewCall(operation, request, logger)
+}
+
+/*
+An operation that records it was called, then responds with the passed response.
+*/
+func newTestOperation(events *[]string, response Response) *Operation {
+	return &Operation{
+		Name: "TestOperation",
+		handle: func(r *RequestHandler) Response {
+			*events = append(*events, "operation")
+			return response
+		},
+	}
+}
+
+func recordingMiddleware(events *[]string, name string) Middleware {
+	return func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			*events = append(*events, name+" before")
+			response := next(c)
+			*events = append(*events, name+" after")
+			return response
+		}
+	}
+}
+
+func TestDispatchOrder(t *testing.T) {
+	var events []string
+
+	d := newTestDispatcher()
+	d.Use(recordingMiddleware(&events, "first"), recordingMiddleware(&events, "second"))
+	d.Use(recordingMiddleware(&events, "third"))
+
+	response := d.Dispatch(newTestCall(newTestOperation(&events, NewResponse(200, nil))))
+	if response.Code != 200 {
+		t.Errorf("expected a 200, got %d", response.Code)
+	}
+
+	expected := "first before, second before, third before, operation, third after, second after, first after"
+	if strings.Join(events, ", ") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(events, ", "))
+	}
+}
+
+func TestMiddlewareShortCircuits(t *testing.T) {
+	var events []string
+
+	d := newTestDispatcher()
+	d.Use(recordingMiddleware(&events, "outer"))
+	d.Use(func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			return NewAccessDeniedExceptionResponse("denied by middleware")
+		}
+	})
+
+	response := d.Dispatch(newTestCall(newTestOperation(&events, NewResponse(200, nil))))
+	if response.Code != 400 || !strings.Contains(response.Body, "denied by middleware") {
+		t.Errorf("expected the middleware's response, got %d: %s", response.Code, response.Body)
+	}
+
+	if strings.Join(events, ", ") != "outer before, outer after" {
+		t.Errorf("the operation shouldn't have been called: %v", events)
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	var events []string
+	var requestId string
+
+	d := newTestDispatcher()
+	d.Use(RequestId(), func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			requestId = c.Logger.Data["request_id"].(string)
+			return next(c)
+		}
+	})
+
+	call := newTestCall(newTestOperation(&events, NewResponse(200, nil)))
+	d.Dispatch(call)
+
+	if call.RequestId == "" || call.Header.Get("x-amzn-RequestId") != call.RequestId || requestId != call.RequestId {
+		t.Errorf("expected the request ID in the header and log fields, got %q, %q and %q",
+			call.RequestId, call.Header.Get("x-amzn-RequestId"), requestId)
+	}
+
+	other := newTestCall(newTestOperation(&events, NewResponse(200, nil)))
+	d.Dispatch(other)
+
+	if other.RequestId == call.RequestId {
+		t.Errorf("expected each call to have its own request ID")
+	}
+}
+
+func TestThrottling(t *testing.T) {
+	var events []string
+
+	// A negligible rate, so only the burst is allowed
+	d := newTestDispatcher()
+	d.Use(Throttling(0.001, 2))
+
+	for i := 1; i <= 3; i++ {
+		response := d.Dispatch(newTestCall(newTestOperation(&events, NewResponse(200, nil))))
+
+		if i <= 2 && response.Code != 200 {
+			t.Errorf("call %d: expected a 200, got %d: %s", i, response.Code, response.Body)
+		}
+
+		if i == 3 && (response.Code != 400 || !strings.Contains(response.Body, "ThrottlingException")) {
+			t.Errorf("call %d: expected a ThrottlingException, got %d: %s", i, response.Code, response.Body)
+		}
+	}
+
+	if len(events) != 2 {
+		t.Errorf("expected the operation to be called twice, got %d", len(events))
+	}
+}
+
+func TestAuthenticationRejectsUnsignedCalls(t *testing.T) {
+	var events []string
+
+	d := newTestDispatcher()
+	d.Use(Authentication(&auth.Registry{}, func(string) bool { return true }))
+
+	response := d.Dispatch(newTestCall(newTestOperation(&events, NewResponse(200, nil))))
+	if response.Code != 400 || !strings.Contains(response.Body, "MissingAuthenticationTokenException") {
+		t.Errorf("expected a MissingAuthenticationTokenException, got %d: %s", response.Code, response.Body)
+	}
+
+	if len(events) != 0 {
+		t.Errorf("the operation shouldn't have been called")
+	}
+}
+
+func TestLookupOperation(t *testing.T) {
+	if operation, ok := LookupOperation("Encrypt"); !ok || operation.Name != "Encrypt" {
+		t.Errorf("expected Encrypt to be supported")
+	}
+
+	// Methods of RequestHandler that aren't operations can't be requested
+	for _, name := range []string{"", "getKey", "GetKey", "ResolveKeyArn", "encrypt"} {
+		if _, ok := LookupOperation(name); ok {
+			t.Errorf("expected %q not to be an operation", name)
+		}
+	}
+
+	list := Operations()
+	for i, operation := range list {
+		if operation.handle == nil {
+			t.Errorf("%s has no handler", operation.Name)
+		}
+		if i > 0 && list[i-1].Name >= operation.Name {
+			t.Errorf("expected the operations to be ordered by name: %s, %s", list[i-1].Name, operation.Name)
+		}
+	}
+}
+
+/*
+Saves the key, after it's been changed concurrently the first time the operation is called.
+*/
+func newConflictingOperation(t *testing.T, d *Dispatcher, arn string, attempts *int, before func(r *RequestHandler)) *Operation {
+	return &Operation{
+		Name: "TestOperation",
+		handle: func(r *RequestHandler) Response {
+			*attempts++
+
+			key, err := r.database.LoadKey(arn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *attempts == 1 {
+				concurrent, _ := d.database.LoadKey(arn)
+				if err := d.database.SaveKey(concurrent); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before(r)
+
+			description := "changed"
+			key.GetMetadata().Description = &description
+
+			if err := r.database.SaveKey(key); err != nil {
+				return NewInternalFailureExceptionResponse(err.Error())
+			}
+			return NewResponse(200, nil)
+		},
+	}
+}
+
+func TestConflictRetried(t *testing.T) {
+	d := newTestDispatcher()
+	key := newTestKey(t, &RequestHandler{config: d.config, database: d.database}, "")
+
+	var attempts int
+	operation := newConflictingOperation(t, d, key.GetArn(), &attempts, func(r *RequestHandler) {})
+
+	if response := d.Dispatch(newTestCall(operation)); response.Code != 200 {
+		t.Errorf("expected a 200, got %d: %s", response.Code, response.Body)
+	}
+
+	if attempts != 2 {
+		t.Errorf("expected the operation to be retried once, got %d attempts", attempts)
+	}
+}
+
+func TestConflictNotRetriedAfterWriting(t *testing.T) {
+	d := newTestDispatcher()
+	key := newTestKey(t, &RequestHandler{config: d.config, database: d.database}, "")
+
+	var attempts int
+	operation := newConflictingOperation(t, d, key.GetArn(), &attempts, func(r *RequestHandler) {
+		// Written before the conflicting save, so would be repeated by a retry
+		r.database.SaveAlias(&data.Alias{AliasArn: r.arnPrefix() + "alias/written", AliasName: "alias/written"})
+	})
+
+	response := d.Dispatch(newTestCall(operation))
+	if response.Code != 500 || !strings.Contains(response.Body, "changed concurrently") {
+		t.Errorf("expected the conflict to be returned, got %d: %s", response.Code, response.Body)
+	}
+
+	if attempts != 1 {
+		t.Errorf("expected the operation not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestMiddlewareInspectsInput(t *testing.T) {
+	operation, _ := LookupOperation("Encrypt")
+
+	var keyId string
+
+	d := newTestDispatcher()
+	d.Use(func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			if input, ok := c.Input.(*kms.EncryptInput); ok && input.KeyId != nil {
+				keyId = *input.KeyId
+			}
+			return next(c)
+		}
+	})
+
+	c := newTestCall(operation)
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"KeyId": "alias/inspected"}`))
+
+	d.Dispatch(c)
+
+	if keyId != "alias/inspected" {
+		t.Errorf("expected the middleware to see the KeyId, got %q", keyId)
+	}
+}
+
+func TestOperationInputTypes(t *testing.T) {
+	d := newTestDispatcher()
+
+	for _, operation := range Operations() {
+		logger, hook := test.NewNullLogger()
+
+		c := newTestCall(operation)
+		c.Logger = log.NewEntry(logger)
+
+		d.Dispatch(c)
+
+		// A handler decoding into a different type than its operation's input type logs an error
+		for _, entry := range hook.AllEntries() {
+			if entry.Level <= log.ErrorLevel {
+				t.Errorf("%s: %s", operation.Name, entry.Message)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+/*
+A KMS operation that can be requested, via the X-Amz-Target header.
+*/
+type Operation struct {
+	Name string
+
+	// The type the request's body is decoded into
+	Input reflect.Type
+
+	handle func(*RequestHandler) Response
+}
+
+/*
+Returns a pointer to a new, empty, value of the operation's input type.
+*/
+func (o *Operation) NewInput() interface{} {
+	return reflect.New(o.Input).Interface()
+}
+
+/*
+Decodes the JSON body into a new value of the operation's input type. Returns nil if the operation has no input type.
+*/
+func (o *Operation) DecodeInput(body []byte) (interface{}, error) {
+	if o.Input == nil {
+		return nil, nil
+	}
+
+	input := o.NewInput()
+	err := json.Unmarshal(body, input)
+	return input, err
+}
+
+//------------------------------------------
+
+/*
+Every operation LKMS supports, with the type of its input. Only these can be requested.
+*/
+var operations = newOperationRegistry(
+	newOperation("CancelKeyDeletion", kms.CancelKeyDeletionInput{}, (*RequestHandler).CancelKeyDeletion),
+	newOperation("ConnectCustomKeyStore", kms.ConnectCustomKeyStoreInput{}, (*RequestHandler).ConnectCustomKeyStore),
+	newOperation("CreateAlias", kms.CreateAliasInput{}, (*RequestHandler).CreateAlias),
+	newOperation("CreateCustomKeyStore", kms.CreateCustomKeyStoreInput{}, (*RequestHandler).CreateCustomKeyStore),
+	newOperation("CreateGrant", kms.CreateGrantInput{}, (*RequestHandler).CreateGrant),
+	newOperation("CreateKey", kms.CreateKeyInput{}, (*RequestHandler).CreateKey),
+	newOperation("Decrypt", DecryptInput{}, (*RequestHandler).Decrypt),
+	newOperation("DeleteAlias", kms.DeleteAliasInput{}, (*RequestHandler).DeleteAlias),
+	newOperation("DeleteCustomKeyStore", kms.DeleteCustomKeyStoreInput{}, (*RequestHandler).DeleteCustomKeyStore),
+	newOperation("DeleteImportedKeyMaterial", kms.DeleteImportedKeyMaterialInput{}, (*RequestHandler).DeleteImportedKeyMaterial),
+	newOperation("DeriveSharedSecret", DeriveSharedSecretInput{}, (*RequestHandler).DeriveSharedSecret),
+	newOperation("DescribeCustomKeyStores", kms.DescribeCustomKeyStoresInput{}, (*RequestHandler).DescribeCustomKeyStores),
+	newOperation("DescribeKey", kms.DescribeKeyInput{}, (*RequestHandler).DescribeKey),
+	newOperation("DisableKey", kms.DisableKeyInput{}, (*RequestHandler).DisableKey),
+	newOperation("DisableKeyRotation", kms.DisableKeyRotationInput{}, (*RequestHandler).DisableKeyRotation),
+	newOperation("DisconnectCustomKeyStore", kms.DisconnectCustomKeyStoreInput{}, (*RequestHandler).DisconnectCustomKeyStore),
+	newOperation("EnableKey", kms.EnableKeyInput{}, (*RequestHandler).EnableKey),
+	newOperation("EnableKeyRotation", EnableKeyRotationInput{}, (*RequestHandler).EnableKeyRotation),
+	newOperation("Encrypt", kms.EncryptInput{}, (*RequestHandler).Encrypt),
+	newOperation("GenerateDataKey", GenerateDataKeyInput{}, (*RequestHandler).GenerateDataKey),
+	newOperation("GenerateDataKeyPair", kms.GenerateDataKeyPairInput{}, (*RequestHandler).GenerateDataKeyPair),
+	newOperation("GenerateDataKeyPairWithoutPlaintext", kms.GenerateDataKeyPairInput{}, (*RequestHandler).GenerateDataKeyPairWithoutPlaintext),
+	newOperation("GenerateDataKeyWithoutPlaintext", GenerateDataKeyInput{}, (*RequestHandler).GenerateDataKeyWithoutPlaintext),
+	newOperation("GenerateMac", kms.GenerateMacInput{}, (*RequestHandler).GenerateMac),
+	newOperation("GenerateRandom", GenerateRandomInput{}, (*RequestHandler).GenerateRandom),
+	newOperation("GetKeyPolicy", kms.GetKeyPolicyInput{}, (*RequestHandler).GetKeyPolicy),
+	newOperation("GetKeyRotationStatus", kms.GetKeyRotationStatusInput{}, (*RequestHandler).GetKeyRotationStatus),
+	newOperation("GetParametersForImport", kms.GetParametersForImportInput{}, (*RequestHandler).GetParametersForImport),
+	newOperation("GetPublicKey", kms.GetPublicKeyInput{}, (*RequestHandler).GetPublicKey),
+	newOperation("ImportKeyMaterial", ImportKeyMaterialInput{}, (*RequestHandler).ImportKeyMaterial),
+	newOperation("ListAliases", kms.ListAliasesInput{}, (*RequestHandler).ListAliases),
+	newOperation("ListGrants", kms.ListGrantsInput{}, (*RequestHandler).ListGrants),
+	newOperation("ListKeyRotations", ListKeyRotationsInput{}, (*RequestHandler).ListKeyRotations),
+	newOperation("ListKeys", kms.ListKeysInput{}, (*RequestHandler).ListKeys),
+	newOperation("ListResourceTags", kms.ListResourceTagsInput{}, (*RequestHandler).ListResourceTags),
+	newOperation("ListRetirableGrants", kms.ListRetirableGrantsInput{}, (*RequestHandler).ListRetirableGrants),
+	newOperation("PutKeyPolicy", kms.PutKeyPolicyInput{}, (*RequestHandler).PutKeyPolicy),
+	newOperation("ReEncrypt", kms.ReEncryptInput{}, (*RequestHandler).ReEncrypt),
+	newOperation("ReplicateKey", kms.ReplicateKeyInput{}, (*RequestHandler).ReplicateKey),
+	newOperation("RetireGrant", kms.RetireGrantInput{}, (*RequestHandler).RetireGrant),
+	newOperation("RevokeGrant", kms.RevokeGrantInput{}, (*RequestHandler).RevokeGrant),
+	newOperation("RotateKeyOnDemand", RotateKeyOnDemandInput{}, (*RequestHandler).RotateKeyOnDemand),
+	newOperation("ScheduleKeyDeletion", kms.ScheduleKeyDeletionInput{}, (*RequestHandler).ScheduleKeyDeletion),
+	newOperation("Sign", kms.SignInput{}, (*RequestHandler).Sign),
+	newOperation("TagResource", kms.TagResourceInput{}, (*RequestHandler).TagResource),
+	newOperation("UntagResource", kms.UntagResourceInput{}, (*RequestHandler).UntagResource),
+	newOperation("UpdateAlias", kms.UpdateAliasInput{}, (*RequestHandler).UpdateAlias),
+	newOperation("UpdateCustomKeyStore", kms.UpdateCustomKeyStoreInput{}, (*RequestHandler).UpdateCustomKeyStore),
+	newOperation("UpdateKeyDescription", kms.UpdateKeyDescriptionInput{}, (*RequestHandler).UpdateKeyDescription),
+	newOperation("UpdatePrimaryRegion", kms.UpdatePrimaryRegionInput{}, (*RequestHandler).UpdatePrimaryRegion),
+	newOperation("Verify", kms.VerifyInput{}, (*RequestHandler).Verify),
+	newOperation("VerifyMac", kms.VerifyMacInput{}, (*RequestHandler).VerifyMac),
+)
+
+func newOperation(name string, input interface{}, handle func(*RequestHandler) Response) *Operation {
+	return &Operation{
+		Name:   name,
+		Input:  reflect.TypeOf(input),
+		handle: handle,
+	}
+}
+
+func newOperationRegistry(list ...*Operation) map[string]*Operation {
+	registry := make(map[string]*Operation, len(list))
+	for _, o := range list {
+		registry[o.Name] = o
+	}
+	return registry
+}
+
+/*
+Returns the operation with the given name, or false if it's not supported.
+*/
+func LookupOperation(name string) (*Operation, bool) {
+	operation, ok := operations[name]
+	return operation, ok
+}
+
+/*
+Returns all supported operations, ordered by name.
+*/
+func Operations() []*Operation {
+	list := make([]*Operation, 0, len(operations))
+	for _, o := range operations {
+		list = append(list, o)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
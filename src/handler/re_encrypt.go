@@ -52,61 +52,71 @@ func (r *RequestHandler) ReEncrypt() Response {
 	}
 
 	//--------------------------------
-	// Decrypt
+	// Source key
 
 	keyArn, keySourceVersion, ciphertext, _ := service.UnpackCiphertextBlob(body.CiphertextBlob)
 
-	keySource, response := r.getUsableKey(keyArn)
+	keySource, response := r.getUsableKey(r.localMultiRegionKeyArn(keyArn))
 
 	// If the response is not empty, there was an error
 	if !response.Empty() {
 		return response
 	}
 
-	//---
-
-	var plaintext []byte
-
-	switch k := keySource.(type) {
-	case *cmk.AesKey:
-
-		plaintext, err = k.Decrypt(keySourceVersion, ciphertext, body.SourceEncryptionContext)
-		if err != nil {
-			msg := fmt.Sprintf("Unable to decode Ciphertext: %s", err)
-			r.logger.Warnf(msg)
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
 
-			return NewInvalidCiphertextExceptionResponse("")
-		}
+	response = r.checkKeyPolicy(keySource, "kms:ReEncryptFrom", body.SourceEncryptionContext)
+	if !response.Empty() {
+		return response
+	}
 
-	default:
+	aesKeySource, ok := keySource.(*cmk.AesKey)
+	if !ok {
 		return NewInternalFailureExceptionResponse("key type not yet supported for encryption")
 	}
 
 	//--------------------------------
-	// Encrypt
+	// Destination key
 
-	keyDestination, response := r.getUsableKey(*body.DestinationKeyId)
+	keyDestination, response := r.getKeyByParameter("destinationKeyId", *body.DestinationKeyId)
+	if keyDestination == nil {
+		return response
+	}
 
-	// If the response is not empty, there was an error
+	response = r.checkKeyState(keyDestination)
 	if !response.Empty() {
 		return response
 	}
 
-	//---
+	response = r.checkKeyPolicy(keyDestination, "kms:ReEncryptTo", body.DestinationEncryptionContext)
+	if !response.Empty() {
+		return response
+	}
 
-	var cipherResponse []byte
+	aesKeyDestination, ok := keyDestination.(*cmk.AesKey)
+	if !ok {
+		return NewInternalFailureExceptionResponse("key type not yet supported for encryption")
+	}
+
+	// Every check has passed; nothing is decrypted or encrypted for a dry run.
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
 
-	switch k := keyDestination.(type) {
-	case *cmk.AesKey:
+	//--------------------------------
+	// Decrypt, then encrypt
 
-		cipherResponse, err = k.EncryptAndPackage(plaintext, body.DestinationEncryptionContext)
-		if err != nil {
-			r.logger.Error(err.Error())
-			return NewInternalFailureExceptionResponse(err.Error())
-		}
+	plaintext, response := r.decryptSymmetric(aesKeySource, keySourceVersion, ciphertext, body.SourceEncryptionContext)
+	if !response.Empty() {
+		return response
+	}
 
-	default:
-		return NewInternalFailureExceptionResponse("key type not yet supported for encryption")
+	cipherResponse, response := r.encryptAndPackage(aesKeyDestination, plaintext, body.DestinationEncryptionContext)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/nsmithuk/local-kms/src/attestation"
+)
+
+// Custom struct, as Recipient isn't supported by the AWS library version in use.
+type RecipientInfo struct {
+	AttestationDocument    []byte
+	KeyEncryptionAlgorithm *string
+}
+
+/*
+Validates the recipient's attestation document, returning the public key from it that the response is encrypted to.
+*/
+func (r *RequestHandler) recipientPublicKey(recipient *RecipientInfo) (*rsa.PublicKey, Response) {
+
+	if recipient.KeyEncryptionAlgorithm != nil && *recipient.KeyEncryptionAlgorithm != "RSAES_OAEP_SHA_256" {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'recipient.keyEncryptionAlgorithm' failed to "+
+			"satisfy constraint: Member must satisfy enum value set: [RSAES_OAEP_SHA_256]", *recipient.KeyEncryptionAlgorithm)
+
+		r.logger.Warnf(msg)
+		return nil, NewValidationExceptionResponse(msg)
+	}
+
+	if len(recipient.AttestationDocument) < 1 || len(recipient.AttestationDocument) > 262144 {
+		msg := "1 validation error detected: Value at 'recipient.attestationDocument' failed to satisfy constraint: " +
+			"Member must have length greater than or equal to 1 and less than or equal to 262144"
+
+		r.logger.Warnf(msg)
+		return nil, NewValidationExceptionResponse(msg)
+	}
+
+	if r.verifier == nil {
+		msg := "Attestation documents cannot be validated, as no attestation root certificate is configured. " +
+			"Set KMS_ATTESTATION_ROOT_PATH to enable recipients."
+
+		r.logger.Warnf(msg)
+		return nil, NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	document, err := r.verifier.Verify(recipient.AttestationDocument)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid attestation document: %s.", err)
+
+		r.logger.Warnf(msg)
+		return nil, NewValidationExceptionResponse(msg)
+	}
+
+	publicKey, err := document.RecipientPublicKey()
+	if err != nil {
+		msg := fmt.Sprintf("Invalid attestation document: %s.", err)
+
+		r.logger.Warnf(msg)
+		return nil, NewValidationExceptionResponse(msg)
+	}
+
+	r.logger.Infof("Attestation document validated for enclave image %x", document.PCRs[0])
+
+	return publicKey, Response{}
+}
+
+/*
+Returns the plaintext encrypted to the recipient, as a CMS EnvelopedData structure.
+*/
+func (r *RequestHandler) ciphertextForRecipient(plaintext []byte, publicKey *rsa.PublicKey) ([]byte, Response) {
+
+	ciphertext, err := attestation.Envelope(plaintext, publicKey)
+	if err != nil {
+		r.logger.Error(err.Error())
+		return nil, NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	return ciphertext, Response{}
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nsmithuk/local-kms/src/attestation"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	log "github.com/sirupsen/logrus"
+)
+
+// The fixture is generated by attestation/testdata/generate.go
+var attestationTestdata = filepath.Join("..", "attestation", "testdata")
+
+func newRecipientTestHandler(t *testing.T, database *data.Database, verifier *attestation.Verifier, body interface{}) *RequestHandler {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRequestHandler(httptest.NewRequest("POST", "/", bytes.NewReader(encoded)), logger, &config.Config{
+		AWSRegion:    "eu-west-2",
+		AWSAccountId: testAccount,
+	}, database, verifier)
+}
+
+func loadAttestationFixture(t *testing.T) (*attestation.Verifier, []byte, *rsa.PrivateKey) {
+	t.Helper()
+
+	verifier, err := attestation.LoadVerifier(filepath.Join(attestationTestdata, "root.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document, err := os.ReadFile(filepath.Join(attestationTestdata, "attestation-document.cbor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(attestationTestdata, "recipient.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(content)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return verifier, document, key.(*rsa.PrivateKey)
+}
+
+/*
+Decrypts CiphertextForRecipient, as the enclave would. The structures are parsed independently of the attestation
+package, so only the CMS EnvelopedData encoding (RFC 5652) is relied on.
+*/
+func decryptCiphertextForRecipient(t *testing.T, ciphertext []byte, key *rsa.PrivateKey) []byte {
+	t.Helper()
+
+	type algorithmIdentifier struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.RawValue `asn1:"optional"`
+	}
+
+	var envelope struct {
+		ContentType asn1.ObjectIdentifier
+		Content     struct {
+			Version        int
+			RecipientInfos []struct {
+				Version                int
+				SubjectKeyIdentifier   []byte `asn1:"tag:0"`
+				KeyEncryptionAlgorithm algorithmIdentifier
+				EncryptedKey           []byte
+			} `asn1:"set"`
+			EncryptedContentInfo struct {
+				ContentType                asn1.ObjectIdentifier
+				ContentEncryptionAlgorithm algorithmIdentifier
+				EncryptedContent           []byte `asn1:"tag:0"`
+			}
+		} `asn1:"explicit,tag:0"`
+	}
+
+	if _, err := asn1.Unmarshal(ciphertext, &envelope); err != nil {
+		t.Fatalf("CiphertextForRecipient isn't CMS EnvelopedData: %s", err)
+	}
+
+	if len(envelope.Content.RecipientInfos) != 1 {
+		t.Fatalf("expected a single recipient")
+	}
+
+	contentKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, envelope.Content.RecipientInfos[0].EncryptedKey, nil)
+	if err != nil {
+		t.Fatalf("unable to decrypt the content encryption key: %s", err)
+	}
+
+	content := envelope.Content.EncryptedContentInfo
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(content.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := make([]byte, len(content.EncryptedContent))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, content.EncryptedContent)
+
+	return plaintext[:len(plaintext)-int(plaintext[len(plaintext)-1])]
+}
+
+func TestGenerateRandomForRecipient(t *testing.T) {
+	verifier, document, recipientKey := loadAttestationFixture(t)
+
+	r := newRecipientTestHandler(t, data.NewDatabase(data.NewMemoryStorage()), verifier, map[string]interface{}{
+		"NumberOfBytes": 32,
+		"Recipient": map[string]interface{}{
+			"AttestationDocument":    document,
+			"KeyEncryptionAlgorithm": "RSAES_OAEP_SHA_256",
+		},
+	})
+
+	response := r.GenerateRandom()
+	if response.Code != 200 {
+		t.Fatalf("expected a 200, got %d: %s", response.Code, response.Body)
+	}
+
+	var output struct {
+		Plaintext              []byte
+		CiphertextForRecipient []byte
+	}
+	if err := json.Unmarshal([]byte(response.Body), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	if output.Plaintext != nil {
+		t.Errorf("the plaintext shouldn't be returned with a recipient")
+	}
+
+	if random := decryptCiphertextForRecipient(t, output.CiphertextForRecipient, recipientKey); len(random) != 32 {
+		t.Errorf("expected 32 random bytes, got %d", len(random))
+	}
+}
+
+func TestDecryptForRecipient(t *testing.T) {
+	verifier, document, recipientKey := loadAttestationFixture(t)
+
+	database := data.NewDatabase(data.NewMemoryStorage())
+	key := newTestKey(t, newRecipientTestHandler(t, database, verifier, nil), "")
+
+	response := newRecipientTestHandler(t, database, verifier, map[string]interface{}{
+		"KeyId":     key.GetArn(),
+		"Plaintext": []byte("for the enclave"),
+	}).Encrypt()
+	if response.Code != 200 {
+		t.Fatalf("expected a 200, got %d: %s", response.Code, response.Body)
+	}
+
+	var encrypted struct{ CiphertextBlob []byte }
+	if err := json.Unmarshal([]byte(response.Body), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	response = newRecipientTestHandler(t, database, verifier, map[string]interface{}{
+		"CiphertextBlob": encrypted.CiphertextBlob,
+		"Recipient": map[string]interface{}{
+			"AttestationDocument": document,
+		},
+	}).Decrypt()
+	if response.Code != 200 {
+		t.Fatalf("expected a 200, got %d: %s", response.Code, response.Body)
+	}
+
+	var output struct {
+		Plaintext              []byte
+		CiphertextForRecipient []byte
+	}
+	if err := json.Unmarshal([]byte(response.Body), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	if output.Plaintext != nil {
+		t.Errorf("the plaintext shouldn't be returned with a recipient")
+	}
+
+	if plaintext := decryptCiphertextForRecipient(t, output.CiphertextForRecipient, recipientKey); string(plaintext) != "for the enclave" {
+		t.Errorf("unexpected plaintext %q", plaintext)
+	}
+}
+
+func TestRecipientRejected(t *testing.T) {
+	verifier, document, _ := loadAttestationFixture(t)
+
+	tampered := append([]byte{}, document...)
+	tampered[len(tampered)-1] ^= 0x01
+
+	tests := map[string]struct {
+		verifier  *attestation.Verifier
+		recipient map[string]interface{}
+		message   string
+	}{
+		"no root certificate configured": {
+			verifier:  nil,
+			recipient: map[string]interface{}{"AttestationDocument": document},
+			message:   "no attestation root certificate is configured",
+		},
+		"tampered document": {
+			verifier:  verifier,
+			recipient: map[string]interface{}{"AttestationDocument": tampered},
+			message:   "Invalid attestation document",
+		},
+		"unsupported key encryption algorithm": {
+			verifier: verifier,
+			recipient: map[string]interface{}{
+				"AttestationDocument":    document,
+				"KeyEncryptionAlgorithm": "RSAES_OAEP_SHA_1",
+			},
+			message: "recipient.keyEncryptionAlgorithm",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newRecipientTestHandler(t, data.NewDatabase(data.NewMemoryStorage()), test.verifier, map[string]interface{}{
+				"NumberOfBytes": 32,
+				"Recipient":     test.recipient,
+			})
+
+			response := r.GenerateRandom()
+			if response.Code != 400 || !strings.Contains(response.Body, "ValidationException") ||
+				!strings.Contains(response.Body, test.message) {
+				t.Errorf("expected a ValidationException containing %q, got %s", test.message, response.Body)
+			}
+		})
+	}
+}
+
+func TestDecryptForRecipientRejectsAsymmetricKeys(t *testing.T) {
+	verifier, document, _ := loadAttestationFixture(t)
+
+	database := data.NewDatabase(data.NewMemoryStorage())
+
+	key, err := cmk.NewRsaKey(cmk.SpecRsa2048, cmk.UsageEncryptDecrypt, cmk.KeyMetadata{
+		AWSAccountId: testAccount,
+		Arn:          "arn:aws:kms:eu-west-2:111122223333:key/7c1a9e52-3b4d-4f6e-8a9b-0c1d2e3f4a5b",
+		KeyId:        "7c1a9e52-3b4d-4f6e-8a9b-0c1d2e3f4a5b",
+		Enabled:      true,
+		KeyState:     cmk.KeyStateEnabled,
+	}, "", cmk.KeyOriginAwsKms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.SaveKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := key.Encrypt([]byte("for the enclave"), cmk.EncryptionAlgorithmRsaOaepSha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := newRecipientTestHandler(t, database, verifier, map[string]interface{}{
+		"KeyId":               key.GetArn(),
+		"CiphertextBlob":      ciphertext,
+		"EncryptionAlgorithm": cmk.EncryptionAlgorithmRsaOaepSha256,
+		"Recipient": map[string]interface{}{
+			"AttestationDocument": document,
+		},
+	}).Decrypt()
+
+	if response.Code != 400 || !strings.Contains(response.Body, "ValidationException") {
+		t.Errorf("expected a ValidationException, got %d: %s", response.Code, response.Body)
+	}
+}
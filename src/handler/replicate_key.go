@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
+)
+
+var regionPattern = regexp.MustCompile(`^([a-z]+-){2,3}\d+$`)
+
+func (r *RequestHandler) ReplicateKey() Response {
+
+	var body *kms.ReplicateKeyInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.ReplicateKeyInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.ReplicaRegion == nil {
+		msg := "1 validation error detected: Value null at 'replicaRegion' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(*body.ReplicaRegion) > 32 || !regionPattern.MatchString(*body.ReplicaRegion) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'replicaRegion' failed to satisfy constraint: "+
+			"Member must satisfy regular expression pattern: ^([a-z]+-){2,3}\\d+$", *body.ReplicaRegion)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.Description != nil && len(*body.Description) > 8192 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'description' failed to satisfy "+
+			"constraint: Member must have length less than or equal to 8192", *body.Description)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.Policy != nil && len(*body.Policy) > 32768 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'policy' failed to satisfy "+
+			"constraint: Member must have length less than or equal to 32768", *body.Policy)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	response := r.validateTags(body.Tags)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	key, response := r.getKey(*body.KeyId)
+	if key == nil {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:ReplicateKey", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	c := key.GetMetadata().MultiRegionConfiguration
+
+	if c == nil {
+		msg := fmt.Sprintf("%s is not a multi-Region key.", key.GetArn())
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if c.MultiRegionKeyType != cmk.MultiRegionKeyTypePrimary {
+		msg := fmt.Sprintf("%s is a multi-Region replica key. Only primary keys can be replicated.", key.GetArn())
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if response := r.checkKeyState(key); !response.Empty() {
+		return response
+	}
+
+	//---
+
+	region := *body.ReplicaRegion
+
+	if !r.config.IsServedRegion(region) {
+		msg := fmt.Sprintf("Local KMS is not configured to serve region %s. Add it to KMS_ADDITIONAL_REGIONS "+
+			"to replicate keys into it.", region)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if region == c.PrimaryKey.Region {
+		msg := fmt.Sprintf("Cannot replicate %s into region %s, as it's the region of the primary key.", key.GetArn(), region)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	replicaArn := r.config.ArnPrefixForRegion(region) + "key/" + key.GetMetadata().KeyId
+
+	if existing, _ := r.database.LoadKey(replicaArn); existing != nil {
+		msg := fmt.Sprintf("Key %s already exists.", replicaArn)
+
+		r.logger.Warnf(msg)
+		return NewAlreadyExistsExceptionResponse(msg)
+	}
+
+	if body.Policy != nil {
+		response = r.validateKeyPolicy(replicaArn, *body.Policy, body.BypassPolicyLockoutSafetyCheck)
+		if !response.Empty() {
+			return response
+		}
+	}
+
+	//--------------------------------
+	// Create the replica
+
+	// The replica shares the key material and shared properties of the primary key
+	replica, err := data.CloneKey(key)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	// The replica is a new key, rather than a new version of the primary
+	replica.SetVersion(0)
+
+	metadata := replica.GetMetadata()
+	metadata.Arn = replicaArn
+	metadata.CreationDate = time.Now().Unix()
+	metadata.Enabled = true
+	metadata.KeyState = cmk.KeyStateEnabled
+	metadata.DeletionDate = 0
+	metadata.PendingDeletionWindowInDays = 0
+
+	if body.Description != nil {
+		metadata.Description = body.Description
+	}
+
+	if body.Policy != nil {
+		replica.SetPolicy(*body.Policy)
+	} else {
+		replica.SetPolicy(r.defaultKeyPolicy())
+	}
+
+	c.ReplicaKeys = append(c.ReplicaKeys, cmk.MultiRegionKey{
+		Arn:    replicaArn,
+		Region: region,
+	})
+
+	metadata.MultiRegionConfiguration = c
+
+	tags := make([]*data.Tag, 0, len(body.Tags))
+
+	for _, kv := range body.Tags {
+		tags = append(tags, &data.Tag{
+			TagKey:   *kv.TagKey,
+			TagValue: *kv.TagValue,
+		})
+	}
+
+	//--------------------------------
+	// Save the keys
+
+	// Saved together with the primary, whose new configuration is propagated to all of its other replicas
+	err = r.database.SaveReplicaKey(replica, tags, key)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("Key replicated: %s to %s\n", key.GetArn(), replicaArn)
+
+	// Reload to get the replica's view of the configuration
+	replica, err = r.database.LoadKey(replicaArn)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	for _, t := range tags {
+		r.logger.Infof("New tag created: %s / %s\n", t.TagKey, t.TagValue)
+	}
+
+	replicaTags := body.Tags
+	if replicaTags == nil {
+		replicaTags = make([]*kms.Tag, 0)
+	}
+
+	//---
+
+	return NewResponse(200, &struct {
+		ReplicaKeyMetadata *cmk.KeyMetadata
+		ReplicaPolicy      string
+		ReplicaTags        []*kms.Tag
+	}{
+		ReplicaKeyMetadata: replica.GetMetadata(),
+		ReplicaPolicy:      replica.GetPolicy(),
+		ReplicaTags:        replicaTags,
+	})
+}
@@ -1,9 +1,17 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"strings"
 
+	"github.com/nsmithuk/local-kms/src/attestation"
+	"github.com/nsmithuk/local-kms/src/auth"
+	"github.com/nsmithuk/local-kms/src/config"
 	"github.com/nsmithuk/local-kms/src/data"
 	log "github.com/sirupsen/logrus"
 )
@@ -13,24 +21,160 @@ import (
 
 type RequestHandler struct {
 	request  *http.Request
-	logger   *log.Logger
+	logger   log.FieldLogger
+	config   *config.Config
 	database *data.Database
+	region   string
+
+	// Validates recipients' attestation documents. Nil if recipients aren't supported.
+	verifier *attestation.Verifier
+
+	// The calling principal's ARN and account
+	principal string
+	account   string
+
+	// Set when the request's DryRun parameter is true
+	dryRun bool
+
+	// The request's input, as decoded by the Dispatcher using the operation's input type
+	input    interface{}
+	inputErr error
+	hasInput bool
 }
 
-func NewRequestHandler(r *http.Request, l *log.Logger, d *data.Database) *RequestHandler {
-	return &RequestHandler{
+func NewRequestHandler(r *http.Request, l log.FieldLogger, c *config.Config, d *data.Database, v *attestation.Verifier) *RequestHandler {
+	h := &RequestHandler{
 		request:  r,
 		logger:   l,
+		config:   c,
 		database: d,
+		region:   requestRegion(r, c),
+		verifier: v,
+
+		principal: c.CallerPrincipal,
+		account:   c.AWSAccountId,
+	}
+
+	// If the request's signature was verified, it's made as the signing credential's principal.
+	if c, ok := auth.CredentialFrom(r.Context()); ok {
+		h.principal = c.Principal
+		h.account = c.AccountId
+	}
+
+	return h
+}
+
+/*
+Returns the region the request was signed for, taken from the credential scope of the Authorization header.
+
+	The format is:	AWS4-HMAC-SHA256 Credential=<access key>/<date>/<region>/kms/aws4_request, ...
+
+If the region is not one this instance serves, the default region is used.
+*/
+func requestRegion(r *http.Request, c *config.Config) string {
+	auth := r.Header.Get("Authorization")
+
+	i := strings.Index(auth, "Credential=")
+	if i == -1 {
+		return c.AWSRegion
+	}
+
+	credential := auth[i+len("Credential="):]
+	if end := strings.IndexAny(credential, ", "); end != -1 {
+		credential = credential[:end]
 	}
+
+	scope := strings.Split(credential, "/")
+	if len(scope) >= 3 && c.IsServedRegion(scope[2]) {
+		return scope[2]
+	}
+
+	return c.AWSRegion
 }
 
 /*
-	Decodes the request's JSON body into the passed interface
+Returns the ARN prefix for the region the request is being handled in
+*/
+func (r *RequestHandler) arnPrefix() string {
+	return r.config.ArnPrefixForRegion(r.region)
+}
+
+/*
+Prefixes the target with the ARN prefix for the request's region, unless it's already an ARN
+*/
+func (r *RequestHandler) ensureArn(prefix, target string) string {
+	return r.config.EnsureArnForRegion(r.region, prefix, target)
+}
+
+/*
+Returns the name of the operation being requested, taken from the X-Amz-Target header. e.g. TrentService.Encrypt
+*/
+func (r *RequestHandler) operation() string {
+	target := strings.Split(r.request.Header.Get("X-Amz-Target"), ".")
+	return target[len(target)-1]
+}
+
+/*
+Sets the request's input, and any error decoding it, as decoded using the operation's input type
+*/
+func (r *RequestHandler) setInput(input interface{}, err error) {
+	r.input = input
+	r.inputErr = err
+	r.hasInput = true
+}
+
+/*
+	Decodes the request's JSON body into the passed interface, a pointer to a pointer to the operation's input type.
+	If the Dispatcher has already decoded the input, that is used instead.
 */
 func (r *RequestHandler) decodeBodyInto(v interface{}) error {
-	decoder := json.NewDecoder(r.request.Body)
-	return decoder.Decode(v)
+	body, err := io.ReadAll(r.request.Body)
+	if err != nil {
+		return err
+	}
+
+	// DryRun isn't part of the SDK's input types, so it's read separately for every operation
+	var options struct {
+		DryRun *bool
+	}
+	if json.Unmarshal(body, &options) == nil && options.DryRun != nil {
+		r.dryRun = *options.DryRun
+	}
+
+	// Handlers created outside of a Dispatcher, as in tests, decode the body themselves
+	if !r.hasInput {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		return decoder.Decode(v)
+	}
+
+	if r.inputErr != nil {
+		return r.inputErr
+	}
+
+	target := reflect.ValueOf(v).Elem()
+	input := reflect.ValueOf(r.input)
+
+	if !input.IsValid() || input.Type() != target.Type() {
+		err := fmt.Errorf("%s's input is %T, but was decoded as %s", r.operation(), r.input, target.Type())
+		r.logger.Error(err)
+		return err
+	}
+
+	target.Set(input)
+	return nil
+}
+
+/*
+Returns a DryRunOperationException if the request's DryRun parameter is set. Handlers call this once the request has
+been validated and authorized, and before the operation has any effect.
+*/
+func (r *RequestHandler) checkDryRun() Response {
+	if !r.dryRun {
+		return Response{}
+	}
+
+	r.logger.Infof("%s would have succeeded, but DryRun is set\n", r.operation())
+	return NewDryRunOperationExceptionResponse()
 }
 
 //--------------------------------------------------------------------
@@ -125,6 +269,10 @@ func NewKMSInvalidSignatureException(message string) Response {
 	return New400ExceptionResponse("KMSInvalidSignatureException", message)
 }
 
+func NewKMSInvalidMacException(message string) Response {
+	return New400ExceptionResponse("KMSInvalidMacException", message)
+}
+
 func NewAccessDeniedExceptionResponse(message string) Response {
 	return New400ExceptionResponseFormatted("AccessDeniedException", message, true)
 }
@@ -145,8 +293,77 @@ func NewIncorrectKeyMaterialExceptionResponse() Response {
 	return New400ExceptionResponse("IncorrectKeyMaterialException", "")
 }
 
+func NewInvalidGrantTokenExceptionResponse(message string) Response {
+	return New400ExceptionResponse("InvalidGrantTokenException", message)
+}
+
+//---
+
+func NewCustomKeyStoreNotFoundExceptionResponse(message string) Response {
+	return New400ExceptionResponse("CustomKeyStoreNotFoundException", message)
+}
+
+func NewCustomKeyStoreNameInUseExceptionResponse(message string) Response {
+	return New400ExceptionResponse("CustomKeyStoreNameInUseException", message)
+}
+
+func NewCustomKeyStoreInvalidStateExceptionResponse(message string) Response {
+	return New400ExceptionResponse("CustomKeyStoreInvalidStateException", message)
+}
+
+func NewCustomKeyStoreHasCMKsExceptionResponse(message string) Response {
+	return New400ExceptionResponse("CustomKeyStoreHasCMKsException", message)
+}
+
+func NewCloudHsmClusterInUseExceptionResponse(message string) Response {
+	return New400ExceptionResponse("CloudHsmClusterInUseException", message)
+}
+
+func NewCloudHsmClusterNotFoundExceptionResponse(message string) Response {
+	return New400ExceptionResponse("CloudHsmClusterNotFoundException", message)
+}
+
+func NewCloudHsmClusterNotRelatedExceptionResponse(message string) Response {
+	return New400ExceptionResponse("CloudHsmClusterNotRelatedException", message)
+}
+
+func NewIncorrectTrustAnchorExceptionResponse(message string) Response {
+	return New400ExceptionResponse("IncorrectTrustAnchorException", message)
+}
+
+func NewXksProxyUriInUseExceptionResponse(message string) Response {
+	return New400ExceptionResponse("XksProxyUriInUseException", message)
+}
+
+func NewXksKeyInvalidConfigurationExceptionResponse(message string) Response {
+	return New400ExceptionResponse("XksKeyInvalidConfigurationException", message)
+}
+
+func NewXksKeyAlreadyInUseExceptionResponse(message string) Response {
+	return New400ExceptionResponse("XksKeyAlreadyInUseException", message)
+}
+
 //---
 
+func NewMalformedPolicyDocumentExceptionResponse(message string) Response {
+	return New400ExceptionResponse("MalformedPolicyDocumentException", message)
+}
+
+func NewLimitExceededExceptionResponse(message string) Response {
+	return New400ExceptionResponse("LimitExceededException", message)
+}
+
+func NewThrottlingExceptionResponse(message string) Response {
+	return New400ExceptionResponse("ThrottlingException", message)
+}
+
+func NewDryRunOperationExceptionResponse() Response {
+	return NewResponse(412, map[string]string{
+		"__type":  "DryRunOperationException",
+		"message": "The request would have succeeded, but the DryRun option is set.",
+	})
+}
+
 func NewInternalFailureExceptionResponse(message string) Response {
 	return NewResponse(500, map[string]string{
 		"__type":  "InternalFailureException",
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/service"
+)
+
+func (r *RequestHandler) RetireGrant() Response {
+
+	var body *kms.RetireGrantInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.RetireGrantInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	var keyArn, grantId string
+
+	if body.GrantToken != nil {
+
+		var ok bool
+		keyArn, grantId, ok = service.UnpackGrantToken(*body.GrantToken)
+		if !ok {
+			r.logger.Warnf("Unable to deconstruct grant token")
+			return NewInvalidGrantTokenExceptionResponse("Grant token is invalid.")
+		}
+
+	} else if body.KeyId != nil && body.GrantId != nil {
+
+		var response Response
+		keyArn, response = r.resolveKeyArn("keyId", *body.KeyId)
+		if !response.Empty() {
+			return response
+		}
+
+		grantId = *body.GrantId
+
+	} else {
+		msg := "Either GrantToken, or both KeyId and GrantId, must be specified."
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	key, _ := r.database.LoadKey(keyArn)
+
+	if key == nil {
+		msg := fmt.Sprintf("Key '%s' does not exist", keyArn)
+
+		r.logger.Warnf(msg)
+		return NewNotFoundExceptionResponse(msg)
+	}
+
+	grant, err := r.database.LoadGrant(key.GetArn(), grantId)
+	if err != nil {
+
+		if body.GrantToken != nil {
+			r.logger.Warnf("Grant %s referenced by grant token does not exist", grantId)
+			return NewInvalidGrantTokenExceptionResponse("Grant token is invalid.")
+		}
+
+		msg := fmt.Sprintf("Grant ID %s not found", grantId)
+
+		r.logger.Warnf(msg)
+		return NewNotFoundExceptionResponse(msg)
+	}
+
+	//---
+
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
+	err = r.database.DeleteGrant(grant)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("Grant retired: %s on %s\n", grant.GrantId, key.GetArn())
+
+	return NewResponse(200, nil)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func (r *RequestHandler) RevokeGrant() Response {
+
+	var body *kms.RevokeGrantInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.RevokeGrantInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.GrantId == nil {
+		msg := "1 validation error detected: Value null at 'grantId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	key, response := r.getKey(*body.KeyId)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:RevokeGrant", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	grant, err := r.database.LoadGrant(key.GetArn(), *body.GrantId)
+	if err != nil {
+		msg := fmt.Sprintf("Grant ID %s not found", *body.GrantId)
+
+		r.logger.Warnf(msg)
+		return NewNotFoundExceptionResponse(msg)
+	}
+
+	//---
+
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
+	err = r.database.DeleteGrant(grant)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("Grant revoked: %s on %s\n", grant.GrantId, key.GetArn())
+
+	return NewResponse(200, nil)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+// Custom struct, as RotateKeyOnDemand isn't supported by the AWS library version in use.
+type RotateKeyOnDemandInput struct {
+	KeyId *string
+}
+
+func (r *RequestHandler) RotateKeyOnDemand() Response {
+
+	var body *RotateKeyOnDemandInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &RotateKeyOnDemandInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	key, response := r.getKey(*body.KeyId)
+	if key == nil {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:RotateKeyOnDemand", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	aesKey, ok := key.(*cmk.AesKey)
+	if !ok {
+		msg := fmt.Sprintf("%s key spec is %s which is not valid for this operation.", key.GetArn(), key.GetMetadata().KeySpec)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if origin := key.GetMetadata().Origin; origin == cmk.KeyOriginExternal || origin == cmk.KeyOriginAwsCloudHsm ||
+		origin == cmk.KeyOriginExternalKeyStore {
+		msg := fmt.Sprintf("%s origin is %s which is not valid for this operation.", key.GetArn(), key.GetMetadata().Origin)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	// Rotation is a shared property of multi-Region keys, so is only managed on the primary key
+	if c := key.GetMetadata().MultiRegionConfiguration; c != nil && c.MultiRegionKeyType != cmk.MultiRegionKeyTypePrimary {
+		msg := fmt.Sprintf("%s is a multi-Region replica key. On-demand rotation can only be performed on the "+
+			"primary key %s.", key.GetArn(), c.PrimaryKey.Arn)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	err = aesKey.RotateOnDemand()
+	if _, ok := err.(*cmk.RotationLimitExceeded); ok {
+		msg := fmt.Sprintf("%s has reached the maximum of %d on-demand rotations.", key.GetArn(), cmk.MaxOnDemandRotations)
+
+		r.logger.Warnf(msg)
+		return NewLimitExceededExceptionResponse(msg)
+	}
+
+	//--------------------------------
+	// Save the key
+
+	err = r.database.SaveMultiRegionKey(key)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	//---
+
+	r.logger.Infof("Key rotated on demand: %s\n", key.GetArn())
+
+	return NewResponse(200, map[string]string{
+		"KeyId": key.GetArn(),
+	})
+}
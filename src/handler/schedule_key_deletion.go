@@ -6,7 +6,6 @@ import (
 
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 )
 
 func (r *RequestHandler) ScheduleKeyDeletion() Response {
@@ -46,33 +45,37 @@ func (r *RequestHandler) ScheduleKeyDeletion() Response {
 
 	//---
 
-	target := config.EnsureArn("key/", *body.KeyId)
-
-	// Lookup the key
-	key, _ := r.database.LoadKey(target)
-
+	key, response := r.getKey(*body.KeyId)
 	if key == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", target)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:ScheduleKeyDeletion", nil)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	if key.GetMetadata().DeletionDate != 0 {
-		// Key is pending deletion; cannot re-schedule
-		msg := fmt.Sprintf("%s is pending deletion.", target)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	key.GetMetadata().Enabled = false
-	key.GetMetadata().KeyState = cmk.KeyStatePendingDeletion
-	key.GetMetadata().DeletionDate = time.Now().AddDate(0, 0, int(PendingWindowInDays)).Unix()
+	cmk.TransitionKeyState(r.operation(), key.GetMetadata())
+
+	key.GetMetadata().PendingDeletionWindowInDays = PendingWindowInDays
+
+	// A multi-Region primary key's waiting period doesn't start until all of its replicas have been deleted.
+	if c := key.GetMetadata().MultiRegionConfiguration; c != nil &&
+		c.MultiRegionKeyType == cmk.MultiRegionKeyTypePrimary && len(c.ReplicaKeys) > 0 {
+
+		key.GetMetadata().KeyState = cmk.KeyStatePendingReplicaDeletion
+	} else {
+		key.GetMetadata().DeletionDate = time.Now().AddDate(0, 0, int(PendingWindowInDays)).Unix()
+	}
 
 	//--------------------------------
 	// Save the key
@@ -87,8 +90,15 @@ func (r *RequestHandler) ScheduleKeyDeletion() Response {
 
 	r.logger.Infof("Schedule key deletion: %s\n", key.GetArn())
 
-	return NewResponse(200, map[string]interface{}{
-		"KeyId":        key.GetArn(),
-		"DeletionDate": key.GetMetadata().DeletionDate,
-	})
+	output := map[string]interface{}{
+		"KeyId":               key.GetArn(),
+		"KeyState":            key.GetMetadata().KeyState,
+		"PendingWindowInDays": PendingWindowInDays,
+	}
+
+	if key.GetMetadata().DeletionDate != 0 {
+		output["DeletionDate"] = key.GetMetadata().DeletionDate
+	}
+
+	return NewResponse(200, output)
 }
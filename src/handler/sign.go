@@ -69,6 +69,16 @@ func (r *RequestHandler) Sign() Response {
 		return response
 	}
 
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:Sign", nil)
+	if !response.Empty() {
+		return response
+	}
+
 	var signingKey cmk.SigningKey
 
 	switch k := key.(type) {
@@ -83,15 +93,26 @@ func (r *RequestHandler) Sign() Response {
 		signingKey = k
 	case *cmk.EccKey:
 
-		if k.GetMetadata().KeyUsage == cmk.UsageEncryptDecrypt {
-			msg := fmt.Sprintf("%s key usage is ENCRYPT_DECRYPT which is not valid for signing.", k.GetArn())
+		if k.GetMetadata().KeyUsage != cmk.UsageSignVerify {
+			msg := fmt.Sprintf("%s key usage is %s which is not valid for signing.", k.GetArn(), k.GetMetadata().KeyUsage)
+			r.logger.Warnf(msg)
+			return NewInvalidKeyUsageException(msg)
+		}
+
+		signingKey = k
+	case *cmk.Ed25519Key:
+		signingKey = k
+	case *cmk.Sm2Key:
+
+		if k.GetMetadata().KeyUsage != cmk.UsageSignVerify {
+			msg := fmt.Sprintf("%s key usage is %s which is not valid for signing.", k.GetArn(), k.GetMetadata().KeyUsage)
 			r.logger.Warnf(msg)
 			return NewInvalidKeyUsageException(msg)
 		}
 
 		signingKey = k
 	default:
-		msg := fmt.Sprintf("%s key usage is ENCRYPT_DECRYPT which is not valid for Sign.", k.GetArn())
+		msg := fmt.Sprintf("%s key usage is %s which is not valid for Sign.", k.GetArn(), k.GetMetadata().KeyUsage)
 		r.logger.Warnf(msg)
 		return NewInvalidKeyUsageException(msg)
 	}
@@ -115,6 +136,11 @@ func (r *RequestHandler) Sign() Response {
 			return NewInvalidKeyUsageException(msg)
 		}
 
+		if _, ok := err.(*cmk.InvalidMessageType); ok {
+			r.logger.Warnf(err.Error())
+			return NewValidationExceptionResponse(err.Error())
+		}
+
 		if _, ok := err.(*cmk.InvalidDigestLength); ok {
 			msg := fmt.Sprintf("Digest is invalid length for algorithm %s.", *body.SigningAlgorithm)
 
@@ -128,6 +154,10 @@ func (r *RequestHandler) Sign() Response {
 
 	//---
 
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
 	r.logger.Infof("%s message signed with %s, using key %s\n", *body.MessageType, signingKey.GetMetadata().CustomerMasterKeySpec, key.GetArn())
 
 	return NewResponse(200, &struct {
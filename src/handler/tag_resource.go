@@ -1,10 +1,7 @@
 package handler
 
 import (
-	"fmt"
-
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/cmk"
 	"github.com/nsmithuk/local-kms/src/data"
 )
 
@@ -46,29 +43,36 @@ func (r *RequestHandler) TagResource() Response {
 		return response
 	}
 
-	switch key.GetMetadata().KeyState {
-	case cmk.KeyStatePendingDeletion:
-		msg := fmt.Sprintf("%s is pending deletion.", *body.KeyId)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:TagResource", nil)
+	if !response.Empty() {
+		return response
+	}
 
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//--------------------------------
 	// Create the tags
 
-	if body.Tags != nil && len(body.Tags) > 0 {
-		for _, kv := range body.Tags {
-			t := &data.Tag{
-				TagKey:   *kv.TagKey,
-				TagValue: *kv.TagValue,
-			}
+	tags := make([]*data.Tag, 0, len(body.Tags))
+
+	for _, kv := range body.Tags {
+		tags = append(tags, &data.Tag{
+			TagKey:   *kv.TagKey,
+			TagValue: *kv.TagValue,
+		})
+	}
 
-			_ = r.database.SaveTag(key, t)
+	err = r.database.SaveTags(key, tags)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
 
-			r.logger.Infof("New tag created: %s / %s\n", t.TagKey, t.TagValue)
-		}
+	for _, t := range tags {
+		r.logger.Infof("New tag created: %s / %s\n", t.TagKey, t.TagValue)
 	}
 
 	//---
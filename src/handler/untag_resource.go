@@ -1,10 +1,7 @@
 package handler
 
 import (
-	"fmt"
-
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/cmk"
 )
 
 func (r *RequestHandler) UntagResource() Response {
@@ -40,22 +37,31 @@ func (r *RequestHandler) UntagResource() Response {
 		return response
 	}
 
-	switch key.GetMetadata().KeyState {
-	case cmk.KeyStatePendingDeletion:
-		msg := fmt.Sprintf("%s is pending deletion.", *body.KeyId)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	response = r.checkKeyPolicy(key, "kms:UntagResource", nil)
+	if !response.Empty() {
+		return response
+	}
 
+	response = r.checkKeyState(key)
+	if !response.Empty() {
+		return response
 	}
 
 	//---
 
-	if len(body.TagKeys) > 0 {
-		for _, k := range body.TagKeys {
-			err = r.database.DeleteObject(key.GetArn() + "/tag/" + *k)
-			r.logger.Infof("Tag deleted: %s\n", *k)
-		}
+	tagKeys := make([]string, 0, len(body.TagKeys))
+	for _, k := range body.TagKeys {
+		tagKeys = append(tagKeys, *k)
+	}
+
+	err = r.database.DeleteTags(key, tagKeys)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	for _, k := range tagKeys {
+		r.logger.Infof("Tag deleted: %s\n", k)
 	}
 
 	return NewResponse(200, nil)
@@ -3,7 +3,7 @@ package handler
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/cmk"
 	"reflect"
 	"strings"
 )
@@ -57,7 +57,7 @@ func (r *RequestHandler) UpdateAlias() Response {
 
 	//---
 
-	aliasArn := config.ArnPrefix() + *body.AliasName
+	aliasArn := r.arnPrefix() + *body.AliasName
 
 	alias, err := r.database.LoadAlias(aliasArn)
 
@@ -70,7 +70,7 @@ func (r *RequestHandler) UpdateAlias() Response {
 
 	//---
 
-	originalKeyArn := config.EnsureArn("key/", alias.TargetKeyId)
+	originalKeyArn := r.ensureArn("key/", alias.TargetKeyId)
 
 	// Lookup the key
 	originalKey, _ := r.database.LoadKey(originalKeyArn)
@@ -83,16 +83,17 @@ func (r *RequestHandler) UpdateAlias() Response {
 
 	//---
 
-	targetKeyArn := config.EnsureArn("key/", *body.TargetKeyId)
-
-	// Lookup the key
-	targetKey, _ := r.database.LoadKey(targetKeyArn)
-
+	targetKey, response := r.getKeyByParameter("targetKeyId", *body.TargetKeyId)
 	if targetKey == nil {
-		msg := fmt.Sprintf("Key '%s' does not exist", targetKeyArn)
+		return response
+	}
 
-		r.logger.Warnf(msg)
-		return NewNotFoundExceptionResponse(msg)
+	// The caller needs permission on both the current and new target keys
+	for _, key := range []cmk.Key{originalKey, targetKey} {
+		response := r.checkKeyPolicy(key, "kms:UpdateAlias", nil)
+		if !response.Empty() {
+			return response
+		}
 	}
 
 	//---
@@ -121,12 +122,8 @@ func (r *RequestHandler) UpdateAlias() Response {
 
 	//---
 
-	if targetKey.GetMetadata().DeletionDate != 0 {
-		// Key is pending deletion; cannot create alias
-		msg := fmt.Sprintf("%s is pending deletion.", targetKeyArn)
-
-		r.logger.Warnf(msg)
-		return NewKMSInvalidStateExceptionResponse(msg)
+	if response := r.checkKeyState(targetKey); !response.Empty() {
+		return response
 	}
 
 	//---
@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/hsm"
+)
+
+func (r *RequestHandler) UpdateCustomKeyStore() Response {
+
+	var body *kms.UpdateCustomKeyStoreInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.UpdateCustomKeyStoreInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.CustomKeyStoreId == nil {
+		msg := "CustomKeyStoreId is a required parameter"
+
+		r.logger.Warnf(msg)
+		return NewMissingParameterResponse(msg)
+	}
+
+	if body.NewCustomKeyStoreName != nil && (len(*body.NewCustomKeyStoreName) < 1 || len(*body.NewCustomKeyStoreName) > 256) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'newCustomKeyStoreName' failed to satisfy "+
+			"constraint: Member must have length between 1 and 256", *body.NewCustomKeyStoreName)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.KeyStorePassword != nil && (len(*body.KeyStorePassword) < 7 || len(*body.KeyStorePassword) > 32) {
+		msg := "1 validation error detected: Value at 'keyStorePassword' failed to satisfy " +
+			"constraint: Member must have length between 7 and 32"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.CloudHsmClusterId != nil && (len(*body.CloudHsmClusterId) < 19 || len(*body.CloudHsmClusterId) > 24) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'cloudHsmClusterId' failed to satisfy "+
+			"constraint: Member must have length between 19 and 24", *body.CloudHsmClusterId)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	store, response := r.getCustomKeyStore(*body.CustomKeyStoreId)
+	if store == nil {
+		return response
+	}
+
+	cloudHsmUpdated := body.KeyStorePassword != nil || body.CloudHsmClusterId != nil
+	xksUpdated := body.XksProxyUriEndpoint != nil || body.XksProxyUriPath != nil || body.XksProxyConnectivity != nil ||
+		body.XksProxyAuthenticationCredential != nil || body.XksProxyVpcEndpointServiceName != nil
+
+	if store.CustomKeyStoreType == data.CustomKeyStoreTypeExternalKeyStore && cloudHsmUpdated {
+		msg := "CloudHSM parameters are not valid for custom key stores of type EXTERNAL_KEY_STORE"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if store.CustomKeyStoreType != data.CustomKeyStoreTypeExternalKeyStore && xksUpdated {
+		msg := "XKS proxy parameters are not valid for custom key stores of type AWS_CLOUDHSM"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	// The password and cluster can only be changed whilst the store is disconnected
+	if cloudHsmUpdated && store.ConnectionState != data.ConnectionStateDisconnected {
+		msg := fmt.Sprintf("Custom key store %s must be disconnected before its password or cluster can be "+
+			"updated.", store.CustomKeyStoreId)
+
+		r.logger.Warnf(msg)
+		return NewCustomKeyStoreInvalidStateExceptionResponse(msg)
+	}
+
+	stores, err := r.database.ListCustomKeyStores(r.arnPrefix())
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	for _, s := range stores {
+		if s.CustomKeyStoreId == store.CustomKeyStoreId {
+			continue
+		}
+
+		if body.NewCustomKeyStoreName != nil && s.CustomKeyStoreName == *body.NewCustomKeyStoreName {
+			msg := fmt.Sprintf("Custom key store name '%s' is already in use.", *body.NewCustomKeyStoreName)
+
+			r.logger.Warnf(msg)
+			return NewCustomKeyStoreNameInUseExceptionResponse(msg)
+		}
+
+		if body.CloudHsmClusterId != nil && s.CloudHsmClusterId == *body.CloudHsmClusterId {
+			msg := fmt.Sprintf("Cluster %s is already associated with custom key store %s.",
+				*body.CloudHsmClusterId, s.CustomKeyStoreId)
+
+			r.logger.Warnf(msg)
+			return NewCloudHsmClusterInUseExceptionResponse(msg)
+		}
+	}
+
+	// A store can only be moved to a related cluster; locally, one that shares its trust anchor.
+	if body.CloudHsmClusterId != nil && *body.CloudHsmClusterId != store.CloudHsmClusterId {
+		cluster, err := hsm.OpenCluster(r.config.HsmPath, *body.CloudHsmClusterId)
+		if err == hsm.ErrClusterNotFound {
+			msg := fmt.Sprintf("Cluster %s does not exist.", *body.CloudHsmClusterId)
+
+			r.logger.Warnf(msg)
+			return NewCloudHsmClusterNotFoundExceptionResponse(msg)
+		} else if err != nil {
+			r.logger.Error(err)
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+		if cluster.TrustAnchorCertificate() != store.TrustAnchorCertificate {
+			msg := fmt.Sprintf("Cluster %s is not related to cluster %s.", *body.CloudHsmClusterId, store.CloudHsmClusterId)
+
+			r.logger.Warnf(msg)
+			return NewCloudHsmClusterNotRelatedExceptionResponse(msg)
+		}
+	}
+
+	if xksUpdated {
+		response = r.updateExternalKeyStore(body, store, stores)
+		if !response.Empty() {
+			return response
+		}
+	}
+
+	//---
+
+	if body.NewCustomKeyStoreName != nil {
+		store.CustomKeyStoreName = *body.NewCustomKeyStoreName
+	}
+
+	if body.KeyStorePassword != nil {
+		store.KeyStorePassword = *body.KeyStorePassword
+	}
+
+	var keys []cmk.Key
+
+	if body.CloudHsmClusterId != nil && *body.CloudHsmClusterId != store.CloudHsmClusterId {
+		store.CloudHsmClusterId = *body.CloudHsmClusterId
+
+		keys, err = r.database.ListKeysInCustomKeyStore(r.arnPrefix()+"key/", store.CustomKeyStoreId)
+		if err != nil {
+			r.logger.Error(err)
+			return NewInternalFailureExceptionResponse(err.Error())
+		}
+
+		for _, key := range keys {
+			key.GetMetadata().CloudHsmClusterId = store.CloudHsmClusterId
+		}
+	}
+
+	// The keys are saved with the store, so either all are updated or none are
+	err = r.database.SaveCustomKeyStoreWithKeys(r.arnPrefix(), store, keys)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	r.logger.Infof("Custom key store updated: %s\n", store.CustomKeyStoreId)
+
+	return NewResponse(200, nil)
+}
+
+/*
+Applies changes to an external key store's proxy configuration. The endpoint and connectivity can only be changed whilst
+the store is disconnected; if it's connected, the proxy must accept the new configuration.
+*/
+func (r *RequestHandler) updateExternalKeyStore(body *kms.UpdateCustomKeyStoreInput, store *data.CustomKeyStore, stores []*data.CustomKeyStore) Response {
+
+	if (body.XksProxyUriEndpoint != nil || body.XksProxyConnectivity != nil) && store.ConnectionState != data.ConnectionStateDisconnected {
+		msg := fmt.Sprintf("Custom key store %s must be disconnected before its XKS proxy endpoint or connectivity "+
+			"can be updated.", store.CustomKeyStoreId)
+
+		r.logger.Warnf(msg)
+		return NewCustomKeyStoreInvalidStateExceptionResponse(msg)
+	}
+
+	configuration := *store.XksProxyConfiguration
+	secret := store.XksProxyRawSecretAccessKey
+
+	if body.XksProxyUriEndpoint != nil {
+		configuration.UriEndpoint = *body.XksProxyUriEndpoint
+	}
+
+	if body.XksProxyUriPath != nil {
+		configuration.UriPath = *body.XksProxyUriPath
+	}
+
+	if body.XksProxyConnectivity != nil {
+		configuration.Connectivity = *body.XksProxyConnectivity
+	}
+
+	if credential := body.XksProxyAuthenticationCredential; credential != nil {
+		configuration.AccessKeyId = ""
+		secret = ""
+
+		if credential.AccessKeyId != nil {
+			configuration.AccessKeyId = *credential.AccessKeyId
+		}
+		if credential.RawSecretAccessKey != nil {
+			secret = *credential.RawSecretAccessKey
+		}
+	}
+
+	response := r.validateXksProxyConfiguration(&configuration, secret, body.XksProxyVpcEndpointServiceName)
+	if !response.Empty() {
+		return response
+	}
+
+	for _, s := range stores {
+		if s.CustomKeyStoreId == store.CustomKeyStoreId {
+			continue
+		}
+
+		if c := s.XksProxyConfiguration; c != nil && c.UriEndpoint == configuration.UriEndpoint && c.UriPath == configuration.UriPath {
+			msg := fmt.Sprintf("The XKS proxy URI %s%s is already associated with custom key store %s.",
+				configuration.UriEndpoint, configuration.UriPath, s.CustomKeyStoreId)
+
+			r.logger.Warnf(msg)
+			return NewXksProxyUriInUseExceptionResponse(msg)
+		}
+	}
+
+	//---
+
+	updated := *store
+	updated.XksProxyConfiguration = &configuration
+	updated.XksProxyRawSecretAccessKey = secret
+
+	if store.ConnectionState == data.ConnectionStateConnected {
+		if _, err := r.connectXksProxy(&updated); err != nil {
+			return r.xksErrorResponse(err)
+		}
+	}
+
+	store.XksProxyConfiguration = updated.XksProxyConfiguration
+	store.XksProxyRawSecretAccessKey = updated.XksProxyRawSecretAccessKey
+
+	return Response{}
+}
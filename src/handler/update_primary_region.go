@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+func (r *RequestHandler) UpdatePrimaryRegion() Response {
+
+	var body *kms.UpdatePrimaryRegionInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.UpdatePrimaryRegionInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.PrimaryRegion == nil {
+		msg := "1 validation error detected: Value null at 'primaryRegion' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(*body.PrimaryRegion) > 32 || !regionPattern.MatchString(*body.PrimaryRegion) {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'primaryRegion' failed to satisfy constraint: "+
+			"Member must satisfy regular expression pattern: ^([a-z]+-){2,3}\\d+$", *body.PrimaryRegion)
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//---
+
+	key, response := r.getKey(*body.KeyId)
+	if key == nil {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:UpdatePrimaryRegion", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	//---
+
+	c := key.GetMetadata().MultiRegionConfiguration
+
+	if c == nil {
+		msg := fmt.Sprintf("%s is not a multi-Region key.", key.GetArn())
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if c.MultiRegionKeyType != cmk.MultiRegionKeyTypePrimary {
+		msg := fmt.Sprintf("%s is a multi-Region replica key. The primary region can only be updated from the "+
+			"primary key %s.", key.GetArn(), c.PrimaryKey.Arn)
+
+		r.logger.Warnf(msg)
+		return NewUnsupportedOperationException(msg)
+	}
+
+	if response := r.checkKeyState(key); !response.Empty() {
+		return response
+	}
+
+	// Nothing to do if the key is already the primary in that region
+	if *body.PrimaryRegion == c.PrimaryKey.Region {
+		return NewResponse(200, nil)
+	}
+
+	//---
+
+	var newPrimary cmk.Key
+	var replicas []cmk.MultiRegionKey
+
+	for _, replica := range c.ReplicaKeys {
+		if replica.Region == *body.PrimaryRegion {
+			newPrimary, _ = r.database.LoadKey(replica.Arn)
+		} else {
+			replicas = append(replicas, replica)
+		}
+	}
+
+	if newPrimary == nil {
+		msg := fmt.Sprintf("%s does not have a replica key in region %s.", key.GetArn(), *body.PrimaryRegion)
+
+		r.logger.Warnf(msg)
+		return NewNotFoundExceptionResponse(msg)
+	}
+
+	if response := r.checkKeyState(newPrimary); !response.Empty() {
+		return response
+	}
+
+	//---
+
+	// The current primary becomes a replica of the new primary
+	replicas = append(replicas, c.PrimaryKey)
+
+	newPrimary.GetMetadata().MultiRegionConfiguration = &cmk.MultiRegionConfiguration{
+		MultiRegionKeyType: cmk.MultiRegionKeyTypePrimary,
+		PrimaryKey: cmk.MultiRegionKey{
+			Arn:    newPrimary.GetArn(),
+			Region: *body.PrimaryRegion,
+		},
+		ReplicaKeys: replicas,
+	}
+
+	//--------------------------------
+	// Save the keys
+
+	// Saving the new primary propagates the new configuration to all of its replicas
+	err = r.database.SaveMultiRegionKey(newPrimary)
+	if err != nil {
+		r.logger.Error(err)
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	//---
+
+	r.logger.Infof("Primary region of %s updated to %s\n", key.GetMetadata().KeyId, *body.PrimaryRegion)
+
+	return NewResponse(200, nil)
+}
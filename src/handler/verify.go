@@ -76,6 +76,16 @@ func (r *RequestHandler) Verify() Response {
 		return response
 	}
 
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:Verify", nil)
+	if !response.Empty() {
+		return response
+	}
+
 	var signingKey cmk.SigningKey
 
 	switch k := key.(type) {
@@ -90,16 +100,27 @@ func (r *RequestHandler) Verify() Response {
 		signingKey = k
 	case *cmk.EccKey:
 
-		if k.GetMetadata().KeyUsage == cmk.UsageEncryptDecrypt {
-			msg := fmt.Sprintf("%s key usage is ENCRYPT_DECRYPT which is not valid for signing.", k.GetArn())
+		if k.GetMetadata().KeyUsage != cmk.UsageSignVerify {
+			msg := fmt.Sprintf("%s key usage is %s which is not valid for signing.", k.GetArn(), k.GetMetadata().KeyUsage)
 
 			r.logger.Warnf(msg)
 			return NewInvalidKeyUsageException(msg)
 		}
 
+		signingKey = k
+	case *cmk.Ed25519Key:
+		signingKey = k
+	case *cmk.Sm2Key:
+
+		if k.GetMetadata().KeyUsage != cmk.UsageSignVerify {
+			msg := fmt.Sprintf("%s key usage is %s which is not valid for signing.", k.GetArn(), k.GetMetadata().KeyUsage)
+			r.logger.Warnf(msg)
+			return NewInvalidKeyUsageException(msg)
+		}
+
 		signingKey = k
 	default:
-		msg := fmt.Sprintf("%s key usage is ENCRYPT_DECRYPT which is not valid for Verify.", k.GetArn())
+		msg := fmt.Sprintf("%s key usage is %s which is not valid for Verify.", k.GetArn(), k.GetMetadata().KeyUsage)
 		r.logger.Warnf(msg)
 		return NewInvalidKeyUsageException(msg)
 	}
@@ -115,12 +136,22 @@ func (r *RequestHandler) Verify() Response {
 	}
 
 	if err != nil {
+
+		if _, ok := err.(*cmk.InvalidMessageType); ok {
+			r.logger.Warnf(err.Error())
+			return NewValidationExceptionResponse(err.Error())
+		}
+
 		r.logger.Error(err.Error())
 		return NewInvalidKeyUsageException(err.Error())
 	}
 
 	//---
 
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
 	r.logger.Infof("%s message verification %t with %s, using key %s\n", *body.MessageType, valid, signingKey.GetMetadata().CustomerMasterKeySpec, key.GetArn())
 
 	if !valid {
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/nsmithuk/local-kms/src/cmk"
+)
+
+func (r *RequestHandler) VerifyMac() Response {
+
+	var body *kms.VerifyMacInput
+	err := r.decodeBodyInto(&body)
+
+	if err != nil {
+		body = &kms.VerifyMacInput{}
+	}
+
+	//--------------------------------
+	// Validation
+
+	if body.KeyId == nil {
+		msg := "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.Message == nil {
+		msg := "1 validation error detected: Value null at 'message' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(body.Message) < 1 || len(body.Message) > 4096 {
+		msg := fmt.Sprintf("1 validation error detected: Value '%s' at 'message' failed to satisfy "+
+			"constraint: Member must have minimum length of 1 and maximum length of 4096.", string(body.Message))
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.Mac == nil {
+		msg := "1 validation error detected: Value null at 'mac' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if len(body.Mac) < 1 || len(body.Mac) > 6144 {
+		msg := "1 validation error detected: Value at 'mac' failed to satisfy constraint: Member must have " +
+			"minimum length of 1 and maximum length of 6144."
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	if body.MacAlgorithm == nil {
+		msg := "1 validation error detected: Value null at 'macAlgorithm' failed to satisfy constraint: Member must not be null"
+
+		r.logger.Warnf(msg)
+		return NewValidationExceptionResponse(msg)
+	}
+
+	//----------------------------------
+
+	key, response := r.getUsableKey(*body.KeyId)
+
+	// If the response is not empty, there was an error
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.validateGrantTokens(body.GrantTokens)
+	if !response.Empty() {
+		return response
+	}
+
+	response = r.checkKeyPolicy(key, "kms:VerifyMac", nil)
+	if !response.Empty() {
+		return response
+	}
+
+	hmacKey, ok := key.(*cmk.HmacKey)
+	if !ok {
+		msg := fmt.Sprintf("%s key usage is %s which is not valid for VerifyMac.", key.GetArn(), key.GetMetadata().KeyUsage)
+
+		r.logger.Warnf(msg)
+		return NewInvalidKeyUsageException(msg)
+	}
+
+	//---
+
+	valid, err := hmacKey.VerifyMac(body.Message, body.Mac, cmk.MacAlgorithm(*body.MacAlgorithm))
+	if err != nil {
+
+		if _, ok := err.(*cmk.InvalidMacAlgorithm); ok {
+			msg := fmt.Sprintf("Algorithm %s is incompatible with key spec %s.", *body.MacAlgorithm, key.GetMetadata().KeySpec)
+
+			r.logger.Warnf(msg)
+			return NewInvalidKeyUsageException(msg)
+		}
+
+		r.logger.Error(err.Error())
+		return NewInternalFailureExceptionResponse(err.Error())
+	}
+
+	//---
+
+	if response := r.checkDryRun(); !response.Empty() {
+		return response
+	}
+
+	r.logger.Infof("MAC verification %t with %s, using key %s\n", valid, *body.MacAlgorithm, key.GetArn())
+
+	if !valid {
+		return NewKMSInvalidMacException("")
+	}
+
+	return NewResponse(200, &struct {
+		KeyId        string
+		MacValid     bool
+		MacAlgorithm cmk.MacAlgorithm
+	}{
+		KeyId:        key.GetArn(),
+		MacValid:     valid,
+		MacAlgorithm: cmk.MacAlgorithm(*body.MacAlgorithm),
+	})
+}
@@ -0,0 +1,149 @@
+package hsm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/nsmithuk/local-kms/src/service"
+)
+
+var (
+	ErrClusterNotFound    = errors.New("cluster not found")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrKeyNotFound        = errors.New("key not found")
+)
+
+/*
+A local stand-in for a CloudHSM cluster. Each cluster is a directory, named after the cluster's ID, holding the cluster's
+configuration and the key material generated within it:
+
+	<root>/<cluster id>/cluster.json
+	<root>/<cluster id>/keys/<label>
+
+Removing or renaming a cluster's directory makes the cluster unavailable.
+*/
+type Cluster struct {
+	Id     string
+	path   string
+	config clusterConfig
+}
+
+type clusterConfig struct {
+	TrustAnchorCertificate string
+
+	// The kmsuser crypto user's password, salted and hashed.
+	PasswordSalt []byte
+	PasswordHash []byte
+}
+
+/*
+Opens an existing cluster.
+*/
+func OpenCluster(root, id string) (*Cluster, error) {
+	c := &Cluster{
+		Id:   id,
+		path: filepath.Join(root, filepath.Base(id)),
+	}
+
+	content, err := os.ReadFile(filepath.Join(c.path, "cluster.json"))
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &c.config); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+/*
+Creates a new cluster, with the given trust anchor certificate and kmsuser password.
+*/
+func InitialiseCluster(root, id, trustAnchorCertificate, password string) (*Cluster, error) {
+	c := &Cluster{
+		Id:   id,
+		path: filepath.Join(root, filepath.Base(id)),
+		config: clusterConfig{
+			TrustAnchorCertificate: trustAnchorCertificate,
+			PasswordSalt:           service.GenerateRandomData(16),
+		},
+	}
+
+	c.config.PasswordHash = c.hashPassword(password)
+
+	if err := os.MkdirAll(filepath.Join(c.path, "keys"), 0700); err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(c.path, "cluster.json"), content, 0600); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Cluster) TrustAnchorCertificate() string {
+	return c.config.TrustAnchorCertificate
+}
+
+/*
+Confirms the password is that of the cluster's kmsuser.
+*/
+func (c *Cluster) Login(password string) error {
+	if !bytes.Equal(c.hashPassword(password), c.config.PasswordHash) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+func (c *Cluster) hashPassword(password string) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, c.config.PasswordSalt...), password...))
+	return hash[:]
+}
+
+//---
+
+/*
+Generates a 256 bit AES key within the cluster, returning its key material.
+*/
+func (c *Cluster) GenerateKey(label string) ([]byte, error) {
+	material := service.GenerateRandomData(32)
+
+	err := os.WriteFile(c.keyPath(label), []byte(hex.EncodeToString(material)), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return material, nil
+}
+
+/*
+Returns the key material of a key within the cluster.
+*/
+func (c *Cluster) GetKey(label string) ([]byte, error) {
+	content, err := os.ReadFile(c.keyPath(label))
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(string(content))
+}
+
+func (c *Cluster) keyPath(label string) string {
+	return filepath.Join(c.path, "keys", filepath.Base(label))
+}
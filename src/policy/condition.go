@@ -0,0 +1,126 @@
+package policy
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+Evaluates a single condition key against the request's context.
+
+	Supports the String, Arn, Bool, Numeric and Null operators; with the ForAnyValue: and ForAllValues: set
+	qualifiers, and the IfExists suffix. An unsupported operator never matches.
+*/
+func evaluateCondition(operator, key string, values []string, context map[string][]string) bool {
+
+	actual, present := context[key]
+
+	if operator == "Null" {
+		// "true" means the key must be absent
+		return len(values) > 0 && strings.EqualFold(values[0], "true") != present
+	}
+
+	//---
+
+	qualifier := ""
+	if i := strings.Index(operator, ":"); i != -1 {
+		qualifier, operator = operator[:i], operator[i+1:]
+	}
+
+	ifExists := strings.HasSuffix(operator, "IfExists")
+	operator = strings.TrimSuffix(operator, "IfExists")
+
+	negated := strings.Contains(operator, "Not")
+	operator = strings.Replace(operator, "Not", "", 1)
+
+	compare, ok := comparisons[operator]
+	if !ok {
+		return false
+	}
+
+	//---
+
+	if !present || len(actual) == 0 {
+		// A missing key never matches; unless the condition is negated, or only applies if the key exists.
+		return ifExists || negated || qualifier == "ForAllValues"
+	}
+
+	itemMatches := func(a string) bool {
+		matched := false
+		for _, v := range values {
+			if compare(a, v) {
+				matched = true
+				break
+			}
+		}
+		return matched != negated
+	}
+
+	if qualifier == "ForAllValues" {
+		for _, a := range actual {
+			if !itemMatches(a) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// ForAnyValue, and single valued keys
+	for _, a := range actual {
+		if itemMatches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+//------------------------------------------
+
+var comparisons = map[string]func(actual, expected string) bool{
+	"StringEquals": func(a, e string) bool {
+		return a == e
+	},
+	"StringEqualsIgnoreCase": func(a, e string) bool {
+		return strings.EqualFold(a, e)
+	},
+	"StringLike": like,
+	"ArnEquals":  like,
+	"ArnLike":    like,
+	"Bool": func(a, e string) bool {
+		return strings.EqualFold(a, e)
+	},
+	"NumericEquals": numeric(func(a, e float64) bool {
+		return a == e
+	}),
+	"NumericLessThan": numeric(func(a, e float64) bool {
+		return a < e
+	}),
+	"NumericLessThanEquals": numeric(func(a, e float64) bool {
+		return a <= e
+	}),
+	"NumericGreaterThan": numeric(func(a, e float64) bool {
+		return a > e
+	}),
+	"NumericGreaterThanEquals": numeric(func(a, e float64) bool {
+		return a >= e
+	}),
+}
+
+// The expected value is the pattern
+func like(actual, expected string) bool {
+	return wildcardMatch(expected, actual)
+}
+
+func numeric(compare func(a, e float64) bool) func(actual, expected string) bool {
+	return func(actual, expected string) bool {
+		a, err := strconv.ParseFloat(actual, 64)
+		if err != nil {
+			return false
+		}
+		e, err := strconv.ParseFloat(expected, 64)
+		if err != nil {
+			return false
+		}
+		return compare(a, e)
+	}
+}
@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type Effect string
+
+const (
+	EffectAllow Effect = "Allow"
+	EffectDeny  Effect = "Deny"
+)
+
+//------------------------------------------
+
+type Document struct {
+	Version   string
+	Id        string `json:",omitempty"`
+	Statement Statements
+}
+
+type Statement struct {
+	Sid          string `json:",omitempty"`
+	Effect       Effect
+	Principal    *Principal `json:",omitempty"`
+	NotPrincipal *Principal `json:",omitempty"`
+	Action       StringList `json:",omitempty"`
+	NotAction    StringList `json:",omitempty"`
+	Resource     StringList `json:",omitempty"`
+	NotResource  StringList `json:",omitempty"`
+
+	// Condition operator => condition key => values
+	Condition map[string]map[string]StringList `json:",omitempty"`
+}
+
+/*
+A principal is either the wildcard "*", or a map of principal types to one or more identifiers.
+*/
+type Principal struct {
+	Wildcard  bool
+	AWS       StringList
+	Service   StringList
+	Federated StringList
+}
+
+//------------------------------------------
+
+func Parse(policy string) (*Document, error) {
+	var d Document
+
+	if err := json.Unmarshal([]byte(policy), &d); err != nil {
+		return nil, err
+	}
+
+	for _, s := range d.Statement {
+		if s.Effect != EffectAllow && s.Effect != EffectDeny {
+			return nil, errors.New("statement effect must be Allow or Deny")
+		}
+		if s.Action == nil && s.NotAction == nil {
+			return nil, errors.New("statement must include Action or NotAction")
+		}
+	}
+
+	return &d, nil
+}
+
+//------------------------------------------
+// Policy elements that accept either a single value or an array
+
+type Statements []Statement
+
+func (s *Statements) UnmarshalJSON(b []byte) error {
+	var single Statement
+	if err := json.Unmarshal(b, &single); err == nil {
+		*s = Statements{single}
+		return nil
+	}
+
+	var list []Statement
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	*s = list
+	return nil
+}
+
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+
+	// Condition values can also be booleans or numbers.
+	var list []interface{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		var scalar interface{}
+		if err := json.Unmarshal(b, &scalar); err != nil {
+			return err
+		}
+		list = []interface{}{scalar}
+	}
+
+	*l = make(StringList, len(list))
+	for i, v := range list {
+		switch t := v.(type) {
+		case string:
+			(*l)[i] = t
+		default:
+			encoded, _ := json.Marshal(t)
+			(*l)[i] = string(encoded)
+		}
+	}
+
+	return nil
+}
+
+func (p *Principal) UnmarshalJSON(b []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(b, &wildcard); err == nil {
+		if wildcard != "*" {
+			return errors.New("principal must be \"*\" or an object")
+		}
+		p.Wildcard = true
+		return nil
+	}
+
+	var principals struct {
+		AWS       StringList
+		Service   StringList
+		Federated StringList
+	}
+	if err := json.Unmarshal(b, &principals); err != nil {
+		return err
+	}
+
+	p.AWS = principals.AWS
+	p.Service = principals.Service
+	p.Federated = principals.Federated
+	return nil
+}
@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"strings"
+)
+
+type Decision int
+
+const (
+	ImplicitDeny Decision = iota
+	Allow
+	ExplicitDeny
+)
+
+/*
+The details of a request being checked against a policy.
+*/
+type Request struct {
+	Principal string // The ARN of the calling principal
+	Account   string // The account of the calling principal
+	Action    string // e.g. kms:Decrypt
+	Resource  string // The key's ARN
+
+	// Condition keys available to the request, with lowercase names. Keys may be multi-valued.
+	Context map[string][]string
+}
+
+/*
+Evaluates the request against the policy.
+
+	An explicit deny in any statement overrides any allow.
+	If no statement applies, the request is implicitly denied.
+*/
+func (d *Document) Evaluate(r *Request) Decision {
+	decision := ImplicitDeny
+
+	for _, s := range d.Statement {
+		if !s.applies(r) {
+			continue
+		}
+
+		if s.Effect == EffectDeny {
+			return ExplicitDeny
+		}
+
+		decision = Allow
+	}
+
+	return decision
+}
+
+func (s *Statement) applies(r *Request) bool {
+
+	if s.Principal != nil && !s.Principal.matches(r.Principal, r.Account) {
+		return false
+	}
+
+	if s.NotPrincipal != nil && s.NotPrincipal.matches(r.Principal, r.Account) {
+		return false
+	}
+
+	if s.Action != nil && !matchesAny(s.Action, r.Action, true) {
+		return false
+	}
+
+	if s.NotAction != nil && matchesAny(s.NotAction, r.Action, true) {
+		return false
+	}
+
+	if s.Resource != nil && !matchesAny(s.Resource, r.Resource, false) {
+		return false
+	}
+
+	if s.NotResource != nil && matchesAny(s.NotResource, r.Resource, false) {
+		return false
+	}
+
+	for operator, block := range s.Condition {
+		for key, values := range block {
+			if !evaluateCondition(operator, strings.ToLower(key), values, r.Context) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+/*
+Returns true if the principal is identified by the policy principal.
+
+	The account's root ARN, or just the account ID, identify every principal in the account.
+	A role identifies all of its assumed role sessions.
+*/
+func (p *Principal) matches(principal, account string) bool {
+	if p.Wildcard {
+		return true
+	}
+
+	for _, id := range p.AWS {
+		if Identifies(id, principal, account) {
+			return true
+		}
+	}
+
+	// Service and federated principals never make direct calls to KMS.
+	return false
+}
+
+/*
+Returns true if the AWS principal identifier, as found in a policy or grant, identifies the calling principal.
+*/
+func Identifies(id, principal, account string) bool {
+	switch {
+	case id == "*":
+		return true
+	case id == account || id == "arn:aws:iam::"+account+":root":
+		return true
+	case id == principal:
+		return true
+	case assumedRoleArn(principal) != "" && assumedRoleArn(principal) == roleWithoutPath(id):
+		return true
+	}
+	return false
+}
+
+/*
+Maps an assumed role session ARN to the role's ARN, without a path.
+arn:aws:sts::111122223333:assumed-role/Name/session => arn:aws:iam::111122223333:role/Name
+*/
+func assumedRoleArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return ""
+	}
+
+	resource := strings.Split(parts[5], "/")
+	if len(resource) < 2 {
+		return ""
+	}
+
+	return "arn:aws:iam::" + parts[4] + ":role/" + resource[1]
+}
+
+func roleWithoutPath(arn string) string {
+	i := strings.Index(arn, ":role/")
+	if i == -1 {
+		return arn
+	}
+
+	resource := strings.Split(arn[i+len(":role/"):], "/")
+	return arn[:i] + ":role/" + resource[len(resource)-1]
+}
+
+func matchesAny(patterns []string, value string, ignoreCase bool) bool {
+	for _, p := range patterns {
+		if ignoreCase && wildcardMatch(strings.ToLower(p), strings.ToLower(value)) {
+			return true
+		} else if !ignoreCase && wildcardMatch(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Matches the value against a pattern, where '*' matches any sequence of characters, and '?' any single character.
+*/
+func wildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, match := -1, 0
+
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star != -1:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
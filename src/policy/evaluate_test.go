@@ -0,0 +1,469 @@
+package policy
+
+import (
+	"testing"
+)
+
+const (
+	testAccount   = "111122223333"
+	testPrincipal = "arn:aws:iam::111122223333:user/alice"
+	testKeyArn    = "arn:aws:kms:eu-west-2:111122223333:key/5e0ab35c-2d76-4a8b-a3a4-5ea2b41e3e4b"
+)
+
+func evaluate(t *testing.T, policy string, r *Request) Decision {
+	t.Helper()
+
+	d, err := Parse(policy)
+	if err != nil {
+		t.Fatalf("unable to parse the policy: %s", err)
+	}
+
+	if r.Principal == "" {
+		r.Principal = testPrincipal
+	}
+	if r.Account == "" {
+		r.Account = testAccount
+	}
+	if r.Resource == "" {
+		r.Resource = testKeyArn
+	}
+
+	return d.Evaluate(r)
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		request  Request
+		decision Decision
+	}{
+		{
+			name:     "default key policy allows the account",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:root"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "no statement applies",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:Decrypt","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name:     "no statements",
+			policy:   `{"Version":"2012-10-17","Statement":[]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name: "deny overrides an earlier allow",
+			policy: `{"Version":"2012-10-17","Statement":[
+				{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"*"},
+				{"Effect":"Deny","Principal":"*","Action":"kms:Encrypt","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ExplicitDeny,
+		},
+		{
+			name: "deny overrides a later allow",
+			policy: `{"Version":"2012-10-17","Statement":[
+				{"Effect":"Deny","Principal":"*","Action":"kms:Encrypt","Resource":"*"},
+				{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ExplicitDeny,
+		},
+		{
+			name: "deny that doesn't apply",
+			policy: `{"Version":"2012-10-17","Statement":[
+				{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"*"},
+				{"Effect":"Deny","Principal":"*","Action":"kms:Decrypt","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "a single statement, rather than a list",
+			policy:   `{"Version":"2012-10-17","Statement":{"Effect":"Allow","Principal":"*","Action":"kms:Encrypt","Resource":"*"}}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+
+		//---
+		// Actions
+
+		{
+			name:     "action wildcard suffix",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:Generate*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:GenerateDataKey"},
+			decision: Allow,
+		},
+		{
+			name:     "action wildcard suffix not matching",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:Generate*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name:     "action single character wildcard",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:?ncrypt","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "actions are case insensitive",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"KMS:encrypt","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "action list",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":["kms:Decrypt","kms:Encrypt"],"Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "NotAction excludes the action",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","NotAction":"kms:Encrypt","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name:     "NotAction includes other actions",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","NotAction":"kms:Encrypt","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Decrypt"},
+			decision: Allow,
+		},
+		{
+			name: "deny with NotAction",
+			policy: `{"Version":"2012-10-17","Statement":[
+				{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"*"},
+				{"Effect":"Deny","Principal":"*","NotAction":["kms:Describe*","kms:List*"],"Resource":"*"}]}`,
+			request:  Request{Action: "kms:ScheduleKeyDeletion"},
+			decision: ExplicitDeny,
+		},
+
+		//---
+		// Resources
+
+		{
+			name:     "resource wildcard",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"arn:aws:kms:eu-west-2:111122223333:key/*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "resource in another region",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"arn:aws:kms:us-east-1:111122223333:key/*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name:     "resources are case sensitive",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"ARN:AWS:KMS:*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name:     "NotResource excludes the key",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:*","NotResource":"arn:aws:kms:*:*:key/5e0ab35c-*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+
+		//---
+		// Principals
+
+		{
+			name:     "principal by ARN",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:user/alice"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "principal by account ID",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"111122223333"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name:     "another principal",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:user/bob"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name:     "another account's root",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::444455556666:root"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name:     "a role identifies its sessions",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:role/path/Admin"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt", Principal: "arn:aws:sts::111122223333:assumed-role/Admin/session"},
+			decision: Allow,
+		},
+		{
+			name:     "service principals never match",
+			policy:   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"s3.amazonaws.com"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: ImplicitDeny,
+		},
+		{
+			name: "NotPrincipal excludes the principal from a deny",
+			policy: `{"Version":"2012-10-17","Statement":[
+				{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"*"},
+				{"Effect":"Deny","NotPrincipal":{"AWS":"arn:aws:iam::111122223333:user/alice"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt"},
+			decision: Allow,
+		},
+		{
+			name: "NotPrincipal includes other principals",
+			policy: `{"Version":"2012-10-17","Statement":[
+				{"Effect":"Allow","Principal":"*","Action":"kms:*","Resource":"*"},
+				{"Effect":"Deny","NotPrincipal":{"AWS":"arn:aws:iam::111122223333:user/alice"},"Action":"kms:*","Resource":"*"}]}`,
+			request:  Request{Action: "kms:Encrypt", Principal: "arn:aws:iam::111122223333:user/bob"},
+			decision: ExplicitDeny,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if decision := evaluate(t, test.policy, &test.request); decision != test.decision {
+				t.Errorf("expected decision %d, got %d", test.decision, decision)
+			}
+		})
+	}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		context   map[string][]string
+		allowed   bool
+	}{
+		{
+			name:      "StringEquals",
+			condition: `{"StringEquals":{"kms:EncryptionContext:Department":"IT"}}`,
+			context:   map[string][]string{"kms:encryptioncontext:department": {"IT"}},
+			allowed:   true,
+		},
+		{
+			name:      "StringEquals is case sensitive",
+			condition: `{"StringEquals":{"kms:EncryptionContext:Department":"IT"}}`,
+			context:   map[string][]string{"kms:encryptioncontext:department": {"it"}},
+			allowed:   false,
+		},
+		{
+			name:      "StringEquals with a missing key",
+			condition: `{"StringEquals":{"kms:EncryptionContext:Department":"IT"}}`,
+			context:   map[string][]string{},
+			allowed:   false,
+		},
+		{
+			name:      "StringEquals with any of several values",
+			condition: `{"StringEquals":{"kms:CallerAccount":["444455556666","111122223333"]}}`,
+			context:   map[string][]string{"kms:calleraccount": {"111122223333"}},
+			allowed:   true,
+		},
+		{
+			name:      "StringEqualsIgnoreCase",
+			condition: `{"StringEqualsIgnoreCase":{"kms:EncryptionContext:Department":"it"}}`,
+			context:   map[string][]string{"kms:encryptioncontext:department": {"IT"}},
+			allowed:   true,
+		},
+		{
+			name:      "StringNotEquals",
+			condition: `{"StringNotEquals":{"kms:EncryptionContext:Department":"IT"}}`,
+			context:   map[string][]string{"kms:encryptioncontext:department": {"HR"}},
+			allowed:   true,
+		},
+		{
+			name:      "StringNotEquals with a missing key",
+			condition: `{"StringNotEquals":{"kms:EncryptionContext:Department":"IT"}}`,
+			context:   map[string][]string{},
+			allowed:   true,
+		},
+		{
+			name:      "StringLike",
+			condition: `{"StringLike":{"kms:ViaService":"s3.*.amazonaws.com"}}`,
+			context:   map[string][]string{"kms:viaservice": {"s3.eu-west-2.amazonaws.com"}},
+			allowed:   true,
+		},
+		{
+			name:      "StringNotLike",
+			condition: `{"StringNotLike":{"kms:ViaService":"s3.*.amazonaws.com"}}`,
+			context:   map[string][]string{"kms:viaservice": {"s3.eu-west-2.amazonaws.com"}},
+			allowed:   false,
+		},
+		{
+			name:      "StringEqualsIfExists with a missing key",
+			condition: `{"StringEqualsIfExists":{"kms:EncryptionContext:Department":"IT"}}`,
+			context:   map[string][]string{},
+			allowed:   true,
+		},
+		{
+			name:      "StringEqualsIfExists with a different value",
+			condition: `{"StringEqualsIfExists":{"kms:EncryptionContext:Department":"IT"}}`,
+			context:   map[string][]string{"kms:encryptioncontext:department": {"HR"}},
+			allowed:   false,
+		},
+		{
+			name:      "ArnLike",
+			condition: `{"ArnLike":{"aws:PrincipalArn":"arn:aws:iam::111122223333:user/*"}}`,
+			context:   map[string][]string{"aws:principalarn": {testPrincipal}},
+			allowed:   true,
+		},
+		{
+			name:      "ArnNotEquals",
+			condition: `{"ArnNotEquals":{"aws:PrincipalArn":"arn:aws:iam::111122223333:user/alice"}}`,
+			context:   map[string][]string{"aws:principalarn": {testPrincipal}},
+			allowed:   false,
+		},
+		{
+			name:      "Bool",
+			condition: `{"Bool":{"kms:MultiRegion":true}}`,
+			context:   map[string][]string{"kms:multiregion": {"true"}},
+			allowed:   true,
+		},
+		{
+			name:      "Bool not matching",
+			condition: `{"Bool":{"kms:MultiRegion":"true"}}`,
+			context:   map[string][]string{"kms:multiregion": {"false"}},
+			allowed:   false,
+		},
+		{
+			name:      "NumericLessThan",
+			condition: `{"NumericLessThan":{"kms:ExampleCount":10}}`,
+			context:   map[string][]string{"kms:examplecount": {"9"}},
+			allowed:   true,
+		},
+		{
+			name:      "NumericGreaterThanEquals not matching",
+			condition: `{"NumericGreaterThanEquals":{"kms:ExampleCount":"10"}}`,
+			context:   map[string][]string{"kms:examplecount": {"9"}},
+			allowed:   false,
+		},
+		{
+			name:      "Numeric with a value that isn't a number",
+			condition: `{"NumericEquals":{"kms:ExampleCount":"10"}}`,
+			context:   map[string][]string{"kms:examplecount": {"ten"}},
+			allowed:   false,
+		},
+		{
+			name:      "Null true with a missing key",
+			condition: `{"Null":{"kms:EncryptionContextKeys":"true"}}`,
+			context:   map[string][]string{},
+			allowed:   true,
+		},
+		{
+			name:      "Null false with a missing key",
+			condition: `{"Null":{"kms:EncryptionContextKeys":"false"}}`,
+			context:   map[string][]string{},
+			allowed:   false,
+		},
+		{
+			name:      "ForAnyValue",
+			condition: `{"ForAnyValue:StringEquals":{"kms:EncryptionContextKeys":["Department","Project"]}}`,
+			context:   map[string][]string{"kms:encryptioncontextkeys": {"Owner", "Project"}},
+			allowed:   true,
+		},
+		{
+			name:      "ForAnyValue with no value matching",
+			condition: `{"ForAnyValue:StringEquals":{"kms:EncryptionContextKeys":["Department","Project"]}}`,
+			context:   map[string][]string{"kms:encryptioncontextkeys": {"Owner"}},
+			allowed:   false,
+		},
+		{
+			name:      "ForAllValues",
+			condition: `{"ForAllValues:StringEquals":{"kms:EncryptionContextKeys":["Department","Project"]}}`,
+			context:   map[string][]string{"kms:encryptioncontextkeys": {"Department", "Project"}},
+			allowed:   true,
+		},
+		{
+			name:      "ForAllValues with a value not listed",
+			condition: `{"ForAllValues:StringEquals":{"kms:EncryptionContextKeys":["Department","Project"]}}`,
+			context:   map[string][]string{"kms:encryptioncontextkeys": {"Department", "Owner"}},
+			allowed:   false,
+		},
+		{
+			name:      "ForAllValues with a missing key",
+			condition: `{"ForAllValues:StringEquals":{"kms:EncryptionContextKeys":["Department"]}}`,
+			context:   map[string][]string{},
+			allowed:   true,
+		},
+		{
+			name:      "condition keys are case insensitive",
+			condition: `{"StringEquals":{"KMS:CALLERACCOUNT":"111122223333"}}`,
+			context:   map[string][]string{"kms:calleraccount": {"111122223333"}},
+			allowed:   true,
+		},
+		{
+			name:      "every condition must match",
+			condition: `{"StringEquals":{"kms:CallerAccount":"111122223333"},"Bool":{"kms:MultiRegion":"true"}}`,
+			context:   map[string][]string{"kms:calleraccount": {"111122223333"}, "kms:multiregion": {"false"}},
+			allowed:   false,
+		},
+		{
+			name:      "unsupported operator",
+			condition: `{"DateLessThan":{"aws:CurrentTime":"2030-01-01T00:00:00Z"}}`,
+			context:   map[string][]string{"aws:currenttime": {"2020-01-01T00:00:00Z"}},
+			allowed:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"kms:*",` +
+				`"Resource":"*","Condition":` + test.condition + `}]}`
+
+			decision := evaluate(t, policy, &Request{Action: "kms:Decrypt", Context: test.context})
+
+			if allowed := decision == Allow; allowed != test.allowed {
+				t.Errorf("expected allowed to be %t, got decision %d", test.allowed, decision)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not JSON":                 `{`,
+		"invalid effect":           `{"Version":"2012-10-17","Statement":[{"Effect":"Maybe","Principal":"*","Action":"kms:*"}]}`,
+		"no action":                `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Resource":"*"}]}`,
+		"principal string not '*'": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"alice","Action":"kms:*"}]}`,
+	}
+
+	for name, policy := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(policy); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		match          bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*b*", "abc", true},
+		{"a**", "a", true},
+		{"*:key/*", "arn:aws:kms:eu-west-2:111122223333:key/1234", true},
+	}
+
+	for _, test := range tests {
+		if match := wildcardMatch(test.pattern, test.value); match != test.match {
+			t.Errorf("wildcardMatch(%q, %q): expected %t, got %t", test.pattern, test.value, test.match, match)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import "github.com/nsmithuk/local-kms/src/handler"
+
+/*
+The middleware types, so middleware can be written for Options.Middleware by importing only this package.
+*/
+type (
+	// Wraps an Invoker, acting on calls before they're handled and on responses after.
+	Middleware = handler.Middleware
+
+	// Handles a call, returning the response to send.
+	Invoker = handler.Invoker
+
+	// A single request for an operation, as it's passed along the middleware chain.
+	Call = handler.Call
+
+	// A KMS operation that can be requested, via the X-Amz-Target header.
+	Operation = handler.Operation
+
+	// The response to a call.
+	Response = handler.Response
+)
+
+/*
+Returns a response with the status code, and v encoded as the JSON body.
+*/
+func NewResponse(code int, v interface{}) Response {
+	return handler.NewResponse(code, v)
+}
+
+/*
+Returns a 400 response for the named exception, such as AccessDeniedException.
+*/
+func NewExceptionResponse(exception, message string) Response {
+	return handler.New400ExceptionResponse(exception, message)
+}
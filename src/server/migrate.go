@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/nsmithuk/local-kms/src/data"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Opens the storage given by the options. Returns both the underlying storage, and the storage records should be read and
+written through, which differ when the data is encrypted.
+*/
+func openStorage(options Options, logger log.FieldLogger) (raw data.Storage, storage data.Storage, err error) {
+
+	raw = options.Storage
+
+	if raw == nil {
+		raw, err = data.NewLevelDBStorage(options.DataPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open the database in %s: %s", options.DataPath, err)
+		}
+
+		logger.Infof("Data will be stored in %s", options.DataPath)
+	}
+
+	if options.MasterKey == nil {
+		if encrypted, err := data.IsEncrypted(raw); err != nil || encrypted {
+			raw.Close()
+
+			if err == nil {
+				err = errors.New("the data is encrypted, so a master key is required")
+			}
+			return nil, nil, fmt.Errorf("unable to open the data: %s", err)
+		}
+
+		return raw, raw, nil
+	}
+
+	encrypted, err := data.NewEncryptedStorage(raw, options.MasterKey)
+	if err != nil {
+		raw.Close()
+		return nil, nil, fmt.Errorf("unable to open the encrypted data: %s", err)
+	}
+
+	logger.Info("Data will be encrypted at rest")
+
+	return raw, encrypted, nil
+}
+
+func backupPath(options Options) string {
+	if options.BackupPath == "" && options.DataPath != "" {
+		return filepath.Join(options.DataPath, "backups")
+	}
+	return options.BackupPath
+}
+
+/*
+Brings the data up to the current schema version, backing it up first. With dryRun, only reports what would be done.
+*/
+func migrate(raw, storage data.Storage, options Options, logger log.FieldLogger, dryRun bool) error {
+
+	report, err := data.Migrate(storage, true)
+	if err != nil {
+		return err
+	}
+
+	if !report.Pending() {
+		if dryRun {
+			logger.Infof("The data is at schema version %d; no migrations are needed", report.To)
+		} else {
+			// Records the version of new data
+			_, err = data.Migrate(storage, false)
+		}
+		return err
+	}
+
+	if dryRun {
+		logger.Infof("The data would be migrated from schema version %d to %d", report.From, report.To)
+	} else {
+		logger.Infof("Migrating the data from schema version %d to %d", report.From, report.To)
+	}
+
+	for _, m := range report.Applied {
+		logger.Infof("Migration %d: %s. Records changed: %d", m.Version, m.Description, m.Records)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	//---
+
+	if dir := backupPath(options); dir != "" {
+		path, err := data.Backup(raw, dir, report.From)
+		if err != nil {
+			return fmt.Errorf("unable to back up the data: %s", err)
+		}
+
+		logger.Infof("The data has been backed up to %s", path)
+	} else {
+		logger.Warn("The data has not been backed up, as there's no data path or backup path")
+	}
+
+	report, err = data.Migrate(storage, false)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("The data is now at schema version %d", report.To)
+
+	return nil
+}
+
+/*
+Migrates the data given by the options to the current schema version, as happens when a Server starts, then closes it.
+With dryRun, the migrations that would be applied are logged, and nothing is changed.
+*/
+func Migrate(options Options, dryRun bool) error {
+
+	logger := options.Logger
+	if logger == nil {
+		logger = log.New()
+	}
+
+	raw, storage, err := openStorage(options, logger)
+	if err != nil {
+		return err
+	}
+
+	defer storage.Close()
+
+	return migrate(raw, storage, options, logger, dryRun)
+}
+
+/*
+Replaces the data given by the options with a backup taken before a migration, so it can be used with the earlier
+version of LKMS again. Returns the schema version the restored data is at.
+*/
+func Restore(options Options, path string) (int, error) {
+
+	storage := options.Storage
+
+	if storage == nil {
+		var err error
+		storage, err = data.NewLevelDBStorage(options.DataPath)
+		if err != nil {
+			return 0, fmt.Errorf("unable to open the database in %s: %s", options.DataPath, err)
+		}
+	}
+
+	defer storage.Close()
+
+	return data.RestoreBackup(storage, path)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+const testVersion0Arn = "arn:aws:kms:eu-west-2:111122223333:key/a4f4e4d2-0000-4000-8000-000000000000"
+
+/*
+Storage holding a key as written before schema versions were introduced, with neither a Type nor a KeySpec.
+*/
+func newTestVersion0Storage(t *testing.T) (data.Storage, string) {
+	t.Helper()
+
+	var material [32]byte
+	encoded, _ := json.Marshal(material)
+
+	record := fmt.Sprintf(`{"Metadata":{"Arn":"%s","KeyId":"a4f4e4d2-0000-4000-8000-000000000000","Enabled":true,`+
+		`"KeyState":"Enabled","KeyUsage":"ENCRYPT_DECRYPT","CustomerMasterKeySpec":"SYMMETRIC_DEFAULT"},`+
+		`"BackingKeys":[%s],"Policy":""}`, testVersion0Arn, encoded)
+
+	storage := data.NewMemoryStorage()
+	if err := storage.Put(testVersion0Arn, []byte(record)); err != nil {
+		t.Fatal(err)
+	}
+
+	return storage, record
+}
+
+func expectLogged(t *testing.T, hook *test.Hook, messages ...string) {
+	t.Helper()
+
+	logged := make(map[string]bool)
+	for _, entry := range hook.AllEntries() {
+		logged[entry.Message] = true
+	}
+
+	for _, message := range messages {
+		if !logged[message] {
+			t.Errorf("expected %q to be logged", message)
+		}
+	}
+}
+
+func backups(t *testing.T, dir string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+//------------------------------------
+
+func TestMigrateDryRunReport(t *testing.T) {
+	storage, record := newTestVersion0Storage(t)
+	logger, hook := test.NewNullLogger()
+
+	options := Options{
+		Storage:    storage,
+		BackupPath: t.TempDir(),
+		Logger:     logger,
+	}
+
+	if err := Migrate(options, true); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLogged(t, hook,
+		fmt.Sprintf("The data would be migrated from schema version 0 to %d", data.SchemaVersion()),
+		"Migration 1: Set KeySpec from CustomerMasterKeySpec, on keys created before KeySpec was introduced. Records changed: 1",
+		"Migration 2: Set the Type of keys created before asymmetric keys were supported to AES. Records changed: 1",
+	)
+
+	// Nothing is changed, or backed up
+	if value, err := storage.Get(testVersion0Arn); err != nil || string(value) != record {
+		t.Errorf("expected the key to be unchanged, got %s, %v", value, err)
+	}
+
+	if paths := backups(t, options.BackupPath); len(paths) != 0 {
+		t.Errorf("expected no backups, got %v", paths)
+	}
+}
+
+func TestMigrateBackupAndRestore(t *testing.T) {
+	storage, record := newTestVersion0Storage(t)
+	logger, _ := test.NewNullLogger()
+
+	options := Options{
+		Storage:    storage,
+		BackupPath: t.TempDir(),
+		Logger:     logger,
+	}
+
+	if err := Migrate(options, false); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := backups(t, options.BackupPath)
+	if len(paths) != 1 {
+		t.Fatalf("expected one backup, got %v", paths)
+	}
+
+	// The migrated key can be used
+	_, url := newTestServer(t, Options{Storage: storage})
+
+	content := mustCall(t, url, "DescribeKey", map[string]string{"KeyId": testVersion0Arn})
+	if spec := content["KeyMetadata"].(map[string]interface{})["KeySpec"]; spec != "SYMMETRIC_DEFAULT" {
+		t.Errorf("expected a KeySpec of SYMMETRIC_DEFAULT, got %v", spec)
+	}
+
+	mustCall(t, url, "Encrypt", map[string]string{"KeyId": testVersion0Arn, "Plaintext": "cGxhaW50ZXh0"})
+
+	// Restoring the backup returns the data to as it was before it was migrated
+	version, err := Restore(options, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 0 {
+		t.Errorf("expected the restored data to be at schema version 0, got %d", version)
+	}
+
+	if value, err := storage.Get(testVersion0Arn); err != nil || string(value) != record {
+		t.Errorf("expected the key to be restored, got %s, %v", value, err)
+	}
+
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Errorf("expected the backup to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"time"
+
+	"github.com/nsmithuk/local-kms/src/data"
+	log "github.com/sirupsen/logrus"
+)
+
+// How often due lifecycle transitions are applied, by default.
+const defaultLifecycleInterval = time.Minute
+
+/*
+Applies keys' lifecycle transitions in the background, so keys are rotated, deleted and expire on time, whether or not
+they're being used.
+*/
+type lifecycleScheduler struct {
+	database *data.Database
+	logger   log.FieldLogger
+	interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startLifecycleScheduler(database *data.Database, interval time.Duration, logger log.FieldLogger) *lifecycleScheduler {
+
+	s := &lifecycleScheduler{
+		database: database,
+		logger:   logger,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *lifecycleScheduler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.advance()
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *lifecycleScheduler) advance() {
+
+	transitions, err := s.database.AdvanceLifecycle(time.Now())
+
+	for _, t := range transitions {
+		s.logger.Infof("%s: %s", t.Description, t.Arn)
+	}
+
+	if err != nil {
+		s.logger.Errorf("Unable to apply key lifecycle transitions: %s", err)
+	}
+}
+
+/*
+Stops the scheduler, waiting for any transitions being applied to complete.
+*/
+func (s *lifecycleScheduler) close() {
+	close(s.stop)
+	<-s.done
+}
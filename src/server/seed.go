@@ -1,34 +1,45 @@
-package src
+package server
 
 import (
 	"fmt"
 	"github.com/nsmithuk/local-kms/src/cmk"
-	"github.com/nsmithuk/local-kms/src/config"
 	"github.com/nsmithuk/local-kms/src/data"
-	"github.com/syndtr/goleveldb/leveldb"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"os"
 	"path/filepath"
 )
 
-func seed(path string, database *data.Database) {
+/*
+Keys and aliases added to an instance's database when it starts, unless they already exist. Keys' ARNs and account
+IDs, and aliases' ARNs, are set from the instance's account and region.
+*/
+type Seed struct {
+	Keys    []cmk.Key
+	Aliases []data.Alias
+}
+
+/*
+Reads the seed from a YAML file. Nil is returned if there's nothing to seed, or the file can't be used.
+*/
+func loadSeedFile(path string, logger *log.Logger) *Seed {
 
 	if path == "" {
 		logger.Infoln("No seed path passed; skipping.")
-		return
+		return nil
 	}
 
 	path, _ = filepath.Abs(path)
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		logger.Infoln(fmt.Sprintf("No file found at path %s; skipping seeding.", path))
-		return
+		return nil
 	}
 
 	context, err := os.ReadFile(path)
 	if err != nil {
 		logger.Errorln(fmt.Sprintf("Unable to read seed content of file at path %s; skipping.", path))
-		return
+		return nil
 	}
 
 	//---
@@ -37,13 +48,16 @@ func seed(path string, database *data.Database) {
 		Aes []cmk.AesKey `yaml:"Aes"`
 	}
 	type InputAsymmetric struct {
-		Rsa []cmk.RsaKey `yaml:"Rsa"`
-		Ecc []cmk.EccKey `yaml:"Ecc"`
+		Rsa     []cmk.RsaKey     `yaml:"Rsa"`
+		Ecc     []cmk.EccKey     `yaml:"Ecc"`
+		Ed25519 []cmk.Ed25519Key `yaml:"Ed25519"`
+		Sm2     []cmk.Sm2Key     `yaml:"Sm2"`
 	}
 
 	type InputKeys struct {
 		Symmetric  InputSymmetric  `yaml:"Symmetric"`
 		Asymmetric InputAsymmetric `yaml:"Asymmetric"`
+		Hmac       []cmk.HmacKey   `yaml:"Hmac"`
 	}
 
 	type Input struct {
@@ -54,8 +68,11 @@ func seed(path string, database *data.Database) {
 	seed := Input{}
 
 	var eccKeys []cmk.EccKey
+	var ed25519Keys []cmk.Ed25519Key
+	var sm2Keys []cmk.Sm2Key
 	var rsaKeys []cmk.RsaKey
 	var aesKeys []cmk.AesKey
+	var hmacKeys []cmk.HmacKey
 	var aliases []data.Alias
 
 	err = yaml.Unmarshal([]byte(context), &seed)
@@ -77,7 +94,7 @@ func seed(path string, database *data.Database) {
 		err = yaml.Unmarshal([]byte(context), &seed)
 		if err != nil {
 			logger.Errorln(fmt.Sprintf("Error parsing YAML at path %s: %s; skipping.", path, err))
-			return
+			return nil
 		}
 
 		if len(seed.Keys) > 0 {
@@ -105,6 +122,15 @@ func seed(path string, database *data.Database) {
 		for _, key := range seed.Keys.Asymmetric.Ecc {
 			eccKeys = append(eccKeys, key)
 		}
+		for _, key := range seed.Keys.Asymmetric.Ed25519 {
+			ed25519Keys = append(ed25519Keys, key)
+		}
+		for _, key := range seed.Keys.Asymmetric.Sm2 {
+			sm2Keys = append(sm2Keys, key)
+		}
+		for _, key := range seed.Keys.Hmac {
+			hmacKeys = append(hmacKeys, key)
+		}
 		for _, alias := range seed.Aliases {
 			aliases = append(aliases, alias)
 		}
@@ -112,52 +138,62 @@ func seed(path string, database *data.Database) {
 
 	logger.Infof("Importing data from seed file %s\n", path)
 
-	for i, alias := range aliases {
-		aliases[i].AliasArn = config.ArnPrefix() + alias.AliasName
+	result := &Seed{Aliases: aliases}
+
+	for i := range aesKeys {
+		result.Keys = append(result.Keys, &aesKeys[i])
+	}
+	for i := range rsaKeys {
+		result.Keys = append(result.Keys, &rsaKeys[i])
+	}
+	for i := range eccKeys {
+		result.Keys = append(result.Keys, &eccKeys[i])
+	}
+	for i := range ed25519Keys {
+		result.Keys = append(result.Keys, &ed25519Keys[i])
+	}
+	for i := range sm2Keys {
+		result.Keys = append(result.Keys, &sm2Keys[i])
+	}
+	for i := range hmacKeys {
+		result.Keys = append(result.Keys, &hmacKeys[i])
 	}
 
-	//-----------------------------------------
-	// Save to database
+	return result
+}
+
+/*
+Saves the seed's keys and aliases to the database, skipping any that already exist.
+*/
+func (s *Server) seed(seed *Seed) {
 
 	keysAdded := 0
-	for _, key := range aesKeys {
-		if keyIsNew(database, &key.Metadata) {
-			database.SaveKey(&key)
-			keysAdded++
-		}
-	}
-	for _, key := range rsaKeys {
-		if keyIsNew(database, &key.Metadata) {
-			database.SaveKey(&key)
-			keysAdded++
-		}
-	}
-	for _, key := range eccKeys {
-		if keyIsNew(database, &key.Metadata) {
-			database.SaveKey(&key)
-			keysAdded++
+	for _, key := range seed.Keys {
+		metadata := key.GetMetadata()
+		metadata.Arn = s.config.ArnPrefix() + "key/" + metadata.KeyId
+		metadata.AWSAccountId = s.config.AWSAccountId
+
+		if _, err := s.database.LoadKey(metadata.Arn); err != data.ErrNotFound {
+			s.logger.Warnf("Key %s already exists; skipping key", metadata.KeyId)
+			continue
 		}
+
+		s.database.SaveKey(key)
+		keysAdded++
 	}
 
 	aliasesAdded := 0
-	for _, alias := range aliases {
+	for _, alias := range seed.Aliases {
+		alias.AliasArn = s.config.ArnPrefix() + alias.AliasName
 
-		if _, err := database.LoadAlias(alias.AliasArn); err != leveldb.ErrNotFound {
-			logger.Warnf("Alias %s already exists; skipping alias\n", alias.AliasName)
+		if _, err := s.database.LoadAlias(alias.AliasArn); err != data.ErrNotFound {
+			s.logger.Warnf("Alias %s already exists; skipping alias\n", alias.AliasName)
 			continue
 		}
 
-		database.SaveAlias(&alias)
+		s.database.SaveAlias(&alias)
 		aliasesAdded++
 	}
 
-	logger.Infof("%d new keys and %d new aliases added\n", keysAdded, aliasesAdded)
-}
-
-func keyIsNew(database *data.Database, metadata *cmk.KeyMetadata) bool {
-	if _, err := database.LoadKey(metadata.Arn); err != leveldb.ErrNotFound {
-		logger.Warnf("Key %s already exists; skipping key", metadata.KeyId)
-		return false
-	}
-	return true
+	s.logger.Infof("%d new keys and %d new aliases added\n", keysAdded, aliasesAdded)
 }
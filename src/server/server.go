@@ -0,0 +1,449 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nsmithuk/local-kms/src/attestation"
+	"github.com/nsmithuk/local-kms/src/auth"
+	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/handler"
+	"github.com/nsmithuk/local-kms/src/xks"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Configures a Server. Either DataPath or Storage is required.
+*/
+type Options struct {
+	// Default: 111122223333
+	AccountId string
+
+	// Default: eu-west-2
+	Region string
+
+	// Regions served in addition to Region, for use with multi-Region keys.
+	AdditionalRegions []string
+
+	// Directory the database is kept in, when Storage isn't set.
+	DataPath string
+
+	// Where the database's records are kept. Default: a LevelDB database in DataPath
+	Storage data.Storage
+
+	// If set, records are encrypted with it before they're stored.
+	MasterKey data.MasterKey
+
+	// Directory the data is backed up to before it's migrated. Default: backups within DataPath, or no backup
+	BackupPath string
+
+	// Directory of the local stand-ins for CloudHSM clusters. Default: hsm within DataPath, or a temporary directory
+	HsmPath string
+
+	// YAML file of keys and aliases to seed the database with. Skipped if the file doesn't exist.
+	SeedPath string
+
+	// Keys and aliases to seed the database with, in addition to those in SeedPath.
+	Seed *Seed
+
+	// How often keys are checked for lifecycle transitions that are due, such as rotation. Default: one minute
+	LifecycleInterval time.Duration
+
+	// When true, lifecycle transitions, such as automatic rotation and deletion, aren't stored. Keys that are due to be
+	// deleted, or whose key material has expired, still can't be used.
+	DisableLifecycleScheduler bool
+
+	// Credential registry. If set, request signatures are verified.
+	CredentialsPath string
+
+	// Root certificates recipients' attestation documents are validated against. If set, recipients are supported.
+	AttestationRootPath string
+
+	// When true, requests are checked against the key's policy.
+	EnforceKeyPolicies bool
+
+	// The principal unverified requests are made as. Default: the account's root principal
+	CallerPrincipal string
+
+	// Requests per second served, across all operations. If zero, requests aren't throttled.
+	RequestRateLimit float64
+
+	// The most requests served in a burst, before RequestRateLimit applies. Default: RequestRateLimit, rounded up
+	RequestBurstLimit int
+
+	// Middleware run immediately around each operation, after the standard middleware.
+	Middleware []Middleware
+
+	// Credential registry XKS Proxy API requests are verified against. If set, the XKS Proxy API is available.
+	XksProxyCredentialsPath string
+
+	// URI path the XKS Proxy API is served under. Default: /kms/xks/v1
+	XksProxyUriPath string
+
+	// Default: a new logger, writing to stderr
+	Logger *log.Logger
+}
+
+/*
+A single LKMS instance, serving the KMS API. Each has its own configuration and database, so several can be run in
+the same process.
+*/
+type Server struct {
+	config   *config.Config
+	database *data.Database
+	logger   *log.Logger
+
+	mux      *http.ServeMux
+	xksProxy *xks.Proxy
+
+	// Removed on Close. Set when there's no data path for the HSM stand-ins to be kept in.
+	tempPath string
+
+	// Nil when disabled
+	scheduler *lifecycleScheduler
+}
+
+func New(options Options) (*Server, error) {
+
+	if options.DataPath == "" && options.Storage == nil {
+		return nil, errors.New("a data path or storage is required")
+	}
+
+	if options.AccountId == "" {
+		options.AccountId = "111122223333"
+	}
+
+	if options.Region == "" {
+		options.Region = "eu-west-2"
+	}
+
+	var tempPath string
+
+	if options.HsmPath == "" {
+		if options.DataPath != "" {
+			options.HsmPath = filepath.Join(options.DataPath, "hsm")
+		} else {
+			var err error
+			tempPath, err = os.MkdirTemp("", "local-kms-hsm-")
+			if err != nil {
+				return nil, fmt.Errorf("unable to create a temporary HSM directory: %s", err)
+			}
+			options.HsmPath = tempPath
+		}
+	}
+
+	if options.CallerPrincipal == "" {
+		options.CallerPrincipal = "arn:aws:iam::" + options.AccountId + ":root"
+	}
+
+	if options.XksProxyUriPath == "" {
+		options.XksProxyUriPath = "/kms/xks/v1"
+	}
+
+	logger := options.Logger
+	if logger == nil {
+		logger = log.New()
+		logger.SetFormatter(&log.TextFormatter{
+			ForceColors:     true,
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05.000",
+		})
+	}
+
+	s := &Server{
+		config: &config.Config{
+			AWSRegion:          options.Region,
+			AWSAccountId:       options.AccountId,
+			AdditionalRegions:  options.AdditionalRegions,
+			EnforceKeyPolicies: options.EnforceKeyPolicies,
+			CallerPrincipal:    options.CallerPrincipal,
+			HsmPath:            options.HsmPath,
+		},
+		logger:   logger,
+		mux:      http.NewServeMux(),
+		tempPath: tempPath,
+	}
+
+	//-----------
+	// Credentials
+
+	var registry *auth.Registry
+
+	if options.CredentialsPath != "" {
+		var err error
+		registry, err = auth.LoadRegistry(options.CredentialsPath)
+		if err != nil {
+			s.removeTempPath()
+			return nil, fmt.Errorf("unable to load credentials from %s: %s", options.CredentialsPath, err)
+		}
+
+		logger.Infof("Request signatures will be verified against %d credentials", registry.Len())
+	}
+
+	//-----------
+	// Recipient attestation
+
+	var verifier *attestation.Verifier
+
+	if options.AttestationRootPath != "" {
+		var err error
+		verifier, err = attestation.LoadVerifier(options.AttestationRootPath)
+		if err != nil {
+			s.removeTempPath()
+			return nil, fmt.Errorf("unable to load attestation root certificates from %s: %s", options.AttestationRootPath, err)
+		}
+
+		logger.Infof("Recipient attestation documents will be validated against %s", options.AttestationRootPath)
+	}
+
+	//-----------
+	// XKS proxy credentials
+
+	var xksRegistry *auth.Registry
+
+	if options.XksProxyCredentialsPath != "" {
+		var err error
+		xksRegistry, err = auth.LoadRegistry(options.XksProxyCredentialsPath)
+		if err != nil {
+			s.removeTempPath()
+			return nil, fmt.Errorf("unable to load XKS proxy credentials from %s: %s", options.XksProxyCredentialsPath, err)
+		}
+	}
+
+	//-----------
+	// DB Setup
+
+	raw, storage, err := openStorage(options, logger)
+	if err != nil {
+		s.removeTempPath()
+		return nil, err
+	}
+
+	if err := migrate(raw, storage, options, logger, false); err != nil {
+		storage.Close()
+		s.removeTempPath()
+		return nil, fmt.Errorf("unable to migrate the data: %s", err)
+	}
+
+	s.database = data.NewDatabase(storage)
+
+	//-----------
+	// Seeding
+
+	if seed := loadSeedFile(options.SeedPath, logger); seed != nil {
+		s.seed(seed)
+	}
+
+	if options.Seed != nil {
+		s.seed(options.Seed)
+	}
+
+	//-----------
+	// Lifecycle
+
+	if !options.DisableLifecycleScheduler {
+		interval := options.LifecycleInterval
+		if interval <= 0 {
+			interval = defaultLifecycleInterval
+		}
+
+		s.scheduler = startLifecycleScheduler(s.database, interval, logger)
+	}
+
+	//-----------
+	// Middleware
+
+	metrics := handler.NewMetrics()
+
+	dispatcher := handler.NewDispatcher(s.config, s.database, verifier)
+	dispatcher.Use(handler.RequestId(), handler.Logging(), metrics.Middleware())
+
+	if options.RequestRateLimit > 0 {
+		burst := options.RequestBurstLimit
+		if burst < 1 {
+			burst = int(math.Ceil(options.RequestRateLimit))
+		}
+
+		dispatcher.Use(handler.Throttling(options.RequestRateLimit, burst))
+
+		logger.Infof("Requests will be throttled to %g per second", options.RequestRateLimit)
+	}
+
+	if registry != nil {
+		dispatcher.Use(handler.Authentication(registry, s.config.IsServedRegion))
+	}
+
+	dispatcher.Use(options.Middleware...)
+
+	//-----------
+	// Routes
+
+	s.mux.Handle("/metrics", metrics)
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s.handleRequest(w, r, dispatcher)
+	})
+
+	if xksRegistry != nil {
+		s.xksProxy = xks.NewProxy(options.XksProxyUriPath, s.config, s.database, xksRegistry, logger)
+	}
+
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+/*
+Returns the handler serving the XKS Proxy API, backed by this instance's keys. Nil unless XksProxyCredentialsPath
+was set.
+*/
+func (s *Server) XksProxy() *xks.Proxy {
+	return s.xksProxy
+}
+
+/*
+Closes the instance's database. The server can't be used afterwards.
+*/
+func (s *Server) Close() {
+	if s.scheduler != nil {
+		s.scheduler.close()
+	}
+	s.database.Close()
+	s.removeTempPath()
+}
+
+func (s *Server) removeTempPath() {
+	if s.tempPath != "" {
+		os.RemoveAll(s.tempPath)
+	}
+}
+
+//------------------------------------------
+
+/*
+Runs a server on the given port, returning only when it can no longer serve requests. If xksProxyPort is set, the
+XKS Proxy API is also served, on that port.
+*/
+func Run(port, xksProxyPort string, options Options) error {
+
+	server, err := New(options)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	logger := server.logger
+
+	// The first error from either listener stops the server
+	errs := make(chan error, 2)
+
+	//-----------
+	// XKS proxy
+
+	if xksProxyPort != "" {
+		proxy := server.XksProxy()
+		if proxy == nil {
+			return errors.New("a credential registry is required to serve the XKS Proxy API")
+		}
+
+		go func() {
+			logger.Infof("XKS Proxy API started on 0.0.0.0:%s%s", xksProxyPort, proxy.UriPath())
+
+			errs <- fmt.Errorf("XKS proxy ListenAndServe: %s", http.ListenAndServe(":"+xksProxyPort, proxy))
+		}()
+	}
+
+	//-----------
+	// Start
+
+	go func() {
+		logger.Infof("Local KMS started on 0.0.0.0:%s", port)
+
+		errs <- fmt.Errorf("ListenAndServe: %s", http.ListenAndServe(":"+port, server))
+	}()
+
+	return <-errs
+}
+
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request, dispatcher *handler.Dispatcher) {
+	s.logger.Debugf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+	if r.URL.Path != "/" {
+		error404(w)
+
+	} else if r.Method != "POST" {
+		error405(w)
+
+	} else if !strings.Contains(r.Header.Get("Content-Type"), "json") {
+		// Allows both application/x-amz-json-1.1 and application/json
+		error415(w)
+
+	} else {
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		/*
+			The target endpoint is specified in the `X-Amz-Target` header.
+
+			The format is:	TrentService.<method>
+			For example: 	TrentService.ListKeys
+		*/
+
+		target := strings.Split(r.Header.Get("X-Amz-Target"), ".")
+
+		// Ensure we have at least the 2 components we expect, and the operation is one we support.
+		if len(target) >= 2 {
+			if operation, ok := handler.LookupOperation(target[1]); ok {
+
+				call := handler.NewCall(operation, r, s.logger)
+				response := dispatcher.Dispatch(call)
+
+				for name, values := range call.Header {
+					w.Header()[name] = values
+				}
+
+				respond(w, response)
+				return
+			}
+		}
+
+		// If we couldn't find a valid operation matching the request
+		error501(w, r)
+		return
+	}
+
+}
+
+func respond(w http.ResponseWriter, r handler.Response) {
+	w.WriteHeader(r.Code)
+	fmt.Fprint(w, r.Body)
+}
+
+func error404(w http.ResponseWriter) {
+	w.WriteHeader(404)
+	fmt.Fprint(w, "Page not found")
+}
+
+func error405(w http.ResponseWriter) {
+	w.WriteHeader(405)
+	fmt.Fprint(w, "Method Not Allowed")
+}
+
+func error415(w http.ResponseWriter) {
+	w.WriteHeader(415)
+	fmt.Fprint(w, "Only JSON based content types accepted")
+}
+
+func error501(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(501)
+	fmt.Fprintf(w, "Passed X-Amz-Target (%s) is not implemented", r.Header.Get("X-Amz-Target"))
+}
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nsmithuk/local-kms/src/data"
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestLogger() *log.Logger {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+/*
+Starts a server with the passed options, backed by memory storage, returning the URL the KMS API is served on.
+*/
+func newTestServer(t *testing.T, options Options) (*Server, string) {
+	t.Helper()
+
+	if options.Storage == nil {
+		options.Storage = data.NewMemoryStorage()
+	}
+
+	options.Logger = newTestLogger()
+	options.DisableLifecycleScheduler = true
+
+	server, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	kms := httptest.NewServer(server)
+	t.Cleanup(kms.Close)
+
+	return server, kms.URL
+}
+
+/*
+Calls a KMS operation, returning the response's status code and decoded body.
+*/
+func call(t *testing.T, url, operation string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := http.NewRequest("POST", url, bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", "TrentService."+operation)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	encoded, err = io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Some operations have an empty response
+	var content map[string]interface{}
+	if len(encoded) > 0 {
+		if err := json.Unmarshal(encoded, &content); err != nil {
+			t.Fatalf("%s: unable to decode the response: %s", operation, err)
+		}
+	}
+
+	return response.StatusCode, content
+}
+
+func mustCall(t *testing.T, url, operation string, body interface{}) map[string]interface{} {
+	t.Helper()
+
+	code, content := call(t, url, operation, body)
+	if code != 200 {
+		t.Fatalf("%s: expected a 200, got %d: %v", operation, code, content)
+	}
+
+	return content
+}
+
+func expectException(t *testing.T, url, operation string, body interface{}, exception string) {
+	t.Helper()
+
+	code, content := call(t, url, operation, body)
+	if code != 400 || content["__type"] != exception {
+		t.Errorf("%s: expected a %s, got %d: %v", operation, exception, code, content)
+	}
+}
+
+//------------------------------------------
+// External key stores
+
+const (
+	testXksAccessKeyId     = "AKIAXKSPROXYEXAMPLE1"
+	testXksSecretAccessKey = "Zm9vYmFyYmF6cXV4Zm9vYmFyYmF6cXV4Zm9vYmFyYmF6cXV4"
+)
+
+/*
+Starts a server whose XKS proxy is served alongside it, returning the URLs of both.
+*/
+func newXksTestServer(t *testing.T) (kmsUrl string, proxy *httptest.Server) {
+	t.Helper()
+
+	credentialsPath := filepath.Join(t.TempDir(), "xks-credentials.yaml")
+	err := os.WriteFile(credentialsPath, []byte("Credentials:\n"+
+		"  - AccessKeyId: "+testXksAccessKeyId+"\n"+
+		"    SecretAccessKey: "+testXksSecretAccessKey+"\n"+
+		"    AccountId: \"111122223333\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, kmsUrl := newTestServer(t, Options{XksProxyCredentialsPath: credentialsPath})
+
+	proxy = httptest.NewServer(server.XksProxy())
+	t.Cleanup(proxy.Close)
+
+	return kmsUrl, proxy
+}
+
+func createXksKeyStore(t *testing.T, kmsUrl, proxyUrl, secret string) (int, map[string]interface{}) {
+	t.Helper()
+
+	return call(t, kmsUrl, "CreateCustomKeyStore", map[string]interface{}{
+		"CustomKeyStoreName":   "xks",
+		"CustomKeyStoreType":   "EXTERNAL_KEY_STORE",
+		"XksProxyConnectivity": "PUBLIC_ENDPOINT",
+		"XksProxyUriEndpoint":  proxyUrl,
+		"XksProxyUriPath":      "/kms/xks/v1",
+		"XksProxyAuthenticationCredential": map[string]string{
+			"AccessKeyId":        testXksAccessKeyId,
+			"RawSecretAccessKey": secret,
+		},
+	})
+}
+
+/*
+Creates an EXTERNAL_KEY_STORE key, backed via the XKS proxy by another key on the same server.
+*/
+func createXksKey(t *testing.T, kmsUrl, proxyUrl string) (keyArn, externalKeyId string) {
+	t.Helper()
+
+	externalKey := mustCall(t, kmsUrl, "CreateKey", map[string]interface{}{})
+	externalKeyId = externalKey["KeyMetadata"].(map[string]interface{})["KeyId"].(string)
+
+	code, store := createXksKeyStore(t, kmsUrl, proxyUrl, testXksSecretAccessKey)
+	if code != 200 {
+		t.Fatalf("unable to create the external key store: %v", store)
+	}
+
+	mustCall(t, kmsUrl, "ConnectCustomKeyStore", map[string]interface{}{
+		"CustomKeyStoreId": store["CustomKeyStoreId"],
+	})
+
+	key := mustCall(t, kmsUrl, "CreateKey", map[string]interface{}{
+		"CustomKeyStoreId": store["CustomKeyStoreId"],
+		"Origin":           "EXTERNAL_KEY_STORE",
+		"XksKeyId":         externalKeyId,
+	})
+
+	metadata := key["KeyMetadata"].(map[string]interface{})
+	if metadata["Origin"] != "EXTERNAL_KEY_STORE" || metadata["KeyState"] != "Enabled" {
+		t.Fatalf("unexpected key metadata %v", metadata)
+	}
+
+	return metadata["Arn"].(string), externalKeyId
+}
+
+func TestExternalKeyStoreEncryptDecrypt(t *testing.T) {
+	kmsUrl, proxy := newXksTestServer(t)
+	keyArn, _ := createXksKey(t, kmsUrl, proxy.URL)
+
+	context := map[string]string{"purpose": "test"}
+
+	encrypted := mustCall(t, kmsUrl, "Encrypt", map[string]interface{}{
+		"KeyId":             keyArn,
+		"Plaintext":         []byte("external plaintext"),
+		"EncryptionContext": context,
+	})
+
+	decrypted := mustCall(t, kmsUrl, "Decrypt", map[string]interface{}{
+		"CiphertextBlob":    encrypted["CiphertextBlob"],
+		"EncryptionContext": context,
+	})
+
+	if decrypted["KeyId"] != keyArn || decrypted["Plaintext"] != "ZXh0ZXJuYWwgcGxhaW50ZXh0" {
+		t.Errorf("unexpected decrypt response %v", decrypted)
+	}
+
+	// The encryption context is bound to the ciphertext by the proxy
+	expectException(t, kmsUrl, "Decrypt", map[string]interface{}{
+		"CiphertextBlob":    encrypted["CiphertextBlob"],
+		"EncryptionContext": map[string]string{"purpose": "other"},
+	}, "InvalidCiphertextException")
+}
+
+func TestExternalKeyStoreProxyErrors(t *testing.T) {
+	kmsUrl, proxy := newXksTestServer(t)
+
+	code, content := createXksKeyStore(t, kmsUrl, proxy.URL, "b3RoZXJzZWNyZXRvdGhlcnNlY3JldG90aGVyc2VjcmV0b3RoZXI=")
+	if code != 400 || content["__type"] != "XksProxyIncorrectAuthenticationCredentialException" {
+		t.Errorf("expected an XksProxyIncorrectAuthenticationCredentialException, got %d: %v", code, content)
+	}
+
+	keyArn, externalKeyId := createXksKey(t, kmsUrl, proxy.URL)
+
+	keys := mustCall(t, kmsUrl, "DescribeKey", map[string]interface{}{"KeyId": keyArn})
+	storeId := keys["KeyMetadata"].(map[string]interface{})["CustomKeyStoreId"]
+
+	expectException(t, kmsUrl, "CreateKey", map[string]interface{}{
+		"CustomKeyStoreId": storeId,
+		"Origin":           "EXTERNAL_KEY_STORE",
+		"XksKeyId":         "2d1f6c1e-7e0a-4c55-8b53-6f0e0c2a4d91",
+	}, "XksKeyNotFoundException")
+
+	// The proxy refuses to use its key once it's disabled
+	mustCall(t, kmsUrl, "DisableKey", map[string]interface{}{"KeyId": externalKeyId})
+
+	expectException(t, kmsUrl, "Encrypt", map[string]interface{}{
+		"KeyId":     keyArn,
+		"Plaintext": []byte("external plaintext"),
+	}, "KMSInvalidStateException")
+
+	mustCall(t, kmsUrl, "EnableKey", map[string]interface{}{"KeyId": externalKeyId})
+
+	proxy.Close()
+
+	expectException(t, kmsUrl, "Encrypt", map[string]interface{}{
+		"KeyId":     keyArn,
+		"Plaintext": []byte("external plaintext"),
+	}, "XksProxyUriUnreachableException")
+}
+
+func TestExternalKeyStoreDryRun(t *testing.T) {
+	kmsUrl, proxy := newXksTestServer(t)
+	keyArn, _ := createXksKey(t, kmsUrl, proxy.URL)
+
+	encrypted := mustCall(t, kmsUrl, "Encrypt", map[string]interface{}{
+		"KeyId":     keyArn,
+		"Plaintext": []byte("external plaintext"),
+	})
+
+	// A dry run passes every check, without calling the proxy
+	proxy.Close()
+
+	tests := map[string]map[string]interface{}{
+		"Encrypt":         {"KeyId": keyArn, "Plaintext": []byte("external plaintext")},
+		"Decrypt":         {"CiphertextBlob": encrypted["CiphertextBlob"]},
+		"GenerateDataKey": {"KeyId": keyArn, "KeySpec": "AES_256"},
+		"ReEncrypt":       {"CiphertextBlob": encrypted["CiphertextBlob"], "DestinationKeyId": keyArn},
+	}
+
+	for operation, body := range tests {
+		body["DryRun"] = true
+
+		code, content := call(t, kmsUrl, operation, body)
+		if code != 412 || content["__type"] != "DryRunOperationException" {
+			t.Errorf("%s: expected a DryRunOperationException, got %d: %v", operation, code, content)
+		}
+	}
+}
+
+//------------------------------------------
+// Embedding
+
+func TestServersAreIsolated(t *testing.T) {
+	serverA, urlA := newTestServer(t, Options{})
+	_, urlB := newTestServer(t, Options{
+		AccountId:        "444455556666",
+		Region:           "us-east-1",
+		RequestRateLimit: 0.001,
+		// Allows the calls below, and no more
+		RequestBurstLimit: 4,
+	})
+
+	key := mustCall(t, urlA, "CreateKey", map[string]interface{}{})
+	metadata := key["KeyMetadata"].(map[string]interface{})
+
+	if metadata["Arn"] != "arn:aws:kms:eu-west-2:111122223333:key/"+metadata["KeyId"].(string) {
+		t.Errorf("unexpected ARN %s", metadata["Arn"])
+	}
+
+	mustCall(t, urlA, "CreateAlias", map[string]interface{}{"AliasName": "alias/shared", "TargetKeyId": metadata["KeyId"]})
+
+	// The other server has its own database
+	expectException(t, urlB, "DescribeKey", map[string]interface{}{"KeyId": metadata["KeyId"]}, "NotFoundException")
+	expectException(t, urlB, "DescribeKey", map[string]interface{}{"KeyId": "alias/shared"}, "NotFoundException")
+
+	if keys := mustCall(t, urlB, "ListKeys", map[string]interface{}{}); len(keys["Keys"].([]interface{})) != 0 {
+		t.Errorf("expected no keys, got %v", keys["Keys"])
+	}
+
+	// And its own account and region
+	other := mustCall(t, urlB, "CreateKey", map[string]interface{}{})
+	otherMetadata := other["KeyMetadata"].(map[string]interface{})
+
+	if otherMetadata["Arn"] != "arn:aws:kms:us-east-1:444455556666:key/"+otherMetadata["KeyId"].(string) {
+		t.Errorf("unexpected ARN %s", otherMetadata["Arn"])
+	}
+
+	// Only the second server is throttled
+	code, content := call(t, urlB, "ListKeys", map[string]interface{}{})
+	if code != 400 || content["__type"] != "ThrottlingException" {
+		t.Errorf("expected a ThrottlingException, got %d: %v", code, content)
+	}
+
+	for i := 0; i < 5; i++ {
+		mustCall(t, urlA, "ListKeys", map[string]interface{}{})
+	}
+
+	// Each has its own metrics
+	recorder := httptest.NewRecorder()
+	serverA.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if body := recorder.Body.String(); !strings.Contains(body, `local_kms_requests_total{operation="ListKeys",code="200"} 5`+"\n") ||
+		strings.Contains(body, `code="400"`) {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+}
+
+func TestRunReturnsErrors(t *testing.T) {
+	options := Options{Storage: data.NewMemoryStorage(), Logger: newTestLogger(), DisableLifecycleScheduler: true}
+
+	if err := Run("0", "", Options{Logger: newTestLogger()}); err == nil {
+		t.Error("expected an error without a data path or storage")
+	}
+
+	if err := Run("0", "0", options); err == nil {
+		t.Error("expected an error serving the XKS Proxy API without a credential registry")
+	}
+
+	// The port is already in use
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	options.Storage = data.NewMemoryStorage()
+	if err := Run(port, "", options); err == nil {
+		t.Error("expected an error listening on a port in use")
+	}
+}
+
+func TestMiddlewareOption(t *testing.T) {
+	deny := func(next Invoker) Invoker {
+		return func(c *Call) Response {
+			if c.Operation.Name == "ScheduleKeyDeletion" {
+				return NewExceptionResponse("AccessDeniedException", "denied by middleware")
+			}
+			return next(c)
+		}
+	}
+
+	_, url := newTestServer(t, Options{Middleware: []Middleware{deny}})
+
+	key := mustCall(t, url, "CreateKey", map[string]interface{}{})["KeyMetadata"].(map[string]interface{})
+
+	expectException(t, url, "ScheduleKeyDeletion", map[string]interface{}{"KeyId": key["KeyId"]}, "AccessDeniedException")
+}
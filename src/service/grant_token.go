@@ -0,0 +1,54 @@
+package service
+
+import (
+	"encoding/base64"
+)
+
+/*
+Grant tokens are opaque to the caller, but we pack in the grant's key ARN and ID so
+the grant can be found again when the token is presented.
+
+The decoded token is:
+
+	A) The length of the key ARN	: 1 byte
+	B) The key ARN					: A bytes
+	C) The length of the grant ID	: 1 byte
+	D) The grant ID					: C bytes
+	E) Random padding				: remaining bytes
+*/
+func PackGrantToken(keyArn, grantId string) string {
+	data := []byte{byte(len(keyArn))}
+	data = append(data, []byte(keyArn)...)
+	data = append(data, byte(len(grantId)))
+	data = append(data, []byte(grantId)...)
+	data = append(data, GenerateRandomData(64)...)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func UnpackGrantToken(token string) (keyArn, grantId string, ok bool) {
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) < 1 {
+		return
+	}
+
+	arnLength := int(data[0])
+	if len(data) < 1+arnLength+1 {
+		return // data too short.
+	}
+
+	keyArn = string(data[1 : arnLength+1])
+
+	//---
+
+	idLength := int(data[arnLength+1])
+	if len(data) < 1+arnLength+1+idLength {
+		return // data too short.
+	}
+
+	grantId = string(data[arnLength+2 : arnLength+2+idLength])
+
+	ok = keyArn != "" && grantId != ""
+	return
+}
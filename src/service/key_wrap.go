@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+)
+
+// The alternative initial value defined by RFC 5649
+var keyWrapWithPaddingIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+/*
+Unwraps key material wrapped with AES Key Wrap with Padding, as defined by RFC 5649.
+*/
+func UnwrapKeyWithPadding(kek []byte, wrapped []byte) ([]byte, error) {
+
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, errors.New("invalid wrapped key length")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+
+	a := make([]byte, 8)
+	r := make([]byte, len(wrapped)-8)
+	b := make([]byte, 16)
+
+	if n == 1 {
+		// A single block is encrypted directly
+		block.Decrypt(b, wrapped)
+		copy(a, b[:8])
+		copy(r, b[8:])
+
+	} else {
+		// Otherwise it's the RFC 3394 unwrapping process
+		copy(a, wrapped[:8])
+		copy(r, wrapped[8:])
+
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				t := uint64(n*j + i)
+
+				binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a)^t)
+				copy(b[8:], r[(i-1)*8:i*8])
+
+				block.Decrypt(b, b)
+
+				copy(a, b[:8])
+				copy(r[(i-1)*8:i*8], b[8:])
+			}
+		}
+	}
+
+	//---
+
+	if !bytes.Equal(a[:4], keyWrapWithPaddingIV) {
+		return nil, errors.New("integrity check failed")
+	}
+
+	// The message length indicator must leave between 0 and 7 bytes of padding
+	mli := int(binary.BigEndian.Uint32(a[4:]))
+	if mli <= len(r)-8 || mli > len(r) {
+		return nil, errors.New("integrity check failed")
+	}
+
+	for _, p := range r[mli:] {
+		if p != 0 {
+			return nil, errors.New("integrity check failed")
+		}
+	}
+
+	return r[:mli], nil
+}
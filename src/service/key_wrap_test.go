@@ -0,0 +1,81 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The test vectors from section 6 of RFC 5649
+const rfc5649Kek = "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8"
+
+func TestUnwrapKeyWithPadding(t *testing.T) {
+	tests := map[string]struct {
+		key     string
+		wrapped string
+	}{
+		"20 octet key": {
+			key:     "c37b7e6492584340bed12207808941155068f738",
+			wrapped: "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a",
+		},
+		"7 octet key, wrapped as a single block": {
+			key:     "466f7250617369",
+			wrapped: "afbeb0f07dfbf5419200f2ccb50bb24f",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			key, err := UnwrapKeyWithPadding(decodeHex(t, rfc5649Kek), decodeHex(t, test.wrapped))
+			if err != nil {
+				t.Fatalf("unable to unwrap the key: %s", err)
+			}
+
+			if !bytes.Equal(key, decodeHex(t, test.key)) {
+				t.Errorf("expected %s, got %x", test.key, key)
+			}
+		})
+	}
+}
+
+func TestUnwrapKeyWithPaddingInvalid(t *testing.T) {
+	kek := decodeHex(t, rfc5649Kek)
+	wrapped := "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"
+
+	tampered := decodeHex(t, wrapped)
+	tampered[len(tampered)-1] ^= 0x01
+
+	otherKek := decodeHex(t, rfc5649Kek)
+	otherKek[0] ^= 0x01
+
+	tests := map[string]struct {
+		kek     []byte
+		wrapped []byte
+	}{
+		"tampered":                  {kek, tampered},
+		"another key":               {otherKek, decodeHex(t, wrapped)},
+		"too short":                 {kek, decodeHex(t, "afbeb0f07dfbf541")},
+		"not a multiple of 8":       {kek, decodeHex(t, wrapped)[:30]},
+		"invalid key length":        {kek[:10], decodeHex(t, wrapped)},
+		"tampered, single block":    {kek, decodeHex(t, "afbeb0f07dfbf5419200f2ccb50bb24e")},
+		"another key, single block": {otherKek, decodeHex(t, "afbeb0f07dfbf5419200f2ccb50bb24f")},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := UnwrapKeyWithPadding(test.kek, test.wrapped); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
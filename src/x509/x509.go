@@ -15,6 +15,7 @@ import (
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/tjfoc/gmsm/sm2"
 )
 
 // RFC 3279, 2.3 Public Key Algorithms
@@ -213,6 +214,8 @@ func marshalPublicKey(pub interface{}) (publicKeyBytes []byte, publicKeyAlgorith
 
 // secp256k1 ident 1.3.132.0.10
 
+// sm2p256v1 ident 1.2.156.10197.1.301 (GM/T 0006)
+
 // NB: secp256r1 is equivalent to prime256v1
 var (
 	oidNamedCurveP224  = asn1.ObjectIdentifier{1, 3, 132, 0, 33}
@@ -220,6 +223,7 @@ var (
 	oidNamedCurveP384  = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
 	oidNamedCurveP521  = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
 	oidNamedCurve256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+	oidNamedCurveSM2   = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
 )
 
 func oidFromNamedCurve(curve elliptic.Curve) (asn1.ObjectIdentifier, bool) {
@@ -234,7 +238,27 @@ func oidFromNamedCurve(curve elliptic.Curve) (asn1.ObjectIdentifier, bool) {
 		return oidNamedCurveP521, true
 	case btcec.S256():
 		return oidNamedCurve256k1, true
+	case sm2.P256Sm2():
+		return oidNamedCurveSM2, true
 	}
 
 	return nil, false
 }
+
+func namedCurveFromOID(oid asn1.ObjectIdentifier) elliptic.Curve {
+	switch {
+	case oid.Equal(oidNamedCurveP224):
+		return elliptic.P224()
+	case oid.Equal(oidNamedCurveP256):
+		return elliptic.P256()
+	case oid.Equal(oidNamedCurveP384):
+		return elliptic.P384()
+	case oid.Equal(oidNamedCurveP521):
+		return elliptic.P521()
+	case oid.Equal(oidNamedCurve256k1):
+		return btcec.S256()
+	case oid.Equal(oidNamedCurveSM2):
+		return sm2.P256Sm2()
+	}
+	return nil
+}
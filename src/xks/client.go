@@ -0,0 +1,269 @@
+package xks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// The service name requests to an XKS proxy are signed for.
+const SigningService = "kms-xks-proxy"
+
+// The only key spec, and encryption algorithm, supported by external keys.
+const (
+	KeySpecAes256          = "AES_256"
+	EncryptionAlgorithmGcm = "AES_GCM"
+)
+
+/*
+Returned when a request to the proxy fails. Type is the name of the exception returned to the KMS client.
+*/
+type Error struct {
+	Type    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+func newError(exception, format string, a ...interface{}) *Error {
+	return &Error{exception, fmt.Sprintf(format, a...)}
+}
+
+//------------------------------------------
+
+/*
+Describes the KMS request a proxy request is made on behalf of.
+*/
+type RequestMetadata struct {
+	AwsPrincipalArn string `json:"awsPrincipalArn"`
+	KmsKeyArn       string `json:"kmsKeyArn,omitempty"`
+	KmsOperation    string `json:"kmsOperation"`
+	KmsRequestId    string `json:"kmsRequestId"`
+}
+
+type KeyMetadata struct {
+	KeySpec   string   `json:"keySpec"`
+	KeyUsage  []string `json:"keyUsage"`
+	KeyStatus string   `json:"keyStatus"`
+}
+
+/*
+A plaintext, as encrypted by the proxy. All fields are needed to decrypt it.
+*/
+type Ciphertext struct {
+	Ciphertext           []byte `json:"ciphertext"`
+	InitializationVector []byte `json:"initializationVector"`
+	AuthenticationTag    []byte `json:"authenticationTag"`
+	CiphertextMetadata   []byte `json:"ciphertextMetadata,omitempty"`
+}
+
+type HealthStatus struct {
+	XksProxyFleetSize int    `json:"xksProxyFleetSize"`
+	XksProxyVendor    string `json:"xksProxyVendor"`
+	XksProxyModel     string `json:"xksProxyModel"`
+	EkmVendor         string `json:"ekmVendor"`
+	EkmFleetDetails   []struct {
+		Id           string `json:"id"`
+		Model        string `json:"model"`
+		HealthStatus string `json:"healthStatus"`
+	} `json:"ekmFleetDetails"`
+}
+
+//------------------------------------------
+
+/*
+A client for the XKS Proxy API, as defined by the AWS KMS External Key Store Proxy API Specification.
+*/
+type Client struct {
+	// The URI endpoint and path, e.g. https://xks.example.com/example/kms/xks/v1
+	base   string
+	region string
+	signer *v4.Signer
+	http   *http.Client
+}
+
+func NewClient(uriEndpoint, uriPath, accessKeyId, secretAccessKey, region string) *Client {
+	return &Client{
+		base:   strings.TrimSuffix(uriEndpoint, "/") + uriPath,
+		region: region,
+		signer: v4.NewSigner(credentials.NewStaticCredentials(accessKeyId, secretAccessKey, "")),
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Health(metadata RequestMetadata) (*HealthStatus, error) {
+	var status HealthStatus
+
+	err := c.post("/health", &struct {
+		RequestMetadata RequestMetadata `json:"requestMetadata"`
+	}{metadata}, &status)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+func (c *Client) GetKeyMetadata(keyId string, metadata RequestMetadata) (*KeyMetadata, error) {
+	var key KeyMetadata
+
+	err := c.post("/keys/"+url.PathEscape(keyId)+"/metadata", &struct {
+		RequestMetadata RequestMetadata `json:"requestMetadata"`
+	}{metadata}, &key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key.KeySpec == "" || key.KeyStatus == "" {
+		return nil, newError("XksProxyInvalidResponseException", "The XKS proxy returned incomplete key metadata.")
+	}
+
+	return &key, nil
+}
+
+func (c *Client) Encrypt(keyId string, plaintext, aad []byte, metadata RequestMetadata) (*Ciphertext, error) {
+	var ciphertext Ciphertext
+
+	err := c.post("/keys/"+url.PathEscape(keyId)+"/encrypt", &struct {
+		RequestMetadata             RequestMetadata `json:"requestMetadata"`
+		Plaintext                   []byte          `json:"plaintext"`
+		EncryptionAlgorithm         string          `json:"encryptionAlgorithm"`
+		AdditionalAuthenticatedData []byte          `json:"additionalAuthenticatedData,omitempty"`
+	}{metadata, plaintext, EncryptionAlgorithmGcm, aad}, &ciphertext)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext.InitializationVector) == 0 || len(ciphertext.AuthenticationTag) == 0 {
+		return nil, newError("XksProxyInvalidResponseException", "The XKS proxy returned an incomplete ciphertext.")
+	}
+
+	return &ciphertext, nil
+}
+
+func (c *Client) Decrypt(keyId string, ciphertext *Ciphertext, aad []byte, metadata RequestMetadata) ([]byte, error) {
+	var plaintext struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+
+	err := c.post("/keys/"+url.PathEscape(keyId)+"/decrypt", &struct {
+		RequestMetadata             RequestMetadata `json:"requestMetadata"`
+		EncryptionAlgorithm         string          `json:"encryptionAlgorithm"`
+		AdditionalAuthenticatedData []byte          `json:"additionalAuthenticatedData,omitempty"`
+		*Ciphertext
+	}{metadata, EncryptionAlgorithmGcm, aad, ciphertext}, &plaintext)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return plaintext.Plaintext, nil
+}
+
+//------------------------------------------
+
+/*
+Sends a signed request to the proxy, decoding the response into v.
+*/
+func (c *Client) post(path string, body interface{}, v interface{}) error {
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, c.base+path, bytes.NewReader(encoded))
+	if err != nil {
+		return newError("XksProxyInvalidConfigurationException", "The XKS proxy URI is invalid: %s", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = c.signer.Sign(request, bytes.NewReader(encoded), SigningService, c.region, time.Now())
+	if err != nil {
+		return err
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return newError("DependencyTimeoutException", "The XKS proxy timed out: %s", err)
+		}
+		return newError("XksProxyUriUnreachableException", "The XKS proxy is unreachable: %s", err)
+	}
+	defer response.Body.Close()
+
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return newError("XksProxyUriUnreachableException", "Unable to read the XKS proxy's response: %s", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return responseError(response.StatusCode, content)
+	}
+
+	if err := json.Unmarshal(content, v); err != nil {
+		return newError("XksProxyInvalidResponseException", "The XKS proxy's response is invalid: %s", err)
+	}
+
+	return nil
+}
+
+/*
+Maps an error returned by the proxy to the exception KMS returns.
+*/
+func responseError(status int, content []byte) *Error {
+
+	var proxyError struct {
+		ErrorName    string `json:"errorName"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+
+	if err := json.Unmarshal(content, &proxyError); err != nil || proxyError.ErrorName == "" {
+		return newError("XksProxyInvalidResponseException", "The XKS proxy returned HTTP %d, without a valid error.", status)
+	}
+
+	// The message is optional, so fall back to the error's name
+	if proxyError.ErrorMessage == "" {
+		proxyError.ErrorMessage = proxyError.ErrorName
+	}
+
+	switch proxyError.ErrorName {
+	case "AuthenticationFailedException":
+		return newError("XksProxyIncorrectAuthenticationCredentialException",
+			"The XKS proxy rejected the authentication credential: %s", proxyError.ErrorMessage)
+	case "AccessDeniedException":
+		return newError("KMSInvalidStateException",
+			"The XKS proxy denied access: %s", proxyError.ErrorMessage)
+	case "InvalidUriPathException":
+		return newError("XksProxyInvalidConfigurationException",
+			"The XKS proxy URI path is invalid: %s", proxyError.ErrorMessage)
+	case "KeyNotFoundException":
+		return newError("XksKeyNotFoundException",
+			"The external key was not found: %s", proxyError.ErrorMessage)
+	case "InvalidStateException", "InvalidKeyUsageException":
+		return newError("KMSInvalidStateException",
+			"The external key cannot be used: %s", proxyError.ErrorMessage)
+	case "InvalidCiphertextException":
+		return newError("InvalidCiphertextException", "")
+	default:
+		return newError("XksProxyInvalidResponseException",
+			"The XKS proxy returned %s: %s", proxyError.ErrorName, proxyError.ErrorMessage)
+	}
+}
@@ -0,0 +1,149 @@
+package xks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testUriPath         = "/kms/xks/v1"
+	testAccessKeyId     = "AKIAXKSPROXYEXAMPLE1"
+	testSecretAccessKey = "Zm9vYmFyYmF6cXV4Zm9vYmFyYmF6cXV4Zm9vYmFyYmF6cXV4"
+)
+
+var testMetadata = RequestMetadata{
+	AwsPrincipalArn: "arn:aws:iam::111122223333:user/alice",
+	KmsOperation:    "Encrypt",
+	KmsRequestId:    "4112f4d6-db54-4af4-ae30-c55a22a8dfae",
+}
+
+/*
+Starts a proxy that responds to every request with the passed status and body.
+*/
+func newStubProxy(t *testing.T, status int, body string) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return NewClient(server.URL, testUriPath, testAccessKeyId, testSecretAccessKey, "eu-west-2")
+}
+
+func expectError(t *testing.T, err error, exception string) {
+	t.Helper()
+
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected a %s, got %v", exception, err)
+	}
+
+	if e.Type != exception {
+		t.Errorf("expected a %s, got %s", exception, e)
+	}
+}
+
+func TestProxyErrorMapping(t *testing.T) {
+	tests := map[string]struct {
+		status    int
+		body      string
+		exception string
+	}{
+		"authentication failed": {
+			http.StatusUnauthorized, `{"errorName":"AuthenticationFailedException"}`,
+			"XksProxyIncorrectAuthenticationCredentialException",
+		},
+		"access denied": {
+			http.StatusForbidden, `{"errorName":"AccessDeniedException","errorMessage":"denied"}`,
+			"KMSInvalidStateException",
+		},
+		"invalid URI path": {
+			http.StatusNotFound, `{"errorName":"InvalidUriPathException"}`,
+			"XksProxyInvalidConfigurationException",
+		},
+		"key not found": {
+			http.StatusNotFound, `{"errorName":"KeyNotFoundException"}`,
+			"XksKeyNotFoundException",
+		},
+		"invalid state": {
+			http.StatusBadRequest, `{"errorName":"InvalidStateException"}`,
+			"KMSInvalidStateException",
+		},
+		"invalid key usage": {
+			http.StatusBadRequest, `{"errorName":"InvalidKeyUsageException"}`,
+			"KMSInvalidStateException",
+		},
+		"invalid ciphertext": {
+			http.StatusBadRequest, `{"errorName":"InvalidCiphertextException"}`,
+			"InvalidCiphertextException",
+		},
+		"unknown error": {
+			http.StatusInternalServerError, `{"errorName":"InternalException","errorMessage":"failed"}`,
+			"XksProxyInvalidResponseException",
+		},
+		"error without a name": {
+			http.StatusInternalServerError, `{}`,
+			"XksProxyInvalidResponseException",
+		},
+		"error that isn't JSON": {
+			http.StatusBadGateway, `Bad Gateway`,
+			"XksProxyInvalidResponseException",
+		},
+		"success that isn't JSON": {
+			http.StatusOK, `ok`,
+			"XksProxyInvalidResponseException",
+		},
+		"incomplete key metadata": {
+			http.StatusOK, `{"keySpec":"AES_256"}`,
+			"XksProxyInvalidResponseException",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := newStubProxy(t, test.status, test.body).GetKeyMetadata("key", testMetadata)
+			expectError(t, err, test.exception)
+		})
+	}
+}
+
+func TestProxyIncompleteCiphertext(t *testing.T) {
+	body, _ := json.Marshal(&Ciphertext{Ciphertext: []byte("ciphertext")})
+
+	_, err := newStubProxy(t, http.StatusOK, string(body)).Encrypt("key", []byte("plaintext"), nil, testMetadata)
+	expectError(t, err, "XksProxyInvalidResponseException")
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, err := NewClient(server.URL, testUriPath, testAccessKeyId, testSecretAccessKey, "eu-west-2").Health(testMetadata)
+	expectError(t, err, "XksProxyUriUnreachableException")
+}
+
+func TestProxyInvalidUri(t *testing.T) {
+	_, err := NewClient("http://xks example", testUriPath, testAccessKeyId, testSecretAccessKey, "eu-west-2").Health(testMetadata)
+	expectError(t, err, "XksProxyInvalidConfigurationException")
+}
+
+func TestProxyTimeout(t *testing.T) {
+	done := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := NewClient(server.URL, testUriPath, testAccessKeyId, testSecretAccessKey, "eu-west-2")
+	client.http.Timeout = 50 * time.Millisecond
+
+	_, err := client.Health(testMetadata)
+	expectError(t, err, "DependencyTimeoutException")
+}
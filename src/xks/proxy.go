@@ -0,0 +1,327 @@
+package xks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nsmithuk/local-kms/src/auth"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// The length of the initialization vectors generated by the proxy.
+const ivSize = 12
+
+/*
+An error returned by the proxy, as defined by the XKS Proxy API. Its name and message are returned to KMS.
+*/
+type proxyError struct {
+	status  int
+	name    string
+	message string
+}
+
+func newProxyError(status int, name, format string, a ...interface{}) *proxyError {
+	return &proxyError{status, name, fmt.Sprintf(format, a...)}
+}
+
+//------------------------------------------
+
+/*
+Serves the XKS Proxy API, backed by local-kms's own AES keys.
+
+	External key IDs are the IDs, ARNs or aliases of local SYMMETRIC_DEFAULT keys. Requests must be signed, using SigV4
+	for the kms-xks-proxy service, with one of the registry's credentials.
+*/
+type Proxy struct {
+	uriPath  string
+	config   *config.Config
+	database *data.Database
+	registry *auth.Registry
+	logger   *log.Logger
+}
+
+func NewProxy(uriPath string, config *config.Config, database *data.Database, registry *auth.Registry, logger *log.Logger) *Proxy {
+	return &Proxy{
+		uriPath:  strings.TrimSuffix(uriPath, "/"),
+		config:   config,
+		database: database,
+		registry: registry,
+		logger:   logger,
+	}
+}
+
+// The URI path prefix the API is served under.
+func (p *Proxy) UriPath() string {
+	return p.uriPath
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.logger.Debugf("XKS proxy: %s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+	response, err := p.handle(r)
+	if err != nil {
+		p.logger.Warnf("XKS proxy: %s: %s", err.name, err.message)
+
+		response = &struct {
+			ErrorName    string `json:"errorName"`
+			ErrorMessage string `json:"errorMessage"`
+		}{err.name, err.message}
+	}
+
+	encoded, _ := json.Marshal(response)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+		w.WriteHeader(err.status)
+	}
+
+	w.Write(encoded)
+}
+
+func (p *Proxy) handle(r *http.Request) (interface{}, *proxyError) {
+
+	if r.Method != http.MethodPost {
+		return nil, newProxyError(http.StatusMethodNotAllowed, "ValidationException", "Method %s is not supported", r.Method)
+	}
+
+	if _, err := p.registry.Verify(r, SigningService, p.config.IsServedRegion); err != nil {
+		return nil, newProxyError(http.StatusUnauthorized, "AuthenticationFailedException", "%s", err)
+	}
+
+	//---
+	// Route the request. The key ID is taken from the escaped path, as it may contain slashes.
+
+	path := r.URL.EscapedPath()
+
+	if !strings.HasPrefix(path, p.uriPath+"/") {
+		return nil, newProxyError(http.StatusNotFound, "InvalidUriPathException", "Unknown URI path %s", r.URL.Path)
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, p.uriPath+"/"), "/")
+
+	var body struct {
+		RequestMetadata             *RequestMetadata `json:"requestMetadata"`
+		Plaintext                   []byte           `json:"plaintext"`
+		EncryptionAlgorithm         string           `json:"encryptionAlgorithm"`
+		AdditionalAuthenticatedData []byte           `json:"additionalAuthenticatedData"`
+		Ciphertext
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, newProxyError(http.StatusBadRequest, "ValidationException", "Unable to decode the request body: %s", err)
+	}
+
+	if body.RequestMetadata == nil {
+		return nil, newProxyError(http.StatusBadRequest, "ValidationException", "requestMetadata is required")
+	}
+
+	p.logger.Infof("XKS proxy: %s request from %s, for %s", r.URL.Path, body.RequestMetadata.AwsPrincipalArn,
+		body.RequestMetadata.KmsOperation)
+
+	if len(segments) == 1 && segments[0] == "health" {
+		return p.health(), nil
+	}
+
+	if len(segments) != 3 || segments[0] != "keys" {
+		return nil, newProxyError(http.StatusNotFound, "InvalidUriPathException", "Unknown URI path %s", r.URL.Path)
+	}
+
+	keyId, err := url.PathUnescape(segments[1])
+	if err != nil {
+		return nil, newProxyError(http.StatusNotFound, "InvalidUriPathException", "Invalid key ID %s", segments[1])
+	}
+
+	key, e := p.getKey(keyId)
+	if e != nil {
+		return nil, e
+	}
+
+	switch segments[2] {
+	case "metadata":
+		return newKeyMetadata(key), nil
+
+	case "encrypt":
+		if e := checkUsable(key, body.EncryptionAlgorithm); e != nil {
+			return nil, e
+		}
+		return encrypt(key, body.Plaintext, body.AdditionalAuthenticatedData)
+
+	case "decrypt":
+		if e := checkUsable(key, body.EncryptionAlgorithm); e != nil {
+			return nil, e
+		}
+		return decrypt(key, &body.Ciphertext, body.AdditionalAuthenticatedData)
+	}
+
+	return nil, newProxyError(http.StatusNotFound, "InvalidUriPathException", "Unknown URI path %s", r.URL.Path)
+}
+
+//------------------------------------------
+
+func (p *Proxy) health() *HealthStatus {
+	status := &HealthStatus{
+		XksProxyFleetSize: 1,
+		XksProxyVendor:    "local-kms",
+		XksProxyModel:     "local-kms",
+		EkmVendor:         "local-kms",
+	}
+
+	status.EkmFleetDetails = append(status.EkmFleetDetails, struct {
+		Id           string `json:"id"`
+		Model        string `json:"model"`
+		HealthStatus string `json:"healthStatus"`
+	}{"local-kms", "local-kms", "ACTIVE"})
+
+	return status
+}
+
+/*
+Finds the local key backing the external key ID, following aliases.
+*/
+func (p *Proxy) getKey(keyId string) (*cmk.AesKey, *proxyError) {
+
+	arn := keyId
+
+	if strings.HasPrefix(keyId, "alias/") || (strings.HasPrefix(keyId, "arn:") && strings.Contains(keyId, ":alias/")) {
+		alias, err := p.database.LoadAlias(p.config.EnsureArn("", keyId))
+		if err != nil {
+			return nil, newProxyError(http.StatusNotFound, "KeyNotFoundException", "Alias %s not found", keyId)
+		}
+		arn = alias.TargetKeyId
+	}
+
+	key, err := p.database.LoadKey(p.config.EnsureArn("key/", arn))
+	if err != nil {
+		return nil, newProxyError(http.StatusNotFound, "KeyNotFoundException", "Key %s not found", keyId)
+	}
+
+	aesKey, ok := key.(*cmk.AesKey)
+	if !ok {
+		return nil, newProxyError(http.StatusBadRequest, "InvalidKeyUsageException",
+			"Key %s is a %s key. Only SYMMETRIC_DEFAULT keys can be used.", keyId, key.GetMetadata().KeySpec)
+	}
+
+	return aesKey, nil
+}
+
+func newKeyMetadata(key *cmk.AesKey) *KeyMetadata {
+	status := "DISABLED"
+	if key.GetMetadata().KeyState == cmk.KeyStateEnabled && len(key.BackingKeys) > 0 {
+		status = "ENABLED"
+	}
+
+	return &KeyMetadata{
+		KeySpec:   KeySpecAes256,
+		KeyUsage:  []string{"ENCRYPT", "DECRYPT"},
+		KeyStatus: status,
+	}
+}
+
+func checkUsable(key *cmk.AesKey, algorithm string) *proxyError {
+	if algorithm != EncryptionAlgorithmGcm {
+		return newProxyError(http.StatusNotImplemented, "UnsupportedOperationException",
+			"Encryption algorithm '%s' is not supported", algorithm)
+	}
+
+	if key.GetMetadata().KeyState != cmk.KeyStateEnabled || len(key.BackingKeys) == 0 {
+		return newProxyError(http.StatusBadRequest, "InvalidStateException",
+			"Key %s is %s", key.GetArn(), key.GetMetadata().KeyState)
+	}
+
+	return nil
+}
+
+//------------------------------------------
+
+/*
+Encrypts the plaintext under the key's current backing key. The backing key's version is returned as the ciphertext
+metadata, which is then authenticated along with the additional authenticated data.
+*/
+func encrypt(key *cmk.AesKey, plaintext, aad []byte) (*Ciphertext, *proxyError) {
+
+	version := uint32(len(key.BackingKeys) - 1)
+
+	metadata := make([]byte, 4)
+	binary.LittleEndian.PutUint32(metadata, version)
+
+	gcm, err := newGcm(key.BackingKeys[version].Key)
+	if err != nil {
+		return nil, newProxyError(http.StatusInternalServerError, "InternalException", "%s", err)
+	}
+
+	iv := service.GenerateRandomData(ivSize)
+
+	sealed := gcm.Seal(nil, iv, plaintext, authenticatedData(aad, metadata))
+	tagStart := len(sealed) - gcm.Overhead()
+
+	return &Ciphertext{
+		Ciphertext:           sealed[:tagStart],
+		InitializationVector: iv,
+		AuthenticationTag:    sealed[tagStart:],
+		CiphertextMetadata:   metadata,
+	}, nil
+}
+
+func decrypt(key *cmk.AesKey, ciphertext *Ciphertext, aad []byte) (interface{}, *proxyError) {
+
+	if len(ciphertext.CiphertextMetadata) != 4 || len(ciphertext.InitializationVector) != ivSize {
+		return nil, newProxyError(http.StatusBadRequest, "InvalidCiphertextException", "The ciphertext is invalid")
+	}
+
+	version := binary.LittleEndian.Uint32(ciphertext.CiphertextMetadata)
+	if version >= uint32(len(key.BackingKeys)) {
+		return nil, newProxyError(http.StatusBadRequest, "InvalidCiphertextException", "The ciphertext is invalid")
+	}
+
+	gcm, err := newGcm(key.BackingKeys[version].Key)
+	if err != nil {
+		return nil, newProxyError(http.StatusInternalServerError, "InternalException", "%s", err)
+	}
+
+	sealed := append(append([]byte{}, ciphertext.Ciphertext...), ciphertext.AuthenticationTag...)
+
+	plaintext, err := gcm.Open(nil, ciphertext.InitializationVector, sealed,
+		authenticatedData(aad, ciphertext.CiphertextMetadata))
+
+	if err != nil {
+		return nil, newProxyError(http.StatusBadRequest, "InvalidCiphertextException", "The ciphertext is invalid")
+	}
+
+	return &struct {
+		Plaintext []byte `json:"plaintext"`
+	}{plaintext}, nil
+}
+
+func newGcm(key [32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCMWithNonceSize(block, ivSize)
+}
+
+/*
+Combines the additional authenticated data and ciphertext metadata, as described by the XKS Proxy API:
+the 2 byte length of the AAD, the AAD, the 1 byte length of the metadata, then the metadata.
+*/
+func authenticatedData(aad, metadata []byte) []byte {
+	result := make([]byte, 2, 3+len(aad)+len(metadata))
+	binary.BigEndian.PutUint16(result, uint16(len(aad)))
+
+	result = append(result, aad...)
+	result = append(result, byte(len(metadata)))
+
+	return append(result, metadata...)
+}
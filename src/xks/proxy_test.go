@@ -0,0 +1,239 @@
+package xks
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsmithuk/local-kms/src/auth"
+	"github.com/nsmithuk/local-kms/src/cmk"
+	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	log "github.com/sirupsen/logrus"
+)
+
+const testKeyId = "0f3e8b4c-6c2f-4d55-9d7a-2b8c9a1e5f10"
+
+/*
+Serves the XKS Proxy API, backed by a single enabled AES key, returning a client for it.
+*/
+func newTestProxy(t *testing.T) (*Client, *data.Database) {
+	t.Helper()
+
+	c := &config.Config{AWSRegion: "eu-west-2", AWSAccountId: "111122223333"}
+	database := data.NewDatabase(data.NewMemoryStorage())
+
+	key := cmk.NewAesKey(cmk.KeyMetadata{
+		AWSAccountId: c.AWSAccountId,
+		Arn:          c.ArnPrefix() + "key/" + testKeyId,
+		KeyId:        testKeyId,
+		Enabled:      true,
+		KeyState:     cmk.KeyStateEnabled,
+		KeyUsage:     cmk.UsageEncryptDecrypt,
+		KeySpec:      cmk.SpecSymmetricDefault,
+	}, "", cmk.KeyOriginAwsKms)
+
+	if err := database.SaveKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.SaveAlias(&data.Alias{
+		AliasName:   "alias/xks",
+		AliasArn:    c.ArnPrefix() + "alias/xks",
+		TargetKeyId: testKeyId,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	registryPath := filepath.Join(t.TempDir(), "credentials.yaml")
+	err := os.WriteFile(registryPath, []byte("Credentials:\n"+
+		"  - AccessKeyId: "+testAccessKeyId+"\n"+
+		"    SecretAccessKey: "+testSecretAccessKey+"\n"+
+		"    AccountId: \"111122223333\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := auth.LoadRegistry(registryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	server := httptest.NewServer(NewProxy(testUriPath, c, database, registry, logger))
+	t.Cleanup(server.Close)
+
+	return NewClient(server.URL, testUriPath, testAccessKeyId, testSecretAccessKey, "eu-west-2"), database
+}
+
+func TestProxyHealth(t *testing.T) {
+	client, _ := newTestProxy(t)
+
+	status, err := client.Health(testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status.EkmFleetDetails) != 1 || status.EkmFleetDetails[0].HealthStatus != "ACTIVE" {
+		t.Errorf("unexpected health status %+v", status)
+	}
+}
+
+func TestProxyKeyMetadata(t *testing.T) {
+	client, _ := newTestProxy(t)
+
+	for _, keyId := range []string{testKeyId, "alias/xks"} {
+		metadata, err := client.GetKeyMetadata(keyId, testMetadata)
+		if err != nil {
+			t.Fatalf("%s: %s", keyId, err)
+		}
+
+		if metadata.KeySpec != KeySpecAes256 || metadata.KeyStatus != "ENABLED" || len(metadata.KeyUsage) != 2 {
+			t.Errorf("%s: unexpected metadata %+v", keyId, metadata)
+		}
+	}
+}
+
+func TestProxyEncryptDecrypt(t *testing.T) {
+	client, _ := newTestProxy(t)
+
+	aad := []byte("additional authenticated data")
+
+	ciphertext, err := client.Encrypt(testKeyId, []byte("plaintext"), aad, testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := client.Decrypt(testKeyId, ciphertext, aad, testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != "plaintext" {
+		t.Errorf("unexpected plaintext %q", plaintext)
+	}
+
+	//---
+	// Anything changed is rejected
+
+	_, err = client.Decrypt(testKeyId, ciphertext, []byte("other data"), testMetadata)
+	expectError(t, err, "InvalidCiphertextException")
+
+	tampered := *ciphertext
+	tampered.AuthenticationTag = bytes.Repeat([]byte{0}, len(ciphertext.AuthenticationTag))
+
+	_, err = client.Decrypt(testKeyId, &tampered, aad, testMetadata)
+	expectError(t, err, "InvalidCiphertextException")
+
+	tampered = *ciphertext
+	tampered.CiphertextMetadata = []byte{1, 0, 0, 0}
+
+	_, err = client.Decrypt(testKeyId, &tampered, aad, testMetadata)
+	expectError(t, err, "InvalidCiphertextException")
+}
+
+func TestProxyDecryptAfterRotation(t *testing.T) {
+	client, database := newTestProxy(t)
+
+	ciphertext, err := client.Encrypt(testKeyId, []byte("plaintext"), nil, testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := database.LoadKey("arn:aws:kms:eu-west-2:111122223333:key/" + testKeyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := key.(*cmk.AesKey).RotateOnDemand(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SaveKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypted under the previous backing key
+	plaintext, err := client.Decrypt(testKeyId, ciphertext, nil, testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != "plaintext" {
+		t.Errorf("unexpected plaintext %q", plaintext)
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	client, database := newTestProxy(t)
+
+	_, err := client.GetKeyMetadata("c2b1a4b0-0000-4000-8000-000000000000", testMetadata)
+	expectError(t, err, "XksKeyNotFoundException")
+
+	_, err = client.GetKeyMetadata("alias/missing", testMetadata)
+	expectError(t, err, "XksKeyNotFoundException")
+
+	// Signed with another secret
+	other := NewClient(client.base[:len(client.base)-len(testUriPath)], testUriPath, testAccessKeyId,
+		"b3RoZXJzZWNyZXRvdGhlcnNlY3JldG90aGVyc2VjcmV0b3RoZXI=", "eu-west-2")
+
+	_, err = other.Health(testMetadata)
+	expectError(t, err, "XksProxyIncorrectAuthenticationCredentialException")
+
+	// Another URI path
+	other = NewClient(client.base[:len(client.base)-len(testUriPath)], "/other/kms/xks/v1", testAccessKeyId,
+		testSecretAccessKey, "eu-west-2")
+
+	_, err = other.Health(testMetadata)
+	expectError(t, err, "XksProxyInvalidConfigurationException")
+
+	//---
+	// Disabled keys can't be used
+
+	key, err := database.LoadKey("arn:aws:kms:eu-west-2:111122223333:key/" + testKeyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key.GetMetadata().Enabled = false
+	key.GetMetadata().KeyState = cmk.KeyStateDisabled
+
+	if err := database.SaveKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := client.GetKeyMetadata(testKeyId, testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.KeyStatus != "DISABLED" {
+		t.Errorf("expected the key to be DISABLED, got %s", metadata.KeyStatus)
+	}
+
+	_, err = client.Encrypt(testKeyId, []byte("plaintext"), nil, testMetadata)
+	expectError(t, err, "KMSInvalidStateException")
+}
+
+func TestProxyUnsupportedAlgorithm(t *testing.T) {
+	_, database := newTestProxy(t)
+
+	key, err := database.LoadKey("arn:aws:kms:eu-west-2:111122223333:key/" + testKeyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As in the XKS Proxy API specification, unsupported operations are a 501, not a validation error
+	e := checkUsable(key.(*cmk.AesKey), "AES_CBC")
+	if e == nil || e.status != http.StatusNotImplemented || e.name != "UnsupportedOperationException" {
+		t.Errorf("expected a 501 UnsupportedOperationException, got %+v", e)
+	}
+
+	if e := checkUsable(key.(*cmk.AesKey), EncryptionAlgorithmGcm); e != nil {
+		t.Errorf("expected %s to be supported, got %+v", EncryptionAlgorithmGcm, e)
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
-	"github.com/nsmithuk/local-kms/src"
-	"github.com/nsmithuk/local-kms/src/config"
+	"github.com/nsmithuk/local-kms/src/data"
+	"github.com/nsmithuk/local-kms/src/server"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -50,7 +53,11 @@ func main() {
 	if accountId == "" {
 		accountId = "111122223333"
 	}
-	config.AWSAccountId = accountId
+
+	options := server.Options{
+		Logger:    logger,
+		AccountId: accountId,
+	}
 
 	region := os.Getenv("KMS_REGION")
 
@@ -65,7 +72,76 @@ func main() {
 	if region == "" {
 		region = "eu-west-2"
 	}
-	config.AWSRegion = region
+	options.Region = region
+
+	// Additional regions are needed for multi-Region keys; requests are routed based on the region they're signed for.
+	for _, r := range strings.Split(os.Getenv("KMS_ADDITIONAL_REGIONS"), ",") {
+		r = strings.TrimSpace(r)
+		if r != "" && r != region {
+			options.AdditionalRegions = append(options.AdditionalRegions, r)
+		}
+	}
+
+	if len(options.AdditionalRegions) > 0 {
+		logger.Infof("Additional regions served: %s", strings.Join(options.AdditionalRegions, ", "))
+	}
+
+	//-------------------------------
+	// Signature verification
+
+	options.CredentialsPath = os.Getenv("KMS_CREDENTIALS_PATH")
+
+	//-------------------------------
+	// Recipient attestation
+
+	options.AttestationRootPath = os.Getenv("KMS_ATTESTATION_ROOT_PATH")
+
+	//-------------------------------
+	// XKS proxy
+
+	xksProxyPort := os.Getenv("KMS_XKS_PROXY_PORT")
+
+	options.XksProxyUriPath = os.Getenv("KMS_XKS_PROXY_URI_PATH")
+
+	options.XksProxyCredentialsPath = os.Getenv("KMS_XKS_PROXY_CREDENTIALS_PATH")
+	if options.XksProxyCredentialsPath == "" {
+		options.XksProxyCredentialsPath = options.CredentialsPath
+	}
+
+	//-------------------------------
+	// Throttling
+
+	options.RequestRateLimit, _ = strconv.ParseFloat(os.Getenv("KMS_REQUEST_RATE_LIMIT"), 64)
+	options.RequestBurstLimit, _ = strconv.Atoi(os.Getenv("KMS_REQUEST_BURST_LIMIT"))
+
+	//-------------------------------
+	// Key lifecycle
+
+	if interval := os.Getenv("KMS_LIFECYCLE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			logger.Fatalf("Invalid KMS_LIFECYCLE_INTERVAL '%s'. It must be a duration, such as 30s or 5m.", interval)
+		}
+
+		options.LifecycleInterval = d
+		options.DisableLifecycleScheduler = d == 0
+	}
+
+	//-------------------------------
+	// Key policies
+
+	options.EnforceKeyPolicies, _ = strconv.ParseBool(os.Getenv("KMS_ENFORCE_KEY_POLICIES"))
+
+	options.CallerPrincipal = os.Getenv("KMS_CALLER_PRINCIPAL")
+	if options.CallerPrincipal == "" {
+		options.CallerPrincipal = "arn:aws:iam::" + accountId + ":root"
+	}
+
+	if options.EnforceKeyPolicies {
+		logger.Infof("Key policies will be enforced. Requests are made as %s", options.CallerPrincipal)
+	}
+
+	//-------------------------------
 
 	dataPath := os.Getenv("KMS_DATA_PATH")
 	if dataPath == "" {
@@ -80,7 +156,71 @@ func main() {
 		dataPath = "/tmp/local-kms"
 	}
 
-	config.DatabasePath, _ = filepath.Abs(dataPath)
+	hsmPath := os.Getenv("KMS_HSM_PATH")
+
+	switch storage := os.Getenv("KMS_STORAGE"); storage {
+	case "", "leveldb":
+		options.DataPath, _ = filepath.Abs(dataPath)
+
+		if hsmPath == "" {
+			hsmPath = filepath.Join(options.DataPath, "hsm")
+		}
+
+	case "json":
+		options.DataPath, _ = filepath.Abs(dataPath)
+
+		storage, err := data.NewJSONStorage(options.DataPath, logger)
+		if err != nil {
+			logger.Fatalf("Unable to load data from %s: %s", options.DataPath, err)
+		}
+		options.Storage = storage
+
+		if hsmPath == "" {
+			hsmPath = filepath.Join(options.DataPath, "hsm")
+		}
+
+		logger.Infof("Data will be stored as JSON files in %s", options.DataPath)
+
+	case "memory":
+		// Nothing is persisted. Unless a path is given, the HSM stand-ins are kept in a temporary directory.
+		options.Storage = data.NewMemoryStorage()
+
+		logger.Info("Data will be stored in memory, and lost when Local KMS stops")
+
+	default:
+		logger.Fatalf("Unknown KMS_STORAGE '%s'. Valid values are leveldb, json and memory.", storage)
+	}
+
+	if hsmPath != "" {
+		options.HsmPath, _ = filepath.Abs(hsmPath)
+	}
+
+	//-------------------------------
+	// Encryption at rest
+
+	options.MasterKey = loadMasterKey(logger, "KMS_MASTER_KEY_PASSPHRASE", "KMS_MASTER_KEY_PATH")
+
+	if len(os.Args) > 1 && os.Args[1] == "rekey" {
+		rekey(logger, options, loadMasterKey(logger, "KMS_NEW_MASTER_KEY_PASSPHRASE", "KMS_NEW_MASTER_KEY_PATH"))
+		return
+	}
+
+	//-------------------------------
+	// Migrations
+
+	if backupPath := os.Getenv("KMS_BACKUP_PATH"); backupPath != "" {
+		options.BackupPath, _ = filepath.Abs(backupPath)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(logger, options, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		restore(logger, options, os.Args[2:])
+		return
+	}
 
 	//-------------------------------
 	// Seed
@@ -99,6 +239,8 @@ func main() {
 		seedPath = "/init/seed.yaml"
 	}
 
+	options.SeedPath = seedPath
+
 	//-------------------------------
 	// Run
 
@@ -108,5 +250,11 @@ func main() {
 		port = "8080"
 	}
 
-	src.Run(port, seedPath)
+	if xksProxyPort != "" && options.XksProxyCredentialsPath == "" {
+		logger.Fatal("A credential registry is required to serve the XKS Proxy API. Set KMS_XKS_PROXY_CREDENTIALS_PATH.")
+	}
+
+	if err := server.Run(port, xksProxyPort, options); err != nil {
+		logger.Fatal(err)
+	}
 }
@@ -31,7 +31,7 @@ class KmsClient:
                                         aws_region=region,
                                         aws_service='kms')
 
-    def post(self, handler, payload=None):
+    def post(self, handler, payload=None, region=None):
 
         # If we're using real KMS we need to Throttle requests.
         if self.real_kms:
@@ -41,13 +41,20 @@ class KmsClient:
             # AWS always expects a payload, even if it's empty
             payload = {}
 
+        headers = {
+            'X-Amz-Target': 'TrentService.%s' % handler,
+            'Content-Type': 'application/x-amz-json-1.1'
+        }
+
+        # Local KMS routes unverified requests by the region in their credential scope.
+        if region is not None and not self.real_kms:
+            headers['Authorization'] = 'AWS4-HMAC-SHA256 Credential=AKIAEXAMPLE/20200101/%s/kms/aws4_request, ' \
+                                       'SignedHeaders=host, Signature=0' % region
+
         response = requests.post(
             self.kms_url,
             auth=self.auth,
-            headers={
-                'X-Amz-Target': 'TrentService.%s' % handler,
-                'Content-Type': 'application/x-amz-json-1.1'
-            },
+            headers=headers,
             json=payload,
         )
 
@@ -0,0 +1,166 @@
+from base64 import b64decode, b64encode
+from concurrent.futures import ThreadPoolExecutor
+from pprint import pprint
+
+import pytest
+
+"""
+Requests that change the same key at the same time conflict. Each is retried against the key as it then is, so no
+change is lost, and no request's changes are applied twice.
+"""
+
+# Local KMS must serve these regions, via KMS_ADDITIONAL_REGIONS, as it does when run with docker-compose.
+PRIMARY_REGION = 'eu-west-2'
+REPLICA_REGIONS = ['us-east-1', 'us-west-2']
+
+
+def post_concurrently(kms_client, requests):
+    """
+    Makes all of the requests at once, returning their results in the same order.
+    Each request is a tuple of the operation, its body, and optionally the region it's made in.
+    """
+    def post(request):
+        operation, body, *region = request
+        return kms_client.post(operation, body, region=region[0] if region else None)
+
+    with ThreadPoolExecutor(max_workers=len(requests)) as executor:
+        results = list(executor.map(post, requests))
+
+    pprint(results)
+    return results
+
+
+@pytest.fixture
+def multi_region_key(kms_client):
+    code, content = kms_client.post('CreateKey', {'MultiRegion': True})
+    assert code == 200
+
+    yield content['KeyMetadata']
+
+    # Replicas must be deleted before their primary
+    code, content = kms_client.post('DescribeKey', {'KeyId': content['KeyMetadata']['Arn']})
+    if code != 200:
+        return
+
+    configuration = content['KeyMetadata']['MultiRegionConfiguration']
+
+    for replica in configuration['ReplicaKeys']:
+        kms_client.post('ScheduleKeyDeletion', {'KeyId': replica['Arn'], 'PendingWindowInDays': 7},
+                        region=replica['Region'])
+
+    kms_client.post('ScheduleKeyDeletion', {'KeyId': configuration['PrimaryKey']['Arn'], 'PendingWindowInDays': 7})
+
+
+class TestConcurrency:
+
+    def test_concurrent_changes_to_a_key_are_all_kept(self, kms_client, key_arn):
+        requests = []
+        for i in range(5):
+            requests.append(('RotateKeyOnDemand', {'KeyId': key_arn}))
+            requests.append(('TagResource', {'KeyId': key_arn, 'Tags': [{'TagKey': 'tag-%d' % i, 'TagValue': 'v'}]}))
+            requests.append(('UpdateKeyDescription', {'KeyId': key_arn, 'Description': 'description-%d' % i}))
+            requests.append(('CreateAlias', {'AliasName': 'alias/concurrency-%s-%d' % (key_arn[-12:], i),
+                                             'TargetKeyId': key_arn}))
+
+        results = post_concurrently(kms_client, requests)
+        assert all(code == 200 for code, _ in results)
+
+        # Each rotation is applied exactly once
+        code, content = kms_client.post('ListKeyRotations', {'KeyId': key_arn})
+        assert code == 200
+        assert len(content['Rotations']) == 5
+
+        code, content = kms_client.post('ListResourceTags', {'KeyId': key_arn})
+        assert code == 200
+        assert sorted(t['TagKey'] for t in content['Tags']) == ['tag-%d' % i for i in range(5)]
+
+        code, content = kms_client.post('ListAliases', {'KeyId': key_arn})
+        assert code == 200
+        assert len(content['Aliases']) == 5
+
+        code, content = kms_client.post('DescribeKey', {'KeyId': key_arn})
+        assert code == 200
+        assert content['KeyMetadata']['Description'] in ['description-%d' % i for i in range(5)]
+
+        for alias in ['alias/concurrency-%s-%d' % (key_arn[-12:], i) for i in range(5)]:
+            kms_client.post('DeleteAlias', {'AliasName': alias})
+
+    def test_ciphertexts_survive_concurrent_rotations(self, kms_client, key_arn):
+        plaintexts = [b'plaintext-%d' % i for i in range(20)]
+
+        requests = [('Encrypt', {'KeyId': key_arn, 'Plaintext': b64encode(p).decode()}) for p in plaintexts]
+
+        # Interleaved, so some ciphertexts are made with each of the key's versions
+        for i in range(5):
+            requests.insert(i * 4, ('RotateKeyOnDemand', {'KeyId': key_arn}))
+
+        results = post_concurrently(kms_client, requests)
+        assert all(code == 200 for code, _ in results)
+
+        ciphertexts = [content['CiphertextBlob'] for (code, content), request in zip(results, requests)
+                       if request[0] == 'Encrypt']
+
+        # No rotation lost the key material another was made with
+        results = post_concurrently(kms_client, [('Decrypt', {'CiphertextBlob': c}) for c in ciphertexts])
+
+        assert all(code == 200 for code, _ in results)
+        assert [b64decode(content['Plaintext']) for _, content in results] == plaintexts
+
+        code, content = kms_client.post('ListKeyRotations', {'KeyId': key_arn})
+        assert code == 200
+        assert len(content['Rotations']) == 5
+
+    def test_concurrent_replication(self, kms_client, multi_region_key):
+        primary_arn = multi_region_key['Arn']
+
+        requests = [('ReplicateKey', {
+            'KeyId': primary_arn,
+            'ReplicaRegion': region,
+            'Tags': [{'TagKey': 'region', 'TagValue': region}],
+        }) for region in REPLICA_REGIONS]
+
+        # The primary is also changed while it's replicated
+        requests += [('UpdateKeyDescription', {'KeyId': primary_arn, 'Description': 'description-%d' % i})
+                     for i in range(3)]
+        requests += [('RotateKeyOnDemand', {'KeyId': primary_arn})]
+
+        results = post_concurrently(kms_client, requests)
+        assert all(code == 200 for code, _ in results)
+
+        code, content = kms_client.post('DescribeKey', {'KeyId': primary_arn})
+        assert code == 200
+
+        primary = content['KeyMetadata']
+        assert primary['Description'] in ['description-%d' % i for i in range(3)]
+        assert sorted(r['Region'] for r in primary['MultiRegionConfiguration']['ReplicaKeys']) == REPLICA_REGIONS
+
+        ciphertexts = []
+        for region in [PRIMARY_REGION] + REPLICA_REGIONS:
+            arn = primary_arn.replace(':%s:' % PRIMARY_REGION, ':%s:' % region)
+
+            # Each copy sees every replica, and was tagged exactly as requested
+            code, content = kms_client.post('DescribeKey', {'KeyId': arn}, region=region)
+            assert code == 200
+            configuration = content['KeyMetadata']['MultiRegionConfiguration']
+            assert sorted(r['Region'] for r in configuration['ReplicaKeys']) == REPLICA_REGIONS
+
+            if region != PRIMARY_REGION:
+                assert configuration['MultiRegionKeyType'] == 'REPLICA'
+
+                code, content = kms_client.post('ListResourceTags', {'KeyId': arn}, region=region)
+                assert code == 200
+                assert content['Tags'] == [{'TagKey': 'region', 'TagValue': region}]
+
+            code, content = kms_client.post('Encrypt', {
+                'KeyId': arn,
+                'Plaintext': b64encode(region.encode()).decode(),
+            }, region=region)
+            assert code == 200
+            ciphertexts.append((region, content['CiphertextBlob']))
+
+        # The copies share the rotated key material, so each decrypts the others' ciphertexts
+        for region, ciphertext in ciphertexts:
+            for other in [PRIMARY_REGION] + REPLICA_REGIONS:
+                code, content = kms_client.post('Decrypt', {'CiphertextBlob': ciphertext}, region=other)
+                assert code == 200
+                assert b64decode(content['Plaintext']) == region.encode()
@@ -0,0 +1,123 @@
+import secrets
+from base64 import b64encode
+from pprint import pprint
+
+import pytest
+
+from tests import validate_error_response
+
+TRUST_ANCHOR = """-----BEGIN CERTIFICATE-----
+MIIBfzCCASWgAwIBAgIUYadIMII9TIfAcCmej3js+EY/J7QwCgYIKoZIzj0EAwIw
+FTETMBEGA1UEAwwKY3VzdG9tZXJDQTAeFw0yNjEwMTcxODMwNDZaFw0zNjEwMTQx
+ODMwNDZaMBUxEzARBgNVBAMMCmN1c3RvbWVyQ0EwWTATBgcqhkjOPQIBBggqhkjO
+PQMBBwNCAAQhyYeD7Qs9VhQct1v+L4dN3tBFb1Aw8wLgYuJFA4mYhD8haoQo/NZA
+0SfhK7BGxFuBuDiXl6B6C70V5WXAQqIzo1MwUTAdBgNVHQ4EFgQUTYVoxBbfpt7k
+6IiZdO+Awq3zN5MwHwYDVR0jBBgwFoAUTYVoxBbfpt7k6IiZdO+Awq3zN5MwDwYD
+VR0TAQH/BAUwAwEB/zAKBggqhkjOPQQDAgNIADBFAiBOVafQCevjh3JWGs/eygBd
+4Mq+YpDyUs1ZK2ZHxApoCAIhALpQBnb/R2Pz0M9pSrB8znl5piDuCYBvwkjd+VXa
+ZX6G
+-----END CERTIFICATE-----
+"""
+
+
+@pytest.fixture
+def custom_key_store(kms_client):
+    """
+    Create a custom key store, with a new cluster, for use in a single test.
+    """
+    code, content = kms_client.post('CreateCustomKeyStore', {
+        'CustomKeyStoreName': 'test-' + secrets.token_hex(8),
+        'CloudHsmClusterId': 'cluster-' + secrets.token_hex(6),
+        'TrustAnchorCertificate': TRUST_ANCHOR,
+        'KeyStorePassword': 'kmsuser-password',
+    })
+    pprint(content)
+    assert code == 200
+
+    yield content['CustomKeyStoreId']
+
+
+def describe(kms_client, custom_key_store_id):
+    code, content = kms_client.post('DescribeCustomKeyStores', {
+        'CustomKeyStoreId': custom_key_store_id,
+    })
+    assert code == 200
+    return content['CustomKeyStores'][0]
+
+
+class TestCustomKeyStores:
+
+    def test_create_and_describe(self, kms_client, custom_key_store):
+        store = describe(kms_client, custom_key_store)
+        pprint(store)
+
+        assert store['CustomKeyStoreType'] == 'AWS_CLOUDHSM'
+        assert store['ConnectionState'] == 'DISCONNECTED'
+        assert store['TrustAnchorCertificate'] == TRUST_ANCHOR
+        assert 'KeyStorePassword' not in store
+
+    def test_key_is_unavailable_whilst_disconnected(self, kms_client, custom_key_store):
+        code, unused = kms_client.post('ConnectCustomKeyStore', {'CustomKeyStoreId': custom_key_store})
+        assert code == 200
+        assert describe(kms_client, custom_key_store)['ConnectionState'] == 'CONNECTED'
+
+        code, cmk = kms_client.post('CreateKey', {
+            'Origin': 'AWS_CLOUDHSM',
+            'CustomKeyStoreId': custom_key_store,
+        })
+        pprint(cmk)
+        assert code == 200
+        assert cmk['KeyMetadata']['CustomKeyStoreId'] == custom_key_store
+
+        key_id = cmk['KeyMetadata']['KeyId']
+        plaintext = b64encode('Hello World'.encode("utf-8")).decode('ascii')
+
+        code, encrypted = kms_client.post('Encrypt', {'KeyId': key_id, 'Plaintext': plaintext})
+        assert code == 200
+
+        # -------------------
+
+        code, unused = kms_client.post('DisconnectCustomKeyStore', {'CustomKeyStoreId': custom_key_store})
+        assert code == 200
+
+        code, described = kms_client.post('DescribeKey', {'KeyId': key_id})
+        assert described['KeyMetadata']['KeyState'] == 'Unavailable'
+
+        code, response = kms_client.post('Encrypt', {'KeyId': key_id, 'Plaintext': plaintext})
+        assert code == 400
+        assert validate_error_response(response, 'KMSInvalidStateException', '.* is unavailable.')
+
+        code, response = kms_client.post('DeleteCustomKeyStore', {'CustomKeyStoreId': custom_key_store})
+        assert code == 400
+        assert response['__type'] == 'CustomKeyStoreHasCMKsException'
+
+        # -------------------
+
+        code, unused = kms_client.post('ConnectCustomKeyStore', {'CustomKeyStoreId': custom_key_store})
+        assert code == 200
+
+        code, decrypted = kms_client.post('Decrypt', {'CiphertextBlob': encrypted['CiphertextBlob']})
+        assert code == 200
+        assert decrypted['Plaintext'] == plaintext
+
+    def test_connect_with_incorrect_password(self, kms_client, custom_key_store):
+        code, unused = kms_client.post('UpdateCustomKeyStore', {
+            'CustomKeyStoreId': custom_key_store,
+            'KeyStorePassword': 'incorrect-password',
+        })
+        assert code == 200
+
+        code, unused = kms_client.post('ConnectCustomKeyStore', {'CustomKeyStoreId': custom_key_store})
+        assert code == 200
+
+        store = describe(kms_client, custom_key_store)
+        assert store['ConnectionState'] == 'FAILED'
+        assert store['ConnectionErrorCode'] == 'INVALID_CREDENTIALS'
+
+    def test_delete(self, kms_client, custom_key_store):
+        code, unused = kms_client.post('DeleteCustomKeyStore', {'CustomKeyStoreId': custom_key_store})
+        assert code == 200
+
+        code, response = kms_client.post('DescribeCustomKeyStores', {'CustomKeyStoreId': custom_key_store})
+        assert code == 400
+        assert response['__type'] == 'CustomKeyStoreNotFoundException'
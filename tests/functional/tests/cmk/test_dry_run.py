@@ -0,0 +1,165 @@
+from base64 import b64encode
+from pprint import pprint
+
+from tests import validate_error_response
+
+DRY_RUN_MESSAGE = 'The request would have succeeded, but the DryRun option is set.'
+
+
+class TestDryRun:
+
+    def test_encrypt(self, kms_client, key_arn):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 412
+        assert validate_error_response(content, 'DryRunOperationException', DRY_RUN_MESSAGE)
+
+    def test_decrypt(self, kms_client, key_arn):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+        })
+        assert code == 200
+
+        code, content = kms_client.post('Decrypt', {
+            'CiphertextBlob': content['CiphertextBlob'],
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 412
+        assert validate_error_response(content, 'DryRunOperationException', DRY_RUN_MESSAGE)
+
+    def test_state_checked_first(self, kms_client, key_arn):
+        code, unused = kms_client.post('DisableKey', {'KeyId': key_arn})
+        assert code == 200
+
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'DisabledException', key_arn + ' is disabled.')
+
+    def test_create_grant_has_no_effect(self, kms_client, key_arn):
+        code, content = kms_client.post('CreateGrant', {
+            'KeyId': key_arn,
+            'GranteePrincipal': 'arn:aws:iam::111122223333:role/example',
+            'Operations': ['Encrypt'],
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 412
+        assert validate_error_response(content, 'DryRunOperationException', DRY_RUN_MESSAGE)
+
+        code, content = kms_client.post('ListGrants', {'KeyId': key_arn})
+        assert code == 200
+        assert len(content['Grants']) == 0
+
+    def test_generate_data_key(self, kms_client, key_arn):
+        code, content = kms_client.post('GenerateDataKey', {
+            'KeyId': key_arn,
+            'KeySpec': 'AES_256',
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 412
+        assert validate_error_response(content, 'DryRunOperationException', DRY_RUN_MESSAGE)
+
+    def test_re_encrypt(self, kms_client, key_arn):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+        })
+        assert code == 200
+
+        code, content = kms_client.post('ReEncrypt', {
+            'CiphertextBlob': content['CiphertextBlob'],
+            'DestinationKeyId': key_arn,
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 412
+        assert validate_error_response(content, 'DryRunOperationException', DRY_RUN_MESSAGE)
+
+    def test_re_encrypt_destination_checked_first(self, kms_client, key_arn):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+        })
+        assert code == 200
+
+        code, destination = kms_client.post('CreateKey', {})
+        assert code == 200
+        destination_arn = destination['KeyMetadata']['Arn']
+
+        code, unused = kms_client.post('DisableKey', {'KeyId': destination_arn})
+        assert code == 200
+
+        code, content = kms_client.post('ReEncrypt', {
+            'CiphertextBlob': content['CiphertextBlob'],
+            'DestinationKeyId': destination_arn,
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'DisabledException', destination_arn + ' is disabled.')
+
+    def test_key_usage_checked_first(self, kms_client):
+        code, content = kms_client.post('CreateKey', {'KeySpec': 'ECC_NIST_P256', 'KeyUsage': 'SIGN_VERIFY'})
+        assert code == 200
+        signing_key_arn = content['KeyMetadata']['Arn']
+
+        for operation, payload in [
+            ('Encrypt', {'Plaintext': b64encode(b'plaintext').decode()}),
+            ('GenerateDataKey', {'KeySpec': 'AES_256'}),
+            ('GenerateDataKeyPair', {'KeyPairSpec': 'ECC_NIST_P256'}),
+        ]:
+            code, content = kms_client.post(operation, dict(payload, KeyId=signing_key_arn, DryRun=True))
+            pprint(content)
+
+            assert code == 400
+            assert content['__type'] == 'InvalidKeyUsageException'
+
+    def test_derive_shared_secret_public_key_checked_first(self, kms_client):
+        code, content = kms_client.post('CreateKey', {'KeySpec': 'ECC_NIST_P256', 'KeyUsage': 'KEY_AGREEMENT'})
+        assert code == 200
+        key_arn = content['KeyMetadata']['Arn']
+
+        code, content = kms_client.post('DeriveSharedSecret', {
+            'KeyId': key_arn,
+            'KeyAgreementAlgorithm': 'ECDH',
+            'PublicKey': b64encode(b'not a public key').decode(),
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 400
+        assert content['__type'] == 'ValidationException'
+
+        # With a valid public key, the dry run succeeds
+        code, content = kms_client.post('GetPublicKey', {'KeyId': key_arn})
+        assert code == 200
+
+        code, content = kms_client.post('DeriveSharedSecret', {
+            'KeyId': key_arn,
+            'KeyAgreementAlgorithm': 'ECDH',
+            'PublicKey': content['PublicKey'],
+            'DryRun': True,
+        })
+        pprint(content)
+
+        assert code == 412
+        assert validate_error_response(content, 'DryRunOperationException', DRY_RUN_MESSAGE)
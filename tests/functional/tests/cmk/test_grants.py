@@ -0,0 +1,127 @@
+from pprint import pprint
+
+from tests import validate_error_response
+
+
+class TestGrants:
+    def test_grant_lifecycle(self, kms_client, symmetric_key):
+
+        retiring_principal = 'arn:aws:iam::111122223333:role/retiring'
+
+        code, grant = kms_client.post(
+            'CreateGrant',
+            {
+                "KeyId": symmetric_key['KeyId'],
+                "GranteePrincipal": 'arn:aws:iam::111122223333:role/grantee',
+                "RetiringPrincipal": retiring_principal,
+                "Operations": ['Encrypt', 'Decrypt'],
+            },
+        )
+        pprint(grant)
+
+        assert code == 200
+        assert 'GrantId' in grant
+        assert 'GrantToken' in grant
+
+        # -------------------
+
+        code, content = kms_client.post('ListGrants', {"KeyId": symmetric_key['KeyId']})
+
+        assert code == 200
+        assert grant['GrantId'] in [g['GrantId'] for g in content['Grants']]
+
+        code, content = kms_client.post('ListRetirableGrants', {"RetiringPrincipal": retiring_principal})
+
+        assert code == 200
+        assert grant['GrantId'] in [g['GrantId'] for g in content['Grants']]
+
+        # -------------------
+
+        code, content = kms_client.post('RetireGrant', {"GrantToken": grant['GrantToken']})
+
+        assert code == 200
+
+        code, content = kms_client.post('ListGrants', {"KeyId": symmetric_key['KeyId']})
+
+        assert code == 200
+        assert grant['GrantId'] not in [g['GrantId'] for g in content['Grants']]
+
+    def test_grant_constraints(self, kms_client, symmetric_key):
+
+        code, grant = kms_client.post(
+            'CreateGrant',
+            {
+                "KeyId": symmetric_key['KeyId'],
+                "GranteePrincipal": 'arn:aws:iam::111122223333:role/grantee',
+                "Operations": ['Encrypt'],
+                "Constraints": {"EncryptionContextSubset": {"Department": "IT"}},
+            },
+        )
+
+        assert code == 200
+
+        # -------------------
+
+        code, content = kms_client.post(
+            'Encrypt',
+            {
+                "KeyId": symmetric_key['KeyId'],
+                "Plaintext": 'SGVsbG8gV29ybGQ=',
+                "EncryptionContext": {"Department": "IT", "Project": "Alpha"},
+                "GrantTokens": [grant['GrantToken']],
+            },
+        )
+
+        assert code == 200
+
+        # A token for a grant that doesn't apply is ignored; the request is allowed, or denied, as it would be without it
+        code, content = kms_client.post(
+            'Encrypt',
+            {
+                "KeyId": symmetric_key['KeyId'],
+                "Plaintext": 'SGVsbG8gV29ybGQ=',
+                "EncryptionContext": {"Department": "HR"},
+                "GrantTokens": [grant['GrantToken']],
+            },
+        )
+
+        assert code == 200
+
+        code, content = kms_client.post(
+            'Encrypt',
+            {
+                "KeyId": symmetric_key['KeyId'],
+                "Plaintext": 'SGVsbG8gV29ybGQ=',
+                "GrantTokens": ['not-a-grant-token'],
+            },
+        )
+
+        assert code == 400
+        assert content['__type'] == 'InvalidGrantTokenException'
+
+        # -------------------
+
+        code, content = kms_client.post(
+            'RevokeGrant',
+            {"KeyId": symmetric_key['KeyId'], "GrantId": grant['GrantId']},
+        )
+
+        assert code == 200
+
+    def test_grant_invalid_operation(self, kms_client, symmetric_key):
+
+        code, content = kms_client.post(
+            'CreateGrant',
+            {
+                "KeyId": symmetric_key['KeyId'],
+                "GranteePrincipal": 'arn:aws:iam::111122223333:role/grantee',
+                "Operations": ['Sign'],
+            },
+        )
+
+        assert code == 400
+        assert validate_error_response(
+            content,
+            'ValidationException',
+            'Grant operation Sign is not valid for key .*',
+        )
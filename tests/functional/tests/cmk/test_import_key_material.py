@@ -0,0 +1,284 @@
+import hashlib
+import hmac
+import os
+import struct
+from base64 import b64decode, b64encode
+from pprint import pprint
+
+import pytest
+from Crypto.Cipher import AES, PKCS1_OAEP, PKCS1_v1_5
+from Crypto.Hash import SHA1, SHA256
+from Crypto.PublicKey import ECC, RSA
+
+from tests import validate_error_response
+
+"""
+Key material is wrapped locally with the public key from GetParametersForImport, then imported and used.
+"""
+
+
+def aes_key_wrap_with_padding(kek, key):
+    """
+    Wraps the key with AES Key Wrap with Padding, as defined by RFC 5649.
+    """
+    aes = AES.new(kek, AES.MODE_ECB)
+
+    a = bytes.fromhex('a65959a6') + struct.pack('>I', len(key))
+    padded = key + b'\x00' * (-len(key) % 8)
+
+    if len(padded) == 8:
+        return aes.encrypt(a + padded)
+
+    r = [padded[i:i + 8] for i in range(0, len(padded), 8)]
+    n = len(r)
+
+    for j in range(6):
+        for i in range(n):
+            b = aes.encrypt(a + r[i])
+            a = struct.pack('>Q', struct.unpack('>Q', b[:8])[0] ^ (n * j + i + 1))
+            r[i] = b[8:]
+
+    return a + b''.join(r)
+
+
+def wrap(parameters, wrapping_algorithm, key_material):
+    public_key = RSA.import_key(b64decode(parameters['PublicKey']))
+
+    if wrapping_algorithm == 'RSAES_PKCS1_V1_5':
+        return PKCS1_v1_5.new(public_key).encrypt(key_material)
+
+    hash_algorithm = SHA1 if wrapping_algorithm.endswith('SHA_1') else SHA256
+    oaep = PKCS1_OAEP.new(public_key, hashAlgo=hash_algorithm)
+
+    if wrapping_algorithm.startswith('RSAES_OAEP'):
+        return oaep.encrypt(key_material)
+
+    # RSA_AES_KEY_WRAP: an ephemeral AES key is encrypted with RSA, and the key material with the AES key
+    aes_key = os.urandom(32)
+    return oaep.encrypt(aes_key) + aes_key_wrap_with_padding(aes_key, key_material)
+
+
+def create_external_key(kms_client, key_spec, key_usage):
+    code, content = kms_client.post('CreateKey', {
+        'Origin': 'EXTERNAL',
+        'KeySpec': key_spec,
+        'KeyUsage': key_usage,
+    })
+    pprint(content)
+    assert code == 200
+    assert content['KeyMetadata']['KeyState'] == 'PendingImport'
+
+    return content['KeyMetadata']['Arn']
+
+
+def import_key_material(kms_client, key_arn, key_material, wrapping_algorithm, wrapping_key_spec='RSA_2048'):
+    code, parameters = kms_client.post('GetParametersForImport', {
+        'KeyId': key_arn,
+        'WrappingAlgorithm': wrapping_algorithm,
+        'WrappingKeySpec': wrapping_key_spec,
+    })
+    assert code == 200
+
+    # The wrapping key is the size requested
+    assert RSA.import_key(b64decode(parameters['PublicKey'])).size_in_bits() == int(wrapping_key_spec[4:])
+
+    return kms_client.post('ImportKeyMaterial', {
+        'KeyId': key_arn,
+        'ImportToken': parameters['ImportToken'],
+        'EncryptedKeyMaterial': b64encode(wrap(parameters, wrapping_algorithm, key_material)).decode(),
+        'ExpirationModel': 'KEY_MATERIAL_DOES_NOT_EXPIRE',
+    })
+
+
+def delete_key(kms_client, key_arn):
+    code, content = kms_client.post('ScheduleKeyDeletion', {'KeyId': key_arn, 'PendingWindowInDays': 7})
+    assert code == 200
+
+
+class TestImportKeyMaterial:
+
+    @pytest.mark.parametrize('wrapping_algorithm', [
+        'RSAES_PKCS1_V1_5',
+        'RSAES_OAEP_SHA_1',
+        'RSAES_OAEP_SHA_256',
+        'RSA_AES_KEY_WRAP_SHA_1',
+        'RSA_AES_KEY_WRAP_SHA_256',
+    ])
+    @pytest.mark.parametrize('wrapping_key_spec', ['RSA_2048', 'RSA_3072', 'RSA_4096'])
+    def test_import_symmetric_key(self, kms_client, wrapping_algorithm, wrapping_key_spec):
+        key_arn = create_external_key(kms_client, 'SYMMETRIC_DEFAULT', 'ENCRYPT_DECRYPT')
+
+        code, content = import_key_material(kms_client, key_arn, os.urandom(32), wrapping_algorithm, wrapping_key_spec)
+        pprint(content)
+        assert code == 200
+
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'imported').decode(),
+        })
+        assert code == 200
+
+        code, content = kms_client.post('Decrypt', {'CiphertextBlob': content['CiphertextBlob']})
+        assert code == 200
+        assert b64decode(content['Plaintext']) == b'imported'
+
+        delete_key(kms_client, key_arn)
+
+    @pytest.mark.parametrize('key_spec', ['RSA_2048', 'RSA_3072', 'RSA_4096'])
+    @pytest.mark.parametrize('wrapping_algorithm', ['RSA_AES_KEY_WRAP_SHA_1', 'RSA_AES_KEY_WRAP_SHA_256'])
+    def test_import_rsa_key(self, kms_client, key_spec, wrapping_algorithm):
+        key_arn = create_external_key(kms_client, key_spec, 'SIGN_VERIFY')
+
+        private_key = RSA.generate(int(key_spec[4:]))
+        key_material = private_key.export_key(format='DER', pkcs=8)
+
+        code, content = import_key_material(kms_client, key_arn, key_material, wrapping_algorithm, 'RSA_4096')
+        pprint(content)
+        assert code == 200
+
+        # LKMS holds the imported key
+        code, content = kms_client.post('GetPublicKey', {'KeyId': key_arn})
+        assert code == 200
+        assert b64decode(content['PublicKey']) == private_key.public_key().export_key(format='DER')
+
+        code, content = kms_client.post('Sign', {
+            'KeyId': key_arn,
+            'MessageType': 'RAW',
+            'SigningAlgorithm': 'RSASSA_PSS_SHA_256',
+            'Message': b64encode(b'imported').decode(),
+        })
+        assert code == 200
+
+        delete_key(kms_client, key_arn)
+
+    @pytest.mark.parametrize('key_spec, curve', [
+        ('ECC_NIST_P256', 'P-256'),
+        ('ECC_NIST_P384', 'P-384'),
+        ('ECC_NIST_P521', 'P-521'),
+    ])
+    def test_import_ecc_key(self, kms_client, key_spec, curve):
+        key_arn = create_external_key(kms_client, key_spec, 'SIGN_VERIFY')
+
+        private_key = ECC.generate(curve=curve)
+        key_material = private_key.export_key(format='DER', use_pkcs8=True)
+
+        code, content = import_key_material(kms_client, key_arn, key_material, 'RSA_AES_KEY_WRAP_SHA_256')
+        pprint(content)
+        assert code == 200
+
+        code, content = kms_client.post('GetPublicKey', {'KeyId': key_arn})
+        assert code == 200
+        assert b64decode(content['PublicKey']) == private_key.public_key().export_key(format='DER')
+
+        delete_key(kms_client, key_arn)
+
+    @pytest.mark.parametrize('key_spec, mac_algorithm, digest, length', [
+        ('HMAC_224', 'HMAC_SHA_224', hashlib.sha224, 28),
+        ('HMAC_256', 'HMAC_SHA_256', hashlib.sha256, 32),
+        ('HMAC_384', 'HMAC_SHA_384', hashlib.sha384, 48),
+        ('HMAC_512', 'HMAC_SHA_512', hashlib.sha512, 64),
+    ])
+    def test_import_hmac_key(self, kms_client, key_spec, mac_algorithm, digest, length):
+        key_arn = create_external_key(kms_client, key_spec, 'GENERATE_VERIFY_MAC')
+
+        key_material = os.urandom(length)
+
+        code, content = import_key_material(kms_client, key_arn, key_material, 'RSAES_OAEP_SHA_256')
+        pprint(content)
+        assert code == 200
+
+        code, content = kms_client.post('GenerateMac', {
+            'KeyId': key_arn,
+            'MacAlgorithm': mac_algorithm,
+            'Message': b64encode(b'imported').decode(),
+        })
+        assert code == 200
+
+        # The MAC is made with the imported key material
+        assert b64decode(content['Mac']) == hmac.new(key_material, b'imported', digest).digest()
+
+        delete_key(kms_client, key_arn)
+
+    def test_import_key_material_of_wrong_type(self, kms_client):
+        key_arn = create_external_key(kms_client, 'RSA_2048', 'SIGN_VERIFY')
+
+        # An EC key, rather than an RSA key
+        key_material = ECC.generate(curve='P-256').export_key(format='DER', use_pkcs8=True)
+
+        code, content = import_key_material(kms_client, key_arn, key_material, 'RSA_AES_KEY_WRAP_SHA_256')
+        pprint(content)
+
+        assert code == 400
+        assert content['__type'] == 'IncorrectKeyMaterialException'
+
+        delete_key(kms_client, key_arn)
+
+    def test_import_rsa_key_of_wrong_size(self, kms_client):
+        key_arn = create_external_key(kms_client, 'RSA_3072', 'SIGN_VERIFY')
+
+        key_material = RSA.generate(2048).export_key(format='DER', pkcs=8)
+
+        code, content = import_key_material(kms_client, key_arn, key_material, 'RSA_AES_KEY_WRAP_SHA_256')
+
+        assert code == 400
+        assert content['__type'] == 'IncorrectKeyMaterialException'
+
+        delete_key(kms_client, key_arn)
+
+    def test_reimport_different_key_material(self, kms_client):
+        key_arn = create_external_key(kms_client, 'SYMMETRIC_DEFAULT', 'ENCRYPT_DECRYPT')
+
+        key_material = os.urandom(32)
+
+        code, content = import_key_material(kms_client, key_arn, key_material, 'RSAES_OAEP_SHA_256')
+        assert code == 200
+
+        # The same material can be imported again
+        code, content = import_key_material(kms_client, key_arn, key_material, 'RSAES_OAEP_SHA_256')
+        assert code == 200
+
+        code, content = import_key_material(kms_client, key_arn, os.urandom(32), 'RSAES_OAEP_SHA_256')
+
+        assert code == 400
+        assert content['__type'] == 'IncorrectKeyMaterialException'
+
+        delete_key(kms_client, key_arn)
+
+    def test_tampered_aes_wrapped_key_material(self, kms_client):
+        key_arn = create_external_key(kms_client, 'SYMMETRIC_DEFAULT', 'ENCRYPT_DECRYPT')
+
+        code, parameters = kms_client.post('GetParametersForImport', {
+            'KeyId': key_arn,
+            'WrappingAlgorithm': 'RSA_AES_KEY_WRAP_SHA_256',
+            'WrappingKeySpec': 'RSA_2048',
+        })
+        assert code == 200
+
+        encrypted = bytearray(wrap(parameters, 'RSA_AES_KEY_WRAP_SHA_256', os.urandom(32)))
+        encrypted[-1] ^= 0x01
+
+        code, content = kms_client.post('ImportKeyMaterial', {
+            'KeyId': key_arn,
+            'ImportToken': parameters['ImportToken'],
+            'EncryptedKeyMaterial': b64encode(bytes(encrypted)).decode(),
+            'ExpirationModel': 'KEY_MATERIAL_DOES_NOT_EXPIRE',
+        })
+
+        assert code == 400
+        assert content['__type'] == 'InvalidCiphertextException'
+
+        delete_key(kms_client, key_arn)
+
+    def test_pkcs1_v1_5_not_supported_for_asymmetric_keys(self, kms_client):
+        key_arn = create_external_key(kms_client, 'RSA_2048', 'SIGN_VERIFY')
+
+        code, content = kms_client.post('GetParametersForImport', {
+            'KeyId': key_arn,
+            'WrappingAlgorithm': 'RSAES_PKCS1_V1_5',
+            'WrappingKeySpec': 'RSA_2048',
+        })
+
+        assert code == 400
+        assert validate_error_response(content, 'ValidationException', '')
+
+        delete_key(kms_client, key_arn)
@@ -0,0 +1,135 @@
+from base64 import b64encode
+from pprint import pprint
+from uuid import uuid4
+
+import pytest
+
+from tests import validate_error_response
+
+
+@pytest.fixture
+def key(kms_client):
+    code, content = kms_client.post('CreateKey', {})
+    assert code == 200
+
+    metadata = content['KeyMetadata']
+
+    alias_name = 'alias/' + str(uuid4())
+    code, unused = kms_client.post('CreateAlias', {'AliasName': alias_name, 'TargetKeyId': metadata['KeyId']})
+    assert code == 200
+
+    yield metadata, alias_name
+
+
+class TestKeyIdentifiers:
+
+    def test_encrypt_with_alias_arn(self, kms_client, key):
+        metadata, alias_name = key
+
+        alias_arn = metadata['Arn'].split(':key/')[0] + ':' + alias_name
+
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': alias_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+        })
+        pprint(content)
+
+        assert code == 200
+        assert content['KeyId'] == metadata['Arn']
+
+    def test_describe_key_with_alias_name(self, kms_client, key):
+        metadata, alias_name = key
+
+        code, content = kms_client.post('DescribeKey', {'KeyId': alias_name})
+        pprint(content)
+
+        assert code == 200
+        assert content['KeyMetadata']['Arn'] == metadata['Arn']
+
+    @pytest.mark.parametrize("operation", ['DisableKey', 'EnableKey', 'GetKeyPolicy', 'ListResourceTags'])
+    def test_alias_not_accepted(self, kms_client, key, operation):
+        metadata, alias_name = key
+
+        payload = {'KeyId': alias_name}
+        if operation == 'GetKeyPolicy':
+            payload['PolicyName'] = 'default'
+
+        code, content = kms_client.post(operation, payload)
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'NotFoundException', 'Invalid keyId ' + alias_name)
+
+    @pytest.mark.parametrize("operation", ['DescribeKey', 'DisableKey'])
+    def test_key_arn_in_another_region(self, kms_client, key, operation):
+        metadata, unused = key
+
+        parts = metadata['Arn'].split(':')
+        parts[3] = 'us-east-1' if parts[3] != 'us-east-1' else 'us-west-2'
+        key_arn = ':'.join(parts)
+
+        code, content = kms_client.post(operation, {'KeyId': key_arn})
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'NotFoundException', 'Invalid arn ' + key_arn + '.')
+
+    @pytest.mark.parametrize("operation", ['DescribeKey', 'GetKeyRotationStatus', 'ListGrants'])
+    def test_key_arn_in_another_account(self, kms_client, key, operation):
+        metadata, unused = key
+
+        parts = metadata['Arn'].split(':')
+        parts[4] = '444455556666'
+        key_arn = ':'.join(parts)
+
+        code, content = kms_client.post(operation, {'KeyId': key_arn})
+        pprint(content)
+
+        # The ARN is accepted, but the key doesn't exist in the other account
+        assert code == 400
+        assert validate_error_response(content, 'NotFoundException', "Key '" + key_arn + "' does not exist")
+
+    @pytest.mark.parametrize("operation", ['DisableKey', 'EnableKey', 'ListResourceTags', 'ScheduleKeyDeletion'])
+    def test_key_arn_in_another_account_not_accepted(self, kms_client, key, operation):
+        metadata, unused = key
+
+        parts = metadata['Arn'].split(':')
+        parts[4] = '444455556666'
+        key_arn = ':'.join(parts)
+
+        code, content = kms_client.post(operation, {'KeyId': key_arn})
+        pprint(content)
+
+        # Rather than being looked up in the instance's account
+        assert code == 400
+        assert validate_error_response(content, 'NotFoundException',
+                                       'Invalid arn ' + key_arn + '. ' + operation +
+                                       ' cannot be used with keys in another account.')
+
+        # The key in the instance's account is unchanged
+        code, content = kms_client.post('DescribeKey', {'KeyId': metadata['Arn']})
+        assert code == 200
+        assert content['KeyMetadata']['KeyState'] == 'Enabled'
+
+    def test_alias_arn_in_another_account(self, kms_client, key):
+        metadata, alias_name = key
+
+        parts = metadata['Arn'].split(':key/')[0].split(':')
+        parts[4] = '444455556666'
+        alias_arn = ':'.join(parts) + ':' + alias_name
+
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': alias_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+        })
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'NotFoundException', 'Alias ' + alias_arn + ' is not found.')
+
+    def test_empty_key_id(self, kms_client):
+        code, content = kms_client.post('DescribeKey', {'KeyId': ''})
+        pprint(content)
+
+        assert code == 400
+        assert content['__type'] == 'ValidationException'
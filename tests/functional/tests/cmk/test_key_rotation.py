@@ -0,0 +1,222 @@
+import time
+from base64 import b64encode
+from pprint import pprint
+
+import pytest
+
+from tests import validate_error_response
+
+DAY = 24 * 60 * 60
+
+
+def validate_unsupported_operation(content, error_message_expression):
+    """
+    UnsupportedOperationException is returned with a capital 'M' on Message.
+    """
+    return content['__type'] == 'UnsupportedOperationException' \
+        and error_message_expression in content['Message']
+
+
+def rotate(kms_client, key_arn, times):
+    for i in range(times):
+        code, content = kms_client.post('RotateKeyOnDemand', {'KeyId': key_arn})
+        assert code == 200
+
+
+class TestOnDemandRotation:
+
+    def test_rotate_key_on_demand(self, kms_client, key_arn):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'before rotation').decode(),
+        })
+        assert code == 200
+        ciphertext = content['CiphertextBlob']
+
+        code, content = kms_client.post('RotateKeyOnDemand', {'KeyId': key_arn})
+        pprint(content)
+
+        assert code == 200
+        assert content['KeyId'] == key_arn
+
+        code, content = kms_client.post('ListKeyRotations', {'KeyId': key_arn})
+        assert code == 200
+        assert len(content['Rotations']) == 1
+        assert content['Rotations'][0]['KeyId'] == key_arn
+        assert content['Rotations'][0]['RotationType'] == 'ON_DEMAND'
+        assert abs(content['Rotations'][0]['RotationDate'] - time.time()) < 60
+        assert content['Truncated'] is False
+
+        # Ciphertext from before the rotation can still be decrypted
+        code, content = kms_client.post('Decrypt', {'CiphertextBlob': ciphertext})
+        assert code == 200
+        assert content['Plaintext'] == b64encode(b'before rotation').decode()
+
+    def test_rotate_key_on_demand_limit(self, kms_client, key_arn):
+        rotate(kms_client, key_arn, 10)
+
+        code, content = kms_client.post('RotateKeyOnDemand', {'KeyId': key_arn})
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'LimitExceededException', '.*maximum of 10 on-demand rotations.*')
+
+    def test_rotate_key_on_demand_does_not_change_schedule(self, kms_client, key_arn):
+        code, content = kms_client.post('EnableKeyRotation', {'KeyId': key_arn})
+        assert code == 200
+
+        code, before = kms_client.post('GetKeyRotationStatus', {'KeyId': key_arn})
+        assert code == 200
+
+        rotate(kms_client, key_arn, 1)
+
+        code, after = kms_client.post('GetKeyRotationStatus', {'KeyId': key_arn})
+        assert code == 200
+        assert after['NextRotationDate'] == before['NextRotationDate']
+
+    def test_rotate_asymmetric_key_on_demand(self, kms_client, rsa_encryption_key):
+        code, content = kms_client.post('RotateKeyOnDemand', {'KeyId': rsa_encryption_key['Arn']})
+
+        assert code == 400
+        assert validate_unsupported_operation(content, 'not valid for this operation')
+
+    def test_rotate_missing_key_on_demand(self, kms_client):
+        code, content = kms_client.post('RotateKeyOnDemand', {})
+
+        assert code == 400
+        assert validate_error_response(content, 'ValidationException', '')
+
+
+class TestRotationPeriod:
+
+    def test_default_rotation_period(self, kms_client, key_arn):
+        code, content = kms_client.post('GetKeyRotationStatus', {'KeyId': key_arn})
+        assert code == 200
+        assert content['KeyRotationEnabled'] is False
+        assert 'RotationPeriodInDays' not in content
+        assert 'NextRotationDate' not in content
+
+        code, content = kms_client.post('EnableKeyRotation', {'KeyId': key_arn})
+        assert code == 200
+
+        code, content = kms_client.post('GetKeyRotationStatus', {'KeyId': key_arn})
+        pprint(content)
+
+        assert code == 200
+        assert content['KeyRotationEnabled'] is True
+        assert content['RotationPeriodInDays'] == 365
+        assert abs(content['NextRotationDate'] - (time.time() + 365 * DAY)) < 60
+
+    def test_rotation_period(self, kms_client, key_arn):
+        code, content = kms_client.post('EnableKeyRotation', {'KeyId': key_arn, 'RotationPeriodInDays': 90})
+        assert code == 200
+
+        code, content = kms_client.post('GetKeyRotationStatus', {'KeyId': key_arn})
+        assert code == 200
+        assert content['RotationPeriodInDays'] == 90
+        assert abs(content['NextRotationDate'] - (time.time() + 90 * DAY)) < 60
+
+    def test_change_rotation_period(self, kms_client, key_arn):
+        code, content = kms_client.post('DescribeKey', {'KeyId': key_arn})
+        assert code == 200
+        created = content['KeyMetadata']['CreationDate']
+
+        code, content = kms_client.post('EnableKeyRotation', {'KeyId': key_arn, 'RotationPeriodInDays': 90})
+        assert code == 200
+
+        # The new period applies from the key's last rotation; here, its creation.
+        code, content = kms_client.post('EnableKeyRotation', {'KeyId': key_arn, 'RotationPeriodInDays': 180})
+        assert code == 200
+
+        code, content = kms_client.post('GetKeyRotationStatus', {'KeyId': key_arn})
+        assert code == 200
+        assert content['RotationPeriodInDays'] == 180
+        assert abs(content['NextRotationDate'] - (created + 180 * DAY)) < 60
+
+    def test_disable_key_rotation(self, kms_client, key_arn):
+        code, content = kms_client.post('EnableKeyRotation', {'KeyId': key_arn, 'RotationPeriodInDays': 90})
+        assert code == 200
+
+        code, content = kms_client.post('DisableKeyRotation', {'KeyId': key_arn})
+        assert code == 200
+
+        code, content = kms_client.post('GetKeyRotationStatus', {'KeyId': key_arn})
+        assert code == 200
+        assert content['KeyRotationEnabled'] is False
+
+    @pytest.mark.parametrize('period', [89, 2561])
+    def test_invalid_rotation_period(self, kms_client, key_arn, period):
+        code, content = kms_client.post('EnableKeyRotation', {'KeyId': key_arn, 'RotationPeriodInDays': period})
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(
+            content,
+            'ValidationException',
+            "1 validation error detected: Value '%d' at 'rotationPeriodInDays' failed to satisfy constraint: "
+            "Member must have value between 90 and 2560" % period,
+        )
+
+
+class TestListKeyRotations:
+
+    def test_no_rotations(self, kms_client, key_arn):
+        code, content = kms_client.post('ListKeyRotations', {'KeyId': key_arn})
+        assert code == 200
+        assert content['Rotations'] == []
+        assert content['Truncated'] is False
+        assert 'NextMarker' not in content
+
+    def test_paging(self, kms_client, key_arn):
+        rotate(kms_client, key_arn, 5)
+
+        rotations = []
+        pages = 0
+        marker = None
+
+        while True:
+            payload = {'KeyId': key_arn, 'Limit': 2}
+            if marker is not None:
+                payload['Marker'] = marker
+
+            code, content = kms_client.post('ListKeyRotations', payload)
+            assert code == 200
+
+            pages += 1
+            rotations += content['Rotations']
+
+            if not content['Truncated']:
+                assert 'NextMarker' not in content
+                break
+
+            assert len(content['Rotations']) == 2
+            marker = content['NextMarker']
+
+        assert pages == 3
+        assert len(rotations) == 5
+        assert all(r['RotationType'] == 'ON_DEMAND' for r in rotations)
+
+        # Oldest first
+        dates = [r['RotationDate'] for r in rotations]
+        assert dates == sorted(dates)
+
+    def test_invalid_marker(self, kms_client, key_arn):
+        rotate(kms_client, key_arn, 1)
+
+        code, content = kms_client.post('ListKeyRotations', {'KeyId': key_arn, 'Marker': 'not-a-marker'})
+
+        assert code == 400
+        assert content['__type'] == 'InvalidMarkerException'
+
+    @pytest.mark.parametrize('limit', [0, 1001])
+    def test_invalid_limit(self, kms_client, key_arn, limit):
+        code, content = kms_client.post('ListKeyRotations', {'KeyId': key_arn, 'Limit': limit})
+
+        assert code == 400
+        assert validate_error_response(content, 'ValidationException', '')
+
+    def test_asymmetric_key(self, kms_client, rsa_encryption_key):
+        code, content = kms_client.post('ListKeyRotations', {'KeyId': rsa_encryption_key['Arn']})
+
+        assert code == 400
+        assert validate_unsupported_operation(content, 'not valid for this operation')
@@ -0,0 +1,91 @@
+from base64 import b64encode
+from pprint import pprint
+
+import pytest
+
+from tests import validate_error_response
+
+
+def key_state(kms_client, key_arn):
+    code, content = kms_client.post('DescribeKey', {'KeyId': key_arn})
+    assert code == 200
+    return content['KeyMetadata']['KeyState']
+
+
+class TestKeyStates:
+
+    def test_disabled_key_cannot_encrypt(self, kms_client, key_arn):
+        code, unused = kms_client.post('DisableKey', {'KeyId': key_arn})
+        assert code == 200
+
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+        })
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'DisabledException', key_arn + ' is disabled.')
+
+    def test_disabled_key_cannot_decrypt_ciphertext(self, kms_client, key_arn):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': key_arn,
+            'Plaintext': b64encode(b'plaintext').decode(),
+        })
+        assert code == 200
+
+        ciphertext = content['CiphertextBlob']
+
+        code, unused = kms_client.post('DisableKey', {'KeyId': key_arn})
+        assert code == 200
+
+        # The key is found via the ciphertext, but the reason it can't be used is still returned
+        code, content = kms_client.post('Decrypt', {'CiphertextBlob': ciphertext})
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'DisabledException', key_arn + ' is disabled.')
+
+    @pytest.mark.parametrize("operation,payload", [
+        ('EnableKey', {}),
+        ('TagResource', {'Tags': [{'TagKey': 'key', 'TagValue': 'value'}]}),
+        ('UpdateKeyDescription', {'Description': 'description'}),
+        ('ScheduleKeyDeletion', {'PendingWindowInDays': 7}),
+    ])
+    def test_pending_deletion(self, kms_client, key_arn, operation, payload):
+        code, unused = kms_client.post('ScheduleKeyDeletion', {'KeyId': key_arn, 'PendingWindowInDays': 7})
+        assert code == 200
+
+        code, content = kms_client.post(operation, dict(payload, KeyId=key_arn))
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'KMSInvalidStateException', key_arn + ' is pending deletion.')
+
+    def test_cancel_key_deletion_disables_key(self, kms_client, key_arn):
+        code, unused = kms_client.post('ScheduleKeyDeletion', {'KeyId': key_arn, 'PendingWindowInDays': 7})
+        assert code == 200
+
+        code, unused = kms_client.post('CancelKeyDeletion', {'KeyId': key_arn})
+        assert code == 200
+
+        assert key_state(kms_client, key_arn) == 'Disabled'
+
+    def test_cancel_key_deletion_when_not_pending(self, kms_client, key_arn):
+        code, content = kms_client.post('CancelKeyDeletion', {'KeyId': key_arn})
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'KMSInvalidStateException', key_arn + ' is not pending deletion.')
+
+    def test_pending_import_key_cannot_be_enabled(self, kms_client):
+        code, content = kms_client.post('CreateKey', {'Origin': 'EXTERNAL'})
+        assert code == 200
+
+        key_arn = content['KeyMetadata']['Arn']
+
+        code, content = kms_client.post('EnableKey', {'KeyId': key_arn})
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'KMSInvalidStateException', key_arn + ' is pending import.')
@@ -0,0 +1,268 @@
+import re
+from base64 import b64encode
+from pprint import pprint
+
+import pytest
+
+from tests import validate_error_response
+
+# Local KMS must serve these regions, via KMS_ADDITIONAL_REGIONS, as it does when run with docker-compose.
+PRIMARY_REGION = 'eu-west-2'
+REPLICA_REGION = 'us-east-1'
+OTHER_REGION = 'us-west-2'
+
+
+@pytest.fixture
+def multi_region_key(kms_client):
+    code, content = kms_client.post('CreateKey', {'MultiRegion': True})
+    assert code == 200
+
+    yield content['KeyMetadata']
+
+    # Replicas must be deleted before their primary
+    code, content = kms_client.post('DescribeKey', {'KeyId': content['KeyMetadata']['Arn']})
+    if code != 200:
+        return
+
+    configuration = content['KeyMetadata']['MultiRegionConfiguration']
+    primary = configuration['PrimaryKey']
+
+    for replica in configuration['ReplicaKeys']:
+        kms_client.post('ScheduleKeyDeletion', {'KeyId': replica['Arn'], 'PendingWindowInDays': 7},
+                        region=replica['Region'])
+
+    kms_client.post('ScheduleKeyDeletion', {'KeyId': primary['Arn'], 'PendingWindowInDays': 7},
+                    region=primary['Region'])
+
+
+def validate_unsupported_operation(content, error_message_expression):
+    """
+    UnsupportedOperationException is returned with a capital 'M' on Message.
+    """
+    return content['__type'] == 'UnsupportedOperationException' \
+        and re.match(error_message_expression, content['Message']) is not None
+
+
+def replica_arn(metadata, region):
+    return metadata['Arn'].replace(':%s:' % PRIMARY_REGION, ':%s:' % region)
+
+
+def test_create_multi_region_key(kms_client, multi_region_key):
+    assert multi_region_key['MultiRegion'] is True
+    assert multi_region_key['KeyId'].startswith('mrk-')
+
+    configuration = multi_region_key['MultiRegionConfiguration']
+    assert configuration['MultiRegionKeyType'] == 'PRIMARY'
+    assert configuration['PrimaryKey'] == {'Arn': multi_region_key['Arn'], 'Region': PRIMARY_REGION}
+    assert configuration['ReplicaKeys'] == []
+
+
+def test_replicate_key(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+        'Description': 'replica',
+        'Tags': [{'TagKey': 'team', 'TagValue': 'payments'}],
+    })
+    pprint(content)
+
+    assert code == 200
+
+    replica = content['ReplicaKeyMetadata']
+    assert replica['Arn'] == replica_arn(multi_region_key, REPLICA_REGION)
+    assert replica['KeyId'] == multi_region_key['KeyId']
+    assert replica['Description'] == 'replica'
+    assert replica['MultiRegionConfiguration']['MultiRegionKeyType'] == 'REPLICA'
+    assert content['ReplicaTags'] == [{'TagKey': 'team', 'TagValue': 'payments'}]
+
+    # The primary lists its new replica
+    code, content = kms_client.post('DescribeKey', {'KeyId': multi_region_key['Arn']})
+    assert code == 200
+    assert content['KeyMetadata']['MultiRegionConfiguration']['ReplicaKeys'] == [
+        {'Arn': replica['Arn'], 'Region': REPLICA_REGION},
+    ]
+
+    code, content = kms_client.post('ListResourceTags', {'KeyId': replica['Arn']}, region=REPLICA_REGION)
+    assert code == 200
+    assert content['Tags'] == [{'TagKey': 'team', 'TagValue': 'payments'}]
+
+
+def test_replica_decrypts_primary_ciphertext(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+    })
+    assert code == 200
+
+    code, content = kms_client.post('Encrypt', {
+        'KeyId': multi_region_key['Arn'],
+        'Plaintext': b64encode(b'shared key material').decode(),
+    })
+    assert code == 200
+
+    code, content = kms_client.post('Decrypt', {
+        'KeyId': replica_arn(multi_region_key, REPLICA_REGION),
+        'CiphertextBlob': content['CiphertextBlob'],
+    }, region=REPLICA_REGION)
+    pprint(content)
+
+    assert code == 200
+    assert content['KeyId'] == replica_arn(multi_region_key, REPLICA_REGION)
+    assert content['Plaintext'] == b64encode(b'shared key material').decode()
+
+
+def test_region_is_taken_from_credential_scope(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+    })
+    assert code == 200
+
+    # A bare key ID resolves within the region the request is signed for
+    code, content = kms_client.post('DescribeKey', {'KeyId': multi_region_key['KeyId']}, region=REPLICA_REGION)
+    assert code == 200
+    assert content['KeyMetadata']['Arn'] == replica_arn(multi_region_key, REPLICA_REGION)
+
+    code, content = kms_client.post('DescribeKey', {'KeyId': multi_region_key['KeyId']})
+    assert code == 200
+    assert content['KeyMetadata']['Arn'] == multi_region_key['Arn']
+
+    # There's no replica in this region
+    code, content = kms_client.post('DescribeKey', {'KeyId': multi_region_key['KeyId']}, region=OTHER_REGION)
+    assert code == 400
+    assert validate_error_response(content, 'NotFoundException', '')
+
+
+def test_region_not_served_falls_back_to_default_region(kms_client, multi_region_key):
+    code, content = kms_client.post('DescribeKey', {'KeyId': multi_region_key['KeyId']}, region='ap-south-1')
+    assert code == 200
+    assert content['KeyMetadata']['Arn'] == multi_region_key['Arn']
+
+
+def test_replicate_key_into_region_not_served(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': 'ap-south-1',
+    })
+    pprint(content)
+
+    assert code == 400
+    assert validate_unsupported_operation(content, '.*KMS_ADDITIONAL_REGIONS.*')
+
+
+def test_replicate_key_into_primary_region(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': PRIMARY_REGION,
+    })
+
+    assert code == 400
+    assert validate_error_response(content, 'ValidationException', '')
+
+
+def test_replicate_key_twice(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+    })
+    assert code == 200
+
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+    })
+
+    assert code == 400
+    assert validate_error_response(content, 'AlreadyExistsException', '')
+
+
+def test_replicate_single_region_key(kms_client, symmetric_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': symmetric_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+    })
+
+    assert code == 400
+    assert validate_unsupported_operation(content, '.*is not a multi-Region key.*')
+
+
+def test_replicate_replica_key(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+    })
+    assert code == 200
+
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': content['ReplicaKeyMetadata']['Arn'],
+        'ReplicaRegion': OTHER_REGION,
+    }, region=REPLICA_REGION)
+
+    assert code == 400
+    assert validate_unsupported_operation(content, '.*Only primary keys can be replicated.*')
+
+
+def test_update_primary_region(kms_client, multi_region_key):
+    for region in (REPLICA_REGION, OTHER_REGION):
+        code, content = kms_client.post('ReplicateKey', {
+            'KeyId': multi_region_key['Arn'],
+            'ReplicaRegion': region,
+        })
+        assert code == 200
+
+    code, content = kms_client.post('UpdatePrimaryRegion', {
+        'KeyId': multi_region_key['Arn'],
+        'PrimaryRegion': REPLICA_REGION,
+    })
+    assert code == 200
+
+    new_primary = replica_arn(multi_region_key, REPLICA_REGION)
+
+    # Every copy of the key sees the new configuration
+    for arn, region, key_type in (
+        (new_primary, REPLICA_REGION, 'PRIMARY'),
+        (multi_region_key['Arn'], PRIMARY_REGION, 'REPLICA'),
+        (replica_arn(multi_region_key, OTHER_REGION), OTHER_REGION, 'REPLICA'),
+    ):
+        code, content = kms_client.post('DescribeKey', {'KeyId': arn}, region=region)
+        assert code == 200
+
+        configuration = content['KeyMetadata']['MultiRegionConfiguration']
+        assert configuration['MultiRegionKeyType'] == key_type
+        assert configuration['PrimaryKey'] == {'Arn': new_primary, 'Region': REPLICA_REGION}
+        assert sorted(r['Region'] for r in configuration['ReplicaKeys']) == sorted([OTHER_REGION, PRIMARY_REGION])
+
+    # Only the primary can be replicated
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': 'eu-west-1',
+    })
+    assert code == 400
+    assert validate_unsupported_operation(content, '')
+
+
+def test_update_primary_region_without_replica(kms_client, multi_region_key):
+    code, content = kms_client.post('UpdatePrimaryRegion', {
+        'KeyId': multi_region_key['Arn'],
+        'PrimaryRegion': OTHER_REGION,
+    })
+    pprint(content)
+
+    assert code == 400
+    assert validate_error_response(content, 'NotFoundException', '.*does not have a replica key in region.*')
+
+
+def test_update_primary_region_of_replica(kms_client, multi_region_key):
+    code, content = kms_client.post('ReplicateKey', {
+        'KeyId': multi_region_key['Arn'],
+        'ReplicaRegion': REPLICA_REGION,
+    })
+    assert code == 200
+
+    code, content = kms_client.post('UpdatePrimaryRegion', {
+        'KeyId': content['ReplicaKeyMetadata']['Arn'],
+        'PrimaryRegion': REPLICA_REGION,
+    }, region=REPLICA_REGION)
+
+    assert code == 400
+    assert validate_unsupported_operation(content, '')
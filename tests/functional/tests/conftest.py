@@ -124,3 +124,32 @@ def ecc_signing_key(kms_client):
         raise ValueError(
             'Unable to delete test key %s' % content['KeyMetadata']['KeyId']
         )
+
+
+@pytest.fixture
+def key_arn(kms_client):
+    """
+    Create a symmetric key for a single test, which is free to change the key's state.
+    The key is marked for deletion once the test has finished, unless the test already did so.
+    :param kms_client:
+    :return:
+    """
+    code, content = kms_client.post('CreateKey', {})
+    assert code == 200
+
+    arn = content['KeyMetadata']['Arn']
+
+    yield arn
+
+    code, content = kms_client.post('DescribeKey', {'KeyId': arn})
+    if code == 200 and content['KeyMetadata']['KeyState'] == 'PendingDeletion':
+        return
+
+    code, unused = kms_client.post(
+        'ScheduleKeyDeletion',
+        {'KeyId': arn, 'PendingWindowInDays': 7},
+    )
+    if code != 200:
+        raise ValueError(
+            'Unable to delete test key %s' % arn
+        )
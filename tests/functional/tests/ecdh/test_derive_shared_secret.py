@@ -0,0 +1,71 @@
+import pytest
+from pprint import pprint
+
+
+class TestDeriveSharedSecret:
+
+    @pytest.mark.parametrize("key_spec", [
+        'ECC_NIST_P256',
+        'ECC_NIST_P384',
+        'ECC_NIST_P521',
+    ])
+    def test_both_parties_derive_the_same_secret(self, kms_client, key_spec):
+
+        keys = []
+        for _ in range(2):
+            code, cmk = kms_client.post('CreateKey', {
+                "KeySpec": key_spec,
+                "KeyUsage": 'KEY_AGREEMENT',
+            })
+            pprint(cmk)
+            assert code == 200
+            assert cmk['KeyMetadata']['KeyAgreementAlgorithms'] == ['ECDH']
+
+            code, public_key = kms_client.post('GetPublicKey', {
+                'KeyId': cmk['KeyMetadata']['KeyId'],
+            })
+            assert code == 200
+
+            keys.append((cmk['KeyMetadata']['KeyId'], public_key['PublicKey']))
+
+        # -------------------
+
+        secrets = []
+        for key_id, peer_public_key in [(keys[0][0], keys[1][1]), (keys[1][0], keys[0][1])]:
+            code, derived = kms_client.post('DeriveSharedSecret', {
+                'KeyId': key_id,
+                'KeyAgreementAlgorithm': 'ECDH',
+                'PublicKey': peer_public_key,
+            })
+            pprint(derived)
+            assert code == 200
+            assert derived['KeyAgreementAlgorithm'] == 'ECDH'
+
+            secrets.append(derived['SharedSecret'])
+
+        assert secrets[0] == secrets[1]
+
+        # -------------------
+
+        for key_id, _ in keys:
+            code, delete = kms_client.post('ScheduleKeyDeletion', {
+                'KeyId': key_id,
+                'PendingWindowInDays': 7
+            })
+
+            assert code == 200
+
+    def test_signing_key_cannot_derive(self, kms_client, ecc_signing_key):
+
+        code, public_key = kms_client.post('GetPublicKey', {
+            'KeyId': ecc_signing_key['KeyId'],
+        })
+        assert code == 200
+
+        code, derived = kms_client.post('DeriveSharedSecret', {
+            'KeyId': ecc_signing_key['KeyId'],
+            'KeyAgreementAlgorithm': 'ECDH',
+            'PublicKey': public_key['PublicKey'],
+        })
+        assert code == 400
+        assert derived['__type'] == 'InvalidKeyUsageException'
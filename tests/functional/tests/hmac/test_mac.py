@@ -0,0 +1,67 @@
+import pytest
+from base64 import b64encode
+from pprint import pprint
+
+
+class TestMac:
+
+    @pytest.mark.parametrize("key_spec_and_algorithm", [
+        ('HMAC_224', 'HMAC_SHA_224'),
+        ('HMAC_256', 'HMAC_SHA_256'),
+        ('HMAC_384', 'HMAC_SHA_384'),
+        ('HMAC_512', 'HMAC_SHA_512'),
+    ])
+    def test_generate_and_verify_mac(self, kms_client, key_spec_and_algorithm):
+
+        code, cmk = kms_client.post('CreateKey', {
+            "KeySpec": key_spec_and_algorithm[0],
+            "KeyUsage": 'GENERATE_VERIFY_MAC',
+        })
+        pprint(cmk)
+        assert code == 200
+        assert cmk['KeyMetadata']['MacAlgorithms'] == [key_spec_and_algorithm[1]]
+
+        # -------------------
+
+        message = b64encode('Hello World'.encode("utf-8")).decode('ascii')
+
+        code, generated = kms_client.post('GenerateMac', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MacAlgorithm': key_spec_and_algorithm[1],
+            'Message': message,
+        })
+        pprint(generated)
+        assert code == 200
+        assert 'Mac' in generated
+
+        # -------------------
+
+        code, verified = kms_client.post('VerifyMac', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MacAlgorithm': key_spec_and_algorithm[1],
+            'Message': message,
+            'Mac': generated['Mac'],
+        })
+        pprint(verified)
+        assert code == 200
+        assert verified['MacValid'] is True
+
+        # -------------------
+
+        code, verified = kms_client.post('VerifyMac', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MacAlgorithm': key_spec_and_algorithm[1],
+            'Message': b64encode('Goodbye World'.encode("utf-8")).decode('ascii'),
+            'Mac': generated['Mac'],
+        })
+        assert code == 400
+        assert verified['__type'] == 'KMSInvalidMacException'
+
+        # -------------------
+
+        code, delete = kms_client.post('ScheduleKeyDeletion', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'PendingWindowInDays': 7
+        })
+
+        assert code == 200
@@ -1,7 +1,39 @@
 import pytest
-from base64 import b64encode
+import hashlib
+from base64 import b64decode, b64encode
 from pprint import pprint
 
+# The SM2 curve's parameters, as used in ZA; from GM/T 0003.5
+SM2_A = bytes.fromhex('FFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF00000000FFFFFFFFFFFFFFFC')
+SM2_B = bytes.fromhex('28E9FA9E9D9F5E344D5A9E4BCF6509A7F39789F515AB8F92DDBCBD414D940E93')
+SM2_GX = bytes.fromhex('32C4AE2C1F1981195F9904466A39C9948FE30BBFF2660BE1715A4589334C74C7')
+SM2_GY = bytes.fromhex('BC3736A2F4F6779C59BDCEE36B692153D0A9877CC62A474002DF32E52139F0A0')
+
+# The default distinguishing ID
+SM2_ID = b'1234567812345678'
+
+
+def sm3(data):
+    h = hashlib.new('sm3')
+    h.update(data)
+    return h.digest()
+
+
+def sm2_digest(public_key, message):
+    """
+    Returns SM3(ZA || message), where ZA identifies the signer by their distinguishing ID and public key.
+    The public key is DER encoded, so its uncompressed point is the last 65 bytes.
+    """
+    point = public_key[-65:]
+    assert point[0] == 0x04
+
+    za = sm3(
+        (len(SM2_ID) * 8).to_bytes(2, 'big') + SM2_ID +
+        SM2_A + SM2_B + SM2_GX + SM2_GY + point[1:33] + point[33:]
+    )
+
+    return sm3(za + message)
+
 
 class TestSigning:
 
@@ -16,6 +48,8 @@ class TestSigning:
         ('ECC_NIST_P384', 'ECDSA_SHA_384'),
         ('ECC_NIST_P521', 'ECDSA_SHA_512'),
         ('ECC_SECG_P256K1', 'ECDSA_SHA_256'),
+        ('ECC_NIST_EDWARDS25519', 'ED25519_SHA_512'),
+        ('SM2', 'SM2DSA'),
     ])
     def test_message_signing(self, kms_client, key_pair_spec_and_algorithm):
 
@@ -63,3 +97,188 @@ class TestSigning:
         pprint(delete)
 
         assert code == 200
+
+    def test_ed25519_message_type_rules(self, kms_client):
+
+        code, cmk = kms_client.post('CreateKey', {
+            "KeySpec": 'ECC_NIST_EDWARDS25519',
+            "KeyUsage": 'SIGN_VERIFY',
+        })
+        pprint(cmk)
+        assert code == 200
+        assert cmk['KeyMetadata']['SigningAlgorithms'] == ['ED25519_SHA_512', 'ED25519_PH_SHA_512']
+
+        message = b64encode('Hello World'.encode("utf-8")).decode('ascii')
+        digest = b64encode(hashlib.sha512('Hello World'.encode("utf-8")).digest()).decode('ascii')
+
+        # -------------------
+        # Ed25519ph requires a digest
+
+        code, signed = kms_client.post('Sign', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'RAW',
+            'SigningAlgorithm': 'ED25519_PH_SHA_512',
+            'Message': message,
+        })
+        assert code == 400
+        assert signed['__type'] == 'ValidationException'
+
+        code, signed = kms_client.post('Sign', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'DIGEST',
+            'SigningAlgorithm': 'ED25519_PH_SHA_512',
+            'Message': digest,
+        })
+        pprint(signed)
+        assert code == 200
+
+        code, verified = kms_client.post('Verify', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'DIGEST',
+            'SigningAlgorithm': 'ED25519_PH_SHA_512',
+            'Message': digest,
+            'Signature': signed['Signature']
+        })
+        assert code == 200
+        assert verified['SignatureValid'] is True
+
+        # -------------------
+        # Pure Ed25519 requires the raw message
+
+        code, signed = kms_client.post('Sign', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'DIGEST',
+            'SigningAlgorithm': 'ED25519_SHA_512',
+            'Message': digest,
+        })
+        assert code == 400
+        assert signed['__type'] == 'ValidationException'
+
+        # -------------------
+
+        code, delete = kms_client.post('ScheduleKeyDeletion', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'PendingWindowInDays': 7
+        })
+
+        assert code == 200
+
+    def test_sm2_message_type_rules(self, kms_client):
+        if 'sm3' not in hashlib.algorithms_available:
+            pytest.skip('SM3 is not supported by this Python build')
+
+        code, cmk = kms_client.post('CreateKey', {
+            "KeySpec": 'SM2',
+            "KeyUsage": 'SIGN_VERIFY',
+        })
+        pprint(cmk)
+        assert code == 200
+        assert cmk['KeyMetadata']['SigningAlgorithms'] == ['SM2DSA']
+
+        code, content = kms_client.post('GetPublicKey', {'KeyId': cmk['KeyMetadata']['KeyId']})
+        assert code == 200
+
+        message = 'Hello World'.encode("utf-8")
+        digest = sm2_digest(b64decode(content['PublicKey']), message)
+
+        # -------------------
+        # A DIGEST is SM3(ZA || message), so its signature verifies against the RAW message
+
+        code, signed = kms_client.post('Sign', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'DIGEST',
+            'SigningAlgorithm': 'SM2DSA',
+            'Message': b64encode(digest).decode('ascii'),
+        })
+        pprint(signed)
+        assert code == 200
+
+        code, verified = kms_client.post('Verify', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'RAW',
+            'SigningAlgorithm': 'SM2DSA',
+            'Message': b64encode(message).decode('ascii'),
+            'Signature': signed['Signature']
+        })
+        assert code == 200
+        assert verified['SignatureValid'] is True
+
+        # -------------------
+        # And the other way round
+
+        code, signed = kms_client.post('Sign', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'RAW',
+            'SigningAlgorithm': 'SM2DSA',
+            'Message': b64encode(message).decode('ascii'),
+        })
+        assert code == 200
+
+        code, verified = kms_client.post('Verify', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'DIGEST',
+            'SigningAlgorithm': 'SM2DSA',
+            'Message': b64encode(digest).decode('ascii'),
+            'Signature': signed['Signature']
+        })
+        assert code == 200
+        assert verified['SignatureValid'] is True
+
+        # -------------------
+        # A plain SM3 digest of the message, without ZA, doesn't verify
+
+        code, verified = kms_client.post('Verify', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'DIGEST',
+            'SigningAlgorithm': 'SM2DSA',
+            'Message': b64encode(sm3(message)).decode('ascii'),
+            'Signature': signed['Signature']
+        })
+        assert code == 400
+        assert verified['__type'] == 'KMSInvalidSignatureException'
+
+        # -------------------
+        # A digest must be 32 bytes
+
+        code, signed = kms_client.post('Sign', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'DIGEST',
+            'SigningAlgorithm': 'SM2DSA',
+            'Message': b64encode(hashlib.sha512(message).digest()).decode('ascii'),
+        })
+        assert code == 400
+        assert signed['__type'] == 'ValidationException'
+
+        # -------------------
+
+        code, delete = kms_client.post('ScheduleKeyDeletion', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'PendingWindowInDays': 7
+        })
+
+        assert code == 200
+
+    def test_sm2_signing_algorithm_rules(self, kms_client):
+
+        code, cmk = kms_client.post('CreateKey', {
+            "KeySpec": 'SM2',
+            "KeyUsage": 'SIGN_VERIFY',
+        })
+        assert code == 200
+
+        code, signed = kms_client.post('Sign', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'MessageType': 'RAW',
+            'SigningAlgorithm': 'ECDSA_SHA_256',
+            'Message': b64encode('Hello World'.encode("utf-8")).decode('ascii'),
+        })
+        pprint(signed)
+        assert code == 400
+        assert signed['__type'] == 'InvalidKeyUsageException'
+
+        code, delete = kms_client.post('ScheduleKeyDeletion', {
+            'KeyId': cmk['KeyMetadata']['KeyId'],
+            'PendingWindowInDays': 7
+        })
+
+        assert code == 200
@@ -0,0 +1,290 @@
+import hashlib
+import os
+from base64 import b64decode, b64encode
+from pprint import pprint
+
+import pytest
+
+from tests import validate_error_response
+
+# The SM2 curve; from GM/T 0003.5
+P = 0xFFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF00000000FFFFFFFFFFFFFFFF
+A = 0xFFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF00000000FFFFFFFFFFFFFFFC
+N = 0xFFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFF7203DF6B21C6052B53BBF40939D54123
+G = (
+    0x32C4AE2C1F1981195F9904466A39C9948FE30BBFF2660BE1715A4589334C74C7,
+    0xBC3736A2F4F6779C59BDCEE36B692153D0A9877CC62A474002DF32E52139F0A0,
+)
+
+# DER encoded OIDs
+OID_EC_PUBLIC_KEY = bytes.fromhex('06072a8648ce3d0201')  # 1.2.840.10045.2.1
+OID_SM2 = bytes.fromhex('06082a811ccf5501822d')  # 1.2.156.10197.1.301
+
+pytestmark = pytest.mark.skipif('sm3' not in hashlib.algorithms_available,
+                                reason='SM3 is not supported by this Python build')
+
+
+# ---------------------------------------------
+# A minimal SM2 implementation, so LKMS's output can be checked independently.
+
+def sm3(data):
+    h = hashlib.new('sm3')
+    h.update(data)
+    return h.digest()
+
+
+def point_add(p1, p2):
+    if p1 is None:
+        return p2
+    if p2 is None:
+        return p1
+    if p1[0] == p2[0] and (p1[1] + p2[1]) % P == 0:
+        return None
+    if p1 == p2:
+        slope = (3 * p1[0] * p1[0] + A) * pow(2 * p1[1], -1, P) % P
+    else:
+        slope = (p2[1] - p1[1]) * pow(p2[0] - p1[0], -1, P) % P
+    x = (slope * slope - p1[0] - p2[0]) % P
+    return x, (slope * (p1[0] - x) - p1[1]) % P
+
+
+def point_multiply(k, point):
+    result = None
+    while k:
+        if k & 1:
+            result = point_add(result, point)
+        point = point_add(point, point)
+        k >>= 1
+    return result
+
+
+def kdf(z, length):
+    output = b''
+    counter = 1
+    while len(output) < length:
+        output += sm3(z + counter.to_bytes(4, 'big'))
+        counter += 1
+    return output[:length]
+
+
+def sm2_encrypt(public_point, plaintext):
+    """
+    Returns the ciphertext in the ASN.1 C1C3C2 format defined by GM/T 0009.
+    """
+    k = int.from_bytes(os.urandom(32), 'big') % (N - 1) + 1
+
+    c1 = point_multiply(k, G)
+    x2, y2 = point_multiply(k, public_point)
+
+    x2, y2 = x2.to_bytes(32, 'big'), y2.to_bytes(32, 'big')
+
+    c2 = bytes(a ^ b for a, b in zip(plaintext, kdf(x2 + y2, len(plaintext))))
+    c3 = sm3(x2 + plaintext + y2)
+
+    return der(0x30, der_integer(c1[0]) + der_integer(c1[1]) + der(0x04, c3) + der(0x04, c2))
+
+
+# ---------------------------------------------
+# DER
+
+def der(tag, value):
+    if len(value) < 0x80:
+        length = bytes([len(value)])
+    else:
+        encoded = len(value).to_bytes((len(value).bit_length() + 7) // 8, 'big')
+        length = bytes([0x80 | len(encoded)]) + encoded
+    return bytes([tag]) + length + value
+
+
+def der_integer(i):
+    encoded = i.to_bytes((i.bit_length() + 8) // 8, 'big')
+    return der(0x02, encoded)
+
+
+def der_elements(data):
+    """
+    Returns the (tag, value) pairs of the DER elements in data, without descending into them.
+    """
+    elements = []
+    while data:
+        tag, length, offset = data[0], data[1], 2
+        if length & 0x80:
+            size = length & 0x7f
+            length = int.from_bytes(data[2:2 + size], 'big')
+            offset += size
+        elements.append((tag, data[offset:offset + length]))
+        data = data[offset + length:]
+    return elements
+
+
+def public_point(public_key):
+    """
+    Returns the point held in a DER encoded SubjectPublicKeyInfo, confirming it's an SM2 key.
+    """
+    [(tag, spki)] = der_elements(public_key)
+    assert tag == 0x30
+
+    [(_, algorithm), (tag, bit_string)] = der_elements(spki)
+    assert algorithm == OID_EC_PUBLIC_KEY + OID_SM2
+    assert tag == 0x03
+
+    # The bit string has no unused bits, and the point is uncompressed
+    assert bit_string[0] == 0x00 and bit_string[1] == 0x04 and len(bit_string) == 66
+
+    point = (int.from_bytes(bit_string[2:34], 'big'), int.from_bytes(bit_string[34:], 'big'))
+
+    # The point is on the curve
+    b = 0x28E9FA9E9D9F5E344D5A9E4BCF6509A7F39789F515AB8F92DDBCBD414D940E93
+    assert (point[1] ** 2 - point[0] ** 3 - A * point[0] - b) % P == 0
+
+    return point
+
+
+# ---------------------------------------------
+
+@pytest.fixture(scope="module")
+def sm2_encryption_key(kms_client):
+    code, content = kms_client.post('CreateKey', {
+        'KeySpec': 'SM2',
+        'KeyUsage': 'ENCRYPT_DECRYPT',
+    })
+    assert code == 200
+
+    yield content['KeyMetadata']
+
+    code, unused = kms_client.post('ScheduleKeyDeletion', {
+        'KeyId': content['KeyMetadata']['KeyId'],
+        'PendingWindowInDays': 7,
+    })
+    assert code == 200
+
+
+class TestSm2:
+
+    def test_create_key(self, kms_client, sm2_encryption_key):
+        assert sm2_encryption_key['KeySpec'] == 'SM2'
+        assert sm2_encryption_key['KeyUsage'] == 'ENCRYPT_DECRYPT'
+        assert sm2_encryption_key['EncryptionAlgorithms'] == ['SM2PKE']
+        assert 'SigningAlgorithms' not in sm2_encryption_key
+
+    def test_create_key_without_usage(self, kms_client):
+        code, content = kms_client.post('CreateKey', {'KeySpec': 'SM2'})
+
+        assert code == 400
+        assert validate_error_response(content, 'ValidationException', '')
+
+    def test_get_public_key(self, kms_client, sm2_encryption_key):
+        code, content = kms_client.post('GetPublicKey', {'KeyId': sm2_encryption_key['KeyId']})
+        pprint(content)
+
+        assert code == 200
+        assert content['KeySpec'] == 'SM2'
+        assert content['EncryptionAlgorithms'] == ['SM2PKE']
+
+        public_point(b64decode(content['PublicKey']))
+
+    def test_encrypt_decrypt(self, kms_client, sm2_encryption_key):
+        plaintext = b64encode(b'Hello World').decode()
+
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': sm2_encryption_key['KeyId'],
+            'EncryptionAlgorithm': 'SM2PKE',
+            'Plaintext': plaintext,
+        })
+        pprint(content)
+
+        assert code == 200
+        assert content['EncryptionAlgorithm'] == 'SM2PKE'
+
+        # C1 (the point's coordinates), C3 (the SM3 hash) and C2 (the ciphertext, as long as the plaintext)
+        [(tag, ciphertext)] = der_elements(b64decode(content['CiphertextBlob']))
+        elements = der_elements(ciphertext)
+        assert tag == 0x30
+        assert [e[0] for e in elements] == [0x02, 0x02, 0x04, 0x04]
+        assert len(elements[2][1]) == 32
+        assert len(elements[3][1]) == len(b'Hello World')
+
+        code, content = kms_client.post('Decrypt', {
+            'KeyId': sm2_encryption_key['KeyId'],
+            'EncryptionAlgorithm': 'SM2PKE',
+            'CiphertextBlob': content['CiphertextBlob'],
+        })
+
+        assert code == 200
+        assert content['Plaintext'] == plaintext
+
+    def test_decrypt_ciphertext_encrypted_locally(self, kms_client, sm2_encryption_key):
+        code, content = kms_client.post('GetPublicKey', {'KeyId': sm2_encryption_key['KeyId']})
+        assert code == 200
+
+        ciphertext = sm2_encrypt(public_point(b64decode(content['PublicKey'])), b'Encrypted elsewhere')
+
+        code, content = kms_client.post('Decrypt', {
+            'KeyId': sm2_encryption_key['KeyId'],
+            'EncryptionAlgorithm': 'SM2PKE',
+            'CiphertextBlob': b64encode(ciphertext).decode(),
+        })
+        pprint(content)
+
+        assert code == 200
+        assert b64decode(content['Plaintext']) == b'Encrypted elsewhere'
+
+    def test_decrypt_tampered_ciphertext(self, kms_client, sm2_encryption_key):
+        code, content = kms_client.post('GetPublicKey', {'KeyId': sm2_encryption_key['KeyId']})
+        assert code == 200
+
+        ciphertext = bytearray(sm2_encrypt(public_point(b64decode(content['PublicKey'])), b'Encrypted elsewhere'))
+        ciphertext[-1] ^= 0x01
+
+        code, content = kms_client.post('Decrypt', {
+            'KeyId': sm2_encryption_key['KeyId'],
+            'EncryptionAlgorithm': 'SM2PKE',
+            'CiphertextBlob': b64encode(bytes(ciphertext)).decode(),
+        })
+
+        assert code == 400
+        assert content['__type'] == 'InvalidCiphertextException'
+
+    def test_encrypt_with_another_algorithm(self, kms_client, sm2_encryption_key):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': sm2_encryption_key['KeyId'],
+            'EncryptionAlgorithm': 'RSAES_OAEP_SHA_256',
+            'Plaintext': b64encode(b'Hello World').decode(),
+        })
+        pprint(content)
+
+        assert code == 400
+        assert validate_error_response(content, 'InvalidKeyUsageException', '')
+
+    def test_encrypt_plaintext_too_long(self, kms_client, sm2_encryption_key):
+        code, content = kms_client.post('Encrypt', {
+            'KeyId': sm2_encryption_key['KeyId'],
+            'EncryptionAlgorithm': 'SM2PKE',
+            'Plaintext': b64encode(b'x' * 1025).decode(),
+        })
+
+        assert code == 400
+        assert validate_error_response(content, 'ValidationException', '')
+
+    def test_generate_data_key_pair(self, kms_client, symmetric_key):
+        code, content = kms_client.post('GenerateDataKeyPair', {
+            'KeyId': symmetric_key['KeyId'],
+            'KeyPairSpec': 'SM2',
+        })
+
+        assert code == 200
+        assert content['KeyPairSpec'] == 'SM2'
+
+        point = public_point(b64decode(content['PublicKey']))
+
+        # PKCS#8: the version, the algorithm, then the ECPrivateKey
+        [(tag, pkcs8)] = der_elements(b64decode(content['PrivateKeyPlaintext']))
+        [(_, version), (_, algorithm), (_, ec_private_key)] = der_elements(pkcs8)
+        assert algorithm == OID_EC_PUBLIC_KEY + OID_SM2
+
+        # ECPrivateKey: the version, then the private key
+        [(_, ec_private_key)] = der_elements(ec_private_key)
+        d = int.from_bytes(der_elements(ec_private_key)[1][1], 'big')
+
+        # The private key is the public key's
+        assert point_multiply(d, G) == point